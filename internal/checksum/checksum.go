@@ -0,0 +1,103 @@
+// Package checksum computes the per-part integrity checksums sent with
+// multipart uploads and the composite checksums S3 derives from them.
+package checksum
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+)
+
+// Algorithm is a checksum algorithm supported for uploads.
+type Algorithm string
+
+// Supported checksum algorithms.
+const (
+	None   Algorithm = ""
+	MD5    Algorithm = "md5"
+	CRC32C Algorithm = "crc32c"
+	SHA256 Algorithm = "sha256"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// ParseAlgorithm parses an algorithm name, case-insensitively. An empty
+// name or "none" disables checksums.
+func ParseAlgorithm(name string) (Algorithm, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return None, nil
+	case "md5":
+		return MD5, nil
+	case "crc32c":
+		return CRC32C, nil
+	case "sha256", "sha-256":
+		return SHA256, nil
+	}
+	return None, fmt.Errorf("unsupported checksum algorithm: %s (use none, md5, crc32c or sha256)", name)
+}
+
+// String returns the algorithm name.
+func (a Algorithm) String() string {
+	if a == None {
+		return "none"
+	}
+	return string(a)
+}
+
+// New returns a new hash for the algorithm, or nil for None.
+func (a Algorithm) New() hash.Hash {
+	switch a {
+	case MD5:
+		return md5.New()
+	case CRC32C:
+		return crc32.New(castagnoli)
+	case SHA256:
+		return sha256.New()
+	}
+	return nil
+}
+
+// Compute reads r to the end and returns its base64-encoded checksum, the
+// encoding S3 uses in checksum headers. It returns "" for None.
+func Compute(a Algorithm, r io.Reader) (string, error) {
+	h := a.New()
+	if h == nil {
+		return "", nil
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// Composite combines the base64 checksums of all parts, in part order, into
+// the checksum S3 reports for the completed multipart object: the checksum
+// of the concatenated raw part checksums followed by "-" and the number of
+// parts.
+//
+// For MD5 the result is the multipart ETag, which S3 reports in hex.
+func Composite(a Algorithm, partChecksums []string) (string, error) {
+	h := a.New()
+	if h == nil {
+		return "", nil
+	}
+	for i, c := range partChecksums {
+		raw, err := base64.StdEncoding.DecodeString(c)
+		if err != nil {
+			return "", fmt.Errorf("invalid checksum for part %d: %w", i+1, err)
+		}
+		h.Write(raw)
+	}
+	sum := h.Sum(nil)
+	if a == MD5 {
+		return fmt.Sprintf("%s-%d", hex.EncodeToString(sum), len(partChecksums)), nil
+	}
+	return fmt.Sprintf("%s-%d", base64.StdEncoding.EncodeToString(sum), len(partChecksums)), nil
+}
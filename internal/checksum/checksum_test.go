@@ -0,0 +1,131 @@
+package checksum
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"testing"
+)
+
+func TestCompute(t *testing.T) {
+	tests := []struct {
+		algorithm Algorithm
+		data      string
+		want      string
+	}{
+		{None, "123456789", ""},
+		// The check value of CRC-32C, 0xe3069283, big-endian as S3 sends it.
+		{CRC32C, "123456789", "4waSgw=="},
+		{CRC32C, "", "AAAAAA=="},
+		{SHA256, "", "47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="},
+		{SHA256, "abc", "ungWv48Bz+pBQUDeXa4iI7ADYaOWF3qctBD/YfIAFa0="},
+		{MD5, "", "1B2M2Y8AsgTpgAmY7PhCfg=="},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s/%q", tt.algorithm, tt.data), func(t *testing.T) {
+			got, err := Compute(tt.algorithm, strings.NewReader(tt.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Compute = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComposite(t *testing.T) {
+	parts := []string{"first part", "second part", "third"}
+	// S3 combines the raw part checksums, not their base64 encoding, and
+	// appends the part count.
+	crc := crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	sha := sha256.New()
+	md := md5.New()
+	var crcParts, shaParts, mdParts []string
+	for _, p := range parts {
+		c := crc32.Checksum([]byte(p), crc32.MakeTable(crc32.Castagnoli))
+		raw := binary.BigEndian.AppendUint32(nil, c)
+		crc.Write(raw)
+		crcParts = append(crcParts, base64.StdEncoding.EncodeToString(raw))
+
+		s := sha256.Sum256([]byte(p))
+		sha.Write(s[:])
+		shaParts = append(shaParts, base64.StdEncoding.EncodeToString(s[:]))
+
+		m := md5.Sum([]byte(p))
+		md.Write(m[:])
+		mdParts = append(mdParts, base64.StdEncoding.EncodeToString(m[:]))
+	}
+
+	tests := []struct {
+		algorithm Algorithm
+		parts     []string
+		want      string
+	}{
+		{CRC32C, crcParts, base64.StdEncoding.EncodeToString(crc.Sum(nil)) + "-3"},
+		{SHA256, shaParts, base64.StdEncoding.EncodeToString(sha.Sum(nil)) + "-3"},
+		// The composite MD5 is the multipart ETag, in hex.
+		{MD5, mdParts, hex.EncodeToString(md.Sum(nil)) + "-3"},
+		{None, crcParts, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.algorithm.String(), func(t *testing.T) {
+			got, err := Composite(tt.algorithm, tt.parts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Composite = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompositeSinglePart(t *testing.T) {
+	sum, err := Compute(CRC32C, strings.NewReader("123456789"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := Composite(CRC32C, []string{sum})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The checksum of the part checksum, not the part checksum itself.
+	raw, _ := base64.StdEncoding.DecodeString(sum)
+	want := base64.StdEncoding.EncodeToString(binary.BigEndian.AppendUint32(nil, crc32.Checksum(raw, crc32.MakeTable(crc32.Castagnoli)))) + "-1"
+	if got != want {
+		t.Errorf("Composite = %q, want %q", got, want)
+	}
+}
+
+func TestCompositeInvalidChecksum(t *testing.T) {
+	if _, err := Composite(SHA256, []string{"47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=", "not base64!"}); err == nil || !strings.Contains(err.Error(), "part 2") {
+		t.Errorf("Composite returned %v, want an error about part 2", err)
+	}
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	tests := []struct {
+		name string
+		want Algorithm
+		ok   bool
+	}{
+		{"", None, true},
+		{"none", None, true},
+		{"MD5", MD5, true},
+		{"crc32c", CRC32C, true},
+		{"SHA-256", SHA256, true},
+		{"sha1", None, false},
+	}
+	for _, tt := range tests {
+		got, err := ParseAlgorithm(tt.name)
+		if got != tt.want || (err == nil) != tt.ok {
+			t.Errorf("ParseAlgorithm(%q) = %q, %v", tt.name, got, err)
+		}
+	}
+}
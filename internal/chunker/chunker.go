@@ -1,13 +1,73 @@
 package chunker
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
+	"sync"
 )
 
 const DefaultChunkSize = 5 * 1024 * 1024 // 5 MB
 
+// Limits of S3 multipart uploads.
+const (
+	MinPartSize   = 5 * 1024 * 1024               // Smallest size of every part but the last
+	MaxPartSize   = 5 * 1024 * 1024 * 1024        // Largest size of a part
+	MaxParts      = 10000                         // Largest number of parts in an upload
+	MaxObjectSize = 5 * 1024 * 1024 * 1024 * 1024 // Largest size of an object
+)
+
+// ValidatePartSize checks that splitting fileSize bytes into parts of
+// partSize bytes respects the limits of S3 multipart uploads.
+func ValidatePartSize(fileSize, partSize int64) error {
+	switch {
+	case partSize <= 0:
+		return fmt.Errorf("part size must be greater than 0, got %d", partSize)
+	case fileSize > MaxObjectSize:
+		return fmt.Errorf("file size %d exceeds the maximum object size of %d bytes", fileSize, int64(MaxObjectSize))
+	case partSize > MaxPartSize:
+		return fmt.Errorf("part size %d exceeds the maximum of %d bytes", partSize, int64(MaxPartSize))
+	// A single part may be smaller than the minimum.
+	case partSize < MinPartSize && fileSize > partSize:
+		return fmt.Errorf("part size %d is below the minimum of %d bytes", partSize, MinPartSize)
+	case (fileSize+partSize-1)/partSize > MaxParts:
+		return fmt.Errorf("part size %d splits %d bytes into %d parts, more than the maximum of %d", partSize, fileSize, (fileSize+partSize-1)/partSize, MaxParts)
+	}
+	return nil
+}
+
+// OptimalPartSize returns partSize if it is valid for a file of fileSize
+// bytes, and otherwise the closest valid part size, along with the reason
+// it was changed. Part sizes raised to fit the file in MaxParts parts are
+// rounded up to a whole number of MiB, like most tools do.
+func OptimalPartSize(fileSize, partSize int64) (int64, string, error) {
+	if fileSize > MaxObjectSize {
+		return 0, "", fmt.Errorf("file size %d exceeds the maximum object size of %d bytes", fileSize, int64(MaxObjectSize))
+	}
+	if partSize <= 0 {
+		partSize = DefaultChunkSize
+	}
+	if err := ValidatePartSize(fileSize, partSize); err == nil {
+		return partSize, "", nil
+	}
+
+	const mib = 1024 * 1024
+	size, reason := partSize, ""
+	switch {
+	case size > MaxPartSize:
+		size, reason = MaxPartSize, fmt.Sprintf("part size %d exceeds the maximum of %d bytes", partSize, int64(MaxPartSize))
+	case size < MinPartSize:
+		size, reason = MinPartSize, fmt.Sprintf("part size %d is below the minimum of %d bytes", partSize, MinPartSize)
+	}
+	if parts := (fileSize + size - 1) / size; parts > MaxParts {
+		reason = fmt.Sprintf("part size %d would split the file into %d parts, more than the maximum of %d", partSize, (fileSize+partSize-1)/partSize, MaxParts)
+		size = (fileSize + MaxParts - 1) / MaxParts
+		size = (size + mib - 1) / mib * mib
+	}
+	return size, reason, nil
+}
+
 // Chunk represents a part of a file to be uploaded.
 type Chunk struct {
 	Index    int    // Part number
@@ -18,66 +78,205 @@ type Chunk struct {
 
 // FileChunker provides methods to chunk a file.
 type FileChunker struct {
-	filePath  string
-	fileSize  int64
-	chunkSize int64
+	filePath   string
+	fileSize   int64
+	chunkSize  int64
+	adjustment string // Why chunkSize differs from the requested size, if it does
 }
 
-// NewFileChunker creates a new FileChunker.
+// NewFileChunker creates a new FileChunker. If chunkSize does not respect
+// the limits of S3 multipart uploads for the file, the chunker uses the
+// part size returned by OptimalPartSize instead, and Adjustment says why.
 func NewFileChunker(filePath string, chunkSize int64) (*FileChunker, error) {
 	fileInfo, err := os.Stat(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get file info: %w", err)
 	}
 
-	if chunkSize <= 0 {
-		chunkSize = DefaultChunkSize
+	partSize, reason, err := OptimalPartSize(fileInfo.Size(), chunkSize)
+	if err != nil {
+		return nil, err
 	}
 
 	return &FileChunker{
-		filePath:  filePath,
-		fileSize:  fileInfo.Size(),
-		chunkSize: chunkSize,
+		filePath:   filePath,
+		fileSize:   fileInfo.Size(),
+		chunkSize:  partSize,
+		adjustment: reason,
 	}, nil
 }
 
 // Chunks returns a slice of Chunks for the file.
 func (fc *FileChunker) Chunks() []Chunk {
+	chunks := ChunksForSize(fc.fileSize, fc.chunkSize)
+	for i := range chunks {
+		chunks[i].FilePath = fc.filePath
+	}
+	return chunks
+}
+
+// GrowingPartSize returns the size of part partNumber of an upload whose
+// first step parts are base bytes, after which the part size doubles every
+// step parts, up to largest. Streams of unknown length are uploaded this
+// way, so that they fit in MaxParts parts.
+func GrowingPartSize(base, largest int64, step, partNumber int) int64 {
+	size := base
+	for n := (partNumber - 1) / step; n > 0 && size < largest; n-- {
+		size *= 2
+	}
+	return min(size, largest)
+}
+
+// ChunksForSize splits size bytes into chunks of chunkSize bytes, the last
+// one possibly smaller. It is used for objects that are not local files,
+// such as byte ranges of a remote object.
+func ChunksForSize(size, chunkSize int64) []Chunk {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
 	var chunks []Chunk
 	for i := 0; ; i++ {
-		offset := int64(i) * fc.chunkSize
-		remaining := fc.fileSize - offset
+		offset := int64(i) * chunkSize
+		remaining := size - offset
 		if remaining <= 0 {
 			break
 		}
 
-		chunkSize := fc.chunkSize
-		if remaining < chunkSize {
-			chunkSize = remaining
+		partSize := chunkSize
+		if remaining < partSize {
+			partSize = remaining
 		}
 
 		chunks = append(chunks, Chunk{
-			Index:    i + 1, // S3 part numbers start from 1
-			Offset:   offset,
-			Size:     chunkSize,
-			FilePath: fc.filePath,
+			Index:  i + 1, // S3 part numbers start from 1
+			Offset: offset,
+			Size:   partSize,
 		})
 	}
 	return chunks
 }
 
-// GetChunkReader returns an io.Reader for a specific chunk.
-func (fc *FileChunker) GetChunkReader(chunk Chunk) (io.Reader, error) {
+// chunkReader reads a single chunk and closes the underlying file when done.
+type chunkReader struct {
+	*io.SectionReader
+	file *os.File
+	ctx  context.Context
+}
+
+// Read reads from the chunk, failing with the context's error once it is done.
+func (cr *chunkReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.SectionReader.Read(p)
+}
+
+// Close closes the underlying file.
+func (cr *chunkReader) Close() error {
+	return cr.file.Close()
+}
+
+// GetChunkReader returns a reader for a specific chunk.
+// The reader is seekable within the chunk so that a failed part can be
+// retried from the beginning, and must be closed by the caller.
+func (fc *FileChunker) GetChunkReader(chunk Chunk) (io.ReadSeekCloser, error) {
+	return fc.GetChunkReaderContext(context.Background(), chunk)
+}
+
+// GetChunkReaderContext is like GetChunkReader, but reads fail once ctx is
+// done, so that a part being sent stops promptly when it is canceled.
+func (fc *FileChunker) GetChunkReaderContext(ctx context.Context, chunk Chunk) (io.ReadSeekCloser, error) {
 	file, err := os.Open(fc.filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file: %w", err)
 	}
-	// Seek to the start of the chunk
-	_, err = file.Seek(chunk.Offset, io.SeekStart)
-	if err != nil {
-		file.Close()
-		return nil, fmt.Errorf("failed to seek to chunk offset: %w", err)
+	// Limit the reader to the chunk's offset and size
+	return &chunkReader{
+		SectionReader: io.NewSectionReader(file, chunk.Offset, chunk.Size),
+		file:          file,
+		ctx:           ctx,
+	}, nil
+}
+
+// ChunkSize returns the chunk size used to split the file.
+func (fc *FileChunker) ChunkSize() int64 {
+	return fc.chunkSize
+}
+
+// Adjustment returns why the chunk size differs from the one requested, or
+// an empty string if the requested size was used.
+func (fc *FileChunker) Adjustment() string {
+	return fc.adjustment
+}
+
+// FileSize returns the size of the file at the time the chunker was created.
+func (fc *FileChunker) FileSize() int64 {
+	return fc.fileSize
+}
+
+// ForEach calls fn for every chunk using a pool of at most concurrency
+// workers. Workers stop picking up new chunks after the first failure, and
+// the first error encountered is returned once all in-flight calls finish.
+func ForEach(chunks []Chunk, concurrency int, fn func(Chunk) error) error {
+	return ForEachContext(context.Background(), chunks, concurrency, fn)
+}
+
+// ForEachContext is like ForEach, but also stops picking up new chunks once
+// ctx is done. Calls already running are left to finish; if no call failed,
+// ctx's error is returned when some chunks were never started.
+func ForEachContext(ctx context.Context, chunks []Chunk, concurrency int, fn func(Chunk) error) error {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	if concurrency > len(chunks) {
+		concurrency = len(chunks)
+	}
+
+	jobs := make(chan Chunk)
+	done := make(chan struct{})
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ch := range jobs {
+				if err := fn(ch); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						close(done)
+					})
+				}
+			}
+		}()
+	}
+
+	scheduled := 0
+schedule:
+	for _, ch := range chunks {
+		// Check for cancellation first, since select picks randomly among
+		// ready cases.
+		if ctx.Err() != nil {
+			break
+		}
+		select {
+		case jobs <- ch:
+			scheduled++
+		case <-done:
+			break schedule
+		case <-ctx.Done():
+			break schedule
+		}
 	}
-	// Return a limited reader to read only the chunk's size
-	return io.LimitReader(file, chunk.Size), nil
-}
\ No newline at end of file
+	close(jobs)
+	wg.Wait()
+
+	if firstErr == nil && scheduled < len(chunks) {
+		return ctx.Err()
+	}
+	return firstErr
+}
@@ -0,0 +1,108 @@
+package chunker
+
+import (
+	"fmt"
+	"testing"
+)
+
+const (
+	mib = 1024 * 1024
+	gib = 1024 * mib
+	tib = 1024 * gib
+)
+
+func TestValidatePartSize(t *testing.T) {
+	tests := []struct {
+		fileSize, partSize int64
+		ok                 bool
+	}{
+		{0, MinPartSize, true},
+		{10 * mib, MinPartSize, true},
+		{10 * mib, MinPartSize - 1, false},
+		// A single part may be smaller than the minimum.
+		{mib, mib, true},
+		{mib, MinPartSize - 1, true},
+		{10 * gib, MaxPartSize, true},
+		{10 * gib, MaxPartSize + 1, false},
+		{MaxParts * MinPartSize, MinPartSize, true},
+		{MaxParts*MinPartSize + 1, MinPartSize, false},
+		{MaxObjectSize, 525 * mib, true},
+		{MaxObjectSize + 1, MaxPartSize, false},
+		{10 * mib, 0, false},
+		{10 * mib, -1, false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d/%d", tt.fileSize, tt.partSize), func(t *testing.T) {
+			if err := ValidatePartSize(tt.fileSize, tt.partSize); (err == nil) != tt.ok {
+				t.Errorf("ValidatePartSize(%d, %d) = %v, want ok %v", tt.fileSize, tt.partSize, err, tt.ok)
+			}
+		})
+	}
+}
+
+func TestOptimalPartSize(t *testing.T) {
+	tests := []struct {
+		name               string
+		fileSize, partSize int64
+		want               int64
+		adjusted           bool
+	}{
+		{"valid", 100 * mib, 8 * mib, 8 * mib, false},
+		{"default", 100 * mib, 0, DefaultChunkSize, false},
+		{"below minimum", 100 * mib, mib, MinPartSize, true},
+		{"single small part", mib, mib, mib, false},
+		{"above maximum", 100 * gib, 6 * gib, MaxPartSize, true},
+		{"at part limit", MaxParts * MinPartSize, MinPartSize, MinPartSize, false},
+		// 10,001 parts of 5 MiB: the smallest whole MiB fitting 10,000 parts.
+		{"above part limit", MaxParts*MinPartSize + 1, MinPartSize, 6 * mib, true},
+		{"1 TiB", tib, 8 * mib, 105 * mib, true},
+		{"largest object", MaxObjectSize, MinPartSize, 525 * mib, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reason, err := OptimalPartSize(tt.fileSize, tt.partSize)
+			if err != nil {
+				t.Fatalf("OptimalPartSize: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("OptimalPartSize(%d, %d) = %d, want %d", tt.fileSize, tt.partSize, got, tt.want)
+			}
+			if (reason != "") != tt.adjusted {
+				t.Errorf("reason %q, want one %v", reason, tt.adjusted)
+			}
+			if err := ValidatePartSize(tt.fileSize, got); err != nil {
+				t.Errorf("part size %d is not valid: %v", got, err)
+			}
+		})
+	}
+	if _, _, err := OptimalPartSize(MaxObjectSize+1, MaxPartSize); err == nil {
+		t.Error("OptimalPartSize accepted an object larger than the maximum")
+	}
+}
+
+func TestChunksForSize(t *testing.T) {
+	tests := []struct {
+		size, chunkSize int64
+		want            []int64
+	}{
+		{0, 5, nil},
+		{5, 5, []int64{5}},
+		{12, 5, []int64{5, 5, 2}},
+		{15, 5, []int64{5, 5, 5}},
+	}
+	for _, tt := range tests {
+		chunks := ChunksForSize(tt.size, tt.chunkSize)
+		var sizes []int64
+		var offset int64
+		for i, c := range chunks {
+			if c.Index != i+1 || c.Offset != offset {
+				t.Errorf("chunk %d of %d bytes is part %d at offset %d", i, tt.size, c.Index, c.Offset)
+			}
+			sizes = append(sizes, c.Size)
+			offset += c.Size
+		}
+		if fmt.Sprint(sizes) != fmt.Sprint(tt.want) {
+			t.Errorf("ChunksForSize(%d, %d) has sizes %v, want %v", tt.size, tt.chunkSize, sizes, tt.want)
+		}
+	}
+}
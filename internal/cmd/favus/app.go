@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+
+	"github.com/yucori/Favus/internal/config"
+	"github.com/yucori/Favus/internal/encryption"
+	"github.com/yucori/Favus/internal/progress"
+	"github.com/yucori/Favus/internal/uploader"
+	"github.com/yucori/Favus/pkg/utils"
+)
+
+// app holds what the commands share. The configuration and everything
+// built from it are set up by setup, which commands that talk to the
+// storage run first, so that commands such as `favus config` or `favus
+// completion` work without a valid configuration.
+type app struct {
+	// Global flags
+	configFile   string
+	profile      string
+	progressMode string
+	output       string
+
+	cfg      *config.Config
+	logger   *utils.Logger
+	uploader *uploader.S3Uploader
+	keys     encryption.KeyProvider // Master key of client-side encryption; nil if off
+	ctx      context.Context        // Canceled on interrupt
+	bar      *progress.Bar          // Progress bar, in bar mode
+	progress progress.Func          // Receives upload progress; nil when off
+}
+
+// loadConfig loads the configuration with the setting flags given to cmd.
+func (a *app) loadConfig(cmd *cobra.Command) (*config.Config, error) {
+	opts := config.LoadOptions{File: a.configFile, Profile: a.profile, Flags: make(map[string]string)}
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		opts.Flags[f.Name] = f.Value.String()
+	})
+	return config.Load(opts)
+}
+
+// setup loads the configuration and sets up logging, progress reporting,
+// interrupt handling, the encryption key and the uploader. It is a PreRunE
+// of the commands that need them.
+func (a *app) setup(cmd *cobra.Command, args []string) error {
+	cfg, err := a.loadConfig(cmd)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	a.cfg = cfg
+
+	mode := a.progressMode
+	if mode == "auto" {
+		mode = "log"
+		if progress.IsTerminal(os.Stderr) {
+			mode = "bar"
+		}
+	}
+	logOptions := utils.LogOptions{Level: cfg.LogLevel, Format: cfg.LogFormat, File: cfg.LogFile}
+	switch mode {
+	case "bar":
+		// The bar shares stderr with the logs, which are written around
+		// it, so that stdout only has the result of the command.
+		a.bar = progress.NewBar(os.Stderr)
+		logOptions.Output = a.bar.Wrap(os.Stderr)
+	case "log", "off":
+	default:
+		return usageError{cmd, fmt.Errorf("invalid --progress flag: %s (use auto, bar, log or off)", a.progressMode)}
+	}
+	if a.logger, err = utils.NewLogger(logOptions); err != nil {
+		return fmt.Errorf("failed to set up logging: %w", err)
+	}
+	utils.SetDefault(a.logger)
+	switch mode {
+	case "bar":
+		a.progress = a.bar.Update
+	case "log":
+		a.progress = progress.NewLogReporter(a.logger, 0)
+	}
+
+	a.ctx = interruptContext(cfg.ShutdownGracePeriod)
+
+	if a.keys, err = encryption.NewKeyProviderFromConfig(cfg); err != nil {
+		return fmt.Errorf("failed to load encryption key: %w", err)
+	}
+
+	a.uploader, err = uploader.NewS3Uploader(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to initialize S3 uploader: %w", err)
+	}
+	a.uploader.Progress = a.progress
+	a.uploader.Keys = a.keys
+	return nil
+}
+
+// finishProgress ends the progress bar, if any, before the result of a
+// command is printed.
+func (a *app) finishProgress() {
+	if a.bar != nil {
+		a.bar.Finish()
+	}
+}
+
+// close releases what setup opened.
+func (a *app) close() {
+	if a.logger != nil {
+		a.logger.Close()
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/yucori/Favus/internal/config"
+)
+
+func newConfigCommand(a *app) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Show or validate the configuration",
+		Args:  checkArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return cmd.Help()
+		},
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "show",
+		Short: "Print the effective configuration and where each value comes from",
+		Args:  checkArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := a.loadConfig(cmd)
+			if a.output != outputText {
+				doc := newConfigDoc(cfg, err)
+				doc.Settings = []settingDoc{}
+				for _, v := range cfg.Values() {
+					doc.Settings = append(doc.Settings, settingDoc{Key: v.Key, Value: v.Value, Source: v.Source})
+				}
+				if err := a.printResult(doc); err != nil {
+					return err
+				}
+				return reportInvalidConfig(err)
+			}
+			if cfg.Profile != "" {
+				fmt.Printf("Profile: %s\n", cfg.Profile)
+			}
+			if len(cfg.Files) == 0 {
+				fmt.Println("Config files: none")
+			} else {
+				fmt.Printf("Config files: %s\n", strings.Join(cfg.Files, ", "))
+			}
+			fmt.Println()
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "SETTING\tVALUE\tSOURCE")
+			for _, v := range cfg.Values() {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", v.Key, v.Value, v.Source)
+			}
+			w.Flush()
+			return reportInvalidConfig(err)
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "validate",
+		Short: "Check the configuration, reporting every invalid value",
+		Args:  checkArgs(cobra.NoArgs),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := a.loadConfig(cmd)
+			if a.output != outputText {
+				if err := a.printResult(newConfigDoc(cfg, err)); err != nil {
+					return err
+				}
+				return reportInvalidConfig(err)
+			}
+			if err != nil {
+				return reportInvalidConfig(err)
+			}
+			fmt.Println("The configuration is valid.")
+			return nil
+		},
+	})
+	return cmd
+}
+
+// newConfigDoc returns the document of cfg, and of err, the error of
+// config.Load, without the settings.
+func newConfigDoc(cfg *config.Config, err error) configDoc {
+	doc := configDoc{Profile: cfg.Profile, Files: append([]string{}, cfg.Files...), Valid: err == nil, Errors: []string{}}
+	if err != nil {
+		doc.Errors = strings.Split(err.Error(), "\n")
+	}
+	return doc
+}
+
+// reportInvalidConfig prints the problems in err, the error of
+// config.Load, one per line.
+func reportInvalidConfig(err error) error {
+	if err == nil {
+		return nil
+	}
+	fmt.Fprintln(os.Stderr, "\nThe configuration is invalid:")
+	for _, line := range strings.Split(err.Error(), "\n") {
+		fmt.Fprintf(os.Stderr, "  %s\n", line)
+	}
+	return exitError(exitFailure)
+}
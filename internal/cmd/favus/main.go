@@ -1,87 +1,241 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 
-	"github.com/yucori/Favus/internal/config"   // Update with your actual module path
-	"github.com/yucori/Favus/internal/uploader" // Update with your actual module path
-	"github.com/yucori/Favus/pkg/utils"         // Update with your actual module path
+	"github.com/spf13/cobra"
+
+	"github.com/yucori/Favus/internal/config"
+	"github.com/yucori/Favus/internal/progress"
+	"github.com/yucori/Favus/internal/uploader"
+	"github.com/yucori/Favus/pkg/utils"
+)
+
+// Exit statuses.
+const (
+	exitFailure     = 1   // The command failed
+	exitUsage       = 2   // The command line is invalid
+	exitInterrupted = 130 // Interrupted, following the shell convention of 128 + SIGINT
 )
 
 func main() {
-	// logger := utils.NewLogger() // NewLogger가 더 이상 필요 없으므로 제거
+	os.Exit(run(os.Args[1:]))
+}
 
-	cfg, err := config.LoadConfig()
-	if err != nil {
-		utils.Fatal("Failed to load configuration: %v", err) // logger.Fatal 대신 utils.Fatal 사용
-	}
+// run runs the command line args and returns the exit status.
+func run(args []string) int {
+	a := &app{}
+	root := newRootCommand(a)
+	root.SetArgs(args)
+	status := exitStatus(root.Execute())
+	a.close()
+	return status
+}
 
-	s3Uploader, err := uploader.NewS3Uploader(cfg) // logger 인자 제거
-	if err != nil {
-		utils.Fatal("Failed to initialize S3 uploader: %v", err) // logger.Fatal 대신 utils.Fatal 사용
+func newRootCommand(a *app) *cobra.Command {
+	root := &cobra.Command{
+		Use:   "favus",
+		Short: "Upload large files to S3 with parallel, resumable multipart uploads",
+		Long: `Favus uploads large files to S3 or S3-compatible storage as parallel,
+resumable multipart uploads.
+
+Settings come from, in increasing order of precedence, the config files
+(` + config.UserConfigFile() + ` and the nearest ` + config.ProjectConfigFileName + `),
+environment variables (FAVUS_ and the setting in upper case, such as
+FAVUS_LOG_LEVEL) and flags. Run "favus config show" to see them.`,
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return nil
+			}
+			err := fmt.Errorf("unknown command %q", args[0])
+			if suggestions := cmd.SuggestionsFor(args[0]); len(suggestions) > 0 {
+				err = fmt.Errorf("%w (did you mean %s?)", err, strings.Join(suggestions, " or "))
+			}
+			return usageError{cmd, err}
+		},
+		// Without a command, print the help.
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.Help()
+			return exitError(exitUsage)
+		},
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			switch a.output {
+			case outputText, outputJSON, outputTable:
+				return nil
+			}
+			return usageError{cmd, fmt.Errorf("invalid --output flag: %s (use text, json or table)", a.output)}
+		},
+		SilenceErrors: true,
+		SilenceUsage:  true,
 	}
+	root.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return usageError{cmd, err}
+	})
 
-	if len(os.Args) < 2 {
-		fmt.Println("Usage: favus <command> [args...]")
-		fmt.Println("Commands:")
-		fmt.Println("  upload <local_file_path> <s3_key>")
-		fmt.Println("  delete <s3_key>")
-		fmt.Println("  resume <upload_status_file_path>")
-		fmt.Println("  list-uploads")
-		os.Exit(1)
+	flags := root.PersistentFlags()
+	flags.StringVar(&a.configFile, "config", "", "config file to use instead of the project's "+config.ProjectConfigFileName+" (overrides FAVUS_CONFIG)")
+	flags.StringVar(&a.profile, "profile", "", "config file profile to apply (overrides FAVUS_PROFILE)")
+	flags.StringVar(&a.progressMode, "progress", "auto", "upload progress: bar, log, off, or auto for a bar when stderr is a terminal and log otherwise")
+	flags.StringVarP(&a.output, "output", "o", outputText, "format of the result printed on stdout: text, json or table; logs and progress go to stderr")
+	root.MarkPersistentFlagFilename("config", "yaml", "yml")
+	root.RegisterFlagCompletionFunc("profile", cobra.NoFileCompletions)
+	root.RegisterFlagCompletionFunc("progress", cobra.FixedCompletions([]string{"auto", "bar", "log", "off"}, cobra.ShellCompDirectiveNoFileComp))
+	root.RegisterFlagCompletionFunc("output", cobra.FixedCompletions([]string{outputText, outputJSON, outputTable}, cobra.ShellCompDirectiveNoFileComp))
+	for _, f := range config.Flags() {
+		flags.String(f.Name, "", f.Usage)
+		if len(f.Values) > 0 {
+			root.RegisterFlagCompletionFunc(f.Name, cobra.FixedCompletions(f.Values, cobra.ShellCompDirectiveNoFileComp))
+		}
 	}
 
-	command := os.Args[1]
+	root.AddCommand(
+		newUploadCommand(a),
+		newUploadDirCommand(a),
+		newSyncCommand(a),
+		newDownloadCommand(a),
+		newDeleteCommand(a),
+		newVerifyCommand(a),
+		newResumeCommand(a),
+		newStatusCommand(a),
+		newListUploadsCommand(a),
+		newGCUploadsCommand(a),
+		newConfigCommand(a),
+	)
+	return root
+}
 
-	switch command {
-	case "upload":
-		if len(os.Args) != 4 {
-			utils.Fatal("Usage: favus upload <local_file_path> <s3_key>") // logger.Fatal 대신 utils.Fatal 사용
-		}
-		localFilePath := os.Args[2]
-		s3Key := os.Args[3]
-		if err := s3Uploader.UploadFile(localFilePath, s3Key); err != nil {
-			utils.Fatal("Upload failed: %v", err) // logger.Fatal 대신 utils.Fatal 사용
-		}
-		utils.Info("File uploaded successfully.") // logger.Info 대신 utils.Info 사용
-	case "delete":
-		if len(os.Args) != 3 {
-			utils.Fatal("Usage: favus delete <s3_key>") // logger.Fatal 대신 utils.Fatal 사용
-		}
-		s3Key := os.Args[2]
-		if err := s3Uploader.DeleteFile(s3Key); err != nil {
-			utils.Fatal("Deletion failed: %v", err) // logger.Fatal 대신 utils.Fatal 사용
-		}
-		utils.Info("File deleted successfully.") // logger.Info 대신 utils.Info 사용
-	case "resume":
-		if len(os.Args) != 3 {
-			utils.Fatal("Usage: favus resume <upload_status_file_path>") // logger.Fatal 대신 utils.Fatal 사용
-		}
-		statusFilePath := os.Args[2]
-		resumeUploader := uploader.NewResumeUploader(s3Uploader.S3Client) // logger 인자 제거
-		if err := resumeUploader.ResumeUpload(statusFilePath); err != nil {
-			utils.Fatal("Resume upload failed: %v", err) // logger.Fatal 대신 utils.Fatal 사용
-		}
-		utils.Info("Upload resumed and completed successfully.") // logger.Info 대신 utils.Info 사용
-	case "list-uploads":
-		if len(os.Args) != 2 {
-			utils.Fatal("Usage: favus list-uploads") // logger.Fatal 대신 utils.Fatal 사용
-		}
-		uploads, err := s3Uploader.ListMultipartUploads()
-		if err != nil {
-			utils.Fatal("Failed to list multipart uploads: %v", err) // logger.Fatal 대신 utils.Fatal 사용
-		}
-		if len(uploads) == 0 {
-			utils.Info("No ongoing multipart uploads found.") // logger.Info 대신 utils.Info 사용
-			return
+// usageError is an error in the command line of cmd.
+type usageError struct {
+	cmd *cobra.Command
+	err error
+}
+
+func (e usageError) Error() string {
+	return e.err.Error()
+}
+
+func (e usageError) Unwrap() error {
+	return e.err
+}
+
+// exitError makes the program exit with the given status. It is returned
+// by commands that have already reported why they failed.
+type exitError int
+
+func (e exitError) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
+
+// exitStatus reports err, the outcome of a command, and returns the exit
+// status for it.
+func exitStatus(err error) int {
+	var exit exitError
+	var usage usageError
+	switch {
+	case err == nil:
+		return 0
+	case errors.As(err, &exit):
+		return int(exit)
+	case errors.As(err, &usage):
+		fmt.Fprintf(os.Stderr, "Error: %v\nRun '%s --help' for usage.\n", usage.err, usage.cmd.CommandPath())
+		return exitUsage
+	}
+	utils.Error("%v", err)
+	return exitFailure
+}
+
+// checkArgs wraps an argument check so that its errors are usage errors.
+func checkArgs(check cobra.PositionalArgs) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		if err := check(cmd, args); err != nil {
+			return usageError{cmd, err}
 		}
-		utils.Info("Ongoing multipart uploads:") // logger.Info 대신 utils.Info 사용
-		for _, upload := range uploads {
-			utils.Info("  UploadID: %s, Key: %s, Initiated: %s", *upload.UploadId, *upload.Key, upload.Initiated.Format(time.RFC3339))
+		return nil
+	}
+}
+
+// argKind is what a positional argument names, for shell completion.
+type argKind int
+
+const (
+	argKey  argKind = iota // An object key, which is not completed
+	argFile                // A local file
+	argDir                 // A local directory
+)
+
+// completeArgs returns a completion function for commands whose positional
+// arguments are of the given kinds.
+func completeArgs(kinds ...argKind) func(*cobra.Command, []string, string) ([]string, cobra.ShellCompDirective) {
+	return func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		if len(args) < len(kinds) {
+			switch kinds[len(args)] {
+			case argFile:
+				return nil, cobra.ShellCompDirectiveDefault
+			case argDir:
+				return nil, cobra.ShellCompDirectiveFilterDirs
+			}
 		}
-	default:
-		utils.Fatal("Unknown command: %s", command) // logger.Fatal 대신 utils.Fatal 사용
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+}
+
+// interruptContext returns a context that is canceled on the first SIGINT
+// or SIGTERM, so that uploads stop starting new parts and give the parts
+// in flight up to grace to finish. A second signal exits immediately.
+func interruptContext(grace time.Duration) context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		// Restore the default behaviour so that another signal kills us.
+		signal.Reset(os.Interrupt, syscall.SIGTERM)
+		utils.Info("Interrupted: waiting up to %s for uploads in progress to finish (interrupt again to exit now)", grace)
+		cancel()
+	}()
+	return ctx
+}
+
+// printResumeCommand prints how to continue the upload if err says it was
+// interrupted, and reports whether it did. Like the logs, it goes to
+// stderr.
+func printResumeCommand(err error) bool {
+	var interrupted *uploader.InterruptedError
+	if !errors.As(err, &interrupted) {
+		return false
+	}
+	utils.Info("Progress saved to %s. To continue the upload, run:", interrupted.StatusFilePath)
+	fmt.Fprintf(os.Stderr, "  favus resume %s\n", shellQuote(interrupted.StatusFilePath))
+	return true
+}
+
+// confirm asks question on the terminal and reports whether the answer is
+// yes. Without a terminal to ask on, it returns false.
+func confirm(question string) bool {
+	if !progress.IsTerminal(os.Stdin) || !progress.IsTerminal(os.Stderr) {
+		return false
+	}
+	fmt.Fprintf(os.Stderr, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true
+	}
+	return false
+}
+
+// shellQuote quotes s for a POSIX shell if it contains special characters.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./:=@%+,") == "" {
+		return s
 	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
 }
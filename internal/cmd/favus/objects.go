@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/yucori/Favus/internal/downloader"
+	"github.com/yucori/Favus/internal/uploader"
+	"github.com/yucori/Favus/internal/verifier"
+	"github.com/yucori/Favus/pkg/utils"
+)
+
+func newDownloadCommand(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:               "download <s3_key> <local_path>",
+		Short:             "Download an object in parallel byte ranges",
+		Args:              checkArgs(cobra.ExactArgs(2)),
+		ValidArgsFunction: completeArgs(argKey, argFile),
+		PreRunE:           a.setup,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			s3Key, localPath := args[0], args[1]
+			fileDownloader := downloader.NewDownloader(a.cfg, a.uploader.Store)
+			fileDownloader.Keys = a.keys
+			start := time.Now()
+			if err := fileDownloader.DownloadFile(a.ctx, s3Key, localPath); err != nil {
+				return fmt.Errorf("download failed: %w", err)
+			}
+			elapsed := time.Since(start)
+			utils.Info("File downloaded successfully.")
+
+			// A directory gets the file under the base name of the key.
+			info, err := os.Stat(localPath)
+			if err == nil && info.IsDir() {
+				localPath = filepath.Join(localPath, path.Base(s3Key))
+				info, err = os.Stat(localPath)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to stat downloaded file: %w", err)
+			}
+			doc := downloadDoc{Key: s3Key, Path: localPath, Size: info.Size(), DurationSeconds: seconds(elapsed)}
+			if elapsed > 0 {
+				doc.BytesPerSecond = int64(float64(info.Size()) / elapsed.Seconds())
+			}
+			return a.printResult(doc)
+		},
+	}
+}
+
+func newDeleteCommand(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:               "delete <s3_key>",
+		Short:             "Delete an object",
+		Args:              checkArgs(cobra.ExactArgs(1)),
+		ValidArgsFunction: completeArgs(argKey),
+		PreRunE:           a.setup,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := a.uploader.DeleteFile(args[0]); err != nil {
+				return fmt.Errorf("deletion failed: %w", err)
+			}
+			utils.Info("File deleted successfully.") // logger.Info 대신 utils.Info 사용
+			return a.printResult(deleteDoc{Key: args[0], Deleted: true})
+		},
+	}
+}
+
+func newVerifyCommand(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "verify <local_file_path> <s3_key>",
+		Short: "Check that a local file matches a stored object",
+		Long: `Check that a local file matches a stored object by size, ETag and, if the
+object has them, its checksums. Exits with status 1 on a mismatch.`,
+		Args:              checkArgs(cobra.ExactArgs(2)),
+		ValidArgsFunction: completeArgs(argFile, argKey),
+		PreRunE:           a.setup,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			fileVerifier := verifier.NewVerifier(a.cfg, a.uploader.Store)
+			fileVerifier.Keys = a.keys
+			result, err := fileVerifier.VerifyFile(a.ctx, args[0], args[1])
+			if err != nil {
+				return fmt.Errorf("verification failed: %w", err)
+			}
+			if a.output == outputText {
+				result.WriteReport(os.Stdout)
+			} else if err := a.printResult(newVerifyDoc(result)); err != nil {
+				return err
+			}
+			if !result.OK() {
+				return exitError(exitFailure)
+			}
+			utils.Info("File matches the stored object.")
+			return nil
+		},
+	}
+}
+
+func newListUploadsCommand(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:     "list-uploads",
+		Short:   "List the multipart uploads in progress",
+		Args:    checkArgs(cobra.NoArgs),
+		PreRunE: a.setup,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			uploads, err := a.uploader.ListMultipartUploads()
+			if err != nil {
+				return fmt.Errorf("failed to list multipart uploads: %w", err)
+			}
+			if a.output != outputText {
+				return a.printResult(newUploadsDoc(uploads))
+			}
+			if len(uploads) == 0 {
+				utils.Info("No ongoing multipart uploads found.") // logger.Info 대신 utils.Info 사용
+				return nil
+			}
+			return printTable(newUploadsDoc(uploads))
+		},
+	}
+}
+
+func newGCUploadsCommand(a *app) *cobra.Command {
+	var opts uploader.GCOptions
+	cmd := &cobra.Command{
+		Use:   "gc-uploads",
+		Short: "Abort stale multipart uploads and reclaim the storage of their parts",
+		Long: `Abort the multipart uploads started longer ago than --older-than, which
+would otherwise keep their parts, and the storage they take, forever.
+Uploads are listed in full; their parts are listed to report the storage
+reclaimed. Uploads that a running upload or resume is working on are kept,
+and the status files of the uploads aborted are removed.`,
+		Example: `  favus gc-uploads --dry-run
+  favus gc-uploads --older-than 72h --prefix backups/`,
+		Args:    checkArgs(cobra.NoArgs),
+		PreRunE: a.setup,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.OlderThan < 0 {
+				return usageError{cmd, fmt.Errorf("invalid --older-than flag: %s must not be negative", opts.OlderThan)}
+			}
+			result, err := a.uploader.GCUploads(a.ctx, opts)
+			if err != nil {
+				return fmt.Errorf("cleanup failed: %w", err)
+			}
+			if result.DryRun && a.output == outputText {
+				for _, s := range result.Stale {
+					fmt.Printf("(dry run) abort %s %s (initiated %s, %d parts, %d bytes)\n", s.Key, s.UploadID, s.Initiated.Format(time.RFC3339), s.Parts, s.Size)
+				}
+			}
+			verb := "Aborted"
+			if result.DryRun {
+				verb = "Would abort"
+			}
+			utils.Info("%s %d uploads, reclaiming %d bytes; %d kept, %d failed.", verb, len(result.Stale)-len(result.Failed()), result.Reclaimed(), result.Kept, len(result.Failed()))
+			if err := a.printResult(newGCDoc(result)); err != nil {
+				return err
+			}
+			if a.ctx.Err() != nil {
+				return exitError(exitInterrupted)
+			}
+			if len(result.Failed()) > 0 {
+				return exitError(exitFailure)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().DurationVar(&opts.OlderThan, "older-than", 24*time.Hour, "only abort uploads initiated at least this long ago")
+	cmd.Flags().StringVar(&opts.Prefix, "prefix", "", "only abort uploads of keys starting with this prefix")
+	cmd.Flags().StringVar(&opts.Initiator, "initiator", "", "only abort uploads started by this principal (ID, ARN or display name)")
+	cmd.Flags().BoolVar(&opts.DryRun, "dry-run", false, "print the uploads that would be aborted without aborting them")
+	cmd.Flags().IntVar(&opts.Jobs, "jobs", uploader.DefaultJobs, "number of uploads to inspect and abort in parallel")
+	cmd.RegisterFlagCompletionFunc("older-than", cobra.NoFileCompletions)
+	cmd.RegisterFlagCompletionFunc("prefix", cobra.NoFileCompletions)
+	cmd.RegisterFlagCompletionFunc("initiator", cobra.NoFileCompletions)
+	return cmd
+}
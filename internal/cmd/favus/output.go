@@ -0,0 +1,489 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yucori/Favus/internal/storage"
+	"github.com/yucori/Favus/internal/syncer"
+	"github.com/yucori/Favus/internal/uploader"
+	"github.com/yucori/Favus/internal/verifier"
+)
+
+// Output formats of command results, selected with --output.
+const (
+	outputText  = "text"  // What the command logs, and any report or listing it prints
+	outputJSON  = "json"  // A JSON document
+	outputTable = "table" // A table with a header line
+)
+
+// document is the result of a command, printed on stdout with --output
+// json or table. Its JSON encoding is an interface for scripts: fields may
+// be added, but are not renamed or removed.
+type document interface {
+	// table returns the header and rows of the result as a table.
+	table() (header []string, rows [][]string)
+}
+
+// printResult prints doc on stdout in the json and table formats. In the
+// text format the log lines of a command are its output, so nothing is
+// printed; commands whose result is a listing print it with printTable
+// instead.
+func (a *app) printResult(doc document) error {
+	switch a.output {
+	case outputJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(doc)
+	case outputTable:
+		return printTable(doc)
+	}
+	return nil
+}
+
+// printTable prints doc on stdout as a table with a header line, its
+// columns aligned with spaces.
+func printTable(doc document) error {
+	header, rows := doc.table()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+// seconds returns d in seconds, rounded to the millisecond.
+func seconds(d time.Duration) float64 {
+	return d.Round(time.Millisecond).Seconds()
+}
+
+// statusFile returns the status file of an interrupted upload that failed
+// with err, or an empty string.
+func statusFile(err error) string {
+	var interrupted *uploader.InterruptedError
+	if errors.As(err, &interrupted) {
+		return interrupted.StatusFilePath
+	}
+	return ""
+}
+
+// errorString returns the message of err, or an empty string if it is nil.
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// uploadDoc is the result of `favus upload` and `favus resume`.
+type uploadDoc struct {
+	File            string  `json:"file"` // "-" for the standard input
+	Key             string  `json:"key"`
+	Location        string  `json:"location"`
+	UploadID        string  `json:"upload_id"`
+	Size            int64   `json:"size"`
+	Sent            int64   `json:"sent"` // Less than size for a resumed upload
+	Parts           int     `json:"parts"`
+	ETag            string  `json:"etag"`
+	Checksum        string  `json:"checksum,omitempty"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	BytesPerSecond  int64   `json:"bytes_per_second"`
+}
+
+func newUploadDoc(r *uploader.UploadResult, location string) uploadDoc {
+	return uploadDoc{
+		File:            r.File,
+		Key:             r.Key,
+		Location:        location,
+		UploadID:        r.UploadID,
+		Size:            r.Size,
+		Sent:            r.Sent,
+		Parts:           r.Parts,
+		ETag:            r.ETag,
+		Checksum:        r.Checksum,
+		DurationSeconds: seconds(r.Duration),
+		BytesPerSecond:  int64(r.Throughput()),
+	}
+}
+
+func (d uploadDoc) table() ([]string, [][]string) {
+	return []string{"KEY", "SIZE", "PARTS", "ETAG", "DURATION", "BYTES/S", "UPLOAD ID"},
+		[][]string{{d.Key, itoa(d.Size), strconv.Itoa(d.Parts), d.ETag, fmt.Sprintf("%.3fs", d.DurationSeconds), itoa(d.BytesPerSecond), d.UploadID}}
+}
+
+// fileDoc is the outcome of uploading one file of a directory.
+type fileDoc struct {
+	Path       string `json:"path"`
+	Key        string `json:"key"`
+	Size       int64  `json:"size"`
+	Multipart  bool   `json:"multipart"`
+	Error      string `json:"error,omitempty"`
+	StatusFile string `json:"status_file,omitempty"` // To resume an interrupted multipart upload
+}
+
+// uploadDirDoc is the result of `favus upload-dir`.
+type uploadDirDoc struct {
+	Uploaded []fileDoc `json:"uploaded"`
+	Failed   []fileDoc `json:"failed"`
+	Bytes    int64     `json:"bytes"` // Total size of the uploaded files
+}
+
+func newUploadDirDoc(r *uploader.DirResult) uploadDirDoc {
+	doc := uploadDirDoc{Uploaded: []fileDoc{}, Failed: []fileDoc{}, Bytes: r.Bytes}
+	for _, f := range r.Uploaded {
+		doc.Uploaded = append(doc.Uploaded, fileDoc{Path: f.Path, Key: f.Key, Size: f.Size, Multipart: f.Multipart})
+	}
+	for _, f := range r.Failed {
+		doc.Failed = append(doc.Failed, fileDoc{Path: f.Path, Key: f.Key, Size: f.Size, Multipart: f.Multipart, Error: errorString(f.Err), StatusFile: statusFile(f.Err)})
+	}
+	return doc
+}
+
+func (d uploadDirDoc) table() ([]string, [][]string) {
+	var rows [][]string
+	for _, f := range d.Uploaded {
+		rows = append(rows, []string{f.Path, f.Key, itoa(f.Size), "uploaded"})
+	}
+	for _, f := range d.Failed {
+		rows = append(rows, []string{f.Path, f.Key, itoa(f.Size), "failed: " + f.Error})
+	}
+	return []string{"PATH", "KEY", "SIZE", "RESULT"}, rows
+}
+
+// syncActionDoc is an action of a sync.
+type syncActionDoc struct {
+	Action     string `json:"action"` // upload, update or delete
+	Path       string `json:"path,omitempty"`
+	Key        string `json:"key"`
+	Size       int64  `json:"size"`
+	Reason     string `json:"reason"`
+	Error      string `json:"error,omitempty"`
+	StatusFile string `json:"status_file,omitempty"`
+}
+
+// syncDoc is the result of `favus sync`.
+type syncDoc struct {
+	DryRun    bool            `json:"dry_run"`
+	Actions   []syncActionDoc `json:"actions"`
+	Uploaded  int             `json:"uploaded"`
+	Updated   int             `json:"updated"`
+	Deleted   int             `json:"deleted"`
+	Unchanged int             `json:"unchanged"`
+	Failed    int             `json:"failed"`
+}
+
+func newSyncDoc(r *syncer.Result) syncDoc {
+	doc := syncDoc{
+		DryRun:    r.DryRun,
+		Actions:   []syncActionDoc{},
+		Uploaded:  r.Count(syncer.ActionUpload),
+		Updated:   r.Count(syncer.ActionUpdate),
+		Deleted:   r.Count(syncer.ActionDelete),
+		Unchanged: r.Unchanged,
+		Failed:    len(r.Failed()),
+	}
+	for _, a := range r.Actions {
+		doc.Actions = append(doc.Actions, syncActionDoc{
+			Action:     string(a.Kind),
+			Path:       a.Path,
+			Key:        a.Key,
+			Size:       a.Size,
+			Reason:     a.Reason,
+			Error:      errorString(a.Err),
+			StatusFile: statusFile(a.Err),
+		})
+	}
+	return doc
+}
+
+func (d syncDoc) table() ([]string, [][]string) {
+	var rows [][]string
+	for _, a := range d.Actions {
+		result := "done"
+		switch {
+		case d.DryRun:
+			result = "dry run"
+		case a.Error != "":
+			result = "failed: " + a.Error
+		}
+		rows = append(rows, []string{a.Action, a.Key, itoa(a.Size), a.Reason, result})
+	}
+	return []string{"ACTION", "KEY", "SIZE", "REASON", "RESULT"}, rows
+}
+
+// downloadDoc is the result of `favus download`.
+type downloadDoc struct {
+	Key             string  `json:"key"`
+	Path            string  `json:"path"`
+	Size            int64   `json:"size"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	BytesPerSecond  int64   `json:"bytes_per_second"`
+}
+
+func (d downloadDoc) table() ([]string, [][]string) {
+	return []string{"KEY", "PATH", "SIZE", "DURATION", "BYTES/S"},
+		[][]string{{d.Key, d.Path, itoa(d.Size), fmt.Sprintf("%.3fs", d.DurationSeconds), itoa(d.BytesPerSecond)}}
+}
+
+// deleteDoc is the result of `favus delete`.
+type deleteDoc struct {
+	Key     string `json:"key"`
+	Deleted bool   `json:"deleted"`
+}
+
+func (d deleteDoc) table() ([]string, [][]string) {
+	return []string{"KEY", "DELETED"}, [][]string{{d.Key, strconv.FormatBool(d.Deleted)}}
+}
+
+// verifyPartDoc is a part that differs between a file and an object.
+type verifyPartDoc struct {
+	PartNumber int    `json:"part_number"`
+	Offset     int64  `json:"offset"`
+	LocalSize  int64  `json:"local_size"`
+	RemoteSize int64  `json:"remote_size"`
+	Local      string `json:"local"`
+	Remote     string `json:"remote"`
+}
+
+// verifyDoc is the result of `favus verify`.
+type verifyDoc struct {
+	OK                bool            `json:"ok"`
+	LocalPath         string          `json:"local_path"`
+	Key               string          `json:"key"`
+	Location          string          `json:"location"`
+	LocalSize         int64           `json:"local_size"`
+	RemoteSize        int64           `json:"remote_size"`
+	PartSize          int64           `json:"part_size"`
+	LocalETag         string          `json:"local_etag"`
+	RemoteETag        string          `json:"remote_etag"`
+	ChecksumAlgorithm string          `json:"checksum_algorithm,omitempty"`
+	LocalChecksum     string          `json:"local_checksum,omitempty"`
+	RemoteChecksum    string          `json:"remote_checksum,omitempty"`
+	DigestAlgorithm   string          `json:"digest_algorithm,omitempty"`
+	Parts             []verifyPartDoc `json:"parts"` // Parts that differ
+	Mismatches        []string        `json:"mismatches"`
+	Notes             []string        `json:"notes"`
+}
+
+func newVerifyDoc(r *verifier.Result) verifyDoc {
+	doc := verifyDoc{
+		OK:                r.OK(),
+		LocalPath:         r.LocalPath,
+		Key:               r.Key,
+		Location:          r.Location,
+		LocalSize:         r.LocalSize,
+		RemoteSize:        r.RemoteSize,
+		PartSize:          r.PartSize,
+		LocalETag:         r.LocalETag,
+		RemoteETag:        r.RemoteETag,
+		ChecksumAlgorithm: string(r.ChecksumAlgorithm),
+		LocalChecksum:     r.LocalChecksum,
+		RemoteChecksum:    r.RemoteChecksum,
+		DigestAlgorithm:   string(r.DigestAlgorithm),
+		Parts:             []verifyPartDoc{},
+		Mismatches:        append([]string{}, r.Mismatches...),
+		Notes:             append([]string{}, r.Notes...),
+	}
+	for _, p := range r.Parts {
+		doc.Parts = append(doc.Parts, verifyPartDoc(p))
+	}
+	return doc
+}
+
+func (d verifyDoc) table() ([]string, [][]string) {
+	result := "OK"
+	if !d.OK {
+		result = "MISMATCH: " + strings.Join(d.Mismatches, "; ")
+	}
+	return []string{"PATH", "KEY", "SIZE", "ETAG", "RESULT"},
+		[][]string{{d.LocalPath, d.Key, itoa(d.LocalSize), d.LocalETag, result}}
+}
+
+// multipartUploadDoc is an upload listed by `favus list-uploads`.
+type multipartUploadDoc struct {
+	Key       string `json:"key"`
+	UploadID  string `json:"upload_id"`
+	Initiated string `json:"initiated"` // RFC 3339
+}
+
+// uploadsDoc is the result of `favus list-uploads`.
+type uploadsDoc []multipartUploadDoc
+
+func newUploadsDoc(uploads []storage.MultipartUpload) uploadsDoc {
+	doc := uploadsDoc{}
+	for _, u := range uploads {
+		doc = append(doc, multipartUploadDoc{Key: u.Key, UploadID: u.UploadID, Initiated: u.Initiated.UTC().Format(time.RFC3339)})
+	}
+	return doc
+}
+
+func (d uploadsDoc) table() ([]string, [][]string) {
+	var rows [][]string
+	for _, u := range d {
+		rows = append(rows, []string{u.Key, u.UploadID, u.Initiated})
+	}
+	return []string{"KEY", "UPLOAD ID", "INITIATED"}, rows
+}
+
+// trackedUploadDoc is an upload listed by `favus status`.
+type trackedUploadDoc struct {
+	StatusFile     string  `json:"status_file"`
+	File           string  `json:"file,omitempty"`
+	Bucket         string  `json:"bucket,omitempty"`
+	Key            string  `json:"key,omitempty"`
+	UploadID       string  `json:"upload_id,omitempty"`
+	CompletedParts int     `json:"completed_parts"`
+	TotalParts     int     `json:"total_parts"`
+	CompletedBytes int64   `json:"completed_bytes"`
+	Size           int64   `json:"size"`
+	Percent        float64 `json:"percent"`
+	Updated        string  `json:"updated"`         // RFC 3339
+	Active         bool    `json:"active"`          // In progress in another process
+	Error          string  `json:"error,omitempty"` // Why the status file cannot be read
+}
+
+// statusDoc is the result of `favus status`.
+type statusDoc []trackedUploadDoc
+
+func newStatusDoc(uploads []uploader.TrackedUpload) statusDoc {
+	doc := statusDoc{}
+	for _, t := range uploads {
+		d := trackedUploadDoc{
+			StatusFile: t.StatusFilePath,
+			Updated:    t.Updated.UTC().Format(time.RFC3339),
+			Active:     t.Active,
+			Error:      errorString(t.Err),
+		}
+		if s := t.Status; s != nil {
+			d.File = s.FilePath
+			d.Bucket = s.Bucket
+			d.Key = s.Key
+			d.UploadID = s.UploadID
+			d.CompletedParts = len(s.CompletedParts)
+			d.TotalParts = s.TotalParts
+			d.CompletedBytes = t.CompletedBytes()
+			d.Size = s.FileSize
+			if s.FileSize > 0 {
+				d.Percent = float64(d.CompletedBytes*1000/s.FileSize) / 10
+			}
+		}
+		doc = append(doc, d)
+	}
+	return doc
+}
+
+func (d statusDoc) table() ([]string, [][]string) {
+	var rows [][]string
+	for _, t := range d {
+		state := "interrupted"
+		switch {
+		case t.Error != "":
+			state = "unreadable: " + t.Error
+		case t.Active:
+			state = "active"
+		}
+		rows = append(rows, []string{t.File, t.Key, t.UploadID, fmt.Sprintf("%d/%d", t.CompletedParts, t.TotalParts), fmt.Sprintf("%.1f%%", t.Percent), t.Updated, state, t.StatusFile})
+	}
+	return []string{"FILE", "KEY", "UPLOAD ID", "PARTS", "DONE", "UPDATED", "STATE", "STATUS FILE"}, rows
+}
+
+// staleUploadDoc is an upload selected by `favus gc-uploads`.
+type staleUploadDoc struct {
+	Key        string  `json:"key"`
+	UploadID   string  `json:"upload_id"`
+	Initiated  string  `json:"initiated"` // RFC 3339
+	Initiator  string  `json:"initiator,omitempty"`
+	AgeSeconds float64 `json:"age_seconds"`
+	Parts      int     `json:"parts"`
+	Size       int64   `json:"size"` // Total size of the parts
+	Error      string  `json:"error,omitempty"`
+}
+
+// gcDoc is the result of `favus gc-uploads`.
+type gcDoc struct {
+	DryRun         bool             `json:"dry_run"`
+	Uploads        []staleUploadDoc `json:"uploads"` // Aborted, or to abort in a dry run
+	Kept           int              `json:"kept"`
+	Active         int              `json:"active"` // Kept because a running upload holds them
+	Failed         int              `json:"failed"`
+	ReclaimedBytes int64            `json:"reclaimed_bytes"`
+}
+
+func newGCDoc(r *uploader.GCResult) gcDoc {
+	doc := gcDoc{DryRun: r.DryRun, Uploads: []staleUploadDoc{}, Kept: r.Kept, Active: r.Active, Failed: len(r.Failed()), ReclaimedBytes: r.Reclaimed()}
+	for _, s := range r.Stale {
+		initiator := s.InitiatorName
+		if initiator == "" {
+			initiator = s.InitiatorID
+		}
+		doc.Uploads = append(doc.Uploads, staleUploadDoc{
+			Key:        s.Key,
+			UploadID:   s.UploadID,
+			Initiated:  s.Initiated.UTC().Format(time.RFC3339),
+			Initiator:  initiator,
+			AgeSeconds: s.Age.Round(time.Second).Seconds(),
+			Parts:      s.Parts,
+			Size:       s.Size,
+			Error:      errorString(s.Err),
+		})
+	}
+	return doc
+}
+
+func (d gcDoc) table() ([]string, [][]string) {
+	var rows [][]string
+	for _, u := range d.Uploads {
+		result := "aborted"
+		switch {
+		case u.Error != "":
+			result = "failed: " + u.Error
+		case d.DryRun:
+			result = "dry run"
+		}
+		rows = append(rows, []string{u.Key, u.UploadID, u.Initiated, strconv.Itoa(u.Parts), itoa(u.Size), result})
+	}
+	return []string{"KEY", "UPLOAD ID", "INITIATED", "PARTS", "SIZE", "RESULT"}, rows
+}
+
+// settingDoc is a setting shown by `favus config show`.
+type settingDoc struct {
+	Key    string `json:"key"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+// configDoc is the result of `favus config show` and `favus config
+// validate`.
+type configDoc struct {
+	Profile  string       `json:"profile,omitempty"`
+	Files    []string     `json:"files"`
+	Settings []settingDoc `json:"settings,omitempty"` // Only shown by `config show`
+	Valid    bool         `json:"valid"`
+	Errors   []string     `json:"errors"`
+}
+
+func (d configDoc) table() ([]string, [][]string) {
+	if d.Settings == nil {
+		var rows [][]string
+		for _, e := range d.Errors {
+			rows = append(rows, []string{e})
+		}
+		return []string{"ERROR"}, rows
+	}
+	var rows [][]string
+	for _, s := range d.Settings {
+		rows = append(rows, []string{s.Key, s.Value, s.Source})
+	}
+	return []string{"SETTING", "VALUE", "SOURCE"}, rows
+}
+
+func itoa(n int64) string {
+	return strconv.FormatInt(n, 10)
+}
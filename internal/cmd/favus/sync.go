@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/yucori/Favus/internal/syncer"
+	"github.com/yucori/Favus/internal/uploader"
+	"github.com/yucori/Favus/pkg/utils"
+)
+
+func newSyncCommand(a *app) *cobra.Command {
+	var (
+		walk          walkFlags
+		compare       string
+		deleteOrphans bool
+		dryRun        bool
+		jobs          int
+	)
+	cmd := &cobra.Command{
+		Use:   "sync <local_dir> <s3_prefix>",
+		Short: "Upload the new and changed files of a directory under a prefix",
+		Long: `Compare a directory with the objects under a prefix and upload the files
+that are new or changed. With --delete, objects without a local file are
+deleted as well.`,
+		Example: `  favus sync --dry-run site/ www/
+  favus sync --compare etag --delete site/ www/`,
+		Args:              checkArgs(cobra.ExactArgs(2)),
+		ValidArgsFunction: completeArgs(argDir, argKey),
+		PreRunE:           a.setup,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			walkOptions, err := walk.options(cmd)
+			if err != nil {
+				return err
+			}
+			mode, err := syncer.ParseCompareMode(compare)
+			if err != nil {
+				return usageError{cmd, fmt.Errorf("invalid --compare flag: %w", err)}
+			}
+			opts := syncer.Options{
+				Options: walkOptions,
+				Compare: mode,
+				Delete:  deleteOrphans,
+				DryRun:  dryRun,
+				Jobs:    jobs,
+			}
+			s := syncer.NewSyncer(a.cfg, a.uploader.Store)
+			s.Uploader.Progress = a.progress
+			s.Uploader.Keys = a.keys
+			s.Verifier.Keys = a.keys
+			result, err := s.Sync(a.ctx, args[0], args[1], opts)
+			a.finishProgress()
+			if err != nil {
+				return fmt.Errorf("sync failed: %w", err)
+			}
+			for _, action := range result.Actions {
+				switch {
+				case result.DryRun && a.output == outputText:
+					fmt.Printf("(dry run) %s %s (%s)\n", action.Kind, action.Key, action.Reason)
+				case action.Err != nil:
+					utils.Error("Failed to %s %s: %v", action.Kind, action.Key, action.Err)
+					printResumeCommand(action.Err)
+				}
+			}
+			utils.Info("%d new, %d changed, %d deleted, %d unchanged, %d failed.", result.Count(syncer.ActionUpload), result.Count(syncer.ActionUpdate), result.Count(syncer.ActionDelete), result.Unchanged, len(result.Failed()))
+			if err := a.printResult(newSyncDoc(result)); err != nil {
+				return err
+			}
+			if a.ctx.Err() != nil {
+				return exitError(exitInterrupted)
+			}
+			if len(result.Failed()) > 0 {
+				return exitError(exitFailure)
+			}
+			return nil
+		},
+	}
+	walk.register(cmd, "sync")
+	cmd.Flags().StringVar(&compare, "compare", "mtime", "how to detect changed files: size, mtime or etag")
+	cmd.Flags().BoolVar(&deleteOrphans, "delete", false, "delete objects under the prefix that have no local file")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the planned actions without changing anything")
+	cmd.Flags().IntVar(&jobs, "jobs", uploader.DefaultJobs, "number of files to compare or upload in parallel")
+	cmd.RegisterFlagCompletionFunc("compare", cobra.FixedCompletions([]string{"size", "mtime", "etag"}, cobra.ShellCompDirectiveNoFileComp))
+	return cmd
+}
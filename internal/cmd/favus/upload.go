@@ -0,0 +1,245 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/yucori/Favus/internal/uploader"
+	"github.com/yucori/Favus/internal/walker"
+	"github.com/yucori/Favus/pkg/utils"
+)
+
+func newUploadCommand(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "upload <local_file_path|-> <s3_key>",
+		Short: "Upload a file, or the standard input, as a multipart upload",
+		Long: `Upload a file as a parallel multipart upload. If it is interrupted, the
+upload can be continued with "favus resume".
+
+With - as the file, the standard input is streamed until it ends. A stream
+cannot be resumed.`,
+		Example: `  favus upload backup.tar backups/backup.tar
+  tar c dir | favus upload - backups/dir.tar`,
+		Args:              checkArgs(cobra.ExactArgs(2)),
+		ValidArgsFunction: completeArgs(argFile, argKey),
+		PreRunE:           a.setup,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			localFilePath, s3Key := args[0], args[1]
+			var result *uploader.UploadResult
+			var err error
+			if localFilePath == "-" {
+				result, err = a.uploader.UploadStream(a.ctx, os.Stdin, s3Key)
+			} else {
+				result, err = a.uploader.UploadFile(a.ctx, localFilePath, s3Key)
+			}
+			a.finishProgress()
+			if err != nil {
+				if printResumeCommand(err) {
+					return exitError(exitInterrupted)
+				}
+				if a.ctx.Err() != nil {
+					utils.Error("Upload interrupted: %v", err)
+					return exitError(exitInterrupted)
+				}
+				return fmt.Errorf("upload failed: %w", err)
+			}
+			utils.Info("File uploaded successfully.") // logger.Info 대신 utils.Info 사용
+			return a.printResult(newUploadDoc(result, a.uploader.Store.Location(result.Key)))
+		},
+	}
+}
+
+// walkFlags are the flags selecting the files of a directory.
+type walkFlags struct {
+	include, exclude []string
+	symlinks         string
+}
+
+func (w *walkFlags) register(cmd *cobra.Command, verb string) {
+	cmd.Flags().StringArrayVar(&w.include, "include", nil, "only "+verb+" files matching this glob (repeatable; ** matches any number of directories)")
+	cmd.Flags().StringArrayVar(&w.exclude, "exclude", nil, "skip files and directories matching this glob (repeatable)")
+	cmd.Flags().StringVar(&w.symlinks, "symlinks", "skip", "what to do with symbolic links: skip or follow")
+	cmd.RegisterFlagCompletionFunc("include", cobra.NoFileCompletions)
+	cmd.RegisterFlagCompletionFunc("exclude", cobra.NoFileCompletions)
+	cmd.RegisterFlagCompletionFunc("symlinks", cobra.FixedCompletions([]string{"skip", "follow"}, cobra.ShellCompDirectiveNoFileComp))
+}
+
+// options returns the walker options selected by the flags.
+func (w *walkFlags) options(cmd *cobra.Command) (walker.Options, error) {
+	policy, err := walker.ParseSymlinkPolicy(w.symlinks)
+	if err != nil {
+		return walker.Options{}, usageError{cmd, fmt.Errorf("invalid --symlinks flag: %w", err)}
+	}
+	return walker.Options{Include: w.include, Exclude: w.exclude, Symlinks: policy}, nil
+}
+
+func newUploadDirCommand(a *app) *cobra.Command {
+	var walk walkFlags
+	var jobs int
+	cmd := &cobra.Command{
+		Use:   "upload-dir <local_dir> <s3_prefix>",
+		Short: "Upload the files of a directory under a prefix",
+		Example: `  favus upload-dir --exclude '**/*.tmp' photos backups/photos
+  favus upload-dir --include '**/*.log' --jobs 8 /var/log logs/`,
+		Args:              checkArgs(cobra.ExactArgs(2)),
+		ValidArgsFunction: completeArgs(argDir, argKey),
+		PreRunE:           a.setup,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			walkOptions, err := walk.options(cmd)
+			if err != nil {
+				return err
+			}
+			opts := uploader.DirOptions{Options: walkOptions, Jobs: jobs}
+			result, err := a.uploader.UploadDir(a.ctx, args[0], args[1], opts)
+			a.finishProgress()
+			if err != nil {
+				return fmt.Errorf("directory upload failed: %w", err)
+			}
+			utils.Info("Uploaded %d files (%d bytes), %d failed.", len(result.Uploaded), result.Bytes, len(result.Failed))
+			for _, f := range result.Failed {
+				utils.Error("  %s -> %s: %v", f.Path, f.Key, f.Err)
+				printResumeCommand(f.Err)
+			}
+			if err := a.printResult(newUploadDirDoc(result)); err != nil {
+				return err
+			}
+			if a.ctx.Err() != nil {
+				return exitError(exitInterrupted)
+			}
+			if len(result.Failed) > 0 {
+				return exitError(exitFailure)
+			}
+			return nil
+		},
+	}
+	walk.register(cmd, "upload")
+	cmd.Flags().IntVar(&jobs, "jobs", uploader.DefaultJobs, "number of files to upload in parallel")
+	return cmd
+}
+
+func newResumeCommand(a *app) *cobra.Command {
+	var (
+		restart  bool
+		uploadID string
+		key      string
+		verify   bool
+	)
+	cmd := &cobra.Command{
+		Use:   "resume {<upload_status_file_path> | --upload-id <id> --key <s3_key> <local_file_path>}",
+		Short: "Continue an interrupted upload from its status file, or from the store",
+		Long: `Continue an interrupted upload from its status file. The parts the store
+already has are checked against the file first, so that only the missing
+ones are sent.
+
+If the multipart upload no longer exists, because it was aborted or
+expired, the file must be uploaded again: --restart does so, and on a
+terminal you are asked whether to.
+
+If the status file was lost, give the upload ID and key of the upload
+instead, as listed by "favus list-uploads", and the file being uploaded.
+The status is rebuilt from the parts in the store, whose sizes must match
+the file; --verify also checks their content against it. If the store has
+fewer than two parts, the part size is taken from --chunk-size. Uploads
+encrypted on the client cannot be resumed this way.`,
+		Example: `  favus resume ~/.local/state/favus/uploads/backup.tar-1a2b3c4d5e6f7a8b.upload_status
+  favus resume --upload-id 2~abc --key backups/backup.tar --verify backup.tar`,
+		Args:              checkArgs(cobra.ExactArgs(1)),
+		ValidArgsFunction: completeArgs(argFile),
+		PreRunE:           a.setup,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch {
+			case uploadID != "" && key == "":
+				return usageError{cmd, errors.New("--upload-id needs --key")}
+			case uploadID == "" && (key != "" || verify):
+				return usageError{cmd, errors.New("--key and --verify need --upload-id")}
+			case uploadID != "" && restart:
+				return usageError{cmd, errors.New("--restart cannot be used with --upload-id")}
+			}
+			resumeUploader := uploader.NewResumeUploader(a.uploader.Store, a.cfg.Concurrency)
+			resumeUploader.GracePeriod = a.cfg.ShutdownGracePeriod
+			resumeUploader.Retry = a.cfg.Retry
+			resumeUploader.Progress = a.progress
+			resumeUploader.Restart = restart
+			resumeUploader.StateDir = a.cfg.StateDir
+			resumeUploader.Keys = a.keys
+			var result *uploader.UploadResult
+			var err error
+			if uploadID != "" {
+				result, err = resumeUploader.ResumeRemoteUpload(a.ctx, uploader.RemoteUpload{
+					FilePath:          args[0],
+					Key:               key,
+					UploadID:          uploadID,
+					Bucket:            a.cfg.S3BucketName,
+					PartSize:          a.cfg.ChunkSize,
+					ChecksumAlgorithm: a.cfg.ChecksumAlgorithm,
+					Verify:            verify,
+				})
+			} else {
+				result, err = resumeUploader.ResumeUpload(a.ctx, args[0])
+				if errors.Is(err, uploader.ErrUploadNotFound) && confirm("The upload no longer exists. Upload the file again from scratch?") {
+					resumeUploader.Restart = true
+					result, err = resumeUploader.ResumeUpload(a.ctx, args[0])
+				}
+			}
+			a.finishProgress()
+			if err != nil {
+				if printResumeCommand(err) {
+					return exitError(exitInterrupted)
+				}
+				if errors.Is(err, uploader.ErrUploadNotFound) && uploadID == "" {
+					utils.Error("%v", err)
+					fmt.Fprintf(os.Stderr, "To upload the file again from scratch, run:\n  favus resume --restart %s\n", shellQuote(args[0]))
+					return exitError(exitFailure)
+				}
+				return fmt.Errorf("resume upload failed: %w", err)
+			}
+			utils.Info("Upload resumed and completed successfully.") // logger.Info 대신 utils.Info 사용
+			return a.printResult(newUploadDoc(result, a.uploader.Store.Location(result.Key)))
+		},
+	}
+	cmd.Flags().BoolVar(&restart, "restart", false, "upload the file again in a new multipart upload if the recorded one no longer exists")
+	cmd.Flags().StringVar(&uploadID, "upload-id", "", "resume this multipart upload of the given local file, without a status file")
+	cmd.Flags().StringVar(&key, "key", "", "key of the upload given with --upload-id")
+	cmd.Flags().BoolVar(&verify, "verify", false, "with --upload-id, check the content of the stored parts against the file, not only their sizes")
+	cmd.RegisterFlagCompletionFunc("upload-id", cobra.NoFileCompletions)
+	cmd.RegisterFlagCompletionFunc("key", cobra.NoFileCompletions)
+	return cmd
+}
+
+func newStatusCommand(a *app) *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "List the uploads with a status file, and their progress",
+		Long: `List the uploads tracked in the state directory: those interrupted, which
+"favus resume" continues, and those in progress in another process, shown
+as active.`,
+		Args:    checkArgs(cobra.NoArgs),
+		PreRunE: a.setup,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			uploads, err := uploader.ListTrackedUploads(a.cfg.StateDir)
+			if err != nil {
+				return err
+			}
+			if a.output != outputText {
+				return a.printResult(newStatusDoc(uploads))
+			}
+			if len(uploads) == 0 {
+				utils.Info("No tracked uploads in %s.", a.cfg.StateDir)
+				return nil
+			}
+			if err := printTable(newStatusDoc(uploads)); err != nil {
+				return err
+			}
+			for _, t := range uploads {
+				if t.Status != nil && !t.Active {
+					fmt.Fprintln(os.Stderr, "To resume an interrupted upload, run: favus resume <status file>")
+					break
+				}
+			}
+			return nil
+		},
+	}
+}
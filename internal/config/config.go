@@ -1,50 +1,339 @@
+// Package config resolves the configuration of favus from, in increasing
+// order of precedence, built-in defaults, config files, environment
+// variables and command-line flags.
+//
+// Config files are YAML. The per-user file is config.yaml in the favus
+// directory of the user config directory (e.g. ~/.config/favus/config.yaml),
+// and the per-project file is the nearest .favus.yaml in the working
+// directory or one of its parents. The project file overrides the user
+// file. A file sets settings by their keys, and may define named profiles
+// overriding them:
+//
+//	s3_bucket_name: my-bucket
+//	aws_region: eu-west-1
+//	chunk_size: 16MiB
+//	profiles:
+//	  minio:
+//	    s3_endpoint: http://localhost:9000
+//	    s3_bucket_name: test
+//
+// A selected profile overrides the top-level values of both files, while
+// environment variables and flags still override the profile.
+//
+// The environment variable of a setting is its key in upper case prefixed
+// with FAVUS_, such as FAVUS_LOG_LEVEL, except for AWS_REGION,
+// S3_BUCKET_NAME and CHUNK_SIZE, which keep the names of earlier releases.
 package config
 
 import (
+	"errors"
 	"fmt"
 	"os"
-	"strconv"
+	"path/filepath"
+	"time"
+
+	"github.com/yucori/Favus/internal/checksum"
+	"github.com/yucori/Favus/pkg/utils"
 )
 
 const DefaultChunkSize = 1024 * 1024 // 1 MB
 
+// DefaultConcurrency is the number of parts uploaded in parallel by default.
+const DefaultConcurrency = 4
+
+// DefaultShutdownGracePeriod is how long in-flight parts may keep running
+// after an interrupt before they are canceled.
+const DefaultShutdownGracePeriod = 30 * time.Second
+
+// Supported storage backends.
+const (
+	BackendS3    = "s3"
+	BackendLocal = "local"
+)
+
+// Server-side encryption modes of the s3 backend.
+const (
+	SSES3  = "sse-s3"  // Keys managed by S3
+	SSEKMS = "sse-kms" // Keys managed by AWS KMS
+	SSEC   = "sse-c"   // Key provided by the client with every request
+)
+
 type Config struct {
-	AwsRegion    string
-	S3BucketName string
-	ChunkSize    int64
+	AwsRegion        string
+	S3BucketName     string
+	ChunkSize        int64
+	Concurrency      int
+	S3Endpoint       string // Custom S3 endpoint URL, e.g. for S3-compatible servers
+	StorageBackend   string // "s3" (default) or "local"
+	LocalStorageRoot string // Root directory of the local backend
+
+	ChecksumAlgorithm checksum.Algorithm // Per-part checksum sent with uploads
+
+	EncryptionKeyFile string // Master key file of client-side encryption; uploads are not encrypted when empty
+
+	// Server-side encryption settings
+	SSE                string // SSES3, SSEKMS or SSEC; the bucket's default encryption when empty
+	SSEKMSKeyID        string // KMS key of SSE-KMS; the AWS managed key when empty
+	SSEKMSContext      string // Encryption context of SSE-KMS, a JSON object of strings
+	SSECustomerKeyFile string // File with the 32-byte key of SSE-C
+
+	ShutdownGracePeriod time.Duration // Time in-flight parts get to finish after an interrupt
+
+	StateDir string // Directory keeping the status files of uploads in progress
+
+	Retry utils.RetryPolicy // Retries of store requests; zero fields use utils.DefaultRetryPolicy
+
+	// Logging settings
+	LogLevel  utils.Level     // Minimum level of the records written
+	LogFormat utils.LogFormat // "text" (default) or "json"
+	LogFile   string          // File the records are appended to; stderr when empty
+
+	// S3 connection settings
+	S3ForcePathStyle      bool   // Use path-style addressing (bucket in the path)
+	S3CABundle            string // PEM file with extra CA certificates to trust
+	S3InsecureSkipVerify  bool   // Skip TLS certificate verification
+	AwsAccessKeyID        string // Static access key ID
+	AwsSecretAccessKey    string // Static secret access key
+	AwsSessionToken       string // Optional session token for the static keys
+	AwsProfile            string // Named profile from the shared config files
+	AssumeRoleARN         string // Role to assume with the resolved credentials
+	AssumeRoleSessionName string // Session name used when assuming the role
+	AssumeRoleExternalID  string // External ID required by the role, if any
+
+	// Where the configuration came from, as reported by `favus config show`.
+	Profile string            // Profile applied, if any
+	Files   []string          // Config files read, from the lowest precedence to the highest
+	sources map[string]string // Source of every setting not left at its default, by key
+}
+
+// LoadOptions selects where Load reads the configuration from.
+type LoadOptions struct {
+	// File is a config file used instead of the per-project file. It
+	// defaults to the FAVUS_CONFIG environment variable.
+	File string
+	// Profile is the profile to apply. It defaults to the FAVUS_PROFILE
+	// environment variable.
+	Profile string
+	// Flags holds the command-line flags that were set, by name. Flags
+	// that are not settings are ignored.
+	Flags map[string]string
 }
 
+// Value is the effective value of a setting and where it came from.
+type Value struct {
+	Key    string // Key of the setting in config files
+	Value  string // Secrets are masked
+	Source string // "default", "file PATH", "file PATH, profile NAME", "env NAME" or "flag --NAME"
+}
+
+// LoadConfig loads the configuration from the config files and the
+// environment.
 func LoadConfig() (*Config, error) {
-	region := os.Getenv("AWS_REGION")
-	bucketName := os.Getenv("S3_BUCKET_NAME")
-	chunkSizeStr := os.Getenv("CHUNK_SIZE")
+	cfg, err := Load(LoadOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
 
-	if region == "" {
-		return nil, fmt.Errorf("AWS_REGION environment variable is not set")
+// Load resolves the configuration from its defaults, the config files, the
+// environment and opts.Flags, and validates it. Every invalid value is
+// reported in the returned error, not only the first one. On error, the
+// Config still holds what could be resolved, so that it can be shown.
+func Load(opts LoadOptions) (*Config, error) {
+	c := defaults()
+	var errs []error
+	set := func(s setting, value, source string) {
+		if err := s.parse(c, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s (%s): %w", s.key, source, err))
+			return
+		}
+		c.sources[s.key] = source
+	}
+
+	files, err := readConfigFiles(opts.File)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, f := range files {
+		c.Files = append(c.Files, f.path)
+		for _, s := range settings {
+			if value, ok := f.values[s.key]; ok {
+				set(s, value, "file "+f.path)
+			}
+		}
 	}
 
-	if bucketName == "" {
-		return nil, fmt.Errorf("S3_BUCKET_NAME environment variable is not set")
+	c.Profile = opts.Profile
+	if c.Profile == "" {
+		c.Profile = os.Getenv("FAVUS_PROFILE")
+	}
+	if c.Profile != "" {
+		found := false
+		for _, f := range files {
+			values, ok := f.profiles[c.Profile]
+			if !ok {
+				continue
+			}
+			found = true
+			for _, s := range settings {
+				if value, ok := values[s.key]; ok {
+					set(s, value, fmt.Sprintf("file %s, profile %s", f.path, c.Profile))
+				}
+			}
+		}
+		if !found {
+			errs = append(errs, fmt.Errorf("profile %q is not defined in any config file", c.Profile))
+		}
 	}
 
-	// Default chunk size
-	var chunkSize int64 = DefaultChunkSize
-	if chunkSizeStr != "" {
-		parsedSize, err := strconv.ParseInt(chunkSizeStr, 10, 64)
-		if err != nil {
-			// 환경 변수가 있지만 숫자로 파싱할 수 없는 경우 경고를 출력하고 기본값 사용
-			fmt.Printf("Warning: CHUNK_SIZE environment variable '%s' is not a valid number. Using default chunk size (%d bytes).\n", chunkSizeStr, DefaultChunkSize)
-		} else if parsedSize <= 0 {
-			// 파싱되었지만 0 이하인 경우 경고를 출력하고 기본값 사용
-			fmt.Printf("Warning: CHUNK_SIZE environment variable '%s' must be greater than 0. Using default chunk size (%d bytes).\n", chunkSizeStr, DefaultChunkSize)
-		} else {
-			chunkSize = parsedSize
+	for _, s := range settings {
+		if value := os.Getenv(s.env()); value != "" {
+			set(s, value, "env "+s.env())
+		}
+	}
+	for _, s := range settings {
+		if value, ok := opts.Flags[s.flag]; ok && s.flag != "" {
+			set(s, value, "flag --"+s.flag)
 		}
 	}
 
+	// Defaults that depend on other settings.
+	if _, ok := c.sources["s3_force_path_style"]; !ok {
+		// S3-compatible servers generally only support path-style
+		// addressing, so it is the default whenever a custom endpoint is
+		// configured.
+		c.S3ForcePathStyle = c.S3Endpoint != ""
+	}
+	if c.AssumeRoleARN != "" && c.AssumeRoleSessionName == "" {
+		c.AssumeRoleSessionName = "favus"
+	}
+
+	errs = append(errs, c.validate()...)
+	return c, errors.Join(errs...)
+}
+
+// defaults returns the configuration used when nothing is set.
+func defaults() *Config {
 	return &Config{
-		AwsRegion:    region,
-		S3BucketName: bucketName,
-		ChunkSize:    chunkSize,
-	}, nil
+		ChunkSize:           DefaultChunkSize,
+		Concurrency:         DefaultConcurrency,
+		StorageBackend:      BackendS3,
+		ShutdownGracePeriod: DefaultShutdownGracePeriod,
+		StateDir:            DefaultStateDir(),
+		Retry:               utils.DefaultRetryPolicy,
+		LogLevel:            utils.LevelInfo,
+		LogFormat:           utils.LogText,
+		sources:             make(map[string]string),
+	}
+}
+
+// validate checks the settings that depend on each other.
+func (c *Config) validate() []error {
+	var errs []error
+	switch c.StorageBackend {
+	case BackendS3:
+		if c.AwsRegion == "" {
+			errs = append(errs, notSet("aws_region"))
+		}
+		if c.S3BucketName == "" {
+			errs = append(errs, notSet("s3_bucket_name"))
+		}
+	case BackendLocal:
+		if c.LocalStorageRoot == "" {
+			errs = append(errs, notSet("local_storage_root"))
+		}
+	}
+	if (c.AwsAccessKeyID == "") != (c.AwsSecretAccessKey == "") {
+		errs = append(errs, fmt.Errorf("aws_access_key_id and aws_secret_access_key must be set together"))
+	}
+	if c.SSE != "" && c.StorageBackend == BackendLocal {
+		errs = append(errs, fmt.Errorf("s3_sse is not supported by the %s backend", BackendLocal))
+	}
+	if c.SSE == SSEC && c.SSECustomerKeyFile == "" {
+		errs = append(errs, notSet("s3_sse_customer_key_file"))
+	}
+	if c.SSE != SSEC && c.SSECustomerKeyFile != "" {
+		errs = append(errs, fmt.Errorf("s3_sse_customer_key_file is only used with s3_sse %s", SSEC))
+	}
+	if c.SSE != SSEKMS && (c.SSEKMSKeyID != "" || c.SSEKMSContext != "") {
+		errs = append(errs, fmt.Errorf("s3_sse_kms_key_id and s3_sse_kms_context are only used with s3_sse %s", SSEKMS))
+	}
+	return errs
+}
+
+// notSet returns the error for a required setting that is not set, saying
+// how to set it.
+func notSet(key string) error {
+	s := lookup(key)
+	how := "the " + s.env() + " environment variable"
+	if s.flag != "" {
+		how += ", the --" + s.flag + " flag"
+	}
+	return fmt.Errorf("%s is not set (set %s or %s in a config file)", key, how, key)
+}
+
+// Values returns the effective value of every setting and where it came
+// from, in a stable order.
+func (c *Config) Values() []Value {
+	values := make([]Value, 0, len(settings))
+	for _, s := range settings {
+		v := Value{Key: s.key, Value: s.format(c), Source: c.sources[s.key]}
+		if v.Source == "" {
+			v.Source = "default"
+		}
+		if s.secret && v.Value != "" {
+			v.Value = "********"
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+// UserConfigFile returns the path of the per-user config file, or an empty
+// string if the user config directory is unknown.
+func UserConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "favus", "config.yaml")
+}
+
+// DefaultStateDir returns the directory keeping the status files of
+// uploads in progress by default: favus in $XDG_STATE_HOME, or in
+// ~/.local/state if it is not set. Unlike the temporary directory, it
+// survives reboots.
+func DefaultStateDir() string {
+	if dir := os.Getenv("XDG_STATE_HOME"); dir != "" {
+		return filepath.Join(dir, "favus")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "favus")
+	}
+	return filepath.Join(home, ".local", "state", "favus")
+}
+
+// ProjectConfigFileName is the name of per-project config files.
+const ProjectConfigFileName = ".favus.yaml"
+
+// FindProjectConfigFile returns the path of the nearest per-project config
+// file in dir or one of its parents, or an empty string if there is none.
+func FindProjectConfigFile(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		path := filepath.Join(dir, ProjectConfigFileName)
+		if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+			return path
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
 }
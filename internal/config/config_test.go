@@ -0,0 +1,218 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// isolate clears the environment variables Load reads and points the user
+// config directory at a new temporary directory, whose user config file
+// path is returned.
+func isolate(t *testing.T) string {
+	t.Helper()
+	for _, s := range settings {
+		t.Setenv(s.env(), "")
+	}
+	t.Setenv("FAVUS_CONFIG", "")
+	t.Setenv("FAVUS_PROFILE", "")
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	return UserConfigFile()
+}
+
+// writeConfig writes a config file with the given content to path.
+func writeConfig(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// value returns the value of key in cfg and where it came from.
+func value(t *testing.T, cfg *Config, key string) Value {
+	t.Helper()
+	for _, v := range cfg.Values() {
+		if v.Key == key {
+			return v
+		}
+	}
+	t.Fatalf("no setting %s", key)
+	return Value{}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	userFile := isolate(t)
+	writeConfig(t, userFile, `
+aws_region: user-region
+s3_bucket_name: user-bucket
+chunk_size: 8MiB
+concurrency: 2
+log_level: warn
+state_dir: /user/state
+profiles:
+  test:
+    concurrency: 5
+    log_format: json
+`)
+	projectFile := filepath.Join(t.TempDir(), ProjectConfigFileName)
+	writeConfig(t, projectFile, `
+s3_bucket_name: project-bucket
+concurrency: 3
+log_level: error
+profiles:
+  test:
+    log_level: debug
+    state_dir: /profile/state
+`)
+	t.Setenv("FAVUS_STATE_DIR", "/env/state")
+	t.Setenv("FAVUS_LOG_FORMAT", "text")
+	t.Setenv("S3_BUCKET_NAME", "env-bucket")
+
+	cfg, err := Load(LoadOptions{
+		File:    projectFile,
+		Profile: "test",
+		Flags:   map[string]string{"state-dir": "/flag/state", "chunk-size": "16MiB", "version": "true"},
+	})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	profileSource := "file " + projectFile + ", profile test"
+	tests := []struct {
+		key, value, source string
+	}{
+		{"storage_backend", "s3", "default"},
+		{"retry_max_attempts", "5", "default"},
+		{"aws_region", "user-region", "file " + userFile},
+		{"log_level", "debug", profileSource},
+		{"concurrency", "5", "file " + userFile + ", profile test"},
+		{"s3_bucket_name", "env-bucket", "env S3_BUCKET_NAME"},
+		{"log_format", "text", "env FAVUS_LOG_FORMAT"},
+		{"state_dir", "/flag/state", "flag --state-dir"},
+		{"chunk_size", "16777216", "flag --chunk-size"},
+	}
+	for _, tt := range tests {
+		if v := value(t, cfg, tt.key); v.Value != tt.value || v.Source != tt.source {
+			t.Errorf("%s = %q from %q, want %q from %q", tt.key, v.Value, v.Source, tt.value, tt.source)
+		}
+	}
+	if want := []string{userFile, projectFile}; strings.Join(cfg.Files, ",") != strings.Join(want, ",") {
+		t.Errorf("files read: %v, want %v", cfg.Files, want)
+	}
+	if cfg.Profile != "test" {
+		t.Errorf("profile %q, want test", cfg.Profile)
+	}
+}
+
+func TestLoadLayers(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string // Content of the project file
+		profile string
+		env     map[string]string
+		flags   map[string]string
+		value   string // Of concurrency
+		source  string // With PROJECT for the path of the project file
+	}{
+		{"default", "", "", nil, nil, "4", "default"},
+		{"file", "concurrency: 2", "", nil, nil, "2", "file PROJECT"},
+		{"profile", "concurrency: 2\nprofiles:\n  p:\n    concurrency: 3", "p", nil, nil, "3", "file PROJECT, profile p"},
+		{"unselected profile", "concurrency: 2\nprofiles:\n  p:\n    concurrency: 3", "", nil, nil, "2", "file PROJECT"},
+		{"env", "concurrency: 2\nprofiles:\n  p:\n    concurrency: 3", "p", map[string]string{"FAVUS_CONCURRENCY": "6"}, nil, "6", "env FAVUS_CONCURRENCY"},
+		{"unprefixed env", "", "", map[string]string{"CONCURRENCY": "6"}, nil, "4", "default"},
+		{"flag", "concurrency: 2", "", map[string]string{"FAVUS_CONCURRENCY": "6"}, map[string]string{"concurrency": "7"}, "7", "flag --concurrency"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isolate(t)
+			t.Setenv("AWS_REGION", "us-east-1")
+			t.Setenv("S3_BUCKET_NAME", "bucket")
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			projectFile := filepath.Join(t.TempDir(), ProjectConfigFileName)
+			writeConfig(t, projectFile, tt.file)
+
+			cfg, err := Load(LoadOptions{File: projectFile, Profile: tt.profile, Flags: tt.flags})
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			source := strings.ReplaceAll(tt.source, "PROJECT", projectFile)
+			if v := value(t, cfg, "concurrency"); v.Value != tt.value || v.Source != source {
+				t.Errorf("concurrency = %q from %q, want %q from %q", v.Value, v.Source, tt.value, source)
+			}
+		})
+	}
+}
+
+func TestLoadProfileFromEnv(t *testing.T) {
+	isolate(t)
+	projectFile := filepath.Join(t.TempDir(), ProjectConfigFileName)
+	writeConfig(t, projectFile, "aws_region: eu-west-1\ns3_bucket_name: bucket\nprofiles:\n  minio:\n    s3_endpoint: http://localhost:9000\n")
+	t.Setenv("FAVUS_PROFILE", "minio")
+
+	cfg, err := Load(LoadOptions{File: projectFile})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.Profile != "minio" || cfg.S3Endpoint != "http://localhost:9000" {
+		t.Errorf("profile %q set endpoint %q", cfg.Profile, cfg.S3Endpoint)
+	}
+	// Path-style addressing defaults to on with a custom endpoint.
+	if v := value(t, cfg, "s3_force_path_style"); v.Value != "true" || v.Source != "default" {
+		t.Errorf("s3_force_path_style = %q from %q, want true by default", v.Value, v.Source)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		profile string
+		flags   map[string]string
+		want    []string // Substrings of the error
+	}{
+		{"required", "", "", nil, []string{"aws_region is not set", "AWS_REGION", "s3_bucket_name is not set", "--bucket"}},
+		{"unknown profile", "aws_region: r\ns3_bucket_name: b", "missing", nil, []string{`profile "missing" is not defined`}},
+		{"unknown key", "aws_region: r\ns3_bucket_name: b\nchunksize: 5MiB", "", nil, []string{"unknown settings: chunksize"}},
+		{"every invalid value", "aws_region: r\ns3_bucket_name: b\nconcurrency: many", "", map[string]string{"log-level": "loud"}, []string{"concurrency (file ", "log_level (flag --log-level)"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isolate(t)
+			projectFile := filepath.Join(t.TempDir(), ProjectConfigFileName)
+			writeConfig(t, projectFile, tt.file)
+			_, err := Load(LoadOptions{File: projectFile, Profile: tt.profile, Flags: tt.flags})
+			if err == nil {
+				t.Fatal("Load succeeded")
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValuesMasksSecrets(t *testing.T) {
+	isolate(t)
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("S3_BUCKET_NAME", "bucket")
+	t.Setenv("FAVUS_AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("FAVUS_AWS_SECRET_ACCESS_KEY", "secret")
+
+	cfg, err := Load(LoadOptions{File: filepath.Join(t.TempDir(), "missing.yaml")})
+	if err == nil {
+		t.Fatal("Load succeeded with a missing explicit config file")
+	}
+	if v := value(t, cfg, "aws_access_key_id"); v.Value != "AKID" {
+		t.Errorf("aws_access_key_id = %q, want AKID", v.Value)
+	}
+	if v := value(t, cfg, "aws_secret_access_key"); v.Value != "********" || v.Source != "env FAVUS_AWS_SECRET_ACCESS_KEY" {
+		t.Errorf("aws_secret_access_key = %q from %q, want it masked", v.Value, v.Source)
+	}
+}
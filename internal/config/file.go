@@ -0,0 +1,127 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// configFile holds the values set by a config file.
+type configFile struct {
+	path     string
+	values   map[string]string            // Top-level settings, by key
+	profiles map[string]map[string]string // Settings of every profile, by profile name and key
+}
+
+// readConfigFiles reads the per-user config file and either explicit or,
+// when empty, the per-project config file, skipping those that do not
+// exist. An explicit file must exist.
+func readConfigFiles(explicit string) ([]*configFile, error) {
+	if explicit == "" {
+		explicit = os.Getenv("FAVUS_CONFIG")
+	}
+	var paths []string
+	if path := UserConfigFile(); path != "" {
+		if _, err := os.Stat(path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	if explicit != "" {
+		paths = append(paths, explicit)
+	} else if path := FindProjectConfigFile("."); path != "" {
+		paths = append(paths, path)
+	}
+
+	var files []*configFile
+	var errs []error
+	for _, path := range paths {
+		// A file with invalid settings still provides its valid ones.
+		f, err := readConfigFile(path)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if f != nil {
+			files = append(files, f)
+		}
+	}
+	return files, errors.Join(errs...)
+}
+
+// readConfigFile reads a YAML config file. Unknown keys are reported as
+// errors, since they are most likely misspelled settings.
+func readConfigFile(path string) (*configFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	f := &configFile{path: path, profiles: make(map[string]map[string]string)}
+	var errs []error
+	profiles, _ := raw["profiles"].(map[string]interface{})
+	if _, ok := raw["profiles"]; ok && profiles == nil {
+		errs = append(errs, fmt.Errorf("%s: profiles must be a mapping of profile names to settings", path))
+	}
+	delete(raw, "profiles")
+	if f.values, err = settingValues(raw); err != nil {
+		errs = append(errs, fmt.Errorf("%s: %w", path, err))
+	}
+	for name, p := range profiles {
+		values, _ := p.(map[string]interface{})
+		if values == nil && p != nil {
+			errs = append(errs, fmt.Errorf("%s: profile %s must be a mapping of settings", path, name))
+			continue
+		}
+		if f.profiles[name], err = settingValues(values); err != nil {
+			errs = append(errs, fmt.Errorf("%s: profile %s: %w", path, name, err))
+		}
+	}
+	return f, errors.Join(errs...)
+}
+
+// settingValues converts the values of a YAML mapping of settings to
+// strings, as if they were set in the environment.
+func settingValues(raw map[string]interface{}) (map[string]string, error) {
+	values := make(map[string]string, len(raw))
+	var unknown, invalid []string
+	for key, value := range raw {
+		if !isSetting(key) {
+			unknown = append(unknown, key)
+			continue
+		}
+		switch value.(type) {
+		case nil:
+			// An empty value leaves the setting alone.
+		case string, bool, int, float64:
+			values[key] = fmt.Sprint(value)
+		default:
+			invalid = append(invalid, key)
+		}
+	}
+	var errs []error
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		errs = append(errs, fmt.Errorf("unknown settings: %s", strings.Join(unknown, ", ")))
+	}
+	if len(invalid) > 0 {
+		sort.Strings(invalid)
+		errs = append(errs, fmt.Errorf("settings must be single values: %s", strings.Join(invalid, ", ")))
+	}
+	return values, errors.Join(errs...)
+}
+
+func isSetting(key string) bool {
+	for _, s := range settings {
+		if s.key == key {
+			return true
+		}
+	}
+	return false
+}
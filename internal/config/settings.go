@@ -0,0 +1,281 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yucori/Favus/internal/checksum"
+	"github.com/yucori/Favus/pkg/utils"
+)
+
+// setting is a configuration value that can be set in a config file, an
+// environment variable and possibly a command-line flag.
+type setting struct {
+	key    string // Key in config files; see env for the environment variable
+	flag   string // Command-line flag, if any
+	usage  string
+	values []string // Accepted values, if they are a fixed set
+	secret bool     // Masked by Values, and never a flag so that it does not show in process lists
+	parse  func(c *Config, value string) error
+	format func(c *Config) string
+}
+
+// envPrefix is the prefix of the environment variables of settings, so
+// that they do not clash with variables set for other programs.
+const envPrefix = "FAVUS_"
+
+// legacyEnv are the settings read from unprefixed environment variables
+// before config files were supported, which are still honoured.
+var legacyEnv = map[string]bool{
+	"aws_region":     true,
+	"s3_bucket_name": true,
+	"chunk_size":     true,
+}
+
+// env returns the environment variable of the setting: FAVUS_ followed by
+// its key in upper case, or the key alone for the legacy settings.
+func (s setting) env() string {
+	if legacyEnv[s.key] {
+		return strings.ToUpper(s.key)
+	}
+	return envPrefix + strings.ToUpper(s.key)
+}
+
+// settings lists every setting, in the order `favus config show` prints
+// them.
+var settings = []setting{
+	{
+		key: "storage_backend", flag: "backend", usage: "storage backend: s3 or local",
+		values: []string{BackendS3, BackendLocal},
+		parse: func(c *Config, value string) error {
+			switch value {
+			case BackendS3, BackendLocal:
+				c.StorageBackend = value
+				return nil
+			}
+			return fmt.Errorf("'%s' is not supported (use %s or %s)", value, BackendS3, BackendLocal)
+		},
+		format: func(c *Config) string { return c.StorageBackend },
+	},
+	stringSetting("aws_region", "region", "AWS region of the bucket", func(c *Config) *string { return &c.AwsRegion }),
+	stringSetting("s3_bucket_name", "bucket", "bucket to use", func(c *Config) *string { return &c.S3BucketName }),
+	stringSetting("s3_endpoint", "endpoint", "custom S3 endpoint URL, e.g. for S3-compatible servers", func(c *Config) *string { return &c.S3Endpoint }),
+	stringSetting("local_storage_root", "local-root", "root directory of the local backend", func(c *Config) *string { return &c.LocalStorageRoot }),
+	{
+		key: "chunk_size", flag: "chunk-size", usage: "part size in bytes, or with a KiB, MiB or GiB suffix",
+		parse: func(c *Config, value string) (err error) {
+			c.ChunkSize, err = parseSize(value)
+			return err
+		},
+		format: func(c *Config) string { return strconv.FormatInt(c.ChunkSize, 10) },
+	},
+	intSetting("concurrency", "concurrency", "number of parts to upload in parallel", func(c *Config) *int { return &c.Concurrency }),
+	{
+		key: "checksum_algorithm", flag: "checksum", usage: "per-part checksum algorithm: none, md5, crc32c or sha256",
+		values: []string{"none", string(checksum.MD5), string(checksum.CRC32C), string(checksum.SHA256)},
+		parse: func(c *Config, value string) (err error) {
+			c.ChecksumAlgorithm, err = checksum.ParseAlgorithm(value)
+			return err
+		},
+		format: func(c *Config) string {
+			if c.ChecksumAlgorithm == checksum.None {
+				return "none"
+			}
+			return string(c.ChecksumAlgorithm)
+		},
+	},
+	stringSetting("encryption_key_file", "encryption-key-file", "file with the 32-byte master key to encrypt uploads with on the client; objects are downloaded decrypted", func(c *Config) *string { return &c.EncryptionKeyFile }),
+	{
+		key: "s3_sse", flag: "sse", usage: "server-side encryption of uploads: none, sse-s3, sse-kms or sse-c; none leaves it to the bucket",
+		values: []string{"none", SSES3, SSEKMS, SSEC},
+		parse: func(c *Config, value string) error {
+			switch value {
+			case "none":
+				c.SSE = ""
+				return nil
+			case SSES3, SSEKMS, SSEC:
+				c.SSE = value
+				return nil
+			}
+			return fmt.Errorf("'%s' is not supported (use none, %s, %s or %s)", value, SSES3, SSEKMS, SSEC)
+		},
+		format: func(c *Config) string {
+			if c.SSE == "" {
+				return "none"
+			}
+			return c.SSE
+		},
+	},
+	stringSetting("s3_sse_kms_key_id", "sse-kms-key-id", "KMS key ID, ARN or alias of sse-kms; the AWS managed key when empty", func(c *Config) *string { return &c.SSEKMSKeyID }),
+	{
+		key: "s3_sse_kms_context", flag: "sse-kms-context", usage: `encryption context of sse-kms, a JSON object of strings such as {"project":"backup"}`,
+		parse: func(c *Config, value string) error {
+			var context map[string]string
+			if err := json.Unmarshal([]byte(value), &context); err != nil {
+				return fmt.Errorf("not a JSON object of strings: %w", err)
+			}
+			c.SSEKMSContext = value
+			return nil
+		},
+		format: func(c *Config) string { return c.SSEKMSContext },
+	},
+	stringSetting("s3_sse_customer_key_file", "sse-customer-key-file", "file with the 32-byte key of sse-c, raw or in hex or base64; it is needed to read the objects back", func(c *Config) *string { return &c.SSECustomerKeyFile }),
+	durationSetting("shutdown_grace_period", "shutdown-grace-period", "time in-flight parts get to finish after an interrupt", func(c *Config) *time.Duration { return &c.ShutdownGracePeriod }),
+	stringSetting("state_dir", "state-dir", "directory keeping the status of uploads in progress, to resume them", func(c *Config) *string { return &c.StateDir }),
+	intSetting("retry_max_attempts", "retry-max-attempts", "attempts of a failing request, including the first", func(c *Config) *int { return &c.Retry.MaxAttempts }),
+	durationSetting("retry_initial_interval", "retry-initial-interval", "wait before the first retry", func(c *Config) *time.Duration { return &c.Retry.InitialInterval }),
+	durationSetting("retry_max_interval", "retry-max-interval", "longest wait between retries", func(c *Config) *time.Duration { return &c.Retry.MaxInterval }),
+	durationSetting("retry_max_elapsed_time", "retry-max-elapsed-time", "time after which a failing request is no longer retried", func(c *Config) *time.Duration { return &c.Retry.MaxElapsedTime }),
+	{
+		key: "log_level", flag: "log-level", usage: "minimum log level: debug, info, warn or error",
+		values: []string{"debug", "info", "warn", "error"},
+		parse: func(c *Config, value string) (err error) {
+			c.LogLevel, err = utils.ParseLevel(value)
+			return err
+		},
+		format: func(c *Config) string { return strings.ToLower(c.LogLevel.String()) },
+	},
+	{
+		key: "log_format", flag: "log-format", usage: "log format: text or json",
+		values: []string{string(utils.LogText), string(utils.LogJSON)},
+		parse: func(c *Config, value string) (err error) {
+			c.LogFormat, err = utils.ParseLogFormat(value)
+			return err
+		},
+		format: func(c *Config) string { return string(c.LogFormat) },
+	},
+	stringSetting("log_file", "log-file", "append logs to this file instead of stderr", func(c *Config) *string { return &c.LogFile }),
+	boolSetting("s3_force_path_style", "force-path-style", "use path-style addressing; the default with a custom endpoint", func(c *Config) *bool { return &c.S3ForcePathStyle }),
+	stringSetting("s3_ca_bundle", "ca-bundle", "PEM file with extra CA certificates to trust", func(c *Config) *string { return &c.S3CABundle }),
+	boolSetting("s3_insecure_skip_verify", "insecure-skip-verify", "skip TLS certificate verification", func(c *Config) *bool { return &c.S3InsecureSkipVerify }),
+	stringSetting("aws_access_key_id", "", "", func(c *Config) *string { return &c.AwsAccessKeyID }),
+	secret(stringSetting("aws_secret_access_key", "", "", func(c *Config) *string { return &c.AwsSecretAccessKey })),
+	secret(stringSetting("aws_session_token", "", "", func(c *Config) *string { return &c.AwsSessionToken })),
+	stringSetting("aws_profile", "aws-profile", "named profile from the shared AWS config files", func(c *Config) *string { return &c.AwsProfile }),
+	stringSetting("assume_role_arn", "role-arn", "role to assume with the resolved credentials", func(c *Config) *string { return &c.AssumeRoleARN }),
+	stringSetting("assume_role_session_name", "role-session-name", "session name used when assuming the role", func(c *Config) *string { return &c.AssumeRoleSessionName }),
+	stringSetting("assume_role_external_id", "role-external-id", "external ID required by the role", func(c *Config) *string { return &c.AssumeRoleExternalID }),
+}
+
+// lookup returns the setting with the given key.
+func lookup(key string) setting {
+	for _, s := range settings {
+		if s.key == key {
+			return s
+		}
+	}
+	panic("config: unknown setting " + key)
+}
+
+// Flag describes the command-line flag of a setting.
+type Flag struct {
+	Name   string
+	Usage  string
+	Values []string // Accepted values, if they are a fixed set, for shell completion
+}
+
+// Flags returns the command-line flags of the settings that have one, for
+// the command line to define. Their values are passed back to Load in
+// LoadOptions.Flags.
+func Flags() []Flag {
+	var flags []Flag
+	for _, s := range settings {
+		if s.flag != "" {
+			flags = append(flags, Flag{Name: s.flag, Usage: fmt.Sprintf("%s (overrides %s)", s.usage, s.env()), Values: s.values})
+		}
+	}
+	return flags
+}
+
+func stringSetting(key, flag, usage string, field func(*Config) *string) setting {
+	return setting{
+		key: key, flag: flag, usage: usage,
+		parse: func(c *Config, value string) error {
+			*field(c) = value
+			return nil
+		},
+		format: func(c *Config) string { return *field(c) },
+	}
+}
+
+func intSetting(key, flag, usage string, field func(*Config) *int) setting {
+	return setting{
+		key: key, flag: flag, usage: usage,
+		parse: func(c *Config, value string) error {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 {
+				return fmt.Errorf("'%s' must be a positive number", value)
+			}
+			*field(c) = parsed
+			return nil
+		},
+		format: func(c *Config) string { return strconv.Itoa(*field(c)) },
+	}
+}
+
+func durationSetting(key, flag, usage string, field func(*Config) *time.Duration) setting {
+	return setting{
+		key: key, flag: flag, usage: usage,
+		parse: func(c *Config, value string) error {
+			parsed, err := time.ParseDuration(value)
+			if err != nil || parsed < 0 {
+				return fmt.Errorf("'%s' is not a valid duration, such as 30s or 5m", value)
+			}
+			*field(c) = parsed
+			return nil
+		},
+		format: func(c *Config) string { return field(c).String() },
+	}
+}
+
+func boolSetting(key, flag, usage string, field func(*Config) *bool) setting {
+	return setting{
+		key: key, flag: flag, usage: usage,
+		values: []string{"true", "false"},
+		parse: func(c *Config, value string) error {
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("'%s' is not a valid boolean", value)
+			}
+			*field(c) = parsed
+			return nil
+		},
+		format: func(c *Config) string { return strconv.FormatBool(*field(c)) },
+	}
+}
+
+func secret(s setting) setting {
+	s.secret = true
+	return s
+}
+
+// sizeUnits are the suffixes parseSize accepts.
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"KiB", 1 << 10},
+	{"MiB", 1 << 20},
+	{"GiB", 1 << 30},
+	{"B", 1},
+}
+
+// parseSize parses a positive number of bytes, optionally followed by a
+// binary unit, e.g. "8388608" or "8MiB".
+func parseSize(value string) (int64, error) {
+	number, unit := strings.TrimSpace(value), int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(number, u.suffix) {
+			number, unit = strings.TrimSpace(strings.TrimSuffix(number, u.suffix)), u.bytes
+			break
+		}
+	}
+	parsed, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || parsed <= 0 || parsed > (1<<62)/unit {
+		return 0, fmt.Errorf("'%s' is not a valid size, such as 8388608 or 8MiB", value)
+	}
+	return parsed * unit, nil
+}
@@ -0,0 +1,294 @@
+package downloader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/yucori/Favus/internal/chunker"
+	"github.com/yucori/Favus/internal/config"
+	"github.com/yucori/Favus/internal/encryption"
+	"github.com/yucori/Favus/internal/etag"
+	"github.com/yucori/Favus/internal/storage"
+	"github.com/yucori/Favus/pkg/utils"
+)
+
+// Downloader fetches objects in parallel byte ranges.
+type Downloader struct {
+	Store  storage.ObjectStore
+	Config *config.Config
+	// Keys holds the master key of objects encrypted on the client, which
+	// are decrypted as they are downloaded. Such objects cannot be
+	// downloaded when it is nil.
+	Keys encryption.KeyProvider
+	// Logger receives the downloader's log records. The package logger of
+	// pkg/utils is used when nil.
+	Logger *utils.Logger
+}
+
+// NewDownloader creates a new Downloader that reads from store.
+func NewDownloader(cfg *config.Config, store storage.ObjectStore) *Downloader {
+	return &Downloader{
+		Store:  store,
+		Config: cfg,
+	}
+}
+
+func (d *Downloader) logger() *utils.Logger {
+	if d.Logger != nil {
+		return d.Logger
+	}
+	return utils.Default()
+}
+
+// StatusFilePath returns the path of the status file tracking a download to
+// localPath.
+func StatusFilePath(localPath string) string {
+	return localPath + ".download_status"
+}
+
+// partialFilePath returns the path the object is written to until the
+// download is complete and verified.
+func partialFilePath(localPath string) string {
+	return localPath + ".partial"
+}
+
+// DownloadFile downloads the object at s3Key to localPath. If localPath is
+// an existing directory, the object is saved there under its base name.
+//
+// Byte ranges are fetched concurrently into a preallocated file. Finished
+// ranges are tracked in a status file next to localPath, so an interrupted
+// download resumes where it stopped as long as the object is unchanged.
+//
+// An object encrypted on the client is decrypted, and every byte of it
+// authenticated, as the ranges are fetched.
+//
+// Once ctx is done no further range is started, and the status of the
+// ranges already written is saved before returning ctx's error.
+func (d *Downloader) DownloadFile(ctx context.Context, s3Key, localPath string) error {
+	if fi, err := os.Stat(localPath); err == nil && fi.IsDir() {
+		localPath = filepath.Join(localPath, path.Base(s3Key))
+	}
+	log := d.logger().With("key", s3Key, "file", localPath)
+	log.Info("Starting download", "location", d.Store.Location(s3Key))
+
+	retry := d.Config.Retry.With(utils.RetryPolicy{Logger: log})
+	var info storage.ObjectInfo
+	err := retry.Do(ctx, func() error {
+		var err error
+		info, err = d.Store.HeadObject(ctx, s3Key)
+		return err
+	})
+	if err != nil {
+		log.Error("Failed to get object info", "error", err)
+		return fmt.Errorf("failed to get object info: %w", err)
+	}
+
+	key, err := encryption.OpenDataKey(d.Keys, info.Metadata)
+	if err != nil {
+		log.Error("Cannot decrypt object", "error", err)
+		return fmt.Errorf("cannot decrypt object: %w", err)
+	}
+	// The size of the file, and of the ranges, which must hold whole
+	// encryption segments.
+	size, chunkSize := info.Size, d.Config.ChunkSize
+	if key != nil {
+		if size, err = encryption.PlaintextSize(info.Size); err != nil {
+			log.Error("Cannot decrypt object", "error", err)
+			return fmt.Errorf("cannot decrypt object: %w", err)
+		}
+		chunkSize = encryption.PartSize(chunkSize)
+	}
+
+	statusFilePath := StatusFilePath(localPath)
+	partialPath := partialFilePath(localPath)
+	status := d.resumableStatus(statusFilePath, partialPath, info, size, log)
+	if status != nil && key != nil && status.ChunkSize%encryption.SegmentSize != 0 {
+		status = nil
+	}
+	if status == nil {
+		status = NewDownloadStatus(s3Key, localPath, info.ETag, info.Size, chunkSize, 0)
+		status.TotalParts = len(chunker.ChunksForSize(size, status.ChunkSize))
+		if err := createPartialFile(partialPath, size); err != nil {
+			log.Error("Failed to create partial file", "path", partialPath, "error", err)
+			return err
+		}
+	}
+
+	file, err := os.OpenFile(partialPath, os.O_WRONLY, 0)
+	if err != nil {
+		log.Error("Failed to open partial file", "path", partialPath, "error", err)
+		return fmt.Errorf("failed to open partial file: %w", err)
+	}
+
+	var remaining []chunker.Chunk
+	for _, ch := range chunker.ChunksForSize(size, status.ChunkSize) {
+		if status.IsPartCompleted(ch.Index) {
+			continue
+		}
+		remaining = append(remaining, ch)
+	}
+	log.Info("Downloading ranges", "ranges", len(remaining), "total_ranges", status.TotalParts, "bytes", size)
+
+	err = chunker.ForEachContext(ctx, remaining, d.Config.Concurrency, func(ch chunker.Chunk) error {
+		return d.downloadRange(ctx, file, status, statusFilePath, ch, key, size, retry, log.With("range", ch.Index))
+	})
+	if err != nil {
+		file.Close()
+		if saveErr := status.SaveStatus(statusFilePath); saveErr != nil {
+			log.Error("Failed to save download status", "status_file", statusFilePath, "error", saveErr)
+		}
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to sync partial file: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close partial file: %w", err)
+	}
+
+	// Decryption authenticates the content, whose ETag is that of the
+	// ciphertext.
+	if key != nil {
+		log.Info("Decrypted and authenticated download", "bytes", size)
+	} else if err := verifyDownload(partialPath, info, log); err != nil {
+		log.Error("Verification of download failed", "error", err)
+		// The content on disk cannot be trusted, so start over next time.
+		os.Remove(partialPath)
+		os.Remove(statusFilePath)
+		return err
+	}
+
+	if err := os.Rename(partialPath, localPath); err != nil {
+		return fmt.Errorf("failed to move downloaded file into place: %w", err)
+	}
+	if err := os.Remove(statusFilePath); err != nil && !os.IsNotExist(err) {
+		log.Error("Failed to remove status file", "status_file", statusFilePath, "error", err)
+	}
+
+	log.Info("Download completed successfully")
+	return nil
+}
+
+// resumableStatus returns the saved status of a previous download of the
+// same object to a file of size bytes, or nil if there is none or the
+// object has changed since.
+func (d *Downloader) resumableStatus(statusFilePath, partialPath string, info storage.ObjectInfo, size int64, log *utils.Logger) *DownloadStatus {
+	status, err := LoadStatus(statusFilePath)
+	if err != nil {
+		return nil
+	}
+	if !etag.Equal(status.ETag, info.ETag) || status.Size != info.Size || status.ChunkSize <= 0 {
+		log.Info("Object has changed since the previous download; starting over")
+		return nil
+	}
+	fi, err := os.Stat(partialPath)
+	if err != nil || fi.Size() != size {
+		return nil
+	}
+	log.Info("Resuming download", "completed_ranges", len(status.CompletedParts), "total_ranges", status.TotalParts)
+	return status
+}
+
+// createPartialFile creates the file a download is written to, preallocated
+// to the size of the object.
+func createPartialFile(partialPath string, size int64) error {
+	file, err := os.Create(partialPath)
+	if err != nil {
+		return fmt.Errorf("failed to create partial file: %w", err)
+	}
+	if err := file.Truncate(size); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to preallocate partial file: %w", err)
+	}
+	return file.Close()
+}
+
+// downloadRange fetches a single byte range into file, retrying on failure.
+// If key is set, ch is a range of the plaintext of an object of size
+// bytes, which is decrypted from the range of the object holding it.
+func (d *Downloader) downloadRange(ctx context.Context, file *os.File, status *DownloadStatus, statusFilePath string, ch chunker.Chunk, key *encryption.DataKey, size int64, retry utils.RetryPolicy, log *utils.Logger) error {
+	log.Debug("Downloading range", "offset", ch.Offset, "bytes", ch.Size)
+	offset, length := ch.Offset, ch.Size
+	if key != nil {
+		offset, length = encryption.Range(ch.Offset, ch.Size, size)
+	}
+
+	err := retry.Do(ctx, func() error {
+		body, err := d.Store.GetObjectRange(ctx, status.Key, status.ETag, offset, length)
+		if err != nil {
+			log.Error("Failed to get range", "error", err)
+			return err
+		}
+		defer body.Close()
+
+		var r io.Reader = io.LimitReader(body, length)
+		if key != nil {
+			r = key.NewDecrypter(r, ch.Offset, ch.Size, size)
+		}
+		n, err := io.Copy(io.NewOffsetWriter(file, ch.Offset), r)
+		if err != nil {
+			log.Error("Failed to read range", "error", err)
+			return err
+		}
+		if n != ch.Size {
+			return fmt.Errorf("short read for range %d: got %d bytes, expected %d", ch.Index, n, ch.Size)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to download range %d after retries: %w", ch.Index, err)
+	}
+	// The range must be on disk before the status says so, or a crash
+	// would leave a hole that a resumed download skips.
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync range %d: %w", ch.Index, err)
+	}
+
+	status.AddCompletedPart(ch.Index)
+	if err := status.SaveStatus(statusFilePath); err != nil {
+		log.Error("Failed to save status after completing range", "status_file", statusFilePath, "error", err)
+		// Non-fatal, but log it
+	}
+	return nil
+}
+
+// verifyDownload checks the downloaded file against the object's ETag.
+// Multipart ETags are recomputed using the object's part size.
+func verifyDownload(filePath string, info storage.ObjectInfo, log *utils.Logger) error {
+	if !storage.ETagIsMD5(info.SSE) {
+		log.Info("Object ETag is not an MD5 digest under its server-side encryption; skipping ETag verification", "sse", info.SSE)
+		return nil
+	}
+	var partSize int64
+	if etag.PartCount(info.ETag) > 0 {
+		if info.PartSize <= 0 {
+			log.Info("Part size of the object is unknown; skipping ETag verification")
+			return nil
+		}
+		// Objects uploaded with parts of varying size cannot be recomputed
+		// from the size of the first part.
+		if len(chunker.ChunksForSize(info.Size, info.PartSize)) != etag.PartCount(info.ETag) {
+			log.Info("Parts of the object are not of equal size; skipping ETag verification")
+			return nil
+		}
+		partSize = info.PartSize
+	} else if len(etag.Normalize(info.ETag)) != 32 {
+		log.Info("Object ETag is not an MD5 digest; skipping ETag verification", "etag", info.ETag)
+		return nil
+	}
+
+	computed, err := etag.ComputeFile(filePath, partSize)
+	if err != nil {
+		return fmt.Errorf("failed to compute ETag of downloaded file: %w", err)
+	}
+	if !etag.Equal(computed, info.ETag) {
+		return fmt.Errorf("downloaded file does not match %s: ETag is %s, expected %s", info.Key, computed, etag.Normalize(info.ETag))
+	}
+	log.Info("Verified ETag", "etag", computed)
+	return nil
+}
@@ -0,0 +1,190 @@
+package downloader
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yucori/Favus/internal/s3test"
+	"github.com/yucori/Favus/internal/storage"
+	"github.com/yucori/Favus/pkg/utils"
+)
+
+// rangeSize is the size of the ranges objects are downloaded in by tests.
+const rangeSize = 1024
+
+// newTestDownloader returns a downloader reading from a new fake S3
+// server in ranges of rangeSize bytes, with retries without waits.
+func newTestDownloader(t *testing.T) (*Downloader, *s3test.Server) {
+	t.Helper()
+	srv := s3test.NewServer("favus-test")
+	t.Cleanup(srv.Close)
+	cfg := srv.Config()
+	cfg.ChunkSize = rangeSize
+	cfg.Concurrency = 2
+	cfg.Retry = utils.RetryPolicy{InitialInterval: time.Millisecond, MaxInterval: time.Millisecond}
+	return NewDownloader(cfg, srv.Store()), srv
+}
+
+// randomData returns n random bytes.
+func randomData(t *testing.T, n int) []byte {
+	t.Helper()
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// checkFile fails the test unless the file at path holds data and no
+// download status or partial file is left next to it.
+func checkFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("downloaded %d bytes, want the %d bytes of the object", len(got), len(data))
+	}
+	for _, p := range []string{StatusFilePath(path), partialFilePath(path)} {
+		if _, err := os.Stat(p); !os.IsNotExist(err) {
+			t.Errorf("%s left behind: %v", p, err)
+		}
+	}
+}
+
+// interruptedDownload leaves the state of a download of key to path that
+// stopped after writing the ranges in done.
+func interruptedDownload(t *testing.T, d *Downloader, key, path string, data []byte, done ...int) {
+	t.Helper()
+	info, err := d.Store.HeadObject(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := NewDownloadStatus(key, path, info.ETag, info.Size, rangeSize, (len(data)+rangeSize-1)/rangeSize)
+	partial := make([]byte, len(data))
+	for _, n := range done {
+		start := (n - 1) * rangeSize
+		end := min(start+rangeSize, len(data))
+		copy(partial[start:end], data[start:end])
+		status.AddCompletedPart(n)
+	}
+	if err := os.WriteFile(partialFilePath(path), partial, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := status.SaveStatus(StatusFilePath(path)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDownloadFile(t *testing.T) {
+	d, srv := newTestDownloader(t)
+	data := randomData(t, 5*rangeSize+100)
+	srv.PutObject("dir/data.bin", data)
+	dir := t.TempDir()
+
+	// A directory receives the object under its base name.
+	if err := d.DownloadFile(context.Background(), "dir/data.bin", dir); err != nil {
+		t.Fatalf("DownloadFile: %v", err)
+	}
+	checkFile(t, filepath.Join(dir, "data.bin"), data)
+	if n := srv.CountRequests("GetObject", 0); n != 6 {
+		t.Errorf("fetched %d ranges, want 6", n)
+	}
+}
+
+func TestDownloadFileResume(t *testing.T) {
+	d, srv := newTestDownloader(t)
+	data := randomData(t, 5*rangeSize+100)
+	srv.PutObject("data.bin", data)
+	path := filepath.Join(t.TempDir(), "data.bin")
+	interruptedDownload(t, d, "data.bin", path, data, 1, 2, 4)
+
+	if err := d.DownloadFile(context.Background(), "data.bin", path); err != nil {
+		t.Fatalf("DownloadFile: %v", err)
+	}
+	checkFile(t, path, data)
+	// Only the ranges missing from the status were fetched.
+	if n := srv.CountRequests("GetObject", 0); n != 3 {
+		t.Errorf("fetched %d ranges, want the 3 missing ones", n)
+	}
+}
+
+func TestDownloadFileChangedSinceInterrupted(t *testing.T) {
+	d, srv := newTestDownloader(t)
+	data := randomData(t, 5*rangeSize+100)
+	srv.PutObject("data.bin", data)
+	path := filepath.Join(t.TempDir(), "data.bin")
+	interruptedDownload(t, d, "data.bin", path, data, 1, 2, 4)
+
+	// The object is replaced before the download is resumed: the saved
+	// ranges belong to the old one.
+	changed := randomData(t, len(data))
+	srv.PutObject("data.bin", changed)
+	if err := d.DownloadFile(context.Background(), "data.bin", path); err != nil {
+		t.Fatalf("DownloadFile: %v", err)
+	}
+	checkFile(t, path, changed)
+	if n := srv.CountRequests("GetObject", 0); n != 6 {
+		t.Errorf("fetched %d ranges, want all 6", n)
+	}
+}
+
+// replacingStore replaces the object being downloaded with other data
+// once the first range has been fetched.
+type replacingStore struct {
+	storage.ObjectStore
+	srv  *s3test.Server
+	data []byte
+	once sync.Once
+}
+
+func (s *replacingStore) GetObjectRange(ctx context.Context, key, eTag string, offset, length int64) (io.ReadCloser, error) {
+	body, err := s.ObjectStore.GetObjectRange(ctx, key, eTag, offset, length)
+	s.once.Do(func() { s.srv.PutObject(key, s.data) })
+	return body, err
+}
+
+func TestDownloadFileChangedDuringDownload(t *testing.T) {
+	d, srv := newTestDownloader(t)
+	d.Config.Concurrency = 1
+	data := randomData(t, 5*rangeSize+100)
+	srv.PutObject("data.bin", data)
+	changed := randomData(t, len(data))
+	d.Store = &replacingStore{ObjectStore: d.Store, srv: srv, data: changed}
+	path := filepath.Join(t.TempDir(), "data.bin")
+
+	// Ranges of the new object must not be mixed with those of the old.
+	err := d.DownloadFile(context.Background(), "data.bin", path)
+	if err == nil || !strings.Contains(err.Error(), "PreconditionFailed") {
+		t.Fatalf("DownloadFile returned %v, want a failed precondition", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("file was created: %v", err)
+	}
+	if n := srv.CountRequests("GetObject", 0); n != 2 {
+		t.Errorf("fetched %d ranges, want to stop at the first changed one", n)
+	}
+	status, err := LoadStatus(StatusFilePath(path))
+	if err != nil {
+		t.Fatalf("status was not saved: %v", err)
+	}
+	if len(status.CompletedParts) != 1 {
+		t.Errorf("status records %d ranges, want the first one", len(status.CompletedParts))
+	}
+
+	// The next attempt starts over with the new object.
+	d.Store = srv.Store()
+	if err := d.DownloadFile(context.Background(), "data.bin", path); err != nil {
+		t.Fatalf("DownloadFile: %v", err)
+	}
+	checkFile(t, path, changed)
+}
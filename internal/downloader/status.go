@@ -0,0 +1,78 @@
+package downloader
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/yucori/Favus/pkg/utils"
+)
+
+// DownloadStatus represents the status of a ranged download.
+type DownloadStatus struct {
+	Key            string       `json:"key"`
+	LocalPath      string       `json:"localPath"`
+	ETag           string       `json:"etag"`      // ETag of the object when the download started
+	Size           int64        `json:"size"`      // Size of the object
+	ChunkSize      int64        `json:"chunkSize"` // Size of each byte range
+	CompletedParts map[int]bool `json:"completedParts"`
+	TotalParts     int          `json:"totalParts"`
+	Mu             sync.Mutex   `json:"-"` // Mutex to protect concurrent access
+}
+
+// NewDownloadStatus creates a new DownloadStatus.
+func NewDownloadStatus(key, localPath, eTag string, size, chunkSize int64, totalParts int) *DownloadStatus {
+	return &DownloadStatus{
+		Key:            key,
+		LocalPath:      localPath,
+		ETag:           eTag,
+		Size:           size,
+		ChunkSize:      chunkSize,
+		CompletedParts: make(map[int]bool),
+		TotalParts:     totalParts,
+	}
+}
+
+// AddCompletedPart marks a byte range as written to the local file.
+func (ds *DownloadStatus) AddCompletedPart(partNumber int) {
+	ds.Mu.Lock()
+	defer ds.Mu.Unlock()
+	ds.CompletedParts[partNumber] = true
+}
+
+// IsPartCompleted checks if a byte range has been written.
+func (ds *DownloadStatus) IsPartCompleted(partNumber int) bool {
+	ds.Mu.Lock()
+	defer ds.Mu.Unlock()
+	return ds.CompletedParts[partNumber]
+}
+
+// SaveStatus saves the current download status to a file, replacing it
+// atomically so that a crash never leaves a truncated status.
+func (ds *DownloadStatus) SaveStatus(statusFilePath string) error {
+	ds.Mu.Lock()
+	defer ds.Mu.Unlock()
+
+	data, err := json.MarshalIndent(ds, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal download status: %w", err)
+	}
+	return utils.WriteFileAtomic(statusFilePath, data)
+}
+
+// LoadStatus loads a download status from a file.
+func LoadStatus(statusFilePath string) (*DownloadStatus, error) {
+	data, err := os.ReadFile(statusFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read download status file: %w", err)
+	}
+	var ds DownloadStatus
+	if err := json.Unmarshal(data, &ds); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal download status: %w", err)
+	}
+	if ds.CompletedParts == nil {
+		ds.CompletedParts = make(map[int]bool)
+	}
+	return &ds, nil
+}
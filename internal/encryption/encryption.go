@@ -0,0 +1,356 @@
+// Package encryption encrypts objects on the client before they are sent
+// to the store, with envelope encryption: every object is encrypted under
+// its own random data key, which is stored with the object wrapped by a
+// master key that never leaves the host.
+//
+// The content of an object is split into segments of SegmentSize bytes,
+// each encrypted with AES-256-GCM under the data key. The nonce of a
+// segment is its index, and the last segment, which holds less than
+// SegmentSize bytes and may be empty, is authenticated as the final one,
+// so that segments cannot be reordered, dropped or truncated without the
+// decryption failing. Encrypting the same content under the same data key
+// always gives the same ciphertext, so a part can be retried, and checked
+// against a stored part, by encrypting it again.
+//
+// Parts of a multipart upload hold whole segments, so that each part can
+// be encrypted, and each byte range of the object decrypted, on its own.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/yucori/Favus/internal/chunker"
+	"github.com/yucori/Favus/internal/storage"
+)
+
+// Algorithm is the content encryption algorithm, recorded in the
+// storage.MetaEncryption metadata of encrypted objects.
+const Algorithm = "AES-256-GCM"
+
+// Layout of the ciphertext.
+const (
+	SegmentSize = 64 * 1024 // Plaintext bytes in every segment but the last
+	Overhead    = 16        // Bytes added to every segment, its GCM tag
+
+	keySize = 32 // AES-256
+)
+
+// Size returns the size of the ciphertext of size bytes of plaintext.
+func Size(size int64) int64 {
+	return EncryptedSize(size, true)
+}
+
+// EncryptedSize returns the size of the ciphertext of size bytes of
+// plaintext starting at a segment boundary. If final is set they end the
+// object, and the final segment is counted; otherwise size must be a
+// multiple of SegmentSize.
+func EncryptedSize(size int64, final bool) int64 {
+	segments := (size + SegmentSize - 1) / SegmentSize
+	if final {
+		segments = size/SegmentSize + 1
+	}
+	return size + segments*Overhead
+}
+
+// PlaintextSize returns the size of the plaintext of an object whose
+// ciphertext has size bytes.
+func PlaintextSize(size int64) (int64, error) {
+	full := size / (SegmentSize + Overhead)
+	last := size % (SegmentSize + Overhead)
+	if last < Overhead {
+		return 0, fmt.Errorf("%d bytes is not the size of an encrypted object", size)
+	}
+	return full*SegmentSize + last - Overhead, nil
+}
+
+// PartSize returns the part size to split the plaintext of a multipart
+// upload with instead of size: a whole number of segments, small enough
+// for the encrypted part to respect chunker.MaxPartSize.
+func PartSize(size int64) int64 {
+	const largest = chunker.MaxPartSize / (SegmentSize + Overhead) * SegmentSize
+	size = (size + SegmentSize - 1) / SegmentSize * SegmentSize
+	return min(size, largest)
+}
+
+// Range returns the byte range of the ciphertext holding length bytes of
+// plaintext at offset, in an object of size bytes of plaintext. offset
+// must be at a segment boundary, and offset+length too unless it is size.
+func Range(offset, length, size int64) (int64, int64) {
+	start := offset / SegmentSize * (SegmentSize + Overhead)
+	if offset+length == size {
+		return start, Size(size) - start
+	}
+	return start, EncryptedSize(length, false)
+}
+
+// DataKey is the key an object is encrypted with, along with its wrapped
+// form stored with the object.
+type DataKey struct {
+	aead     cipher.AEAD
+	wrapped  []byte
+	provider string
+	keyID    string
+}
+
+// NewDataKey generates a random data key for a new object and wraps it
+// with keys.
+func NewDataKey(keys KeyProvider) (*DataKey, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+	wrapped, err := keys.WrapKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &DataKey{aead: aead, wrapped: wrapped, provider: keys.Name(), keyID: keys.KeyID()}, nil
+}
+
+// IsEncrypted reports whether metadata is that of an encrypted object.
+func IsEncrypted(metadata map[string]string) bool {
+	return metadata[storage.MetaEncryption] != ""
+}
+
+// OpenDataKey returns the data key recorded in metadata, unwrapped with
+// keys, or nil if metadata is not that of an encrypted object. It fails if
+// the object is encrypted and keys is nil or does not hold its master key.
+func OpenDataKey(keys KeyProvider, metadata map[string]string) (*DataKey, error) {
+	if !IsEncrypted(metadata) {
+		return nil, nil
+	}
+	if algorithm := metadata[storage.MetaEncryption]; algorithm != Algorithm {
+		return nil, fmt.Errorf("unsupported encryption algorithm %q", algorithm)
+	}
+	provider, keyID := metadata[storage.MetaEncryptionKeyProvider], metadata[storage.MetaEncryptionKeyID]
+	if keys == nil {
+		return nil, fmt.Errorf("content is encrypted with %s key %s, but no encryption key is configured", provider, keyID)
+	}
+	if provider != keys.Name() || keyID != keys.KeyID() {
+		return nil, fmt.Errorf("content is encrypted with %s key %s, not with the configured %s key %s", provider, keyID, keys.Name(), keys.KeyID())
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(metadata[storage.MetaEncryptionKey])
+	if err != nil {
+		return nil, fmt.Errorf("invalid wrapped data key: %w", err)
+	}
+	key, err := keys.UnwrapKey(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &DataKey{aead: aead, wrapped: wrapped, provider: provider, keyID: keyID}, nil
+}
+
+// Metadata returns the metadata recording the algorithm and the wrapped
+// data key, to store with the object.
+func (k *DataKey) Metadata() map[string]string {
+	return map[string]string{
+		storage.MetaEncryption:            Algorithm,
+		storage.MetaEncryptionKey:         base64.StdEncoding.EncodeToString(k.wrapped),
+		storage.MetaEncryptionKeyProvider: k.provider,
+		storage.MetaEncryptionKeyID:       k.keyID,
+	}
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// nonce returns the nonce of segment index.
+func nonce(index int64) []byte {
+	n := make([]byte, 12)
+	binary.BigEndian.PutUint64(n[4:], uint64(index))
+	return n
+}
+
+// additionalData returns the data authenticated with a segment, which
+// tells whether it is the final one.
+func additionalData(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// Encrypter reads the ciphertext of a range of plaintext that starts at a
+// segment boundary, encrypting it a segment at a time. It is not safe for
+// concurrent use.
+type Encrypter struct {
+	key    *DataKey
+	src    io.ReadSeeker
+	first  int64 // Index of the first segment
+	size   int64 // Plaintext bytes
+	final  bool  // Whether the plaintext ends the object
+	cSize  int64 // Ciphertext bytes
+	pos    int64 // Read position in the ciphertext
+	srcPos int64 // Read position in src
+	seg    int64 // Segment in buf, relative to first; -1 if none
+	buf    []byte
+}
+
+// NewEncrypter returns an Encrypter of the size bytes of plaintext read
+// from src, which are at offset in the object. offset must be a multiple
+// of SegmentSize, and so must size unless final says that the plaintext
+// ends the object.
+func (k *DataKey) NewEncrypter(src io.ReadSeeker, offset, size int64, final bool) (*Encrypter, error) {
+	if offset%SegmentSize != 0 || (!final && size%SegmentSize != 0) {
+		return nil, fmt.Errorf("%d bytes at offset %d do not hold whole encryption segments", size, offset)
+	}
+	return &Encrypter{
+		key:   k,
+		src:   src,
+		first: offset / SegmentSize,
+		size:  size,
+		final: final,
+		cSize: EncryptedSize(size, final),
+		seg:   -1,
+	}, nil
+}
+
+// Size returns the size of the ciphertext.
+func (e *Encrypter) Size() int64 {
+	return e.cSize
+}
+
+// Read reads the ciphertext.
+func (e *Encrypter) Read(p []byte) (int, error) {
+	if e.pos >= e.cSize {
+		return 0, io.EOF
+	}
+	seg := e.pos / (SegmentSize + Overhead)
+	if seg != e.seg {
+		if err := e.seal(seg); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, e.buf[e.pos-seg*(SegmentSize+Overhead):])
+	e.pos += int64(n)
+	return n, nil
+}
+
+// seal encrypts segment seg into buf.
+func (e *Encrypter) seal(seg int64) error {
+	start := seg * SegmentSize
+	if e.srcPos != start {
+		if _, err := e.src.Seek(start, io.SeekStart); err != nil {
+			return err
+		}
+		e.srcPos = start
+	}
+	plain := make([]byte, min(SegmentSize, e.size-start))
+	n, err := io.ReadFull(e.src, plain)
+	e.srcPos += int64(n)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	final := e.final && start+SegmentSize > e.size
+	e.buf = e.key.aead.Seal(e.buf[:0], nonce(e.first+seg), plain, additionalData(final))
+	e.seg = seg
+	return nil
+}
+
+// Seek sets the position of the next Read in the ciphertext.
+func (e *Encrypter) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += e.pos
+	case io.SeekEnd:
+		offset += e.cSize
+	default:
+		return 0, errors.New("encryption: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("encryption: negative position")
+	}
+	e.pos = offset
+	return offset, nil
+}
+
+// Decrypter reads the plaintext of a byte range of an encrypted object,
+// decrypting and authenticating it a segment at a time.
+type Decrypter struct {
+	key   *DataKey
+	src   io.Reader
+	next  int64 // Index of the next segment to decrypt
+	last  int64 // Index of the last segment to decrypt
+	final int64 // Index of the final segment of the object
+	size  int64 // Plaintext bytes in the object
+	buf   []byte
+	plain []byte // Decrypted bytes not read yet
+}
+
+// NewDecrypter returns a Decrypter of length bytes of plaintext at offset
+// in an object of size bytes of plaintext, reading the ciphertext given by
+// Range from src. Read fails if the ciphertext was modified.
+func (k *DataKey) NewDecrypter(src io.Reader, offset, length, size int64) *Decrypter {
+	d := &Decrypter{
+		key:   k,
+		src:   src,
+		next:  offset / SegmentSize,
+		last:  (offset + length - 1) / SegmentSize,
+		final: size / SegmentSize,
+		size:  size,
+	}
+	if offset+length == size {
+		d.last = d.final
+	}
+	return d
+}
+
+// Read reads the plaintext.
+func (d *Decrypter) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.next > d.last {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// open decrypts the next segment into plain.
+func (d *Decrypter) open() error {
+	length := min(SegmentSize, d.size-d.next*SegmentSize) + Overhead
+	if cap(d.buf) < int(length) {
+		d.buf = make([]byte, length)
+	}
+	d.buf = d.buf[:length]
+	if _, err := io.ReadFull(d.src, d.buf); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("failed to read encryption segment %d: %w", d.next, err)
+	}
+	plain, err := d.key.aead.Open(d.buf[:0], nonce(d.next), d.buf, additionalData(d.next == d.final))
+	if err != nil {
+		return fmt.Errorf("encryption segment %d failed authentication: content was modified or is not of this object", d.next)
+	}
+	d.plain = plain
+	d.next++
+	return nil
+}
@@ -0,0 +1,166 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestProvider returns a KeyfileProvider with a random master key.
+func newTestProvider(t *testing.T) *KeyfileProvider {
+	t.Helper()
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "master.key")
+	if err := os.WriteFile(path, key, 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := NewKeyfileProvider(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// encrypt returns the ciphertext of plain as a whole object.
+func encrypt(t *testing.T, key *DataKey, plain []byte) []byte {
+	t.Helper()
+	enc, err := key.NewEncrypter(bytes.NewReader(plain), 0, int64(len(plain)), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := io.ReadAll(enc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ciphertext
+}
+
+var testSizes = []int64{0, 1, SegmentSize - 1, SegmentSize, SegmentSize + 1, 3 * SegmentSize, 5*SegmentSize + 77}
+
+func TestRoundTrip(t *testing.T) {
+	keys := newTestProvider(t)
+	for _, size := range testSizes {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			plain := make([]byte, size)
+			rand.Read(plain)
+			key, err := NewDataKey(keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ciphertext := encrypt(t, key, plain)
+			if int64(len(ciphertext)) != Size(size) {
+				t.Fatalf("ciphertext has %d bytes, want %d", len(ciphertext), Size(size))
+			}
+			if got, err := PlaintextSize(int64(len(ciphertext))); err != nil || got != size {
+				t.Fatalf("PlaintextSize = %d, %v, want %d", got, err, size)
+			}
+
+			// Decrypt in ranges of two segments with the key read back from
+			// the metadata.
+			opened, err := OpenDataKey(keys, key.Metadata())
+			if err != nil {
+				t.Fatalf("OpenDataKey: %v", err)
+			}
+			var got []byte
+			for offset := int64(0); offset == 0 || offset < size; offset += 2 * SegmentSize {
+				length := min(2*SegmentSize, size-offset)
+				start, n := Range(offset, length, size)
+				dec := opened.NewDecrypter(bytes.NewReader(ciphertext[start:start+n]), offset, length, size)
+				b, err := io.ReadAll(dec)
+				if err != nil {
+					t.Fatalf("decrypting %d bytes at %d: %v", length, offset, err)
+				}
+				got = append(got, b...)
+			}
+			if !bytes.Equal(got, plain) {
+				t.Fatal("decrypted content differs from the plaintext")
+			}
+		})
+	}
+}
+
+func TestEncryptPart(t *testing.T) {
+	keys := newTestProvider(t)
+	key, err := NewDataKey(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const size = 5*SegmentSize + 77
+	plain := make([]byte, size)
+	rand.Read(plain)
+	ciphertext := encrypt(t, key, plain)
+
+	// Parts encrypted on their own, as by concurrent workers, are the
+	// ciphertext of the whole object cut at segment boundaries.
+	const offset = 2 * SegmentSize
+	first, err := key.NewEncrypter(bytes.NewReader(plain[:offset]), 0, offset, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := key.NewEncrypter(bytes.NewReader(plain[offset:]), offset, size-offset, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Size() != EncryptedSize(offset, false) || second.Size() != EncryptedSize(size-offset, true) {
+		t.Errorf("parts have %d and %d bytes, want %d and %d", first.Size(), second.Size(), EncryptedSize(offset, false), EncryptedSize(size-offset, true))
+	}
+	parts, err := io.ReadAll(io.MultiReader(first, second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(parts, ciphertext) {
+		t.Error("parts differ from the ciphertext of the whole object")
+	}
+}
+
+func TestDecryptDetectsTampering(t *testing.T) {
+	keys := newTestProvider(t)
+	key, err := NewDataKey(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const size = 3 * SegmentSize
+	plain := make([]byte, size)
+	rand.Read(plain)
+	ciphertext := encrypt(t, key, plain)
+
+	tests := map[string][]byte{
+		// Dropping the final segment must not pass for the end of the
+		// object.
+		"truncated": ciphertext[:EncryptedSize(size, false)],
+		"modified":  append([]byte{ciphertext[0] ^ 1}, ciphertext[1:]...),
+	}
+	for name, tampered := range tests {
+		t.Run(name, func(t *testing.T) {
+			dec := key.NewDecrypter(bytes.NewReader(tampered), 0, size, size)
+			if _, err := io.ReadAll(dec); err == nil {
+				t.Error("tampered ciphertext was decrypted")
+			}
+		})
+	}
+}
+
+func TestOpenDataKey(t *testing.T) {
+	keys := newTestProvider(t)
+	key, err := NewDataKey(keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if key, err := OpenDataKey(nil, map[string]string{"other": "value"}); key != nil || err != nil {
+		t.Errorf("OpenDataKey of plain metadata = %v, %v, want nil, nil", key, err)
+	}
+	if _, err := OpenDataKey(nil, key.Metadata()); err == nil {
+		t.Error("OpenDataKey succeeded without a master key")
+	}
+	if _, err := OpenDataKey(newTestProvider(t), key.Metadata()); err == nil {
+		t.Error("OpenDataKey succeeded with another master key")
+	}
+}
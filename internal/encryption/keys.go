@@ -0,0 +1,96 @@
+package encryption
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+
+	"github.com/yucori/Favus/internal/config"
+	"github.com/yucori/Favus/internal/keyfile"
+)
+
+// KeyProvider wraps the data keys of objects with a master key. Its name
+// and key ID are recorded with every object, so that the provider and key
+// needed to decrypt it can be told apart from others.
+type KeyProvider interface {
+	// Name identifies the kind of provider, such as "keyfile".
+	Name() string
+	// KeyID identifies the master key. It must not reveal the key.
+	KeyID() string
+	// WrapKey encrypts a data key with the master key.
+	WrapKey(key []byte) ([]byte, error)
+	// UnwrapKey decrypts a data key wrapped by WrapKey.
+	UnwrapKey(wrapped []byte) ([]byte, error)
+}
+
+// NewKeyProviderFromConfig returns the KeyProvider selected by cfg, or nil
+// if client-side encryption is not configured.
+func NewKeyProviderFromConfig(cfg *config.Config) (KeyProvider, error) {
+	if cfg.EncryptionKeyFile == "" {
+		return nil, nil
+	}
+	return NewKeyfileProvider(cfg.EncryptionKeyFile)
+}
+
+// KeyfileProvider wraps data keys with AES-256-GCM under a master key read
+// from a local file.
+type KeyfileProvider struct {
+	aead  cipher.AEAD
+	keyID string
+}
+
+// wrapData is authenticated with every wrapped data key, so that the
+// master key cannot be used to unwrap anything else.
+var wrapData = []byte("favus data key")
+
+// NewKeyfileProvider reads the master key from the file at path, in one
+// of the formats of keyfile.Read.
+func NewKeyfileProvider(path string) (*KeyfileProvider, error) {
+	key, err := keyfile.Read(path)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	// The ID is a hash of the key, which cannot be used to recover it.
+	sum := sha256.Sum256(append([]byte("favus key id\x00"), key...))
+	return &KeyfileProvider{aead: aead, keyID: hex.EncodeToString(sum[:8])}, nil
+}
+
+// Name returns "keyfile".
+func (p *KeyfileProvider) Name() string {
+	return "keyfile"
+}
+
+// KeyID returns the first 8 bytes, in hex, of a SHA-256 hash of the master
+// key.
+func (p *KeyfileProvider) KeyID() string {
+	return p.keyID
+}
+
+// WrapKey encrypts key with a random nonce, which is prepended to the
+// result.
+func (p *KeyfileProvider) WrapKey(key []byte) ([]byte, error) {
+	n := make([]byte, p.aead.NonceSize())
+	if _, err := rand.Read(n); err != nil {
+		return nil, err
+	}
+	return p.aead.Seal(n, n, key, wrapData), nil
+}
+
+// UnwrapKey decrypts a key wrapped by WrapKey.
+func (p *KeyfileProvider) UnwrapKey(wrapped []byte) ([]byte, error) {
+	if len(wrapped) < p.aead.NonceSize() {
+		return nil, errors.New("wrapped key is too short")
+	}
+	n := p.aead.NonceSize()
+	key, err := p.aead.Open(nil, wrapped[:n], wrapped[n:], wrapData)
+	if err != nil {
+		return nil, errors.New("wrapped key failed authentication: it was not wrapped with this master key")
+	}
+	return key, nil
+}
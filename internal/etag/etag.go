@@ -0,0 +1,106 @@
+// Package etag computes S3 ETags of local files so that they can be
+// compared against remote objects.
+//
+// A single-part object's ETag is the hex MD5 of its content. A multipart
+// object's ETag is the hex MD5 of the concatenated binary MD5s of its parts,
+// followed by "-" and the number of parts.
+package etag
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/yucori/Favus/internal/chunker"
+)
+
+// Normalize strips the surrounding quotes S3 puts around ETags.
+func Normalize(eTag string) string {
+	return strings.Trim(eTag, `"`)
+}
+
+// Equal reports whether two ETags are the same, ignoring quotes.
+func Equal(a, b string) bool {
+	return Normalize(a) == Normalize(b)
+}
+
+// PartCount returns the number of parts encoded in a multipart ETag, or 0
+// if eTag is a single-part ETag.
+func PartCount(eTag string) int {
+	_, count, ok := strings.Cut(Normalize(eTag), "-")
+	if !ok {
+		return 0
+	}
+	n, err := strconv.Atoi(count)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// PartDigests returns the hex MD5 of each part of the file at filePath
+// when split into parts of partSize bytes.
+func PartDigests(filePath string, partSize int64) ([]string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file info: %w", err)
+	}
+
+	chunks := chunker.ChunksForSize(fileInfo.Size(), partSize)
+	digests := make([]string, 0, len(chunks))
+	for _, ch := range chunks {
+		h := md5.New()
+		if _, err := io.Copy(h, io.NewSectionReader(file, ch.Offset, ch.Size)); err != nil {
+			return nil, fmt.Errorf("failed to read part %d: %w", ch.Index, err)
+		}
+		digests = append(digests, hex.EncodeToString(h.Sum(nil)))
+	}
+	return digests, nil
+}
+
+// FromPartDigests combines hex part MD5s into a multipart ETag.
+func FromPartDigests(digests []string) (string, error) {
+	h := md5.New()
+	for i, d := range digests {
+		raw, err := hex.DecodeString(d)
+		if err != nil {
+			return "", fmt.Errorf("invalid digest for part %d: %w", i+1, err)
+		}
+		h.Write(raw)
+	}
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(h.Sum(nil)), len(digests)), nil
+}
+
+// ComputeFile returns the unquoted ETag the file at filePath would have if
+// uploaded with parts of partSize bytes. A partSize of zero computes the
+// ETag of a single-part upload.
+func ComputeFile(filePath string, partSize int64) (string, error) {
+	if partSize <= 0 {
+		file, err := os.Open(filePath)
+		if err != nil {
+			return "", fmt.Errorf("failed to open file: %w", err)
+		}
+		defer file.Close()
+		h := md5.New()
+		if _, err := io.Copy(h, file); err != nil {
+			return "", fmt.Errorf("failed to read file: %w", err)
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	digests, err := PartDigests(filePath, partSize)
+	if err != nil {
+		return "", err
+	}
+	return FromPartDigests(digests)
+}
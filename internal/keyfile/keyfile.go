@@ -0,0 +1,44 @@
+// Package keyfile reads the 256-bit keys favus is given in files: the
+// master key of client-side encryption and the customer key of SSE-C.
+package keyfile
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+)
+
+// Size is the size of a key, for AES-256.
+const Size = 32
+
+// Read returns the key held by the file at path: 32 bytes, either raw or
+// encoded in hex or base64, such as made by
+// `head -c 32 /dev/urandom > keyfile`.
+func Read(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	key, err := parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key file %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// parse returns the key held by the content of a key file.
+func parse(data []byte) ([]byte, error) {
+	if len(data) == Size {
+		return data, nil
+	}
+	text := string(bytes.TrimSpace(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == Size {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == Size {
+		return key, nil
+	}
+	return nil, fmt.Errorf("want a %d-byte key, raw or in hex or base64", Size)
+}
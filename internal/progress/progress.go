@@ -0,0 +1,232 @@
+// Package progress tracks the bytes sent by uploads and reports them as
+// events, rendered as a terminal progress bar or as periodic log records.
+package progress
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Interval is how often a running Tracker reports progress.
+const Interval = 200 * time.Millisecond
+
+// Event is a snapshot of the progress of one upload.
+type Event struct {
+	Key         string        // Object being uploaded
+	Bytes       int64         // Bytes sent so far, including parts sent before a resume
+	TotalBytes  int64         // Size of the upload, or -1 if unknown as for a stream
+	Parts       int           // Parts completed
+	TotalParts  int           // Parts in the upload, or 0 if unknown
+	ActiveParts int           // Parts being sent right now
+	Elapsed     time.Duration // Time since the tracker started
+	Rate        float64       // Average bytes per second since the tracker started
+	ETA         time.Duration // Estimated time left; zero when unknown
+	Done        bool          // Whether this is the last event of the upload
+	Err         error         // Why the upload failed, for the last event
+}
+
+// Percent returns the share of the upload sent so far, from 0 to 100, or
+// -1 if the size of the upload is unknown.
+func (e Event) Percent() float64 {
+	if e.TotalBytes < 0 {
+		return -1
+	}
+	if e.TotalBytes == 0 {
+		return 100
+	}
+	return float64(e.Bytes) * 100 / float64(e.TotalBytes)
+}
+
+// Func receives progress events. A Func shared by several uploads, such as
+// the uploads of a directory, is called concurrently and must be safe for
+// concurrent use.
+type Func func(Event)
+
+// Tracker tracks the progress of one upload and reports it to a Func, every
+// Interval while running and whenever a part completes.
+//
+// A nil *Tracker is valid and does nothing, so that uploads without a
+// progress callback need no special casing.
+type Tracker struct {
+	fn         Func
+	key        string
+	totalBytes int64
+	totalParts int
+
+	mu       sync.Mutex // Serializes calls to fn
+	state    sync.Mutex
+	bytes    int64
+	skipped  int64 // Bytes sent before the tracker started, not counted in the rate
+	parts    int
+	active   int
+	start    time.Time
+	stop     chan struct{}
+	finished sync.WaitGroup
+}
+
+// NewTracker returns a Tracker for an upload of totalBytes in totalParts
+// parts to key reporting to fn, or nil if fn is nil.
+func NewTracker(key string, totalBytes int64, totalParts int, fn Func) *Tracker {
+	if fn == nil {
+		return nil
+	}
+	return &Tracker{fn: fn, key: key, totalBytes: totalBytes, totalParts: totalParts}
+}
+
+// Skip records parts already uploaded before the tracker was created, as
+// when resuming an upload.
+func (t *Tracker) Skip(bytes int64, parts int) {
+	if t == nil {
+		return
+	}
+	t.state.Lock()
+	defer t.state.Unlock()
+	t.bytes += bytes
+	t.skipped += bytes
+	t.parts += parts
+}
+
+// Start starts reporting progress every Interval until Stop is called.
+func (t *Tracker) Start() {
+	if t == nil {
+		return
+	}
+	t.start = time.Now()
+	t.stop = make(chan struct{})
+	t.finished.Add(1)
+	go func() {
+		defer t.finished.Done()
+		ticker := time.NewTicker(Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.report(false, nil)
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+	t.report(false, nil)
+}
+
+// Stop stops the periodic reports and sends the last event, with err as
+// the outcome of the upload.
+func (t *Tracker) Stop(err error) {
+	if t == nil {
+		return
+	}
+	close(t.stop)
+	t.finished.Wait()
+	t.report(true, err)
+}
+
+// PartStarted records that a part is being sent.
+func (t *Tracker) PartStarted() {
+	if t == nil {
+		return
+	}
+	t.state.Lock()
+	t.active++
+	t.state.Unlock()
+}
+
+// PartFinished records that a part is no longer being sent, and whether it
+// completed.
+func (t *Tracker) PartFinished(completed bool) {
+	if t == nil {
+		return
+	}
+	t.state.Lock()
+	t.active--
+	if completed {
+		t.parts++
+	}
+	t.state.Unlock()
+	if completed {
+		t.report(false, nil)
+	}
+}
+
+// Reader returns r with the bytes read from it counted as sent. Seeking
+// back, as done to retry a part, uncounts the bytes read again.
+func (t *Tracker) Reader(r io.ReadSeeker) io.ReadSeeker {
+	if t == nil {
+		return r
+	}
+	return &countingReader{ReadSeeker: r, tracker: t}
+}
+
+func (t *Tracker) add(n int64) {
+	t.state.Lock()
+	t.bytes += n
+	t.state.Unlock()
+}
+
+// Snapshot returns the current progress.
+func (t *Tracker) Snapshot() Event {
+	t.state.Lock()
+	e := Event{
+		Key:         t.key,
+		Bytes:       t.bytes,
+		TotalBytes:  t.totalBytes,
+		Parts:       t.parts,
+		TotalParts:  t.totalParts,
+		ActiveParts: t.active,
+	}
+	sent := t.bytes - t.skipped
+	t.state.Unlock()
+
+	if !t.start.IsZero() {
+		e.Elapsed = time.Since(t.start)
+	}
+	if secs := e.Elapsed.Seconds(); secs > 0 && sent > 0 {
+		e.Rate = float64(sent) / secs
+		if left := e.TotalBytes - e.Bytes; left > 0 {
+			e.ETA = time.Duration(float64(left) / e.Rate * float64(time.Second))
+		}
+	}
+	return e
+}
+
+func (t *Tracker) report(done bool, err error) {
+	e := t.Snapshot()
+	e.Done, e.Err = done, err
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.fn(e)
+}
+
+// countingReader counts the bytes read through it in its Tracker.
+type countingReader struct {
+	io.ReadSeeker
+	tracker *Tracker
+	pos     int64 // Current offset
+	counted int64 // Offset up to which bytes have been counted
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.ReadSeeker.Read(p)
+	cr.pos += int64(n)
+	if cr.pos > cr.counted {
+		cr.tracker.add(cr.pos - cr.counted)
+		cr.counted = cr.pos
+	}
+	return n, err
+}
+
+// Seek seeks the underlying reader. Seeking forward, as done to measure
+// its length, counts nothing; seeking back uncounts the bytes skipped.
+func (cr *countingReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := cr.ReadSeeker.Seek(offset, whence)
+	if err != nil {
+		return pos, err
+	}
+	cr.pos = pos
+	if cr.pos < cr.counted {
+		cr.tracker.add(cr.pos - cr.counted)
+		cr.counted = cr.pos
+	}
+	return pos, nil
+}
@@ -0,0 +1,206 @@
+package progress
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yucori/Favus/pkg/utils"
+)
+
+// DefaultLogInterval is how often a LogReporter logs the progress of an
+// upload.
+const DefaultLogInterval = 5 * time.Second
+
+// IsTerminal reports whether f is a terminal.
+func IsTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// Bar renders the combined progress of one or more uploads as a single
+// line redrawn in place on a terminal:
+//
+//	[##########----------]  50.0%  60.0 MiB / 120.0 MiB  12.3 MiB/s  ETA 5s  4 active
+//
+// While the size of an upload is unknown, only the bytes sent and the rate
+// are shown.
+type Bar struct {
+	w     io.Writer
+	width int // Width of the bar itself, in characters
+
+	mu       sync.Mutex
+	uploads  map[string]Event // Uploads in progress, by key
+	done     Event            // Totals of the finished uploads
+	start    time.Time
+	lastDraw time.Time
+	drawn    bool // Whether the line currently shows the bar
+}
+
+// NewBar returns a Bar drawing on w, which should be a terminal.
+func NewBar(w io.Writer) *Bar {
+	return &Bar{w: w, width: 30, uploads: make(map[string]Event)}
+}
+
+// Update records e and redraws the bar. It is a Func.
+func (b *Bar) Update(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.start.IsZero() {
+		b.start = time.Now()
+	}
+	if e.Done {
+		delete(b.uploads, e.Key)
+		b.done.Bytes += e.Bytes
+		// Once done, the size of a stream is known.
+		b.done.TotalBytes += max(e.TotalBytes, e.Bytes)
+	} else {
+		b.uploads[e.Key] = e
+	}
+	// Redraw at most once per Interval, but always for the last event.
+	if !e.Done && time.Since(b.lastDraw) < Interval {
+		return
+	}
+	b.draw()
+}
+
+// Finish ends the line of the bar, leaving its last state on screen.
+func (b *Bar) Finish() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.drawn {
+		fmt.Fprintln(b.w)
+		b.drawn = false
+	}
+}
+
+// Wrap returns a writer that writes to w, which usually shares the
+// terminal with the bar, after clearing the bar, and redraws the bar after
+// every write. Log output should go through it while the bar is in use.
+func (b *Bar) Wrap(w io.Writer) io.Writer {
+	return &barWriter{bar: b, w: w}
+}
+
+func (b *Bar) draw() {
+	total := b.done
+	active := 0
+	sized := true
+	for _, e := range b.uploads {
+		total.Bytes += e.Bytes
+		total.TotalBytes += e.TotalBytes
+		active += e.ActiveParts
+		sized = sized && e.TotalBytes >= 0
+	}
+	elapsed := time.Since(b.start)
+	var rate float64
+	if secs := elapsed.Seconds(); secs > 0 {
+		rate = float64(total.Bytes) / secs
+	}
+
+	var line string
+	if !sized {
+		line = fmt.Sprintf("%s sent  %s/s", formatBytes(total.Bytes), formatBytes(int64(rate)))
+	} else {
+		filled := int(total.Percent() / 100 * float64(b.width))
+		filled = max(0, min(filled, b.width))
+		line = fmt.Sprintf("[%s%s] %5.1f%%  %s / %s  %s/s",
+			strings.Repeat("#", filled), strings.Repeat("-", b.width-filled),
+			total.Percent(), formatBytes(total.Bytes), formatBytes(total.TotalBytes), formatBytes(int64(rate)))
+	}
+	if left := total.TotalBytes - total.Bytes; sized && left > 0 && rate > 0 {
+		eta := time.Duration(float64(left) / rate * float64(time.Second))
+		line += "  ETA " + eta.Round(time.Second).String()
+	}
+	if active > 0 {
+		line += fmt.Sprintf("  %d active", active)
+	}
+	fmt.Fprint(b.w, "\r\033[K"+line)
+	b.lastDraw = time.Now()
+	b.drawn = true
+}
+
+// barWriter is the writer returned by Bar.Wrap.
+type barWriter struct {
+	bar *Bar
+	w   io.Writer
+}
+
+func (bw *barWriter) Write(p []byte) (int, error) {
+	b := bw.bar
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.drawn {
+		return bw.w.Write(p)
+	}
+	fmt.Fprint(b.w, "\r\033[K")
+	n, err := bw.w.Write(p)
+	b.draw()
+	return n, err
+}
+
+// formatBytes formats n with a binary unit, e.g. "12.3 MiB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// NewLogReporter returns a Func that logs the progress of every upload to
+// log as machine-readable fields at most once per interval, and once more
+// when it is done. A nil log means the package logger of pkg/utils, and a
+// zero interval means DefaultLogInterval.
+func NewLogReporter(log *utils.Logger, interval time.Duration) Func {
+	if log == nil {
+		log = utils.Default()
+	}
+	if interval <= 0 {
+		interval = DefaultLogInterval
+	}
+	var mu sync.Mutex
+	last := make(map[string]time.Time)
+	return func(e Event) {
+		mu.Lock()
+		if e.Done {
+			delete(last, e.Key)
+		} else if time.Since(last[e.Key]) < interval {
+			mu.Unlock()
+			return
+		} else {
+			last[e.Key] = time.Now()
+		}
+		mu.Unlock()
+
+		fields := []interface{}{
+			"key", e.Key,
+			"bytes", e.Bytes,
+			"total_bytes", e.TotalBytes,
+			"parts", e.Parts,
+			"total_parts", e.TotalParts,
+			"active_parts", e.ActiveParts,
+			"bytes_per_second", int64(e.Rate),
+			"elapsed", e.Elapsed.Round(time.Millisecond),
+		}
+		// The share sent and the time left are unknown for a stream.
+		if e.TotalBytes >= 0 {
+			fields = append(fields, "percent", math.Round(e.Percent()*10)/10, "eta", e.ETA.Round(time.Second))
+		}
+		if e.Done {
+			fields = append(fields, "done", true)
+			if e.Err != nil {
+				fields = append(fields, "error", e.Err)
+			}
+		}
+		log.Info("Progress", fields...)
+	}
+}
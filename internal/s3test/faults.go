@@ -0,0 +1,113 @@
+package s3test
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Fault is an error response injected in place of a real one.
+type Fault struct {
+	Operation  string      // Operation to fail, e.g. "UploadPart"; empty matches every operation
+	PartNumber int         // Part number to fail; zero matches every part
+	Status     int         // HTTP status code of the error response
+	Code       string      // S3 error code, e.g. "InternalError" or "SlowDown"
+	Header     http.Header // Extra response headers, e.g. Retry-After
+	Times      int         // Number of requests to fail; negative fails forever
+}
+
+// faults holds the fault injection state of a Server.
+type faults struct {
+	mu          sync.Mutex
+	injected    []*Fault
+	latency     map[string]time.Duration
+	corruptions map[int]bool
+}
+
+func newFaults() *faults {
+	return &faults{
+		latency:     make(map[string]time.Duration),
+		corruptions: make(map[int]bool),
+	}
+}
+
+// take returns the first matching fault with requests left to fail.
+func (f *faults) take(op string, partNumber int) (Fault, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, fault := range f.injected {
+		if fault.Times == 0 {
+			continue
+		}
+		if fault.Operation != "" && fault.Operation != op {
+			continue
+		}
+		if fault.PartNumber != 0 && fault.PartNumber != partNumber {
+			continue
+		}
+		if fault.Times > 0 {
+			fault.Times--
+		}
+		return *fault, true
+	}
+	return Fault{}, false
+}
+
+func (f *faults) latencyFor(op string) time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if d, ok := f.latency[op]; ok {
+		return d
+	}
+	return f.latency[""]
+}
+
+func (f *faults) corruptETag(partNumber int) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.corruptions[partNumber]
+}
+
+// InjectFault makes the server answer matching requests with fault.
+func (s *Server) InjectFault(fault Fault) {
+	s.faults.mu.Lock()
+	defer s.faults.mu.Unlock()
+	s.faults.injected = append(s.faults.injected, &fault)
+}
+
+// FailPart makes the next times uploads of part partNumber fail with a 500
+// InternalError. A negative times fails the part forever.
+func (s *Server) FailPart(partNumber, times int) {
+	s.InjectFault(Fault{
+		Operation:  "UploadPart",
+		PartNumber: partNumber,
+		Status:     http.StatusInternalServerError,
+		Code:       "InternalError",
+		Times:      times,
+	})
+}
+
+// SetLatency delays every response to operation by d before it is handled.
+// An empty operation applies to every operation without its own latency.
+func (s *Server) SetLatency(operation string, d time.Duration) {
+	s.faults.mu.Lock()
+	defer s.faults.mu.Unlock()
+	s.faults.latency[operation] = d
+}
+
+// CorruptETag makes uploads of part partNumber return a wrong ETag, so that
+// completing the upload fails with InvalidPart.
+func (s *Server) CorruptETag(partNumber int) {
+	s.faults.mu.Lock()
+	defer s.faults.mu.Unlock()
+	s.faults.corruptions[partNumber] = true
+}
+
+// ClearFaults removes every injected fault, latency and ETag corruption.
+func (s *Server) ClearFaults() {
+	s.faults.mu.Lock()
+	defer s.faults.mu.Unlock()
+	s.faults.injected = nil
+	s.faults.latency = make(map[string]time.Duration)
+	s.faults.corruptions = make(map[int]bool)
+}
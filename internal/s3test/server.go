@@ -0,0 +1,1094 @@
+// Package s3test provides an in-process, S3-compatible fake server for
+// exercising the uploader offline, in the spirit of net/http/httptest.
+//
+// The server understands path-style requests for the operations Favus uses:
+// multipart create/upload-part/complete/abort/list-parts, list multipart
+// uploads, ListObjectsV2, PutObject, GetObject (including ranges),
+// HeadObject, GetObjectAttributes and DeleteObject. Request signatures are not verified.
+//
+// Server-side encryption requested with the x-amz-server-side-encryption*
+// headers is recorded and reported back, without encrypting anything. As
+// in S3, objects encrypted with SSE-KMS or SSE-C get ETags that are not
+// MD5 digests, and requests for SSE-C objects must carry the same key.
+package s3test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/yucori/Favus/internal/checksum"
+	"github.com/yucori/Favus/internal/config"
+	"github.com/yucori/Favus/internal/storage"
+)
+
+// DefaultMinPartSize is the smallest size accepted for any part but the
+// last, matching S3.
+const DefaultMinPartSize = 5 * 1024 * 1024 // 5 MB
+
+// Object is an object stored in the fake server.
+type Object struct {
+	Key          string
+	Data         []byte
+	ETag         string
+	LastModified time.Time
+	Metadata     map[string]string
+	PartSizes    []int64 // Sizes of the parts of a multipart object
+	Checksum     string  // Composite checksum of a multipart object
+
+	ChecksumAlgorithm checksum.Algorithm // Additional checksum of the parts, if any
+	PartChecksums     []string           // Base64 checksums of the parts, in order
+
+	Encryption Encryption // Server-side encryption requested for the object
+}
+
+// Part is an uploaded part of an in-progress multipart upload.
+type Part struct {
+	PartNumber   int
+	Data         []byte
+	ETag         string
+	Checksum     string // Base64 additional checksum sent with the part
+	LastModified time.Time
+}
+
+// Upload is an in-progress multipart upload.
+type Upload struct {
+	Key       string
+	UploadID  string
+	Initiated time.Time
+	Initiator string // ID and display name of who started the upload
+	Metadata  map[string]string
+	Parts     map[int]*Part
+
+	ChecksumAlgorithm checksum.Algorithm // Additional checksum declared at creation
+
+	Encryption Encryption // Server-side encryption requested at creation
+}
+
+// Server is a fake S3 server holding a single bucket in memory.
+type Server struct {
+	*httptest.Server
+
+	Bucket string
+
+	// MinPartSize is the minimum size of every part but the last accepted
+	// by CompleteMultipartUpload. Tests may lower it to keep fixtures small.
+	MinPartSize int64
+	// PageSize is the maximum number of entries returned by a single list
+	// request, so that pagination can be exercised with few entries.
+	PageSize int
+
+	mu       sync.Mutex
+	objects  map[string]*Object
+	uploads  map[string]*Upload
+	faults   *faults
+	requests []Request
+}
+
+// NewServer starts a fake S3 server hosting bucket. The caller should call
+// Close when finished, to shut it down.
+func NewServer(bucket string) *Server {
+	s := &Server{
+		Bucket:      bucket,
+		MinPartSize: DefaultMinPartSize,
+		PageSize:    1000,
+		objects:     make(map[string]*Object),
+		uploads:     make(map[string]*Upload),
+		faults:      newFaults(),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Client returns an S3 client configured to talk to the server with
+// path-style addressing and dummy static credentials.
+func (s *Server) Client() *s3.S3 {
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:           aws.String("us-east-1"),
+		Endpoint:         aws.String(s.URL),
+		S3ForcePathStyle: aws.Bool(true),
+		Credentials:      credentials.NewStaticCredentials("s3test", "s3test", ""),
+		MaxRetries:       aws.Int(0),
+	}))
+	return s3.New(sess)
+}
+
+// Config returns a configuration that points NewS3Uploader at the server.
+func (s *Server) Config() *config.Config {
+	return &config.Config{
+		AwsRegion:          "us-east-1",
+		S3BucketName:       s.Bucket,
+		ChunkSize:          DefaultMinPartSize,
+		Concurrency:        config.DefaultConcurrency,
+		S3Endpoint:         s.URL,
+		StorageBackend:     config.BackendS3,
+		S3ForcePathStyle:   true,
+		AwsAccessKeyID:     "s3test",
+		AwsSecretAccessKey: "s3test",
+	}
+}
+
+// Store returns an ObjectStore backed by the server.
+func (s *Server) Store() *storage.S3Store {
+	return storage.NewS3Store(s.Client(), s.Bucket)
+}
+
+// PutObject stores data under key directly, bypassing HTTP.
+func (s *Server) PutObject(key string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = &Object{
+		Key:          key,
+		Data:         append([]byte(nil), data...),
+		ETag:         md5ETag(data),
+		LastModified: time.Now().UTC(),
+		Metadata:     map[string]string{},
+	}
+}
+
+// StartUpload creates a multipart upload of key directly, bypassing HTTP,
+// as if initiator had started it at initiated and uploaded parts, and
+// returns its upload ID.
+func (s *Server) StartUpload(key, initiator string, initiated time.Time, parts ...[]byte) string {
+	upload := &Upload{
+		Key:       key,
+		UploadID:  newUploadID(),
+		Initiated: initiated.UTC(),
+		Initiator: initiator,
+		Metadata:  map[string]string{},
+		Parts:     make(map[int]*Part),
+	}
+	for i, data := range parts {
+		upload.Parts[i+1] = &Part{
+			PartNumber:   i + 1,
+			Data:         append([]byte(nil), data...),
+			ETag:         md5ETag(data),
+			LastModified: initiated.UTC(),
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.uploads[upload.UploadID] = upload
+	return upload.UploadID
+}
+
+// Object returns a copy of the object stored under key.
+func (s *Server) Object(key string) (Object, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.objects[key]
+	if !ok {
+		return Object{}, false
+	}
+	return *obj, true
+}
+
+// Uploads returns the in-progress multipart uploads sorted by key.
+func (s *Server) Uploads() []Upload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	uploads := make([]Upload, 0, len(s.uploads))
+	for _, u := range s.uploads {
+		uploads = append(uploads, *u)
+	}
+	sort.Slice(uploads, func(i, j int) bool {
+		return uploads[i].Key < uploads[j].Key
+	})
+	return uploads
+}
+
+// Request records a request received by the server.
+type Request struct {
+	Method     string
+	Operation  string
+	Key        string
+	PartNumber int
+	Status     int
+}
+
+// Requests returns every request received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// CountRequests returns the number of requests for operation, optionally
+// restricted to a part number when partNumber is positive.
+func (s *Server) CountRequests(operation string, partNumber int) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for _, r := range s.requests {
+		if r.Operation == operation && (partNumber <= 0 || r.PartNumber == partNumber) {
+			n++
+		}
+	}
+	return n
+}
+
+// s3Error is the XML error document returned by S3.
+type s3Error struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource,omitempty"`
+	RequestID string   `xml:"RequestId"`
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	xml.NewEncoder(w).Encode(s3Error{Code: code, Message: message, RequestID: "s3test"})
+}
+
+func writeXML(w http.ResponseWriter, v interface{}) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	if err := xml.NewEncoder(&buf).Encode(v); err != nil {
+		writeError(w, http.StatusInternalServerError, "InternalError", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// statusRecorder captures the response status for the request log.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+// operation classifies a request into an S3 API operation name.
+func operation(r *http.Request, key string) string {
+	q := r.URL.Query()
+	_, hasUploads := q["uploads"]
+	_, hasUploadID := q["uploadId"]
+	_, hasAttributes := q["attributes"]
+	switch {
+	case key == "" && r.Method == http.MethodGet && hasUploads:
+		return "ListMultipartUploads"
+	case key == "" && r.Method == http.MethodGet:
+		return "ListObjectsV2"
+	case r.Method == http.MethodPost && hasUploads:
+		return "CreateMultipartUpload"
+	case r.Method == http.MethodPut && hasUploadID:
+		return "UploadPart"
+	case r.Method == http.MethodPost && hasUploadID:
+		return "CompleteMultipartUpload"
+	case r.Method == http.MethodDelete && hasUploadID:
+		return "AbortMultipartUpload"
+	case r.Method == http.MethodGet && hasUploadID:
+		return "ListParts"
+	case r.Method == http.MethodPut:
+		return "PutObject"
+	case r.Method == http.MethodGet && hasAttributes:
+		return "GetObjectAttributes"
+	case r.Method == http.MethodGet:
+		return "GetObject"
+	case r.Method == http.MethodHead:
+		return "HeadObject"
+	case r.Method == http.MethodDelete:
+		return "DeleteObject"
+	}
+	return ""
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	bucket, key, _ := strings.Cut(path, "/")
+	op := operation(r, key)
+	partNumber, _ := strconv.Atoi(r.URL.Query().Get("partNumber"))
+
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	defer func() {
+		s.mu.Lock()
+		s.requests = append(s.requests, Request{
+			Method:     r.Method,
+			Operation:  op,
+			Key:        key,
+			PartNumber: partNumber,
+			Status:     rec.status,
+		})
+		s.mu.Unlock()
+	}()
+
+	if latency := s.faults.latencyFor(op); latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if bucket != s.Bucket {
+		writeError(rec, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist")
+		return
+	}
+	if f, ok := s.faults.take(op, partNumber); ok {
+		io.Copy(io.Discard, r.Body)
+		if f.Header != nil {
+			for k, v := range f.Header {
+				rec.Header()[k] = v
+			}
+		}
+		writeError(rec, f.Status, f.Code, "injected fault")
+		return
+	}
+
+	switch op {
+	case "ListMultipartUploads":
+		s.listMultipartUploads(rec, r)
+	case "ListObjectsV2":
+		s.listObjects(rec, r)
+	case "CreateMultipartUpload":
+		s.createMultipartUpload(rec, r, key)
+	case "UploadPart":
+		s.uploadPart(rec, r, key, partNumber)
+	case "CompleteMultipartUpload":
+		s.completeMultipartUpload(rec, r, key)
+	case "AbortMultipartUpload":
+		s.abortMultipartUpload(rec, r, key)
+	case "ListParts":
+		s.listParts(rec, r, key)
+	case "PutObject":
+		s.putObject(rec, r, key)
+	case "GetObject", "HeadObject":
+		s.getObject(rec, r, key, op == "HeadObject")
+	case "GetObjectAttributes":
+		s.getObjectAttributes(rec, r, key)
+	case "DeleteObject":
+		s.deleteObject(rec, key)
+	default:
+		writeError(rec, http.StatusNotImplemented, "NotImplemented", "operation not supported by s3test")
+	}
+}
+
+// metadataFromHeader extracts x-amz-meta-* headers.
+func metadataFromHeader(h http.Header) map[string]string {
+	meta := make(map[string]string)
+	for k, v := range h {
+		lk := strings.ToLower(k)
+		if strings.HasPrefix(lk, "x-amz-meta-") && len(v) > 0 {
+			meta[strings.TrimPrefix(lk, "x-amz-meta-")] = v[0]
+		}
+	}
+	return meta
+}
+
+// checksumHeader returns the header carrying a checksum computed with a.
+func checksumHeader(a checksum.Algorithm) string {
+	return "X-Amz-Checksum-" + string(a)
+}
+
+// checkDigests verifies Content-MD5 and any x-amz-checksum-* header sent
+// with data, writing BadDigest and returning false on a mismatch.
+func checkDigests(w http.ResponseWriter, h http.Header, data []byte) bool {
+	expected := map[checksum.Algorithm]string{
+		checksum.MD5:    h.Get("Content-MD5"),
+		checksum.CRC32C: h.Get(checksumHeader(checksum.CRC32C)),
+		checksum.SHA256: h.Get(checksumHeader(checksum.SHA256)),
+	}
+	for algorithm, value := range expected {
+		if value == "" {
+			continue
+		}
+		actual, _ := checksum.Compute(algorithm, bytes.NewReader(data))
+		if actual != value {
+			writeError(w, http.StatusBadRequest, "BadDigest", "The "+algorithm.String()+" you specified did not match the calculated checksum")
+			return false
+		}
+	}
+	return true
+}
+
+func md5ETag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func newUploadID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (s *Server) createMultipartUpload(w http.ResponseWriter, r *http.Request, key string) {
+	algorithm, err := checksum.ParseAlgorithm(r.Header.Get("X-Amz-Checksum-Algorithm"))
+	if err != nil || algorithm == checksum.MD5 {
+		writeError(w, http.StatusBadRequest, "InvalidRequest", "Invalid checksum algorithm")
+		return
+	}
+	enc, ok := encryptionFromHeader(w, r.Header)
+	if !ok {
+		return
+	}
+	upload := &Upload{
+		Key:               key,
+		UploadID:          newUploadID(),
+		Initiated:         time.Now().UTC(),
+		Initiator:         "s3test",
+		Metadata:          metadataFromHeader(r.Header),
+		Parts:             make(map[int]*Part),
+		ChecksumAlgorithm: algorithm,
+		Encryption:        enc,
+	}
+	s.mu.Lock()
+	s.uploads[upload.UploadID] = upload
+	s.mu.Unlock()
+
+	enc.setHeaders(w.Header())
+	writeXML(w, struct {
+		XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+		Bucket   string   `xml:"Bucket"`
+		Key      string   `xml:"Key"`
+		UploadID string   `xml:"UploadId"`
+	}{Bucket: s.Bucket, Key: key, UploadID: upload.UploadID})
+}
+
+// lookupUpload returns the upload for the request, writing NoSuchUpload if
+// it does not exist. The caller must hold s.mu.
+func (s *Server) lookupUpload(w http.ResponseWriter, r *http.Request, key string) *Upload {
+	upload, ok := s.uploads[r.URL.Query().Get("uploadId")]
+	if !ok || upload.Key != key {
+		writeError(w, http.StatusNotFound, "NoSuchUpload", "The specified upload does not exist")
+		return nil
+	}
+	return upload
+}
+
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request, key string, partNumber int) {
+	if partNumber < 1 || partNumber > 10000 {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "Part number must be an integer between 1 and 10000")
+		return
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	if !checkDigests(w, r.Header, data) {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload := s.lookupUpload(w, r, key)
+	if upload == nil || !checkCustomerKey(w, r.Header, upload.Encryption) {
+		return
+	}
+	part := &Part{
+		PartNumber:   partNumber,
+		Data:         data,
+		ETag:         md5ETag(data),
+		LastModified: time.Now().UTC(),
+	}
+	if upload.Encryption.opaqueETags() {
+		part.ETag = randomETag()
+	}
+	if upload.ChecksumAlgorithm != checksum.None {
+		part.Checksum = r.Header.Get(checksumHeader(upload.ChecksumAlgorithm))
+		if part.Checksum == "" {
+			writeError(w, http.StatusBadRequest, "InvalidRequest", "Checksum Type mismatch occurred, expected checksum Type: "+upload.ChecksumAlgorithm.String())
+			return
+		}
+	}
+	upload.Parts[partNumber] = part
+
+	eTag := part.ETag
+	if s.faults.corruptETag(partNumber) {
+		eTag = `"00000000000000000000000000000000"`
+	}
+	upload.Encryption.setHeaders(w.Header())
+	w.Header().Set("ETag", eTag)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) completeMultipartUpload(w http.ResponseWriter, r *http.Request, key string) {
+	var req struct {
+		Parts []struct {
+			PartNumber     int    `xml:"PartNumber"`
+			ETag           string `xml:"ETag"`
+			ChecksumCRC32C string `xml:"ChecksumCRC32C"`
+			ChecksumSHA256 string `xml:"ChecksumSHA256"`
+		} `xml:"Part"`
+	}
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "MalformedXML", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload := s.lookupUpload(w, r, key)
+	if upload == nil {
+		return
+	}
+	if len(req.Parts) == 0 {
+		writeError(w, http.StatusBadRequest, "MalformedXML", "You must specify at least one part")
+		return
+	}
+
+	var data []byte
+	var partSizes []int64
+	var partChecksums []string
+	digests := md5.New()
+	for i, p := range req.Parts {
+		if i > 0 && p.PartNumber <= req.Parts[i-1].PartNumber {
+			writeError(w, http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order")
+			return
+		}
+		part, ok := upload.Parts[p.PartNumber]
+		if !ok || part.ETag != p.ETag {
+			writeError(w, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("Part %d could not be found or its ETag did not match", p.PartNumber))
+			return
+		}
+		if upload.ChecksumAlgorithm != checksum.None {
+			sent := p.ChecksumCRC32C
+			if upload.ChecksumAlgorithm == checksum.SHA256 {
+				sent = p.ChecksumSHA256
+			}
+			if sent != part.Checksum {
+				writeError(w, http.StatusBadRequest, "InvalidPart", fmt.Sprintf("The checksum of part %d did not match", p.PartNumber))
+				return
+			}
+			partChecksums = append(partChecksums, part.Checksum)
+		}
+		if i < len(req.Parts)-1 && int64(len(part.Data)) < s.MinPartSize {
+			writeError(w, http.StatusBadRequest, "EntityTooSmall", "Your proposed upload is smaller than the minimum allowed object size")
+			return
+		}
+		sum := md5.Sum(part.Data)
+		digests.Write(sum[:])
+		data = append(data, part.Data...)
+		partSizes = append(partSizes, int64(len(part.Data)))
+	}
+
+	eTag := fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(digests.Sum(nil)), len(req.Parts))
+	if upload.Encryption.opaqueETags() {
+		eTag = fmt.Sprintf(`"%s-%d"`, strings.Trim(randomETag(), `"`), len(req.Parts))
+	}
+	composite, _ := checksum.Composite(upload.ChecksumAlgorithm, partChecksums)
+	s.objects[key] = &Object{
+		Key:          key,
+		Data:         data,
+		ETag:         eTag,
+		LastModified: time.Now().UTC(),
+		Metadata:     upload.Metadata,
+		PartSizes:    partSizes,
+		Checksum:     composite,
+
+		ChecksumAlgorithm: upload.ChecksumAlgorithm,
+		PartChecksums:     partChecksums,
+		Encryption:        upload.Encryption,
+	}
+	delete(s.uploads, upload.UploadID)
+
+	result := struct {
+		XMLName        xml.Name `xml:"CompleteMultipartUploadResult"`
+		Location       string   `xml:"Location"`
+		Bucket         string   `xml:"Bucket"`
+		Key            string   `xml:"Key"`
+		ETag           string   `xml:"ETag"`
+		ChecksumCRC32C string   `xml:"ChecksumCRC32C,omitempty"`
+		ChecksumSHA256 string   `xml:"ChecksumSHA256,omitempty"`
+	}{Location: s.URL + "/" + s.Bucket + "/" + key, Bucket: s.Bucket, Key: key, ETag: eTag}
+	switch upload.ChecksumAlgorithm {
+	case checksum.CRC32C:
+		result.ChecksumCRC32C = composite
+	case checksum.SHA256:
+		result.ChecksumSHA256 = composite
+	}
+	// Like S3, SSE-C is not reported on completion.
+	enc := upload.Encryption
+	enc.CustomerKeyMD5 = ""
+	enc.setHeaders(w.Header())
+	writeXML(w, result)
+}
+
+func (s *Server) abortMultipartUpload(w http.ResponseWriter, r *http.Request, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload := s.lookupUpload(w, r, key)
+	if upload == nil {
+		return
+	}
+	delete(s.uploads, upload.UploadID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+type xmlPart struct {
+	PartNumber   int       `xml:"PartNumber"`
+	LastModified time.Time `xml:"LastModified"`
+	ETag         string    `xml:"ETag"`
+	Size         int64     `xml:"Size"`
+
+	ChecksumCRC32C string `xml:"ChecksumCRC32C,omitempty"`
+	ChecksumSHA256 string `xml:"ChecksumSHA256,omitempty"`
+}
+
+func (s *Server) listParts(w http.ResponseWriter, r *http.Request, key string) {
+	q := r.URL.Query()
+	marker, _ := strconv.Atoi(q.Get("part-number-marker"))
+	maxParts := s.pageSize(q.Get("max-parts"))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload := s.lookupUpload(w, r, key)
+	if upload == nil {
+		return
+	}
+
+	numbers := make([]int, 0, len(upload.Parts))
+	for n := range upload.Parts {
+		if n > marker {
+			numbers = append(numbers, n)
+		}
+	}
+	sort.Ints(numbers)
+
+	truncated := len(numbers) > maxParts
+	if truncated {
+		numbers = numbers[:maxParts]
+	}
+	parts := make([]xmlPart, 0, len(numbers))
+	for _, n := range numbers {
+		p := upload.Parts[n]
+		part := xmlPart{
+			PartNumber:   n,
+			LastModified: p.LastModified,
+			ETag:         p.ETag,
+			Size:         int64(len(p.Data)),
+		}
+		switch upload.ChecksumAlgorithm {
+		case checksum.CRC32C:
+			part.ChecksumCRC32C = p.Checksum
+		case checksum.SHA256:
+			part.ChecksumSHA256 = p.Checksum
+		}
+		parts = append(parts, part)
+	}
+	next := 0
+	if len(numbers) > 0 {
+		next = numbers[len(numbers)-1]
+	}
+
+	writeXML(w, struct {
+		XMLName              xml.Name  `xml:"ListPartsResult"`
+		Bucket               string    `xml:"Bucket"`
+		Key                  string    `xml:"Key"`
+		UploadID             string    `xml:"UploadId"`
+		PartNumberMarker     int       `xml:"PartNumberMarker"`
+		NextPartNumberMarker int       `xml:"NextPartNumberMarker"`
+		MaxParts             int       `xml:"MaxParts"`
+		IsTruncated          bool      `xml:"IsTruncated"`
+		Parts                []xmlPart `xml:"Part"`
+	}{
+		Bucket:               s.Bucket,
+		Key:                  key,
+		UploadID:             upload.UploadID,
+		PartNumberMarker:     marker,
+		NextPartNumberMarker: next,
+		MaxParts:             maxParts,
+		IsTruncated:          truncated,
+		Parts:                parts,
+	})
+}
+
+// pageSize returns the smaller of the requested page size and s.PageSize.
+func (s *Server) pageSize(requested string) int {
+	n, err := strconv.Atoi(requested)
+	if err != nil || n <= 0 || n > s.PageSize {
+		return s.PageSize
+	}
+	return n
+}
+
+func (s *Server) listMultipartUploads(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	prefix := q.Get("prefix")
+	keyMarker := q.Get("key-marker")
+	uploadIDMarker := q.Get("upload-id-marker")
+	maxUploads := s.pageSize(q.Get("max-uploads"))
+
+	s.mu.Lock()
+	all := make([]*Upload, 0, len(s.uploads))
+	for _, u := range s.uploads {
+		if strings.HasPrefix(u.Key, prefix) {
+			all = append(all, u)
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].Key != all[j].Key {
+			return all[i].Key < all[j].Key
+		}
+		return all[i].UploadID < all[j].UploadID
+	})
+
+	type xmlUpload struct {
+		Key       string    `xml:"Key"`
+		UploadID  string    `xml:"UploadId"`
+		Initiated time.Time `xml:"Initiated"`
+		Initiator struct {
+			ID          string `xml:"ID"`
+			DisplayName string `xml:"DisplayName"`
+		} `xml:"Initiator"`
+	}
+	var page []xmlUpload
+	truncated := false
+	for _, u := range all {
+		if keyMarker != "" && (u.Key < keyMarker || (u.Key == keyMarker && u.UploadID <= uploadIDMarker)) {
+			continue
+		}
+		if len(page) == maxUploads {
+			truncated = true
+			break
+		}
+		xu := xmlUpload{Key: u.Key, UploadID: u.UploadID, Initiated: u.Initiated}
+		xu.Initiator.ID = u.Initiator
+		xu.Initiator.DisplayName = u.Initiator
+		page = append(page, xu)
+	}
+	var nextKey, nextUploadID string
+	if truncated && len(page) > 0 {
+		nextKey = page[len(page)-1].Key
+		nextUploadID = page[len(page)-1].UploadID
+	}
+
+	writeXML(w, struct {
+		XMLName            xml.Name    `xml:"ListMultipartUploadsResult"`
+		Bucket             string      `xml:"Bucket"`
+		KeyMarker          string      `xml:"KeyMarker"`
+		UploadIDMarker     string      `xml:"UploadIdMarker"`
+		NextKeyMarker      string      `xml:"NextKeyMarker"`
+		NextUploadIDMarker string      `xml:"NextUploadIdMarker"`
+		Prefix             string      `xml:"Prefix"`
+		MaxUploads         int         `xml:"MaxUploads"`
+		IsTruncated        bool        `xml:"IsTruncated"`
+		Uploads            []xmlUpload `xml:"Upload"`
+	}{
+		Bucket:             s.Bucket,
+		KeyMarker:          keyMarker,
+		UploadIDMarker:     uploadIDMarker,
+		NextKeyMarker:      nextKey,
+		NextUploadIDMarker: nextUploadID,
+		Prefix:             prefix,
+		MaxUploads:         maxUploads,
+		IsTruncated:        truncated,
+		Uploads:            page,
+	})
+}
+
+func (s *Server) listObjects(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	prefix := q.Get("prefix")
+	after := q.Get("continuation-token")
+	if after == "" {
+		after = q.Get("start-after")
+	}
+	maxKeys := s.pageSize(q.Get("max-keys"))
+
+	s.mu.Lock()
+	keys := make([]string, 0, len(s.objects))
+	for k := range s.objects {
+		if strings.HasPrefix(k, prefix) && k > after {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	truncated := len(keys) > maxKeys
+	if truncated {
+		keys = keys[:maxKeys]
+	}
+
+	type xmlObject struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		ETag         string    `xml:"ETag"`
+		Size         int64     `xml:"Size"`
+		StorageClass string    `xml:"StorageClass"`
+	}
+	contents := make([]xmlObject, 0, len(keys))
+	for _, k := range keys {
+		obj := s.objects[k]
+		contents = append(contents, xmlObject{
+			Key:          k,
+			LastModified: obj.LastModified,
+			ETag:         obj.ETag,
+			Size:         int64(len(obj.Data)),
+			StorageClass: "STANDARD",
+		})
+	}
+	s.mu.Unlock()
+
+	next := ""
+	if truncated {
+		next = keys[len(keys)-1]
+	}
+	writeXML(w, struct {
+		XMLName               xml.Name    `xml:"ListBucketResult"`
+		Name                  string      `xml:"Name"`
+		Prefix                string      `xml:"Prefix"`
+		KeyCount              int         `xml:"KeyCount"`
+		MaxKeys               int         `xml:"MaxKeys"`
+		IsTruncated           bool        `xml:"IsTruncated"`
+		NextContinuationToken string      `xml:"NextContinuationToken,omitempty"`
+		Contents              []xmlObject `xml:"Contents"`
+	}{
+		Name:                  s.Bucket,
+		Prefix:                prefix,
+		KeyCount:              len(contents),
+		MaxKeys:               maxKeys,
+		IsTruncated:           truncated,
+		NextContinuationToken: next,
+		Contents:              contents,
+	})
+}
+
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request, key string) {
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+		return
+	}
+	if !checkDigests(w, r.Header, data) {
+		return
+	}
+	enc, ok := encryptionFromHeader(w, r.Header)
+	if !ok {
+		return
+	}
+	obj := &Object{
+		Key:          key,
+		Data:         data,
+		ETag:         md5ETag(data),
+		LastModified: time.Now().UTC(),
+		Metadata:     metadataFromHeader(r.Header),
+		Encryption:   enc,
+	}
+	if enc.opaqueETags() {
+		obj.ETag = randomETag()
+	}
+	s.mu.Lock()
+	s.objects[key] = obj
+	s.mu.Unlock()
+
+	enc.setHeaders(w.Header())
+	w.Header().Set("ETag", obj.ETag)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, key string, head bool) {
+	s.mu.Lock()
+	obj, ok := s.objects[key]
+	s.mu.Unlock()
+	if !ok {
+		if head {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+
+	if ifMatch := r.Header.Get("If-Match"); ifMatch != "" && ifMatch != obj.ETag {
+		writeError(w, http.StatusPreconditionFailed, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold")
+		return
+	}
+	if !checkCustomerKey(w, r.Header, obj.Encryption) {
+		return
+	}
+
+	h := w.Header()
+	obj.Encryption.setHeaders(h)
+	h.Set("ETag", obj.ETag)
+	h.Set("Last-Modified", obj.LastModified.Format(http.TimeFormat))
+	h.Set("Accept-Ranges", "bytes")
+	for k, v := range obj.Metadata {
+		h.Set("X-Amz-Meta-"+k, v)
+	}
+	if len(obj.PartSizes) > 0 {
+		h.Set("X-Amz-Mp-Parts-Count", strconv.Itoa(len(obj.PartSizes)))
+	}
+
+	data := obj.Data
+	status := http.StatusOK
+	rng := r.Header.Get("Range")
+	if pn := r.URL.Query().Get("partNumber"); pn != "" {
+		// Reading a single part of a multipart object is a range request
+		// covering that part.
+		n, err := strconv.Atoi(pn)
+		if err != nil || n < 1 || (len(obj.PartSizes) == 0 && n != 1) || (len(obj.PartSizes) > 0 && n > len(obj.PartSizes)) {
+			writeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidPartNumber", "The requested partnumber is not satisfiable")
+			return
+		}
+		var start int64
+		end := int64(len(data)) - 1
+		if len(obj.PartSizes) > 0 {
+			for _, size := range obj.PartSizes[:n-1] {
+				start += size
+			}
+			end = start + obj.PartSizes[n-1] - 1
+		}
+		rng = fmt.Sprintf("bytes=%d-%d", start, end)
+	}
+	if rng != "" {
+		start, end, ok := parseRange(rng, int64(len(data)))
+		if !ok {
+			writeError(w, http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable")
+			return
+		}
+		h.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, len(data)))
+		data = data[start : end+1]
+		status = http.StatusPartialContent
+	}
+	h.Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(status)
+	if !head {
+		w.Write(data)
+	}
+}
+
+type xmlObjectPart struct {
+	PartNumber     int    `xml:"PartNumber"`
+	Size           int64  `xml:"Size"`
+	ChecksumCRC32C string `xml:"ChecksumCRC32C,omitempty"`
+	ChecksumSHA256 string `xml:"ChecksumSHA256,omitempty"`
+}
+
+type xmlChecksum struct {
+	ChecksumCRC32C string `xml:"ChecksumCRC32C,omitempty"`
+	ChecksumSHA256 string `xml:"ChecksumSHA256,omitempty"`
+}
+
+func (s *Server) getObjectAttributes(w http.ResponseWriter, r *http.Request, key string) {
+	marker, _ := strconv.Atoi(r.Header.Get("X-Amz-Part-Number-Marker"))
+	maxParts := s.pageSize(r.Header.Get("X-Amz-Max-Parts"))
+
+	s.mu.Lock()
+	obj, ok := s.objects[key]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	if !checkCustomerKey(w, r.Header, obj.Encryption) {
+		return
+	}
+
+	type objectParts struct {
+		PartsCount           int             `xml:"PartsCount"`
+		PartNumberMarker     int             `xml:"PartNumberMarker"`
+		NextPartNumberMarker int             `xml:"NextPartNumberMarker"`
+		MaxParts             int             `xml:"MaxParts"`
+		IsTruncated          bool            `xml:"IsTruncated"`
+		Parts                []xmlObjectPart `xml:"Part"`
+	}
+	result := struct {
+		XMLName     xml.Name     `xml:"GetObjectAttributesResponse"`
+		ETag        string       `xml:"ETag"`
+		Checksum    *xmlChecksum `xml:"Checksum,omitempty"`
+		ObjectParts *objectParts `xml:"ObjectParts,omitempty"`
+		ObjectSize  int64        `xml:"ObjectSize"`
+	}{ETag: strings.Trim(obj.ETag, `"`), ObjectSize: int64(len(obj.Data))}
+
+	// Like S3, parts are only reported for objects uploaded with additional
+	// checksums.
+	if obj.ChecksumAlgorithm != checksum.None {
+		result.Checksum = &xmlChecksum{}
+		switch obj.ChecksumAlgorithm {
+		case checksum.CRC32C:
+			result.Checksum.ChecksumCRC32C = obj.Checksum
+		case checksum.SHA256:
+			result.Checksum.ChecksumSHA256 = obj.Checksum
+		}
+
+		parts := &objectParts{PartsCount: len(obj.PartSizes), PartNumberMarker: marker, MaxParts: maxParts}
+		for i := marker; i < len(obj.PartSizes); i++ {
+			if len(parts.Parts) == maxParts {
+				parts.IsTruncated = true
+				break
+			}
+			part := xmlObjectPart{PartNumber: i + 1, Size: obj.PartSizes[i]}
+			switch obj.ChecksumAlgorithm {
+			case checksum.CRC32C:
+				part.ChecksumCRC32C = obj.PartChecksums[i]
+			case checksum.SHA256:
+				part.ChecksumSHA256 = obj.PartChecksums[i]
+			}
+			parts.Parts = append(parts.Parts, part)
+			parts.NextPartNumberMarker = i + 1
+		}
+		result.ObjectParts = parts
+	}
+	w.Header().Set("Last-Modified", obj.LastModified.Format(http.TimeFormat))
+	writeXML(w, result)
+}
+
+// parseRange parses a single "bytes=start-end" range against size.
+func parseRange(rng string, size int64) (int64, int64, bool) {
+	spec, ok := strings.CutPrefix(rng, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return 0, 0, false
+	}
+	startStr, endStr, ok := strings.Cut(spec, "-")
+	if !ok {
+		return 0, 0, false
+	}
+	if startStr == "" {
+		// Suffix range: the last n bytes.
+		n, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return 0, 0, false
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, size - 1, true
+	}
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start >= size {
+		return 0, 0, false
+	}
+	end := size - 1
+	if endStr != "" {
+		end, err = strconv.ParseInt(endStr, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end, true
+}
+
+func (s *Server) deleteObject(w http.ResponseWriter, key string) {
+	s.mu.Lock()
+	delete(s.objects, key)
+	s.mu.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,128 @@
+package s3test
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// Headers of server-side encryption.
+const (
+	sseHeader               = "X-Amz-Server-Side-Encryption"
+	sseKMSKeyIDHeader       = "X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id"
+	sseKMSContextHeader     = "X-Amz-Server-Side-Encryption-Context"
+	sseCustomerAlgHeader    = "X-Amz-Server-Side-Encryption-Customer-Algorithm"
+	sseCustomerKeyHeader    = "X-Amz-Server-Side-Encryption-Customer-Key"
+	sseCustomerKeyMD5Header = "X-Amz-Server-Side-Encryption-Customer-Key-Md5"
+)
+
+// Encryption is the server-side encryption requested for an object or an
+// upload.
+type Encryption struct {
+	Algorithm      string // AES256, aws:kms or aws:kms:dsse; empty for none or SSE-C
+	KMSKeyID       string // KMS key of SSE-KMS, as sent
+	KMSContext     string // Encryption context of SSE-KMS, in base64 as sent
+	CustomerKeyMD5 string // MD5 of the SSE-C key in base64, if any
+}
+
+// opaqueETags reports whether S3 gives objects encrypted this way ETags
+// that are not MD5 digests.
+func (e Encryption) opaqueETags() bool {
+	return strings.HasPrefix(e.Algorithm, "aws:kms") || e.CustomerKeyMD5 != ""
+}
+
+// setHeaders sets the response headers that report e.
+func (e Encryption) setHeaders(h http.Header) {
+	if e.Algorithm != "" {
+		h.Set(sseHeader, e.Algorithm)
+	}
+	if e.KMSKeyID != "" {
+		h.Set(sseKMSKeyIDHeader, e.KMSKeyID)
+	}
+	if e.CustomerKeyMD5 != "" {
+		h.Set(sseCustomerAlgHeader, "AES256")
+		h.Set(sseCustomerKeyMD5Header, e.CustomerKeyMD5)
+	}
+}
+
+// encryptionFromHeader returns the encryption requested by a request
+// creating an object or an upload, writing InvalidArgument and returning
+// false if it is not valid.
+func encryptionFromHeader(w http.ResponseWriter, h http.Header) (Encryption, bool) {
+	e := Encryption{
+		Algorithm:  h.Get(sseHeader),
+		KMSKeyID:   h.Get(sseKMSKeyIDHeader),
+		KMSContext: h.Get(sseKMSContextHeader),
+	}
+	var ok bool
+	if e.CustomerKeyMD5, ok = customerKeyMD5(w, h); !ok {
+		return Encryption{}, false
+	}
+	switch e.Algorithm {
+	case "", "AES256", "aws:kms", "aws:kms:dsse":
+	default:
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "The encryption method specified is not supported")
+		return Encryption{}, false
+	}
+	if e.Algorithm != "" && e.CustomerKeyMD5 != "" {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "Server Side Encryption with Customer provided key is incompatible with the encryption method specified")
+		return Encryption{}, false
+	}
+	if (e.KMSKeyID != "" || e.KMSContext != "") && !strings.HasPrefix(e.Algorithm, "aws:kms") {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "Server Side Encryption with AWS KMS managed key requires HTTP header x-amz-server-side-encryption : aws:kms")
+		return Encryption{}, false
+	}
+	return e, true
+}
+
+// customerKeyMD5 returns the MD5 of the SSE-C key sent with a request, or
+// an empty string if there is none. It writes InvalidArgument and returns
+// false if the key is malformed or does not match the MD5 sent with it.
+func customerKeyMD5(w http.ResponseWriter, h http.Header) (string, bool) {
+	algorithm, key, keyMD5 := h.Get(sseCustomerAlgHeader), h.Get(sseCustomerKeyHeader), h.Get(sseCustomerKeyMD5Header)
+	if algorithm == "" && key == "" && keyMD5 == "" {
+		return "", true
+	}
+	raw, err := base64.StdEncoding.DecodeString(key)
+	if algorithm != "AES256" || err != nil || len(raw) != 32 {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "The secret key was invalid for the specified algorithm")
+		return "", false
+	}
+	sum := md5.Sum(raw)
+	if base64.StdEncoding.EncodeToString(sum[:]) != keyMD5 {
+		writeError(w, http.StatusBadRequest, "InvalidArgument", "The calculated MD5 hash of the key did not match the hash that was provided")
+		return "", false
+	}
+	return keyMD5, true
+}
+
+// checkCustomerKey writes an error and returns false unless a request
+// reading an object, or adding to an upload, encrypted as e carries the
+// same SSE-C key as was used to encrypt it, or none if SSE-C was not used.
+func checkCustomerKey(w http.ResponseWriter, h http.Header, e Encryption) bool {
+	sent, ok := customerKeyMD5(w, h)
+	switch {
+	case !ok:
+		return false
+	case sent == e.CustomerKeyMD5:
+		return true
+	case e.CustomerKeyMD5 == "":
+		writeError(w, http.StatusBadRequest, "InvalidRequest", "The encryption parameters are not applicable to this object")
+	case sent == "":
+		writeError(w, http.StatusBadRequest, "InvalidRequest", "The object was stored using a form of Server Side Encryption. The correct parameters must be provided to retrieve the object")
+	default:
+		writeError(w, http.StatusForbidden, "AccessDenied", "The provided encryption parameters did not match the ones used originally")
+	}
+	return false
+}
+
+// randomETag returns a quoted ETag that is not the MD5 of anything, like
+// those of objects encrypted with SSE-KMS or SSE-C.
+func randomETag() string {
+	b := make([]byte, md5.Size)
+	rand.Read(b)
+	return `"` + hex.EncodeToString(b) + `"`
+}
@@ -0,0 +1,715 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/yucori/Favus/internal/checksum"
+	"github.com/yucori/Favus/internal/chunker"
+)
+
+// localStateDir is the directory under the store root holding in-progress
+// uploads and object metadata. It is never exposed as an object.
+const localStateDir = ".favus"
+
+// LocalStore is an ObjectStore that keeps objects as files under a root
+// directory, mirroring S3 multipart semantics so that uploads behave the
+// same as against a bucket.
+type LocalStore struct {
+	Root string
+}
+
+// localError returns an error like the one S3 answers with code and
+// status, so that callers classify the errors of both stores alike.
+func localError(status int, code, format string, args ...any) error {
+	return awserr.NewRequestFailure(awserr.New(code, fmt.Sprintf(format, args...), nil), status, "")
+}
+
+// contextReader reads from r until ctx is done, so that long copies stop
+// when the request is canceled.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// localUpload is the on-disk record of an in-progress multipart upload.
+type localUpload struct {
+	Key               string             `json:"key"`
+	Initiated         time.Time          `json:"initiated"`
+	ChecksumAlgorithm checksum.Algorithm `json:"checksumAlgorithm,omitempty"`
+	Metadata          map[string]string  `json:"metadata,omitempty"`
+}
+
+// localObjectMeta is the on-disk metadata kept next to each stored object.
+type localObjectMeta struct {
+	ETag     string            `json:"etag"`
+	PartSize int64             `json:"partSize,omitempty"` // Size of the first part of a multipart object
+	Checksum string            `json:"checksum,omitempty"` // Composite checksum of a multipart object
+	Metadata map[string]string `json:"metadata,omitempty"`
+
+	ChecksumAlgorithm checksum.Algorithm `json:"checksumAlgorithm,omitempty"`
+	Parts             []localPartMeta    `json:"parts,omitempty"` // Parts of a multipart object with checksums
+}
+
+// localPartMeta records a part of a multipart object uploaded with checksums.
+type localPartMeta struct {
+	PartNumber int    `json:"partNumber"`
+	Size       int64  `json:"size"`
+	Checksum   string `json:"checksum"`
+}
+
+// NewLocalStore creates a LocalStore rooted at root, creating it if needed.
+func NewLocalStore(root string) (*LocalStore, error) {
+	if root == "" {
+		return nil, fmt.Errorf("local storage root is not set")
+	}
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve local storage root: %w", err)
+	}
+	if err := os.MkdirAll(absRoot, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create local storage root: %w", err)
+	}
+	return &LocalStore{Root: absRoot}, nil
+}
+
+// objectPath maps key to a path under the store root.
+func (s *LocalStore) objectPath(key string) (string, error) {
+	if key == "" || strings.HasSuffix(key, "/") {
+		return "", fmt.Errorf("invalid object key: %q", key)
+	}
+	p := filepath.Join(s.Root, filepath.FromSlash(key))
+	rel, err := filepath.Rel(s.Root, p)
+	if err != nil || rel == "." || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("invalid object key: %q", key)
+	}
+	if rel == localStateDir || strings.HasPrefix(rel, localStateDir+string(filepath.Separator)) {
+		return "", fmt.Errorf("object key %q is reserved", key)
+	}
+	return p, nil
+}
+
+func (s *LocalStore) metaPath(key string) string {
+	return filepath.Join(s.Root, localStateDir, "objects", filepath.FromSlash(key)+".json")
+}
+
+// uploadIDLen is the length of the upload IDs made by CreateMultipartUpload:
+// 16 random bytes in hex.
+const uploadIDLen = 32
+
+// uploadDir maps uploadID to its directory under the store root. Only IDs
+// of the form made by CreateMultipartUpload are accepted, so that a crafted
+// ID cannot point outside the uploads directory.
+func (s *LocalStore) uploadDir(uploadID string) (string, error) {
+	if len(uploadID) != uploadIDLen {
+		return "", localError(http.StatusNotFound, "NoSuchUpload", "invalid upload ID %q", uploadID)
+	}
+	if _, err := hex.DecodeString(uploadID); err != nil || strings.ToLower(uploadID) != uploadID {
+		return "", localError(http.StatusNotFound, "NoSuchUpload", "invalid upload ID %q", uploadID)
+	}
+	return filepath.Join(s.Root, localStateDir, "uploads", uploadID), nil
+}
+
+func (s *LocalStore) loadUpload(key, uploadID string) (*localUpload, error) {
+	dir, err := s.uploadDir(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "upload.json"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, localError(http.StatusNotFound, "NoSuchUpload", "upload %s does not exist", uploadID)
+		}
+		return nil, err
+	}
+	var upload localUpload
+	if err := json.Unmarshal(data, &upload); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal upload %s: %w", uploadID, err)
+	}
+	if upload.Key != key {
+		return nil, localError(http.StatusNotFound, "NoSuchUpload", "upload %s does not belong to key %s", uploadID, key)
+	}
+	return &upload, nil
+}
+
+// writeFile copies r into path via a temporary file and returns the quoted
+// hex MD5 of the content, like an S3 ETag. The copy stops once ctx is done.
+func writeFile(ctx context.Context, path string, r io.Reader) (string, int64, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", 0, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := md5.New()
+	n, err := io.Copy(io.MultiWriter(tmp, h), contextReader{ctx, r})
+	if err != nil {
+		tmp.Close()
+		return "", 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", 0, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", 0, err
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)) + `"`, n, nil
+}
+
+func (s *LocalStore) saveMeta(key string, meta localObjectMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	p := s.metaPath(key)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(p, data, 0644)
+}
+
+// CreateMultipartUpload starts a multipart upload and returns its upload ID.
+func (s *LocalStore) CreateMultipartUpload(ctx context.Context, key string, algorithm checksum.Algorithm, metadata map[string]string) (string, error) {
+	if _, err := s.objectPath(key); err != nil {
+		return "", err
+	}
+	idBytes := make([]byte, uploadIDLen/2)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", fmt.Errorf("failed to generate upload ID: %w", err)
+	}
+	uploadID := hex.EncodeToString(idBytes)
+
+	data, err := json.Marshal(localUpload{Key: key, Initiated: time.Now().UTC(), ChecksumAlgorithm: algorithm, Metadata: metadata})
+	if err != nil {
+		return "", err
+	}
+	dir, err := s.uploadDir(uploadID)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "upload.json"), data, 0644); err != nil {
+		return "", err
+	}
+	return uploadID, nil
+}
+
+// UploadPart stores a single part and returns its ETag.
+func (s *LocalStore) UploadPart(ctx context.Context, key, uploadID string, partNumber int, body io.ReadSeeker, size int64, sum Checksum) (string, error) {
+	if partNumber < 1 || partNumber > 10000 {
+		return "", localError(http.StatusBadRequest, "InvalidArgument", "part number must be between 1 and %d, got %d", chunker.MaxParts, partNumber)
+	}
+	if _, err := s.loadUpload(key, uploadID); err != nil {
+		return "", err
+	}
+	dir, err := s.uploadDir(uploadID)
+	if err != nil {
+		return "", err
+	}
+
+	var r io.Reader = io.LimitReader(body, size)
+	h := sum.Algorithm.New()
+	if h != nil {
+		r = io.TeeReader(r, h)
+	}
+	partPath := filepath.Join(dir, fmt.Sprintf("part-%05d", partNumber))
+	eTag, n, err := writeFile(ctx, partPath, r)
+	if err != nil {
+		return "", fmt.Errorf("failed to write part %d: %w", partNumber, err)
+	}
+	if n != size {
+		os.Remove(partPath)
+		return "", localError(http.StatusBadRequest, "IncompleteBody", "part %d has %d bytes, expected %d", partNumber, n, size)
+	}
+	if h != nil {
+		if base64.StdEncoding.EncodeToString(h.Sum(nil)) != sum.Value {
+			os.Remove(partPath)
+			return "", localError(http.StatusBadRequest, "BadDigest", "the %s checksum of part %d does not match", sum.Algorithm, partNumber)
+		}
+		if err := os.WriteFile(partPath+".checksum", []byte(sum.Value), 0644); err != nil {
+			return "", fmt.Errorf("failed to save checksum of part %d: %w", partNumber, err)
+		}
+	}
+	return eTag, nil
+}
+
+// CompleteMultipartUpload assembles the object from the uploaded parts.
+func (s *LocalStore) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []CompletedPart) (CompletedObject, error) {
+	upload, err := s.loadUpload(key, uploadID)
+	if err != nil {
+		return CompletedObject{}, err
+	}
+	objPath, err := s.objectPath(key)
+	if err != nil {
+		return CompletedObject{}, err
+	}
+	if len(parts) == 0 {
+		return CompletedObject{}, localError(http.StatusBadRequest, "MalformedXML", "no parts specified")
+	}
+	// Like S3, only additional checksums declared at creation are combined
+	// into a composite checksum; Content-MD5 is covered by the ETag.
+	var partChecksums []string
+	var partMetas []localPartMeta
+	trackChecksums := upload.ChecksumAlgorithm == checksum.CRC32C || upload.ChecksumAlgorithm == checksum.SHA256
+
+	dir, err := s.uploadDir(uploadID)
+	if err != nil {
+		return CompletedObject{}, err
+	}
+	partPaths := make([]string, 0, len(parts))
+	var firstPartSize int64
+	digests := md5.New()
+	for i, p := range parts {
+		if err := ctx.Err(); err != nil {
+			return CompletedObject{}, err
+		}
+		if i > 0 && p.PartNumber <= parts[i-1].PartNumber {
+			return CompletedObject{}, localError(http.StatusBadRequest, "InvalidPartOrder", "parts must be in ascending order")
+		}
+		partPath := filepath.Join(dir, fmt.Sprintf("part-%05d", p.PartNumber))
+		sum, size, err := fileMD5(partPath)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return CompletedObject{}, localError(http.StatusBadRequest, "InvalidPart", "part %d not found", p.PartNumber)
+			}
+			return CompletedObject{}, err
+		}
+		if i < len(parts)-1 && size < chunker.MinPartSize {
+			return CompletedObject{}, localError(http.StatusBadRequest, "EntityTooSmall", "part %d is smaller than the minimum allowed size", p.PartNumber)
+		}
+		if `"`+hex.EncodeToString(sum)+`"` != p.ETag {
+			return CompletedObject{}, localError(http.StatusBadRequest, "InvalidPart", "ETag mismatch for part %d", p.PartNumber)
+		}
+		if trackChecksums {
+			stored, err := os.ReadFile(partPath + ".checksum")
+			if err != nil || string(stored) != p.Checksum.Value {
+				return CompletedObject{}, localError(http.StatusBadRequest, "InvalidPart", "%s checksum mismatch for part %d", upload.ChecksumAlgorithm, p.PartNumber)
+			}
+			partChecksums = append(partChecksums, string(stored))
+			partMetas = append(partMetas, localPartMeta{PartNumber: p.PartNumber, Size: size, Checksum: string(stored)})
+		}
+		if i == 0 {
+			firstPartSize = size
+		}
+		digests.Write(sum)
+		partPaths = append(partPaths, partPath)
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		for _, partPath := range partPaths {
+			f, err := os.Open(partPath)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+			_, err = io.Copy(pw, f)
+			f.Close()
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.Close()
+	}()
+	_, _, err = writeFile(ctx, objPath, pr)
+	pr.Close()
+	if err != nil {
+		return CompletedObject{}, fmt.Errorf("failed to assemble object: %w", err)
+	}
+	result := CompletedObject{ETag: fmt.Sprintf(`"%s-%d"`, hex.EncodeToString(digests.Sum(nil)), len(parts))}
+	if trackChecksums {
+		if result.Checksum, err = checksum.Composite(upload.ChecksumAlgorithm, partChecksums); err != nil {
+			return CompletedObject{}, err
+		}
+	}
+	meta := localObjectMeta{
+		ETag:     result.ETag,
+		PartSize: firstPartSize,
+		Checksum: result.Checksum,
+		Metadata: upload.Metadata,
+	}
+	if trackChecksums {
+		meta.ChecksumAlgorithm = upload.ChecksumAlgorithm
+		meta.Parts = partMetas
+	}
+	if err := s.saveMeta(key, meta); err != nil {
+		return CompletedObject{}, fmt.Errorf("failed to save object metadata: %w", err)
+	}
+	return result, os.RemoveAll(dir)
+}
+
+// AbortMultipartUpload discards an in-progress upload and its parts.
+func (s *LocalStore) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	if _, err := s.loadUpload(key, uploadID); err != nil {
+		return err
+	}
+	dir, err := s.uploadDir(uploadID)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// ListMultipartUploads lists the in-progress multipart uploads whose keys
+// start with prefix.
+func (s *LocalStore) ListMultipartUploads(ctx context.Context, prefix string) ([]MultipartUpload, error) {
+	entries, err := os.ReadDir(filepath.Join(s.Root, localStateDir, "uploads"))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var uploads []MultipartUpload
+	for _, e := range entries {
+		dir, err := s.uploadDir(e.Name())
+		if err != nil {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, "upload.json"))
+		if err != nil {
+			continue
+		}
+		var upload localUpload
+		if err := json.Unmarshal(data, &upload); err != nil || !strings.HasPrefix(upload.Key, prefix) {
+			continue
+		}
+		uploads = append(uploads, MultipartUpload{
+			Key:       upload.Key,
+			UploadID:  e.Name(),
+			Initiated: upload.Initiated,
+		})
+	}
+	sort.Slice(uploads, func(i, j int) bool {
+		if uploads[i].Key != uploads[j].Key {
+			return uploads[i].Key < uploads[j].Key
+		}
+		return uploads[i].Initiated.Before(uploads[j].Initiated)
+	})
+	return uploads, nil
+}
+
+// UploadMetadata implements UploadMetadataReader.
+func (s *LocalStore) UploadMetadata(ctx context.Context, key, uploadID string) (map[string]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	upload, err := s.loadUpload(key, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	return upload.Metadata, nil
+}
+
+// ListParts lists the parts uploaded so far to an in-progress upload.
+func (s *LocalStore) ListParts(ctx context.Context, key, uploadID string) ([]UploadedPart, error) {
+	upload, err := s.loadUpload(key, uploadID)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := s.uploadDir(uploadID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var parts []UploadedPart
+	for _, e := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var partNumber int
+		if n, _ := fmt.Sscanf(e.Name(), "part-%05d", &partNumber); n != 1 || e.Name() != fmt.Sprintf("part-%05d", partNumber) {
+			continue
+		}
+		partPath := filepath.Join(dir, e.Name())
+		sum, size, err := fileMD5(partPath)
+		if err != nil {
+			return nil, err
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		part := UploadedPart{
+			PartNumber:   partNumber,
+			Size:         size,
+			ETag:         `"` + hex.EncodeToString(sum) + `"`,
+			LastModified: info.ModTime().UTC(),
+		}
+		if stored, err := os.ReadFile(partPath + ".checksum"); err == nil {
+			part.Checksum = Checksum{Algorithm: upload.ChecksumAlgorithm, Value: string(stored)}
+		}
+		parts = append(parts, part)
+	}
+	// Part files are named so that they sort by part number.
+	return parts, nil
+}
+
+// PutObject stores an object in a single write.
+func (s *LocalStore) PutObject(ctx context.Context, key string, body io.ReadSeeker, size int64, metadata map[string]string) error {
+	objPath, err := s.objectPath(key)
+	if err != nil {
+		return err
+	}
+	eTag, n, err := writeFile(ctx, objPath, io.LimitReader(body, size))
+	if err != nil {
+		return fmt.Errorf("failed to write object: %w", err)
+	}
+	if n != size {
+		return localError(http.StatusBadRequest, "IncompleteBody", "object has %d bytes, expected %d", n, size)
+	}
+	return s.saveMeta(key, localObjectMeta{ETag: eTag, Metadata: metadata})
+}
+
+// GetObject opens the stored object.
+func (s *LocalStore) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	objPath, err := s.objectPath(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(objPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, localError(http.StatusNotFound, "NoSuchKey", "%s does not exist", key)
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// localRangeReader reads a byte range of an object file.
+type localRangeReader struct {
+	*io.SectionReader
+	file *os.File
+}
+
+func (r *localRangeReader) Close() error {
+	return r.file.Close()
+}
+
+// GetObjectRange returns length bytes of the stored object starting at offset.
+func (s *LocalStore) GetObjectRange(ctx context.Context, key, eTag string, offset, length int64) (io.ReadCloser, error) {
+	objPath, err := s.objectPath(key)
+	if err != nil {
+		return nil, err
+	}
+	if eTag != "" {
+		current, err := s.objectETag(key, objPath)
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil, localError(http.StatusNotFound, "NoSuchKey", "%s does not exist", key)
+			}
+			return nil, err
+		}
+		if current != eTag {
+			return nil, localError(http.StatusPreconditionFailed, "PreconditionFailed", "%s no longer has ETag %s", key, eTag)
+		}
+	}
+	f, err := os.Open(objPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, localError(http.StatusNotFound, "NoSuchKey", "%s does not exist", key)
+		}
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if offset < 0 || offset >= fi.Size() {
+		f.Close()
+		return nil, localError(http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "offset %d is outside the object", offset)
+	}
+	return &localRangeReader{SectionReader: io.NewSectionReader(f, offset, length), file: f}, nil
+}
+
+// HeadObject returns information about the stored object.
+func (s *LocalStore) HeadObject(ctx context.Context, key string) (ObjectInfo, error) {
+	objPath, err := s.objectPath(key)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	fi, err := os.Stat(objPath)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ObjectInfo{}, localError(http.StatusNotFound, "NotFound", "%s does not exist", key)
+		}
+		return ObjectInfo{}, err
+	}
+	meta := s.loadMeta(key)
+	eTag := meta.ETag
+	if eTag == "" {
+		if eTag, err = s.objectETag(key, objPath); err != nil {
+			return ObjectInfo{}, err
+		}
+	}
+	metadata := make(map[string]string, len(meta.Metadata))
+	for k, v := range meta.Metadata {
+		metadata[k] = v
+	}
+	return ObjectInfo{
+		Key:          key,
+		Size:         fi.Size(),
+		ETag:         eTag,
+		LastModified: fi.ModTime(),
+		Metadata:     metadata,
+		PartSize:     meta.PartSize,
+	}, nil
+}
+
+// GetObjectAttributes returns the ETag, size and checksums of the stored object.
+func (s *LocalStore) GetObjectAttributes(ctx context.Context, key string) (ObjectAttributes, error) {
+	info, err := s.HeadObject(ctx, key)
+	if err != nil {
+		return ObjectAttributes{}, err
+	}
+	meta := s.loadMeta(key)
+	attrs := ObjectAttributes{ETag: info.ETag, Size: info.Size}
+	if meta.Checksum != "" {
+		attrs.Checksum = Checksum{Algorithm: meta.ChecksumAlgorithm, Value: meta.Checksum}
+	}
+	for _, p := range meta.Parts {
+		attrs.Parts = append(attrs.Parts, ObjectPart{
+			PartNumber: p.PartNumber,
+			Size:       p.Size,
+			Checksum:   Checksum{Algorithm: meta.ChecksumAlgorithm, Value: p.Checksum},
+		})
+	}
+	return attrs, nil
+}
+
+// DeleteObject deletes an object. Like S3, deleting a missing key succeeds.
+func (s *LocalStore) DeleteObject(ctx context.Context, key string) error {
+	objPath, err := s.objectPath(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(objPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.Remove(s.metaPath(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// ListObjects lists all objects whose keys start with prefix, in key order.
+func (s *LocalStore) ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := filepath.WalkDir(s.Root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(s.Root, p)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if rel == localStateDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) || strings.HasPrefix(d.Name(), ".tmp-") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		eTag, err := s.objectETag(key, p)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{
+			Key:          key,
+			Size:         info.Size(),
+			ETag:         eTag,
+			LastModified: info.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// loadMeta returns the recorded metadata of key, or the zero value for
+// objects placed under the root without going through the store.
+func (s *LocalStore) loadMeta(key string) localObjectMeta {
+	var meta localObjectMeta
+	if data, err := os.ReadFile(s.metaPath(key)); err == nil {
+		json.Unmarshal(data, &meta)
+	}
+	return meta
+}
+
+// objectETag returns the recorded ETag of key, computing the MD5 of the file
+// for objects without recorded metadata.
+func (s *LocalStore) objectETag(key, objPath string) (string, error) {
+	if meta := s.loadMeta(key); meta.ETag != "" {
+		return meta.ETag, nil
+	}
+	sum, _, err := fileMD5(objPath)
+	if err != nil {
+		return "", err
+	}
+	return `"` + hex.EncodeToString(sum) + `"`, nil
+}
+
+// fileMD5 returns the MD5 digest and size of the file at path.
+func fileMD5(path string) ([]byte, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	h := md5.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return nil, 0, err
+	}
+	return h.Sum(nil), n, nil
+}
+
+// Location returns the path of key under the store root.
+func (s *LocalStore) Location(key string) string {
+	return "file://" + filepath.ToSlash(filepath.Join(s.Root, filepath.FromSlash(key)))
+}
@@ -0,0 +1,305 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+
+	"github.com/yucori/Favus/internal/checksum"
+	"github.com/yucori/Favus/internal/chunker"
+	"github.com/yucori/Favus/pkg/utils"
+)
+
+// newTestLocalStore returns a LocalStore rooted in a temporary directory.
+func newTestLocalStore(t *testing.T) *LocalStore {
+	t.Helper()
+	s, err := NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// randomBytes returns n random bytes.
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// sumOf returns the checksum of data with algorithm.
+func sumOf(t *testing.T, algorithm checksum.Algorithm, data []byte) Checksum {
+	t.Helper()
+	value, err := checksum.Compute(algorithm, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Checksum{Algorithm: algorithm, Value: value}
+}
+
+// uploadParts uploads every element of parts as a part of a new upload of
+// key, and returns the upload ID and the parts to complete it with.
+func uploadParts(t *testing.T, s *LocalStore, key string, algorithm checksum.Algorithm, metadata map[string]string, parts ...[]byte) (string, []CompletedPart) {
+	t.Helper()
+	ctx := context.Background()
+	uploadID, err := s.CreateMultipartUpload(ctx, key, algorithm, metadata)
+	if err != nil {
+		t.Fatalf("CreateMultipartUpload: %v", err)
+	}
+	var completed []CompletedPart
+	for i, data := range parts {
+		sum := sumOf(t, algorithm, data)
+		eTag, err := s.UploadPart(ctx, key, uploadID, i+1, bytes.NewReader(data), int64(len(data)), sum)
+		if err != nil {
+			t.Fatalf("UploadPart %d: %v", i+1, err)
+		}
+		completed = append(completed, CompletedPart{PartNumber: i + 1, ETag: eTag, Checksum: sum})
+	}
+	return uploadID, completed
+}
+
+// checkCode fails the test unless err is an S3-like error with code and
+// status.
+func checkCode(t *testing.T, err error, status int, code string) {
+	t.Helper()
+	var failure awserr.RequestFailure
+	if !errors.As(err, &failure) {
+		t.Fatalf("error %v is not an awserr.RequestFailure", err)
+	}
+	if failure.Code() != code || failure.StatusCode() != status {
+		t.Errorf("error %v has code %s and status %d, want %s and %d", err, failure.Code(), failure.StatusCode(), code, status)
+	}
+}
+
+func TestLocalStoreMultipartUpload(t *testing.T) {
+	for _, algorithm := range []checksum.Algorithm{checksum.None, checksum.MD5, checksum.CRC32C, checksum.SHA256} {
+		t.Run(algorithm.String(), func(t *testing.T) {
+			ctx := context.Background()
+			s := newTestLocalStore(t)
+			first := randomBytes(t, chunker.MinPartSize)
+			last := randomBytes(t, 1234)
+			metadata := map[string]string{MetaPartSize: fmt.Sprint(len(first))}
+			uploadID, parts := uploadParts(t, s, "dir/data.bin", algorithm, metadata, first, last)
+
+			if got, err := s.UploadMetadata(ctx, "dir/data.bin", uploadID); err != nil || got[MetaPartSize] != metadata[MetaPartSize] {
+				t.Errorf("UploadMetadata = %v, %v, want %v", got, err, metadata)
+			}
+			listed, err := s.ListParts(ctx, "dir/data.bin", uploadID)
+			if err != nil {
+				t.Fatalf("ListParts: %v", err)
+			}
+			if len(listed) != 2 || listed[0].Size != int64(len(first)) || listed[1].ETag != parts[1].ETag || listed[1].Checksum != parts[1].Checksum {
+				t.Errorf("ListParts = %+v, want the parts uploaded", listed)
+			}
+
+			obj, err := s.CompleteMultipartUpload(ctx, "dir/data.bin", uploadID, parts)
+			if err != nil {
+				t.Fatalf("CompleteMultipartUpload: %v", err)
+			}
+			digests := md5.New()
+			for _, data := range [][]byte{first, last} {
+				sum := md5.Sum(data)
+				digests.Write(sum[:])
+			}
+			if want := fmt.Sprintf(`"%s-2"`, hex.EncodeToString(digests.Sum(nil))); obj.ETag != want {
+				t.Errorf("ETag %s, want %s", obj.ETag, want)
+			}
+			// Only additional checksums make a composite checksum.
+			if tracked := algorithm == checksum.CRC32C || algorithm == checksum.SHA256; (obj.Checksum != "") != tracked {
+				t.Errorf("composite checksum %q reported for %s", obj.Checksum, algorithm)
+			}
+
+			r, err := s.GetObject(ctx, "dir/data.bin")
+			if err != nil {
+				t.Fatalf("GetObject: %v", err)
+			}
+			content, err := io.ReadAll(r)
+			r.Close()
+			if err != nil || !bytes.Equal(content, append(append([]byte(nil), first...), last...)) {
+				t.Errorf("object content differs from the parts (%v)", err)
+			}
+			info, err := s.HeadObject(ctx, "dir/data.bin")
+			if err != nil {
+				t.Fatalf("HeadObject: %v", err)
+			}
+			if info.ETag != obj.ETag || info.PartSize != int64(len(first)) || info.Metadata[MetaPartSize] != metadata[MetaPartSize] {
+				t.Errorf("HeadObject = %+v", info)
+			}
+			attrs, err := s.GetObjectAttributes(ctx, "dir/data.bin")
+			if err != nil {
+				t.Fatalf("GetObjectAttributes: %v", err)
+			}
+			if attrs.Checksum.Value != obj.Checksum || (obj.Checksum != "" && len(attrs.Parts) != 2) {
+				t.Errorf("GetObjectAttributes = %+v", attrs)
+			}
+
+			if uploads, err := s.ListMultipartUploads(ctx, ""); err != nil || len(uploads) != 0 {
+				t.Errorf("uploads left in progress: %v, %v", uploads, err)
+			}
+			if _, err := s.ListParts(ctx, "dir/data.bin", uploadID); !IsNoSuchUpload(err) {
+				t.Errorf("ListParts of a completed upload returned %v, want NoSuchUpload", err)
+			}
+		})
+	}
+}
+
+func TestLocalStoreErrors(t *testing.T) {
+	ctx := context.Background()
+	s := newTestLocalStore(t)
+	small := randomBytes(t, 100)
+	full := randomBytes(t, chunker.MinPartSize)
+
+	uploadID, parts := uploadParts(t, s, "data.bin", checksum.CRC32C, nil, small, full)
+	_, err := s.CompleteMultipartUpload(ctx, "data.bin", uploadID, parts)
+	checkCode(t, err, http.StatusBadRequest, "EntityTooSmall")
+	_, err = s.CompleteMultipartUpload(ctx, "data.bin", uploadID, []CompletedPart{parts[1], parts[0]})
+	checkCode(t, err, http.StatusBadRequest, "InvalidPartOrder")
+	_, err = s.CompleteMultipartUpload(ctx, "data.bin", uploadID, nil)
+	checkCode(t, err, http.StatusBadRequest, "MalformedXML")
+	wrongETag := parts[0]
+	wrongETag.ETag = `"00000000000000000000000000000000"`
+	_, err = s.CompleteMultipartUpload(ctx, "data.bin", uploadID, []CompletedPart{wrongETag})
+	checkCode(t, err, http.StatusBadRequest, "InvalidPart")
+	_, err = s.CompleteMultipartUpload(ctx, "data.bin", uploadID, []CompletedPart{{PartNumber: 3, ETag: parts[0].ETag}})
+	checkCode(t, err, http.StatusBadRequest, "InvalidPart")
+
+	_, err = s.UploadPart(ctx, "data.bin", uploadID, 3, bytes.NewReader(small), int64(len(small)), sumOf(t, checksum.CRC32C, full))
+	checkCode(t, err, http.StatusBadRequest, "BadDigest")
+	_, err = s.UploadPart(ctx, "data.bin", uploadID, 3, bytes.NewReader(small), int64(len(small))+1, Checksum{})
+	checkCode(t, err, http.StatusBadRequest, "IncompleteBody")
+	_, err = s.UploadPart(ctx, "data.bin", uploadID, chunker.MaxParts+1, bytes.NewReader(small), int64(len(small)), Checksum{})
+	checkCode(t, err, http.StatusBadRequest, "InvalidArgument")
+	if listed, _ := s.ListParts(ctx, "data.bin", uploadID); len(listed) != 2 {
+		t.Errorf("%d parts stored after rejected parts, want 2", len(listed))
+	}
+
+	for _, id := range []string{"0123456789abcdef0123456789abcdef", "../../../../etc", "0123456789ABCDEF0123456789ABCDEF"} {
+		_, err := s.ListParts(ctx, "data.bin", id)
+		checkCode(t, err, http.StatusNotFound, "NoSuchUpload")
+		if !IsNoSuchUpload(err) {
+			t.Errorf("IsNoSuchUpload(%v) = false", err)
+		}
+	}
+	err = s.AbortMultipartUpload(ctx, "other.bin", uploadID)
+	checkCode(t, err, http.StatusNotFound, "NoSuchUpload")
+
+	_, err = s.GetObject(ctx, "missing.bin")
+	checkCode(t, err, http.StatusNotFound, "NoSuchKey")
+	_, err = s.HeadObject(ctx, "missing.bin")
+	checkCode(t, err, http.StatusNotFound, "NotFound")
+
+	if err := s.PutObject(ctx, "small.bin", bytes.NewReader(small), int64(len(small)), nil); err != nil {
+		t.Fatalf("PutObject: %v", err)
+	}
+	_, err = s.GetObjectRange(ctx, "small.bin", `"00000000000000000000000000000000"`, 0, 10)
+	checkCode(t, err, http.StatusPreconditionFailed, "PreconditionFailed")
+	_, err = s.GetObjectRange(ctx, "small.bin", "", int64(len(small)), 10)
+	checkCode(t, err, http.StatusRequestedRangeNotSatisfiable, "InvalidRange")
+
+	if err := s.DeleteObject(ctx, "missing.bin"); err != nil {
+		t.Errorf("DeleteObject of a missing key: %v", err)
+	}
+
+	// Only a bad digest, which a corrupted transfer causes, is worth
+	// retrying.
+	_, err = s.UploadPart(ctx, "data.bin", uploadID, 3, bytes.NewReader(small), int64(len(small)), sumOf(t, checksum.CRC32C, full))
+	if !utils.IsRetryable(err) {
+		t.Errorf("BadDigest is not retryable")
+	}
+	if _, err := s.GetObject(ctx, "missing.bin"); utils.IsRetryable(err) {
+		t.Errorf("NoSuchKey is retryable")
+	}
+}
+
+func TestLocalStoreKeys(t *testing.T) {
+	ctx := context.Background()
+	s := newTestLocalStore(t)
+	for _, key := range []string{"", "dir/", "../outside", "a/../../outside", ".favus/uploads/x"} {
+		if _, err := s.CreateMultipartUpload(ctx, key, checksum.None, nil); err == nil {
+			t.Errorf("CreateMultipartUpload accepted key %q", key)
+		}
+		if err := s.PutObject(ctx, key, bytes.NewReader(nil), 0, nil); err == nil {
+			t.Errorf("PutObject accepted key %q", key)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(s.Root), "outside")); err == nil {
+		t.Error("an object was written outside the root")
+	}
+}
+
+func TestLocalStoreListObjects(t *testing.T) {
+	ctx := context.Background()
+	s := newTestLocalStore(t)
+	for _, key := range []string{"b/2.txt", "a/1.txt", "b/1.txt", "c.txt"} {
+		if err := s.PutObject(ctx, key, bytes.NewReader([]byte(key)), int64(len(key)), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// An upload in progress is not an object.
+	uploadParts(t, s, "b/3.txt", checksum.None, nil, []byte("part"))
+
+	objects, err := s.ListObjects(ctx, "b/")
+	if err != nil {
+		t.Fatalf("ListObjects: %v", err)
+	}
+	var keys []string
+	for _, obj := range objects {
+		keys = append(keys, obj.Key)
+	}
+	if fmt.Sprint(keys) != "[b/1.txt b/2.txt]" {
+		t.Errorf("ListObjects(b/) = %v, want [b/1.txt b/2.txt]", keys)
+	}
+	if all, _ := s.ListObjects(ctx, ""); len(all) != 4 {
+		t.Errorf("ListObjects returned %d objects, want 4", len(all))
+	}
+
+	if err := s.DeleteObject(ctx, "b/1.txt"); err != nil {
+		t.Fatalf("DeleteObject: %v", err)
+	}
+	if _, err := s.HeadObject(ctx, "b/1.txt"); err == nil {
+		t.Error("deleted object still exists")
+	}
+	uploads, err := s.ListMultipartUploads(ctx, "b/")
+	if err != nil || len(uploads) != 1 || uploads[0].Key != "b/3.txt" {
+		t.Errorf("ListMultipartUploads(b/) = %v, %v", uploads, err)
+	}
+}
+
+func TestLocalStoreCanceled(t *testing.T) {
+	s := newTestLocalStore(t)
+	uploadID, _ := uploadParts(t, s, "data.bin", checksum.None, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	data := randomBytes(t, chunker.MinPartSize)
+	if _, err := s.UploadPart(ctx, "data.bin", uploadID, 1, bytes.NewReader(data), int64(len(data)), Checksum{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("UploadPart returned %v, want context.Canceled", err)
+	}
+	if err := s.PutObject(ctx, "data.bin", bytes.NewReader(data), int64(len(data)), nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("PutObject returned %v, want context.Canceled", err)
+	}
+	parts, err := s.ListParts(context.Background(), "data.bin", uploadID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parts) != 0 {
+		t.Errorf("%d parts stored by a canceled request", len(parts))
+	}
+	if _, err := s.HeadObject(context.Background(), "data.bin"); err == nil {
+		t.Error("object stored by a canceled request")
+	}
+}
@@ -0,0 +1,447 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/yucori/Favus/internal/checksum"
+	"github.com/yucori/Favus/internal/config"
+)
+
+// S3Store is an ObjectStore backed by an S3 bucket.
+type S3Store struct {
+	Client s3iface.S3API
+	Bucket string
+	// SSE is the server-side encryption requested for the objects the
+	// store writes. With SSE-C, the key is also sent with every request
+	// reading an object or adding to a multipart upload.
+	SSE ServerSideEncryption
+}
+
+// NewS3Store creates an S3Store for bucket using client.
+func NewS3Store(client s3iface.S3API, bucket string) *S3Store {
+	return &S3Store{
+		Client: client,
+		Bucket: bucket,
+	}
+}
+
+// NewS3StoreFromConfig creates an S3Store with an AWS session built from the
+// endpoint, TLS and credential settings in cfg, encrypting objects as the
+// server-side encryption settings say.
+func NewS3StoreFromConfig(cfg *config.Config) (*S3Store, error) {
+	sse, err := NewServerSideEncryption(cfg)
+	if err != nil {
+		return nil, err
+	}
+	sess, err := newSession(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS session: %w", err)
+	}
+	client := s3.New(sess, s3ClientConfig(cfg))
+	client.Handlers.UnmarshalError.PushBack(keepRetryAfter)
+	store := NewS3Store(client, cfg.S3BucketName)
+	store.SSE = sse
+	return store, nil
+}
+
+// retryAfterError is an error response that told the client how long to
+// wait before trying again. Retry policies honor it through RetryAfter.
+type retryAfterError struct {
+	awserr.RequestFailure
+	after time.Duration
+}
+
+func (e *retryAfterError) RetryAfter() time.Duration {
+	return e.after
+}
+
+func (e *retryAfterError) Unwrap() error {
+	return e.RequestFailure
+}
+
+// keepRetryAfter is a request handler that attaches the Retry-After header
+// of an error response, in seconds or as an HTTP date, to the error.
+func keepRetryAfter(r *request.Request) {
+	failure, ok := r.Error.(awserr.RequestFailure)
+	if !ok || r.HTTPResponse == nil {
+		return
+	}
+	value := r.HTTPResponse.Header.Get("Retry-After")
+	if value == "" {
+		return
+	}
+	var after time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		after = time.Duration(seconds) * time.Second
+	} else if at, err := http.ParseTime(value); err == nil {
+		after = time.Until(at)
+	}
+	if after > 0 {
+		r.Error = &retryAfterError{RequestFailure: failure, after: after}
+	}
+}
+
+// CustomerKeyMD5 implements CustomerKeyer.
+func (s *S3Store) CustomerKeyMD5() string {
+	return s.SSE.CustomerKeyMD5()
+}
+
+// CreateMultipartUpload starts a multipart upload and returns its upload ID.
+func (s *S3Store) CreateMultipartUpload(ctx context.Context, key string, algorithm checksum.Algorithm, metadata map[string]string) (string, error) {
+	input := &s3.CreateMultipartUploadInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	}
+	if len(metadata) > 0 {
+		input.Metadata = aws.StringMap(metadata)
+	}
+	input.ServerSideEncryption, input.SSEKMSKeyId, input.SSEKMSEncryptionContext = s.SSE.objectParams()
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.SSE.customerKeyParams()
+	// Content-MD5 needs no declaration; additional checksums do.
+	switch algorithm {
+	case checksum.CRC32C:
+		input.ChecksumAlgorithm = aws.String(s3.ChecksumAlgorithmCrc32c)
+	case checksum.SHA256:
+		input.ChecksumAlgorithm = aws.String(s3.ChecksumAlgorithmSha256)
+	}
+	output, err := s.Client.CreateMultipartUploadWithContext(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(output.UploadId), nil
+}
+
+// UploadPart uploads a single part and returns its ETag.
+func (s *S3Store) UploadPart(ctx context.Context, key, uploadID string, partNumber int, body io.ReadSeeker, size int64, sum Checksum) (string, error) {
+	input := &s3.UploadPartInput{
+		Body:          body,
+		Bucket:        aws.String(s.Bucket),
+		Key:           aws.String(key),
+		PartNumber:    aws.Int64(int64(partNumber)),
+		UploadId:      aws.String(uploadID),
+		ContentLength: aws.Int64(size),
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.SSE.customerKeyParams()
+	switch sum.Algorithm {
+	case checksum.MD5:
+		input.ContentMD5 = aws.String(sum.Value)
+	case checksum.CRC32C:
+		input.ChecksumCRC32C = aws.String(sum.Value)
+	case checksum.SHA256:
+		input.ChecksumSHA256 = aws.String(sum.Value)
+	}
+	output, err := s.Client.UploadPartWithContext(ctx, input)
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(output.ETag), nil
+}
+
+// CompleteMultipartUpload assembles the object from the uploaded parts.
+func (s *S3Store) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []CompletedPart) (CompletedObject, error) {
+	completed := make([]*s3.CompletedPart, 0, len(parts))
+	for _, p := range parts {
+		part := &s3.CompletedPart{
+			PartNumber: aws.Int64(int64(p.PartNumber)),
+			ETag:       aws.String(p.ETag),
+		}
+		switch p.Checksum.Algorithm {
+		case checksum.CRC32C:
+			part.ChecksumCRC32C = aws.String(p.Checksum.Value)
+		case checksum.SHA256:
+			part.ChecksumSHA256 = aws.String(p.Checksum.Value)
+		}
+		completed = append(completed, part)
+	}
+	input := &s3.CompleteMultipartUploadInput{
+		Bucket:   aws.String(s.Bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{
+			Parts: completed,
+		},
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.SSE.customerKeyParams()
+	output, err := s.Client.CompleteMultipartUploadWithContext(ctx, input)
+	if err != nil {
+		return CompletedObject{}, err
+	}
+	// S3 does not report SSE-C here; the upload was made with the key
+	// this store sends.
+	result := CompletedObject{ETag: aws.StringValue(output.ETag), SSE: sseMode(output.ServerSideEncryption, nil)}
+	if s.SSE.Mode == config.SSEC {
+		result.SSE = config.SSEC
+	}
+	switch {
+	case output.ChecksumCRC32C != nil:
+		result.Checksum = aws.StringValue(output.ChecksumCRC32C)
+	case output.ChecksumSHA256 != nil:
+		result.Checksum = aws.StringValue(output.ChecksumSHA256)
+	}
+	return result, nil
+}
+
+// AbortMultipartUpload aborts an in-progress multipart upload.
+func (s *S3Store) AbortMultipartUpload(ctx context.Context, key, uploadID string) error {
+	_, err := s.Client.AbortMultipartUploadWithContext(ctx, &s3.AbortMultipartUploadInput{
+		Bucket:   aws.String(s.Bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	})
+	return err
+}
+
+// ListMultipartUploads lists all in-progress multipart uploads in the
+// bucket whose keys start with prefix, following every page of results.
+func (s *S3Store) ListMultipartUploads(ctx context.Context, prefix string) ([]MultipartUpload, error) {
+	input := &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(s.Bucket),
+	}
+	if prefix != "" {
+		input.Prefix = aws.String(prefix)
+	}
+	var uploads []MultipartUpload
+	err := s.Client.ListMultipartUploadsPagesWithContext(ctx, input, func(page *s3.ListMultipartUploadsOutput, lastPage bool) bool {
+		for _, u := range page.Uploads {
+			upload := MultipartUpload{
+				Key:       aws.StringValue(u.Key),
+				UploadID:  aws.StringValue(u.UploadId),
+				Initiated: aws.TimeValue(u.Initiated),
+			}
+			if u.Initiator != nil {
+				upload.InitiatorID = aws.StringValue(u.Initiator.ID)
+				upload.InitiatorName = aws.StringValue(u.Initiator.DisplayName)
+			}
+			uploads = append(uploads, upload)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return uploads, nil
+}
+
+// ListParts lists the parts uploaded so far to a multipart upload,
+// following every page of results.
+func (s *S3Store) ListParts(ctx context.Context, key, uploadID string) ([]UploadedPart, error) {
+	var parts []UploadedPart
+	input := &s3.ListPartsInput{
+		Bucket:   aws.String(s.Bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.SSE.customerKeyParams()
+	err := s.Client.ListPartsPagesWithContext(ctx, input, func(page *s3.ListPartsOutput, lastPage bool) bool {
+		for _, p := range page.Parts {
+			part := UploadedPart{
+				PartNumber:   int(aws.Int64Value(p.PartNumber)),
+				Size:         aws.Int64Value(p.Size),
+				ETag:         aws.StringValue(p.ETag),
+				LastModified: aws.TimeValue(p.LastModified),
+			}
+			switch {
+			case p.ChecksumCRC32C != nil:
+				part.Checksum = Checksum{Algorithm: checksum.CRC32C, Value: aws.StringValue(p.ChecksumCRC32C)}
+			case p.ChecksumSHA256 != nil:
+				part.Checksum = Checksum{Algorithm: checksum.SHA256, Value: aws.StringValue(p.ChecksumSHA256)}
+			}
+			parts = append(parts, part)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return parts, nil
+}
+
+// PutObject uploads an object in a single request.
+func (s *S3Store) PutObject(ctx context.Context, key string, body io.ReadSeeker, size int64, metadata map[string]string) error {
+	input := &s3.PutObjectInput{
+		Body:          body,
+		Bucket:        aws.String(s.Bucket),
+		Key:           aws.String(key),
+		ContentLength: aws.Int64(size),
+	}
+	if len(metadata) > 0 {
+		input.Metadata = aws.StringMap(metadata)
+	}
+	input.ServerSideEncryption, input.SSEKMSKeyId, input.SSEKMSEncryptionContext = s.SSE.objectParams()
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.SSE.customerKeyParams()
+	_, err := s.Client.PutObjectWithContext(ctx, input)
+	return err
+}
+
+// GetObject returns the content of an object.
+func (s *S3Store) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.SSE.customerKeyParams()
+	output, err := s.Client.GetObjectWithContext(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	return output.Body, nil
+}
+
+// GetObjectRange returns length bytes of an object starting at offset.
+func (s *S3Store) GetObjectRange(ctx context.Context, key, eTag string, offset, length int64) (io.ReadCloser, error) {
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	}
+	if eTag != "" {
+		input.IfMatch = aws.String(eTag)
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.SSE.customerKeyParams()
+	output, err := s.Client.GetObjectWithContext(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	return output.Body, nil
+}
+
+// HeadObject returns information about an object. For multipart objects it
+// also fetches the size of the first part, which is the part size used by
+// the upload.
+func (s *S3Store) HeadObject(ctx context.Context, key string) (ObjectInfo, error) {
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.SSE.customerKeyParams()
+	output, err := s.Client.HeadObjectWithContext(ctx, input)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	info := ObjectInfo{
+		Key:          key,
+		Size:         aws.Int64Value(output.ContentLength),
+		ETag:         aws.StringValue(output.ETag),
+		LastModified: aws.TimeValue(output.LastModified),
+		Metadata:     make(map[string]string, len(output.Metadata)),
+		SSE:          sseMode(output.ServerSideEncryption, output.SSECustomerAlgorithm),
+	}
+	for k, v := range output.Metadata {
+		info.Metadata[strings.ToLower(k)] = aws.StringValue(v)
+	}
+
+	if strings.Contains(info.ETag, "-") {
+		input.PartNumber = aws.Int64(1)
+		partOutput, err := s.Client.HeadObjectWithContext(ctx, input)
+		if err != nil {
+			return ObjectInfo{}, err
+		}
+		info.PartSize = aws.Int64Value(partOutput.ContentLength)
+	}
+	return info, nil
+}
+
+// GetObjectAttributes returns the ETag, size and checksums of an object,
+// following the pagination of its part list.
+func (s *S3Store) GetObjectAttributes(ctx context.Context, key string) (ObjectAttributes, error) {
+	input := &s3.GetObjectAttributesInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+		ObjectAttributes: aws.StringSlice([]string{
+			s3.ObjectAttributesEtag,
+			s3.ObjectAttributesChecksum,
+			s3.ObjectAttributesObjectParts,
+			s3.ObjectAttributesObjectSize,
+		}),
+	}
+	input.SSECustomerAlgorithm, input.SSECustomerKey, input.SSECustomerKeyMD5 = s.SSE.customerKeyParams()
+	var attrs ObjectAttributes
+	for {
+		output, err := s.Client.GetObjectAttributesWithContext(ctx, input)
+		if err != nil {
+			return ObjectAttributes{}, err
+		}
+		// GetObjectAttributes reports the ETag without quotes.
+		attrs.ETag = `"` + strings.Trim(aws.StringValue(output.ETag), `"`) + `"`
+		attrs.Size = aws.Int64Value(output.ObjectSize)
+		if c := output.Checksum; c != nil {
+			switch {
+			case c.ChecksumCRC32C != nil:
+				attrs.Checksum = Checksum{Algorithm: checksum.CRC32C, Value: aws.StringValue(c.ChecksumCRC32C)}
+			case c.ChecksumSHA256 != nil:
+				attrs.Checksum = Checksum{Algorithm: checksum.SHA256, Value: aws.StringValue(c.ChecksumSHA256)}
+			}
+		}
+
+		parts := output.ObjectParts
+		if parts == nil {
+			break
+		}
+		for _, p := range parts.Parts {
+			part := ObjectPart{
+				PartNumber: int(aws.Int64Value(p.PartNumber)),
+				Size:       aws.Int64Value(p.Size),
+			}
+			switch {
+			case p.ChecksumCRC32C != nil:
+				part.Checksum = Checksum{Algorithm: checksum.CRC32C, Value: aws.StringValue(p.ChecksumCRC32C)}
+			case p.ChecksumSHA256 != nil:
+				part.Checksum = Checksum{Algorithm: checksum.SHA256, Value: aws.StringValue(p.ChecksumSHA256)}
+			}
+			attrs.Parts = append(attrs.Parts, part)
+		}
+		if !aws.BoolValue(parts.IsTruncated) {
+			break
+		}
+		input.PartNumberMarker = parts.NextPartNumberMarker
+	}
+	return attrs, nil
+}
+
+// DeleteObject deletes an object.
+func (s *S3Store) DeleteObject(ctx context.Context, key string) error {
+	_, err := s.Client.DeleteObjectWithContext(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+// ListObjects lists all objects whose keys start with prefix.
+func (s *S3Store) ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := s.Client.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:          aws.StringValue(obj.Key),
+				Size:         aws.Int64Value(obj.Size),
+				ETag:         aws.StringValue(obj.ETag),
+				LastModified: aws.TimeValue(obj.LastModified),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// Location returns the s3:// URL of key.
+func (s *S3Store) Location(key string) string {
+	return fmt.Sprintf("s3://%s/%s", s.Bucket, key)
+}
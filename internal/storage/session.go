@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/yucori/Favus/internal/config"
+)
+
+// newSession creates an AWS session from the connection and credential
+// settings in cfg.
+//
+// Credentials are resolved in this order: static keys from cfg, the named
+// profile, then the SDK's default chain. If cfg.AssumeRoleARN is set, the
+// resolved credentials are used to assume that role.
+func newSession(cfg *config.Config) (*session.Session, error) {
+	awsCfg := aws.NewConfig().WithRegion(cfg.AwsRegion)
+
+	if cfg.S3InsecureSkipVerify {
+		awsCfg.HTTPClient = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+			},
+		}
+	}
+
+	if cfg.AwsAccessKeyID != "" {
+		awsCfg.Credentials = credentials.NewStaticCredentials(cfg.AwsAccessKeyID, cfg.AwsSecretAccessKey, cfg.AwsSessionToken)
+	}
+
+	opts := session.Options{
+		Config:            *awsCfg,
+		Profile:           cfg.AwsProfile,
+		SharedConfigState: session.SharedConfigEnable,
+	}
+	if cfg.S3CABundle != "" {
+		bundle, err := os.Open(cfg.S3CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open CA bundle: %w", err)
+		}
+		defer bundle.Close()
+		opts.CustomCABundle = bundle
+	}
+
+	sess, err := session.NewSessionWithOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.AssumeRoleARN != "" {
+		creds := stscreds.NewCredentials(sess, cfg.AssumeRoleARN, func(p *stscreds.AssumeRoleProvider) {
+			p.RoleSessionName = cfg.AssumeRoleSessionName
+			if cfg.AssumeRoleExternalID != "" {
+				p.ExternalID = aws.String(cfg.AssumeRoleExternalID)
+			}
+		})
+		sess = sess.Copy(&aws.Config{Credentials: creds})
+	}
+	return sess, nil
+}
+
+// s3ClientConfig returns the S3-specific client settings from cfg. They are
+// applied to the S3 client only, so that STS keeps using its own endpoint.
+//
+// The SDK does not retry S3 requests itself: callers retry them with
+// cfg.Retry, which would otherwise multiply the attempts and waits of the
+// SDK's retryer.
+func s3ClientConfig(cfg *config.Config) *aws.Config {
+	awsCfg := aws.NewConfig().WithS3ForcePathStyle(cfg.S3ForcePathStyle).WithMaxRetries(0)
+	if cfg.S3Endpoint != "" {
+		awsCfg = awsCfg.WithEndpoint(cfg.S3Endpoint)
+	}
+	return awsCfg
+}
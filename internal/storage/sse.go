@@ -0,0 +1,116 @@
+package storage
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/yucori/Favus/internal/config"
+	"github.com/yucori/Favus/internal/keyfile"
+)
+
+// ServerSideEncryption is how S3 encrypts the objects an S3Store writes.
+// The zero value leaves it to the default encryption of the bucket.
+type ServerSideEncryption struct {
+	Mode        string // config.SSES3, config.SSEKMS or config.SSEC; empty for the bucket default
+	KMSKeyID    string // KMS key of SSE-KMS; the AWS managed key when empty
+	KMSContext  string // Encryption context of SSE-KMS, a JSON object
+	CustomerKey []byte // Key of SSE-C
+}
+
+// NewServerSideEncryption returns the server-side encryption configured
+// in cfg, reading the SSE-C key if there is one.
+func NewServerSideEncryption(cfg *config.Config) (ServerSideEncryption, error) {
+	sse := ServerSideEncryption{Mode: cfg.SSE, KMSKeyID: cfg.SSEKMSKeyID, KMSContext: cfg.SSEKMSContext}
+	if cfg.SSE == config.SSEC {
+		key, err := keyfile.Read(cfg.SSECustomerKeyFile)
+		if err != nil {
+			return ServerSideEncryption{}, fmt.Errorf("failed to load SSE-C key: %w", err)
+		}
+		sse.CustomerKey = key
+	}
+	return sse, nil
+}
+
+// CustomerKeyMD5 returns the MD5 of the SSE-C key in base64, by which S3
+// tells keys apart, or an empty string without SSE-C.
+func (e ServerSideEncryption) CustomerKeyMD5() string {
+	if e.Mode != config.SSEC {
+		return ""
+	}
+	sum := md5.Sum(e.CustomerKey)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// objectParams returns the parameters requesting the encryption of a new
+// object with S3 or KMS keys, which are nil for SSE-C or the bucket
+// default.
+func (e ServerSideEncryption) objectParams() (algorithm, kmsKeyID, kmsContext *string) {
+	switch e.Mode {
+	case config.SSES3:
+		algorithm = aws.String(s3.ServerSideEncryptionAes256)
+	case config.SSEKMS:
+		algorithm = aws.String(s3.ServerSideEncryptionAwsKms)
+		if e.KMSKeyID != "" {
+			kmsKeyID = aws.String(e.KMSKeyID)
+		}
+		if e.KMSContext != "" {
+			kmsContext = aws.String(base64.StdEncoding.EncodeToString([]byte(e.KMSContext)))
+		}
+	}
+	return algorithm, kmsKeyID, kmsContext
+}
+
+// customerKeyParams returns the SSE-C parameters, which every request
+// writing or reading an object encrypted with SSE-C must carry, or nils
+// without SSE-C. The SDK encodes the key in base64.
+func (e ServerSideEncryption) customerKeyParams() (algorithm, key, keyMD5 *string) {
+	if e.Mode != config.SSEC {
+		return nil, nil, nil
+	}
+	return aws.String(s3.ServerSideEncryptionAes256), aws.String(string(e.CustomerKey)), aws.String(e.CustomerKeyMD5())
+}
+
+// sseMode returns the mode of the server-side encryption S3 reports for an
+// object, given its x-amz-server-side-encryption and SSE-C algorithm
+// headers.
+func sseMode(algorithm, customerAlgorithm *string) string {
+	switch {
+	case customerAlgorithm != nil:
+		return config.SSEC
+	case aws.StringValue(algorithm) == s3.ServerSideEncryptionAes256:
+		return config.SSES3
+	case aws.StringValue(algorithm) != "":
+		// aws:kms, or aws:kms:dsse for dual-layer encryption.
+		return config.SSEKMS
+	}
+	return ""
+}
+
+// CustomerKeyer is implemented by stores that may send an SSE-C key with
+// their requests.
+type CustomerKeyer interface {
+	// CustomerKeyMD5 returns the fingerprint of the key, as returned by
+	// ServerSideEncryption.CustomerKeyMD5, or "" if none is sent.
+	CustomerKeyMD5() string
+}
+
+// CustomerKeyMD5 returns the fingerprint of the SSE-C key that store sends
+// with its requests, or an empty string if it sends none.
+func CustomerKeyMD5(store ObjectStore) string {
+	if k, ok := store.(CustomerKeyer); ok {
+		return k.CustomerKeyMD5()
+	}
+	return ""
+}
+
+// ETagIsMD5 reports whether the ETag of an object stored with server-side
+// encryption sse, a mode as in ObjectInfo.SSE, is the MD5 of its content,
+// or of its parts for a multipart object. Objects encrypted with SSE-KMS
+// or SSE-C have ETags that cannot be recomputed.
+func ETagIsMD5(sse string) bool {
+	return sse != config.SSEKMS && sse != config.SSEC
+}
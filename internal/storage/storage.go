@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/yucori/Favus/internal/checksum"
+	"github.com/yucori/Favus/internal/config"
+)
+
+// Checksum is a base64-encoded checksum of some content. The zero value
+// means no checksum.
+type Checksum struct {
+	Algorithm checksum.Algorithm
+	Value     string
+}
+
+// CompletedPart identifies an uploaded part when completing a multipart upload.
+type CompletedPart struct {
+	PartNumber int
+	ETag       string
+	Checksum   Checksum // Checksum sent with the part, if the upload uses one
+}
+
+// CompletedObject describes the object assembled by CompleteMultipartUpload.
+type CompletedObject struct {
+	ETag     string
+	Checksum string // Composite checksum reported by the store, if the upload uses one
+	SSE      string // Server-side encryption of the object, as in ObjectInfo.SSE
+}
+
+// MultipartUpload describes an in-progress multipart upload.
+type MultipartUpload struct {
+	Key       string
+	UploadID  string
+	Initiated time.Time
+	// Who started the upload, if the store reports it: for S3, the ARN or
+	// canonical user ID, and its display name.
+	InitiatorID   string
+	InitiatorName string
+}
+
+// UploadedPart describes a part uploaded to an in-progress multipart upload.
+type UploadedPart struct {
+	PartNumber   int
+	Size         int64
+	ETag         string
+	LastModified time.Time
+	Checksum     Checksum // Checksum sent with the part, if the upload uses one
+}
+
+// ObjectInfo describes a stored object.
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ETag         string
+	LastModified time.Time
+	Metadata     map[string]string // User metadata; only set by HeadObject
+	PartSize     int64             // Size of the first part of a multipart object; only set by HeadObject
+	// SSE is the server-side encryption of the object, config.SSES3,
+	// config.SSEKMS or config.SSEC, or empty if there is none or it is
+	// unknown; only set by HeadObject.
+	SSE string
+}
+
+// ObjectPart describes one part of a stored multipart object.
+type ObjectPart struct {
+	PartNumber int
+	Size       int64
+	Checksum   Checksum // Checksum sent with the part, if the upload used one
+}
+
+// ObjectAttributes describes the integrity information a store keeps for
+// an object.
+type ObjectAttributes struct {
+	ETag     string
+	Size     int64
+	Checksum Checksum     // Checksum of the object, composite for multipart objects
+	Parts    []ObjectPart // Parts of a multipart object, if the store reports them
+}
+
+// User metadata keys Favus records on the objects it uploads.
+const (
+	// MetaPartSize holds the part size of a multipart upload, so that its
+	// ETag can be recomputed from the source file.
+	MetaPartSize = "favus-part-size"
+	// MetaPartSizeStep holds the number of parts after which the part size
+	// of a streamed upload doubles, see chunker.GrowingPartSize. The part
+	// size of other uploads does not change.
+	MetaPartSizeStep = "favus-part-size-step"
+	// MetaMtime holds the modification time of the uploaded file, in
+	// RFC 3339 format, so that sync can tell whether it changed.
+	MetaMtime = "favus-mtime"
+
+	// Metadata of objects encrypted on the client, see package encryption.
+	MetaEncryption            = "favus-encryption"              // Content encryption algorithm
+	MetaEncryptionKey         = "favus-encryption-key"          // Data key wrapped by the master key, in base64
+	MetaEncryptionKeyProvider = "favus-encryption-key-provider" // Provider of the master key
+	MetaEncryptionKeyID       = "favus-encryption-key-id"       // ID of the master key
+)
+
+// ObjectStore is an object storage backend with S3 multipart semantics.
+// Every store is bound to a single bucket (or equivalent namespace), and
+// keys are always slash-separated.
+type ObjectStore interface {
+	// CreateMultipartUpload starts a multipart upload and returns its upload ID.
+	// Every part of the upload must then carry a checksum computed with
+	// algorithm, unless it is checksum.None. metadata is stored as user
+	// metadata on the completed object.
+	CreateMultipartUpload(ctx context.Context, key string, algorithm checksum.Algorithm, metadata map[string]string) (string, error)
+	// UploadPart uploads a single part and returns its ETag. If sum is set,
+	// the store rejects the part unless its content matches.
+	UploadPart(ctx context.Context, key, uploadID string, partNumber int, body io.ReadSeeker, size int64, sum Checksum) (string, error)
+	// CompleteMultipartUpload assembles the object from parts sorted by part number.
+	CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []CompletedPart) (CompletedObject, error)
+	// AbortMultipartUpload discards an in-progress multipart upload and its parts.
+	AbortMultipartUpload(ctx context.Context, key, uploadID string) error
+	// ListMultipartUploads lists all in-progress multipart uploads whose
+	// keys start with prefix.
+	ListMultipartUploads(ctx context.Context, prefix string) ([]MultipartUpload, error)
+	// ListParts lists the parts uploaded so far to a multipart upload,
+	// sorted by part number.
+	ListParts(ctx context.Context, key, uploadID string) ([]UploadedPart, error)
+
+	// PutObject uploads an object in a single request, with metadata as its
+	// user metadata.
+	PutObject(ctx context.Context, key string, body io.ReadSeeker, size int64, metadata map[string]string) error
+	// GetObject returns the content of an object. The caller must close it.
+	GetObject(ctx context.Context, key string) (io.ReadCloser, error)
+	// GetObjectRange returns length bytes of an object starting at offset.
+	// If eTag is not empty, the read fails unless the object still has
+	// that ETag. The caller must close the returned reader.
+	GetObjectRange(ctx context.Context, key, eTag string, offset, length int64) (io.ReadCloser, error)
+	// HeadObject returns information about an object without its content.
+	HeadObject(ctx context.Context, key string) (ObjectInfo, error)
+	// GetObjectAttributes returns the ETag, size and checksums of an object.
+	// Parts are only reported for multipart objects whose parts carry
+	// checksums, as S3 does.
+	GetObjectAttributes(ctx context.Context, key string) (ObjectAttributes, error)
+	// DeleteObject deletes an object.
+	DeleteObject(ctx context.Context, key string) error
+	// ListObjects lists all objects whose keys start with prefix.
+	ListObjects(ctx context.Context, prefix string) ([]ObjectInfo, error)
+
+	// Location returns a human readable location of key, used in logs.
+	Location(key string) string
+}
+
+// IsNoSuchUpload reports whether err says that a multipart upload does not
+// exist, because it was completed, aborted or expired.
+func IsNoSuchUpload(err error) bool {
+	var awsErr awserr.Error
+	return errors.As(err, &awsErr) && awsErr.Code() == s3.ErrCodeNoSuchUpload
+}
+
+// UploadMetadataReader is implemented by stores that return the user
+// metadata a multipart upload was created with before it is completed,
+// which S3 does not.
+type UploadMetadataReader interface {
+	UploadMetadata(ctx context.Context, key, uploadID string) (map[string]string, error)
+}
+
+// UploadMetadata returns the user metadata the multipart upload uploadID
+// of key was created with, and whether store can tell.
+func UploadMetadata(ctx context.Context, store ObjectStore, key, uploadID string) (map[string]string, bool, error) {
+	r, ok := store.(UploadMetadataReader)
+	if !ok {
+		return nil, false, nil
+	}
+	metadata, err := r.UploadMetadata(ctx, key, uploadID)
+	return metadata, true, err
+}
+
+// NewFromConfig creates the ObjectStore selected by cfg.StorageBackend.
+func NewFromConfig(cfg *config.Config) (ObjectStore, error) {
+	switch cfg.StorageBackend {
+	case config.BackendS3, "":
+		return NewS3StoreFromConfig(cfg)
+	case config.BackendLocal:
+		return NewLocalStore(cfg.LocalStorageRoot)
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", cfg.StorageBackend)
+	}
+}
@@ -0,0 +1,340 @@
+// Package syncer makes a prefix of the object store mirror a local
+// directory, uploading only the files that are new or changed.
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yucori/Favus/internal/config"
+	"github.com/yucori/Favus/internal/encryption"
+	"github.com/yucori/Favus/internal/storage"
+	"github.com/yucori/Favus/internal/uploader"
+	"github.com/yucori/Favus/internal/verifier"
+	"github.com/yucori/Favus/internal/walker"
+	"github.com/yucori/Favus/pkg/utils"
+)
+
+// CompareMode says how a local file is compared with the object of the
+// same name to decide whether it changed.
+type CompareMode string
+
+// Supported compare modes.
+const (
+	// CompareSize treats files of the same size as unchanged.
+	CompareSize CompareMode = "size"
+	// CompareMtime also requires the modification time recorded in the
+	// object's metadata to match the file's.
+	CompareMtime CompareMode = "mtime"
+	// CompareETag also requires the ETag recomputed from the file to match
+	// the object's. It reads every file of the same size as its object.
+	// Objects encrypted with SSE-KMS or SSE-C, whose ETags cannot be
+	// recomputed, are compared by modification time instead.
+	CompareETag CompareMode = "etag"
+)
+
+// ParseCompareMode parses a compare mode name. An empty name means mtime.
+func ParseCompareMode(name string) (CompareMode, error) {
+	switch strings.ToLower(name) {
+	case "", "mtime":
+		return CompareMtime, nil
+	case "size":
+		return CompareSize, nil
+	case "etag", "checksum":
+		return CompareETag, nil
+	}
+	return "", fmt.Errorf("unsupported compare mode: %s (use size, mtime or etag)", name)
+}
+
+// Options controls a sync.
+type Options struct {
+	walker.Options
+	Compare CompareMode
+	// Delete removes objects under the prefix that have no local file.
+	// Objects filtered out by Include/Exclude are never deleted.
+	Delete bool
+	// DryRun plans the actions without uploading or deleting anything.
+	DryRun bool
+	// Jobs is the number of files compared or uploaded at the same time.
+	Jobs int
+}
+
+// ActionKind is what a sync does with a file or object.
+type ActionKind string
+
+// Sync actions.
+const (
+	ActionUpload ActionKind = "upload" // The file has no object yet
+	ActionUpdate ActionKind = "update" // The file differs from its object
+	ActionDelete ActionKind = "delete" // The object has no local file
+)
+
+// Action is a planned or performed change to the store.
+type Action struct {
+	Kind   ActionKind
+	Path   string // Local file; empty for deletes
+	Key    string
+	Size   int64
+	Reason string
+	Err    error // Why the action failed, nil on success or in a dry run
+}
+
+// Result describes what a sync did, or would do in a dry run.
+type Result struct {
+	Actions   []Action // In key order
+	Unchanged int      // Files whose object is up to date
+	DryRun    bool
+}
+
+// Failed returns the actions that failed.
+func (r *Result) Failed() []Action {
+	var failed []Action
+	for _, a := range r.Actions {
+		if a.Err != nil {
+			failed = append(failed, a)
+		}
+	}
+	return failed
+}
+
+// Count returns the number of actions of kind.
+func (r *Result) Count(kind ActionKind) int {
+	n := 0
+	for _, a := range r.Actions {
+		if a.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// Syncer synchronizes local directories to the object store.
+type Syncer struct {
+	Uploader *uploader.S3Uploader
+	Verifier *verifier.Verifier
+	// Logger receives the syncer's log records. The package logger of
+	// pkg/utils is used when nil.
+	Logger *utils.Logger
+}
+
+func (s *Syncer) logger() *utils.Logger {
+	if s.Logger != nil {
+		return s.Logger
+	}
+	return utils.Default()
+}
+
+// NewSyncer creates a new Syncer that writes to store.
+func NewSyncer(cfg *config.Config, store storage.ObjectStore) *Syncer {
+	return &Syncer{
+		Uploader: uploader.NewUploaderWithStore(cfg, store),
+		Verifier: verifier.NewVerifier(cfg, store),
+	}
+}
+
+// Sync uploads the files under dir selected by opts that are missing or
+// changed under prefix and, if opts.Delete is set, deletes objects under
+// prefix that no longer have a local file.
+//
+// Failed uploads and deletes do not stop the others and are reported in
+// the result. An error is returned if the local tree or the remote prefix
+// could not be listed or compared, in which case nothing is changed. Once
+// ctx is canceled no new upload or delete is started.
+func (s *Syncer) Sync(ctx context.Context, dir, prefix string, opts Options) (*Result, error) {
+	store := s.Uploader.Store
+	listPrefix := uploader.ObjectKey(prefix, "")
+	log := s.logger().With("dir", dir, "location", store.Location(listPrefix))
+	if opts.Logger == nil {
+		opts.Logger = log
+	}
+	files, err := walker.Walk(dir, opts.Options)
+	if err != nil {
+		log.Error("Failed to list directory", "error", err)
+		return nil, fmt.Errorf("failed to list directory: %w", err)
+	}
+	var objects []storage.ObjectInfo
+	err = s.Uploader.Config.Retry.With(utils.RetryPolicy{Logger: log}).Do(ctx, func() error {
+		var err error
+		objects, err = store.ListObjects(ctx, listPrefix)
+		return err
+	})
+	if err != nil {
+		log.Error("Failed to list objects", "error", err)
+		return nil, fmt.Errorf("failed to list objects: %w", err)
+	}
+	remote := make(map[string]storage.ObjectInfo, len(objects))
+	for _, obj := range objects {
+		remote[strings.TrimPrefix(obj.Key, listPrefix)] = obj
+	}
+	log.Info("Comparing local files with objects", "files", len(files), "objects", len(objects), "compare", opts.Compare)
+
+	actions, unchanged, err := s.plan(ctx, files, remote, prefix, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Delete {
+		local := make(map[string]bool, len(files))
+		for _, f := range files {
+			local[f.RelPath] = true
+		}
+		for rel, obj := range remote {
+			// Keys ending in "/" are folder placeholders, not files.
+			if local[rel] || rel == "" || strings.HasSuffix(rel, "/") || !opts.Selects(rel) {
+				continue
+			}
+			actions = append(actions, Action{Kind: ActionDelete, Key: obj.Key, Size: obj.Size, Reason: "no local file"})
+		}
+	}
+	sort.Slice(actions, func(i, j int) bool {
+		return actions[i].Key < actions[j].Key
+	})
+
+	result := &Result{Actions: actions, Unchanged: unchanged, DryRun: opts.DryRun}
+	if opts.DryRun {
+		return result, nil
+	}
+	s.apply(ctx, result.Actions, opts.Jobs)
+	return result, nil
+}
+
+// plan compares every local file with its object and returns the uploads
+// needed and the number of unchanged files.
+func (s *Syncer) plan(ctx context.Context, files []walker.File, remote map[string]storage.ObjectInfo, prefix string, opts Options) ([]Action, int, error) {
+	jobs := opts.Jobs
+	if jobs <= 0 {
+		jobs = uploader.DefaultJobs
+	}
+
+	planned := make([]*Action, len(files))
+	errs := make([]error, len(files))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				planned[i], errs[i] = s.compare(ctx, files[i], remote, prefix, opts.Compare)
+			}
+		}()
+	}
+	for i := range files {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	var actions []Action
+	unchanged := 0
+	for i, a := range planned {
+		if errs[i] != nil {
+			return nil, 0, errs[i]
+		}
+		if a == nil {
+			unchanged++
+			continue
+		}
+		actions = append(actions, *a)
+	}
+	return actions, unchanged, nil
+}
+
+// compare returns the action needed to bring the object of f up to date,
+// or nil if it already is.
+func (s *Syncer) compare(ctx context.Context, f walker.File, remote map[string]storage.ObjectInfo, prefix string, mode CompareMode) (*Action, error) {
+	key := uploader.ObjectKey(prefix, f.RelPath)
+	obj, ok := remote[f.RelPath]
+	if !ok {
+		return &Action{Kind: ActionUpload, Path: f.Path, Key: key, Size: f.Size, Reason: "new file"}, nil
+	}
+	update := func(reason string) (*Action, error) {
+		return &Action{Kind: ActionUpdate, Path: f.Path, Key: key, Size: f.Size, Reason: reason}, nil
+	}
+	// Objects encrypted on the client are larger than the file by the
+	// overhead of encryption.
+	size := f.Size
+	if s.Uploader.Keys != nil {
+		size = encryption.Size(f.Size)
+	}
+	if obj.Size != size {
+		if s.Uploader.Keys != nil && obj.Size == f.Size {
+			return update("not encrypted")
+		}
+		return update(fmt.Sprintf("size differs (local %d, remote %d)", f.Size, obj.Size))
+	}
+	if mode == CompareSize {
+		return nil, nil
+	}
+
+	log := s.logger().With("file", f.Path, "key", key)
+	var info storage.ObjectInfo
+	err := s.Uploader.Config.Retry.With(utils.RetryPolicy{Logger: log}).Do(ctx, func() error {
+		var err error
+		info, err = s.Uploader.Store.HeadObject(ctx, key)
+		return err
+	})
+	if err != nil {
+		log.Error("Failed to get object info", "error", err)
+		return nil, fmt.Errorf("failed to get object info for %s: %w", key, err)
+	}
+	switch keys := s.Uploader.Keys; {
+	case keys == nil && encryption.IsEncrypted(info.Metadata):
+		return update("encrypted")
+	case keys != nil && !encryption.IsEncrypted(info.Metadata):
+		return update("not encrypted")
+	case keys != nil && info.Metadata[storage.MetaEncryptionKeyID] != keys.KeyID():
+		return update("encrypted with another key")
+	}
+	if mode == CompareETag && storage.ETagIsMD5(info.SSE) {
+		matches, err := s.Verifier.ETagMatches(f.Path, info)
+		if err != nil {
+			log.Error("Failed to compute ETag", "error", err)
+			return nil, fmt.Errorf("failed to compute ETag of %s: %w", f.Path, err)
+		}
+		if !matches {
+			return update("ETag differs")
+		}
+		return nil, nil
+	}
+
+	recorded, ok := info.Metadata[storage.MetaMtime]
+	if !ok {
+		return update("no recorded modification time")
+	}
+	mtime, err := time.Parse(time.RFC3339Nano, recorded)
+	if err != nil || !mtime.Equal(f.ModTime) {
+		return update(fmt.Sprintf("modification time differs (local %s, remote %s)", f.ModTime.UTC().Format(time.RFC3339), recorded))
+	}
+	return nil, nil
+}
+
+// apply performs the actions, recording failures in them. Uploads run
+// before deletes, so that an interrupted sync never loses data.
+func (s *Syncer) apply(ctx context.Context, actions []Action, jobs int) {
+	var uploads []uploader.FileUpload
+	var uploadIndexes []int
+	for i, a := range actions {
+		if a.Kind == ActionUpload || a.Kind == ActionUpdate {
+			uploads = append(uploads, uploader.FileUpload{Path: a.Path, Key: a.Key, Size: a.Size})
+			uploadIndexes = append(uploadIndexes, i)
+		}
+	}
+	for j, r := range s.Uploader.UploadFiles(ctx, uploads, jobs) {
+		actions[uploadIndexes[j]].Err = r.Err
+	}
+
+	for i, a := range actions {
+		if a.Kind != ActionDelete {
+			continue
+		}
+		if ctx.Err() != nil {
+			actions[i].Err = fmt.Errorf("not started: %w", ctx.Err())
+			continue
+		}
+		actions[i].Err = s.Uploader.DeleteFile(a.Key)
+	}
+}
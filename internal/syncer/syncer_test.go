@@ -0,0 +1,201 @@
+package syncer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yucori/Favus/internal/s3test"
+	"github.com/yucori/Favus/internal/walker"
+	"github.com/yucori/Favus/pkg/utils"
+)
+
+// newTestSyncer returns a syncer writing to a new fake S3 server, with
+// status files in a temporary directory and retries without waits.
+func newTestSyncer(t *testing.T) (*Syncer, *s3test.Server) {
+	t.Helper()
+	srv := s3test.NewServer("favus-test")
+	t.Cleanup(srv.Close)
+	cfg := srv.Config()
+	cfg.StateDir = t.TempDir()
+	cfg.Retry = utils.RetryPolicy{InitialInterval: time.Millisecond, MaxInterval: time.Millisecond}
+	return NewSyncer(cfg, srv.Store()), srv
+}
+
+// writeFiles creates the files under dir with the given content, keyed by
+// slash-separated relative path.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for rel, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(rel))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// actions returns the kinds and keys of the actions of result.
+func actions(result *Result) string {
+	var s []string
+	for _, a := range result.Actions {
+		s = append(s, fmt.Sprintf("%s %s", a.Kind, a.Key))
+	}
+	return fmt.Sprint(s)
+}
+
+// runSync syncs dir to the prefix backup and fails the test if the sync or
+// any of its actions fails.
+func runSync(t *testing.T, s *Syncer, dir string, opts Options) *Result {
+	t.Helper()
+	result, err := s.Sync(context.Background(), dir, "backup", opts)
+	if err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	for _, a := range result.Failed() {
+		t.Errorf("%s %s failed: %v", a.Kind, a.Key, a.Err)
+	}
+	return result
+}
+
+func TestSync(t *testing.T) {
+	s, srv := newTestSyncer(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "alpha", "sub/b.txt": "beta"})
+
+	result := runSync(t, s, dir, Options{})
+	if got, want := actions(result), "[upload backup/a.txt upload backup/sub/b.txt]"; got != want {
+		t.Errorf("actions %s, want %s", got, want)
+	}
+	for key, content := range map[string]string{"backup/a.txt": "alpha", "backup/sub/b.txt": "beta"} {
+		if obj, ok := srv.Object(key); !ok || string(obj.Data) != content {
+			t.Errorf("object %s holds %q, want %q", key, obj.Data, content)
+		}
+	}
+
+	// Nothing changed since.
+	result = runSync(t, s, dir, Options{})
+	if len(result.Actions) != 0 || result.Unchanged != 2 {
+		t.Errorf("second sync: actions %s, %d unchanged", actions(result), result.Unchanged)
+	}
+}
+
+func TestSyncCompareModes(t *testing.T) {
+	tests := []struct {
+		mode CompareMode
+		want string
+	}{
+		{CompareSize, "[update backup/grown.txt]"},
+		{CompareMtime, "[update backup/grown.txt update backup/touched.txt]"},
+		{CompareETag, "[update backup/edited.txt update backup/grown.txt]"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.mode), func(t *testing.T) {
+			s, _ := newTestSyncer(t)
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"edited.txt": "one", "touched.txt": "two", "grown.txt": "three"})
+			runSync(t, s, dir, Options{})
+
+			// Same size and modification time, other content.
+			edited := filepath.Join(dir, "edited.txt")
+			fi, err := os.Stat(edited)
+			if err != nil {
+				t.Fatal(err)
+			}
+			writeFiles(t, dir, map[string]string{"edited.txt": "ONE", "grown.txt": "three!"})
+			if err := os.Chtimes(edited, fi.ModTime(), fi.ModTime()); err != nil {
+				t.Fatal(err)
+			}
+			// Same content, other modification time.
+			later := fi.ModTime().Add(time.Hour)
+			if err := os.Chtimes(filepath.Join(dir, "touched.txt"), later, later); err != nil {
+				t.Fatal(err)
+			}
+
+			result := runSync(t, s, dir, Options{Compare: tt.mode})
+			if got := actions(result); got != tt.want {
+				t.Errorf("actions %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyncDelete(t *testing.T) {
+	tests := []struct {
+		name    string
+		options walker.Options
+		want    string
+	}{
+		{"all", walker.Options{}, "[delete backup/gone.log delete backup/gone.txt delete backup/old/gone.txt]"},
+		// Objects filtered out are not deleted.
+		{"exclude", walker.Options{Exclude: []string{"*.log"}}, "[delete backup/gone.txt delete backup/old/gone.txt]"},
+		{"include", walker.Options{Include: []string{"*.log"}}, "[delete backup/gone.log]"},
+		{"excluded directory", walker.Options{Exclude: []string{"old"}}, "[delete backup/gone.log delete backup/gone.txt]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, srv := newTestSyncer(t)
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"kept.txt": "kept"})
+			runSync(t, s, dir, Options{})
+			for _, key := range []string{"backup/gone.txt", "backup/gone.log", "backup/old/gone.txt", "backup/folder/"} {
+				srv.PutObject(key, nil)
+			}
+
+			result := runSync(t, s, dir, Options{Options: tt.options, Delete: true})
+			if got := actions(result); got != tt.want {
+				t.Errorf("actions %s, want %s", got, tt.want)
+			}
+			for _, a := range result.Actions {
+				if _, ok := srv.Object(a.Key); ok {
+					t.Errorf("%s was not deleted", a.Key)
+				}
+			}
+			// Folder placeholders have no local file but are kept.
+			for _, key := range []string{"backup/kept.txt", "backup/folder/"} {
+				if _, ok := srv.Object(key); !ok {
+					t.Errorf("%s was deleted", key)
+				}
+			}
+		})
+	}
+}
+
+func TestSyncDryRun(t *testing.T) {
+	s, srv := newTestSyncer(t)
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"changed.txt": "one"})
+	runSync(t, s, dir, Options{})
+	srv.PutObject("backup/gone.txt", []byte("gone"))
+	writeFiles(t, dir, map[string]string{"changed.txt": "changed", "new.txt": "new"})
+	before := len(srv.Requests())
+
+	result := runSync(t, s, dir, Options{Delete: true, DryRun: true})
+	if !result.DryRun {
+		t.Error("result is not marked as a dry run")
+	}
+	if got, want := actions(result), "[update backup/changed.txt delete backup/gone.txt upload backup/new.txt]"; got != want {
+		t.Errorf("actions %s, want %s", got, want)
+	}
+	for _, r := range srv.Requests()[before:] {
+		switch r.Operation {
+		case "ListObjectsV2", "HeadObject", "GetObject", "GetObjectAttributes":
+		default:
+			t.Errorf("dry run sent %s %s", r.Operation, r.Key)
+		}
+	}
+	if obj, ok := srv.Object("backup/changed.txt"); !ok || string(obj.Data) != "one" {
+		t.Errorf("backup/changed.txt holds %q, want the old content", obj.Data)
+	}
+	if _, ok := srv.Object("backup/new.txt"); ok {
+		t.Error("backup/new.txt was uploaded")
+	}
+	if _, ok := srv.Object("backup/gone.txt"); !ok {
+		t.Error("backup/gone.txt was deleted")
+	}
+}
@@ -0,0 +1,212 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yucori/Favus/internal/chunker"
+	"github.com/yucori/Favus/internal/encryption"
+	"github.com/yucori/Favus/internal/progress"
+	"github.com/yucori/Favus/internal/walker"
+	"github.com/yucori/Favus/pkg/utils"
+)
+
+// DefaultJobs is the number of files a directory upload sends at a time.
+const DefaultJobs = 4
+
+// DirOptions controls a directory upload.
+type DirOptions struct {
+	walker.Options
+	// Jobs is the number of files uploaded at the same time. Each multipart
+	// upload additionally sends up to Config.Concurrency parts in parallel.
+	Jobs int
+}
+
+// FileUpload is a file to upload with UploadFiles.
+type FileUpload struct {
+	Path string
+	Key  string
+	Size int64
+}
+
+// FileResult is the outcome of uploading one file.
+type FileResult struct {
+	Path      string
+	Key       string
+	Size      int64
+	Multipart bool  // Whether the file was sent as a multipart upload
+	Err       error // Why the upload failed, nil on success
+}
+
+// DirResult summarizes a directory upload.
+type DirResult struct {
+	Uploaded []FileResult
+	Failed   []FileResult
+	Bytes    int64 // Total size of the uploaded files
+}
+
+// ObjectKey maps the slash-separated relPath of a file to its key under
+// prefix.
+func ObjectKey(prefix, relPath string) string {
+	if prefix == "" {
+		return relPath
+	}
+	return strings.TrimSuffix(prefix, "/") + "/" + relPath
+}
+
+// UploadDir uploads the files under dir selected by opts to keys under
+// prefix, keeping their relative paths. Files smaller than the chunk size
+// are sent in a single request, larger ones as multipart uploads.
+//
+// A failed file does not stop the others; failures are reported in the
+// result. An error is returned only if the directory could not be listed.
+// Once ctx is canceled no new file is started; see UploadFiles.
+func (u *S3Uploader) UploadDir(ctx context.Context, dir, prefix string, opts DirOptions) (*DirResult, error) {
+	if opts.Logger == nil {
+		opts.Logger = u.logger()
+	}
+	files, err := walker.Walk(dir, opts.Options)
+	if err != nil {
+		u.logger().Error("Failed to list directory", "dir", dir, "error", err)
+		return nil, fmt.Errorf("failed to list directory: %w", err)
+	}
+	u.logger().Info("Uploading directory", "dir", dir, "files", len(files), "location", u.Store.Location(prefix))
+
+	uploads := make([]FileUpload, 0, len(files))
+	for _, f := range files {
+		uploads = append(uploads, FileUpload{Path: f.Path, Key: ObjectKey(prefix, f.RelPath), Size: f.Size})
+	}
+	summary := &DirResult{}
+	for _, r := range u.UploadFiles(ctx, uploads, opts.Jobs) {
+		if r.Err != nil {
+			summary.Failed = append(summary.Failed, r)
+			continue
+		}
+		summary.Uploaded = append(summary.Uploaded, r)
+		summary.Bytes += r.Size
+	}
+	return summary, nil
+}
+
+// UploadFiles uploads files using at most jobs uploads at a time and
+// returns the outcome of each, in the order given. Small files are sent in
+// a single request, larger ones as multipart uploads.
+//
+// Once ctx is canceled no new file is started, and files not started fail
+// with ctx's error. Multipart uploads in flight fail with *InterruptedError.
+func (u *S3Uploader) UploadFiles(ctx context.Context, files []FileUpload, jobs int) []FileResult {
+	if jobs <= 0 {
+		jobs = DefaultJobs
+	}
+	results := make([]FileResult, len(files))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				f := files[i]
+				r := FileResult{Path: f.Path, Key: f.Key, Size: f.Size}
+				r.Multipart, r.Err = u.uploadAny(ctx, f.Path, f.Key, f.Size)
+				results[i] = r
+			}
+		}()
+	}
+	for i, f := range files {
+		if ctx.Err() == nil {
+			select {
+			case indexes <- i:
+				continue
+			case <-ctx.Done():
+			}
+		}
+		results[i] = FileResult{Path: f.Path, Key: f.Key, Size: f.Size, Err: fmt.Errorf("not started: %w", ctx.Err())}
+	}
+	close(indexes)
+	wg.Wait()
+	return results
+}
+
+// uploadAny uploads the file at filePath in a single request if it is
+// smaller than the chunk size, or as a multipart upload otherwise. It
+// reports whether the multipart path was used.
+func (u *S3Uploader) uploadAny(ctx context.Context, filePath, s3Key string, size int64) (bool, error) {
+	// A single request cannot send more than the largest part.
+	sent := size
+	if u.Keys != nil {
+		sent = encryption.Size(size)
+	}
+	if size < u.Config.ChunkSize && sent <= chunker.MaxPartSize {
+		return false, u.PutFile(ctx, filePath, s3Key)
+	}
+	_, err := u.UploadFile(ctx, filePath, s3Key)
+	return true, err
+}
+
+// PutFile uploads a file to the object store in a single request. It is
+// meant for files too small to benefit from a multipart upload. The file is
+// encrypted if Keys is set.
+//
+// No new attempt is made once ctx is canceled; an attempt in flight gets
+// the shutdown grace period to finish.
+func (u *S3Uploader) PutFile(ctx context.Context, filePath, s3Key string) error {
+	start := time.Now()
+	log := u.logger().With("file", filePath, "key", s3Key)
+	file, err := os.Open(filePath)
+	if err != nil {
+		log.Error("Failed to open file", "error", err)
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		log.Error("Failed to get file info", "error", err)
+		return fmt.Errorf("failed to get file info: %w", err)
+	}
+
+	var content io.ReadSeeker = file
+	size, metadata := fileInfo.Size(), objectMetadata(fileInfo)
+	if u.Keys != nil {
+		key, err := encryption.NewDataKey(u.Keys)
+		if err != nil {
+			log.Error("Failed to create data key", "error", err)
+			return err
+		}
+		enc, err := key.NewEncrypter(file, 0, size, true)
+		if err != nil {
+			return err
+		}
+		content, size = enc, enc.Size()
+		maps.Copy(metadata, key.Metadata())
+	}
+
+	log.Info("Uploading file", "location", u.Store.Location(s3Key), "bytes", size)
+	putCtx, cancel := graceContext(ctx, u.Config.ShutdownGracePeriod)
+	defer cancel()
+	tracker := progress.NewTracker(s3Key, size, 1, u.Progress)
+	body := tracker.Reader(content)
+	tracker.Start()
+	tracker.PartStarted()
+	err = u.Config.Retry.With(utils.RetryPolicy{Logger: log}).Do(ctx, func() error {
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		return u.Store.PutObject(putCtx, s3Key, body, size, metadata)
+	})
+	tracker.PartFinished(err == nil)
+	tracker.Stop(err)
+	if err != nil {
+		log.Error("Failed to upload file", "error", err)
+		return fmt.Errorf("failed to upload %s: %w", filePath, err)
+	}
+	log.Info("Uploaded file", "bytes", size, "duration", time.Since(start))
+	return nil
+}
@@ -0,0 +1,123 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yucori/Favus/internal/config"
+	"github.com/yucori/Favus/internal/downloader"
+	"github.com/yucori/Favus/internal/encryption"
+	"github.com/yucori/Favus/internal/s3test"
+	"github.com/yucori/Favus/internal/storage"
+)
+
+// checkDecrypted fails the test unless the object under key, downloaded
+// and decrypted with keys, is want.
+func checkDecrypted(t *testing.T, srv *s3test.Server, cfg *config.Config, keys encryption.KeyProvider, key string, want []byte) {
+	t.Helper()
+	obj, ok := srv.Object(key)
+	if !ok {
+		t.Fatalf("object %s was not stored", key)
+	}
+	if bytes.Contains(obj.Data, want[:1024]) {
+		t.Errorf("object %s holds the plaintext", key)
+	}
+	if size := encryption.Size(int64(len(want))); int64(len(obj.Data)) != size {
+		t.Errorf("object %s has %d bytes, want the %d of the ciphertext", key, len(obj.Data), size)
+	}
+
+	d := downloader.NewDownloader(cfg, srv.Store())
+	d.Keys = keys
+	path := filepath.Join(t.TempDir(), "download.bin")
+	if err := d.DownloadFile(context.Background(), key, path); err != nil {
+		t.Fatalf("DownloadFile: %v", err)
+	}
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("decrypted object %s differs from the file", key)
+	}
+}
+
+func TestUploadFileEncrypted(t *testing.T) {
+	srv := newTestServer(t)
+	cfg := testConfig(t, srv)
+	path, data := writeTestFile(t, 3*partSize5MiB+99)
+	u := newTestUploader(t, cfg)
+	u.Keys = newTestKeys(t)
+
+	if _, err := u.UploadFile(context.Background(), path, "data.bin"); err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	checkDecrypted(t, srv, cfg, u.Keys, "data.bin", data)
+
+	// The object cannot be downloaded without its master key.
+	d := downloader.NewDownloader(cfg, srv.Store())
+	if err := d.DownloadFile(context.Background(), "data.bin", filepath.Join(t.TempDir(), "download.bin")); err == nil {
+		t.Error("DownloadFile succeeded without the master key")
+	}
+}
+
+func TestResumeUploadEncrypted(t *testing.T) {
+	srv := newTestServer(t)
+	cfg := testConfig(t, srv)
+	cfg.Concurrency = 1
+	path, data := writeTestFile(t, 4*partSize5MiB)
+	u := newTestUploader(t, cfg)
+	u.Keys = newTestKeys(t)
+	statusFilePath := interruptAfter(t, u, path, "data.bin", 2)
+	sent := srv.CountRequests("UploadPart", 0)
+
+	ru := newTestResumeUploader(u)
+	for _, keys := range []encryption.KeyProvider{nil, newTestKeys(t)} {
+		ru.Keys = keys
+		if _, err := ru.ResumeUpload(context.Background(), statusFilePath); err == nil {
+			t.Fatalf("ResumeUpload succeeded without the master key of the upload (Keys set %v)", keys != nil)
+		}
+	}
+	if n := srv.CountRequests("UploadPart", 0); n != sent {
+		t.Errorf("%d parts were sent without the master key", n-sent)
+	}
+
+	ru.Keys = u.Keys
+	if _, err := ru.ResumeUpload(context.Background(), statusFilePath); err != nil {
+		t.Fatalf("ResumeUpload: %v", err)
+	}
+	checkDecrypted(t, srv, cfg, u.Keys, "data.bin", data)
+}
+
+func TestResumeUploadEncryptedRestart(t *testing.T) {
+	srv := newTestServer(t)
+	cfg := testConfig(t, srv)
+	cfg.Concurrency = 1
+	path, data := writeTestFile(t, 3*partSize5MiB)
+	u := newTestUploader(t, cfg)
+	u.Keys = newTestKeys(t)
+	statusFilePath := interruptAfter(t, u, path, "data.bin", 1)
+	status, err := LoadStatus(statusFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Store().AbortMultipartUpload(context.Background(), "data.bin", status.UploadID); err != nil {
+		t.Fatal(err)
+	}
+
+	ru := newTestResumeUploader(u)
+	ru.Keys = u.Keys
+	ru.Restart = true
+	if _, err := ru.ResumeUpload(context.Background(), statusFilePath); err != nil {
+		t.Fatalf("ResumeUpload: %v", err)
+	}
+	checkDecrypted(t, srv, cfg, u.Keys, "data.bin", data)
+	// The file is encrypted again under a new data key, not under the one
+	// of the lost upload.
+	obj, _ := srv.Object("data.bin")
+	if wrapped := obj.Metadata[storage.MetaEncryptionKey]; wrapped == "" || wrapped == status.Encryption[storage.MetaEncryptionKey] {
+		t.Errorf("restarted upload is encrypted under data key %q, want a new one", wrapped)
+	}
+}
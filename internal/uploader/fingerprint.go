@@ -0,0 +1,59 @@
+package uploader
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+)
+
+// fingerprintSampleSize is the number of bytes hashed from each sampled
+// region of a file.
+const fingerprintSampleSize = 1024 * 1024 // 1 MB
+
+// fingerprintSamples is the number of evenly spaced regions hashed.
+const fingerprintSamples = 8
+
+// FileFingerprint returns a content fingerprint of the file at filePath.
+//
+// Hashing a multi-gigabyte file in full before every resume would take as
+// long as uploading it, so the fingerprint is a SHA-256 over the file size
+// and a fixed number of evenly spaced samples, including the first and last
+// bytes of the file. Files up to fingerprintSamples*fingerprintSampleSize
+// bytes are hashed in full.
+func FileFingerprint(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file for fingerprint: %w", err)
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return "", fmt.Errorf("failed to get file info for fingerprint: %w", err)
+	}
+	size := fileInfo.Size()
+
+	h := sha256.New()
+	var sizeBuf [8]byte
+	binary.BigEndian.PutUint64(sizeBuf[:], uint64(size))
+	h.Write(sizeBuf[:])
+
+	if size <= fingerprintSamples*fingerprintSampleSize {
+		if _, err := io.Copy(h, file); err != nil {
+			return "", fmt.Errorf("failed to read file for fingerprint: %w", err)
+		}
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+
+	stride := (size - fingerprintSampleSize) / (fingerprintSamples - 1)
+	for i := int64(0); i < fingerprintSamples; i++ {
+		section := io.NewSectionReader(file, i*stride, fingerprintSampleSize)
+		if _, err := io.Copy(h, section); err != nil {
+			return "", fmt.Errorf("failed to read file for fingerprint: %w", err)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
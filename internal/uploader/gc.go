@@ -0,0 +1,211 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/yucori/Favus/internal/storage"
+	"github.com/yucori/Favus/pkg/utils"
+)
+
+// GCOptions selects the multipart uploads aborted by GCUploads.
+type GCOptions struct {
+	// OlderThan is the minimum age of an upload, measured from when it was
+	// initiated.
+	OlderThan time.Duration
+	// Prefix restricts the uploads to keys starting with it.
+	Prefix string
+	// Initiator, if set, restricts the uploads to those started by this
+	// principal, matched against its ID or display name.
+	Initiator string
+	// DryRun only reports the uploads that would be aborted.
+	DryRun bool
+	// Jobs is the number of uploads inspected and aborted at the same time.
+	Jobs int
+}
+
+// StaleUpload is a multipart upload selected by GCUploads.
+type StaleUpload struct {
+	storage.MultipartUpload
+	Age   time.Duration
+	Parts int   // Number of uploaded parts
+	Size  int64 // Total size of the uploaded parts, reclaimed by aborting
+	Err   error // Why the upload could not be aborted, nil on success
+	// StatusFilePath is the status file tracking the upload in the state
+	// directory, removed along with the upload, or empty if there is none.
+	StatusFilePath string
+}
+
+// GCResult summarizes a cleanup of stale multipart uploads.
+type GCResult struct {
+	DryRun bool
+	Stale  []StaleUpload // Uploads matching the options, aborted unless DryRun
+	Kept   int           // Uploads that did not match or are in progress
+	Active int           // Of the kept uploads, those a running process is working on
+}
+
+// Reclaimed returns the storage freed by the aborted uploads, or that would
+// be freed in a dry run.
+func (r *GCResult) Reclaimed() int64 {
+	var n int64
+	for _, s := range r.Stale {
+		if s.Err == nil {
+			n += s.Size
+		}
+	}
+	return n
+}
+
+// Failed returns the uploads that could not be aborted.
+func (r *GCResult) Failed() []StaleUpload {
+	var failed []StaleUpload
+	for _, s := range r.Stale {
+		if s.Err != nil {
+			failed = append(failed, s)
+		}
+	}
+	return failed
+}
+
+// GCUploads aborts the in-progress multipart uploads selected by opts, so
+// that the storage held by their parts is freed. Every page of uploads is
+// listed, and the parts of every selected upload are listed before it is
+// aborted to measure the storage reclaimed.
+//
+// Uploads that a running upload or resume holds the lock of in the state
+// directory are kept, however old, and the status files of the uploads
+// aborted are removed.
+//
+// An upload that cannot be inspected or aborted does not stop the others;
+// failures are reported in the result. An error is returned only if the
+// uploads could not be listed. Once ctx is canceled no new upload is
+// started, and those not started fail with ctx's error.
+func (u *S3Uploader) GCUploads(ctx context.Context, opts GCOptions) (*GCResult, error) {
+	log := u.logger().With("location", u.Store.Location(opts.Prefix))
+	log.Info("Listing multipart uploads", "older_than", opts.OlderThan, "initiator", opts.Initiator)
+	var uploads []storage.MultipartUpload
+	err := u.Config.Retry.With(utils.RetryPolicy{Logger: log}).Do(ctx, func() error {
+		var err error
+		uploads, err = u.Store.ListMultipartUploads(ctx, opts.Prefix)
+		return err
+	})
+	if err != nil {
+		log.Error("Failed to list multipart uploads", "error", err)
+		return nil, fmt.Errorf("failed to list multipart uploads: %w", err)
+	}
+
+	tracked, err := ListTrackedUploads(u.Config.StateDir)
+	if err != nil {
+		log.Error("Failed to list tracked uploads", "error", err)
+		return nil, err
+	}
+	statuses := make(map[string]TrackedUpload, len(tracked))
+	for _, t := range tracked {
+		if t.Status != nil {
+			statuses[t.Status.Key+"\x00"+t.Status.UploadID] = t
+		}
+	}
+
+	now := time.Now()
+	result := &GCResult{DryRun: opts.DryRun}
+	for _, upload := range uploads {
+		age := now.Sub(upload.Initiated)
+		if age < opts.OlderThan || (opts.Initiator != "" && opts.Initiator != upload.InitiatorID && opts.Initiator != upload.InitiatorName) {
+			result.Kept++
+			continue
+		}
+		t := statuses[upload.Key+"\x00"+upload.UploadID]
+		if t.Active {
+			log.Info("Keeping multipart upload in progress", "key", upload.Key, "upload_id", upload.UploadID, "status_file", t.StatusFilePath)
+			result.Kept++
+			result.Active++
+			continue
+		}
+		result.Stale = append(result.Stale, StaleUpload{MultipartUpload: upload, Age: age, StatusFilePath: t.StatusFilePath})
+	}
+	log.Info("Found stale multipart uploads", "stale", len(result.Stale), "kept", result.Kept, "active", result.Active)
+
+	jobs := opts.Jobs
+	if jobs <= 0 {
+		jobs = DefaultJobs
+	}
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < jobs; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				u.collectUpload(ctx, &result.Stale[i], opts.DryRun)
+			}
+		}()
+	}
+	for i := range result.Stale {
+		if ctx.Err() == nil {
+			select {
+			case indexes <- i:
+				continue
+			case <-ctx.Done():
+			}
+		}
+		result.Stale[i].Err = fmt.Errorf("not started: %w", ctx.Err())
+	}
+	close(indexes)
+	wg.Wait()
+	return result, nil
+}
+
+// collectUpload measures the parts of a stale upload and, unless dryRun,
+// aborts it, recording the outcome in s.
+func (u *S3Uploader) collectUpload(ctx context.Context, s *StaleUpload, dryRun bool) {
+	log := u.logger().With("key", s.Key, "upload_id", s.UploadID)
+	retry := u.Config.Retry.With(utils.RetryPolicy{Logger: log}).With(noSuchUploadRetry)
+
+	var parts []storage.UploadedPart
+	err := retry.Do(ctx, func() error {
+		var err error
+		parts, err = u.Store.ListParts(ctx, s.Key, s.UploadID)
+		return err
+	})
+	if err != nil {
+		log.Error("Failed to list parts", "error", err)
+		s.Err = fmt.Errorf("failed to list parts: %w", err)
+		return
+	}
+	s.Parts = len(parts)
+	for _, p := range parts {
+		s.Size += p.Size
+	}
+	if dryRun {
+		log.Info("Would abort multipart upload", "initiated", s.Initiated, "parts", s.Parts, "bytes", s.Size)
+		return
+	}
+
+	// Hold the lock of the upload while aborting it, so that a resume
+	// started meanwhile is not pulled from under it.
+	var lock *statusLock
+	if s.StatusFilePath != "" {
+		if lock, err = lockStatus(s.StatusFilePath); err != nil {
+			log.Error("Cannot lock upload status", "status_file", s.StatusFilePath, "error", err)
+			s.Err = fmt.Errorf("cannot lock upload status: %w", err)
+			return
+		}
+		defer lock.unlock()
+	}
+	err = retry.Do(ctx, func() error {
+		return u.Store.AbortMultipartUpload(ctx, s.Key, s.UploadID)
+	})
+	if err != nil {
+		log.Error("Failed to abort multipart upload", "error", err)
+		s.Err = fmt.Errorf("failed to abort multipart upload: %w", err)
+		return
+	}
+	log.Info("Aborted multipart upload", "initiated", s.Initiated, "parts", s.Parts, "bytes", s.Size)
+	if lock != nil {
+		if err := removeStatus(s.StatusFilePath, lock); err != nil {
+			log.Error("Failed to remove status file", "status_file", s.StatusFilePath, "error", err)
+		}
+	}
+}
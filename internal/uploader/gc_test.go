@@ -0,0 +1,181 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/yucori/Favus/internal/checksum"
+	"github.com/yucori/Favus/internal/config"
+	"github.com/yucori/Favus/internal/s3test"
+)
+
+// uploadKeys returns the keys of uploads, sorted.
+func uploadKeys(uploads []s3test.Upload) []string {
+	var keys []string
+	for _, u := range uploads {
+		keys = append(keys, u.Key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// staleKeys returns the keys of the stale uploads in result, sorted.
+func staleKeys(result *GCResult) []string {
+	var keys []string
+	for _, s := range result.Stale {
+		keys = append(keys, s.Key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// trackUpload saves a status file for an upload of key, as an interrupted
+// upload would have left it, and returns its path.
+func trackUpload(t *testing.T, cfg *config.Config, key, uploadID string) string {
+	t.Helper()
+	path := StatusFilePath(cfg.StateDir, cfg.S3BucketName, key, uploadID)
+	status := NewUploadStatus("/data/"+key, cfg.S3BucketName, key, uploadID, partSize5MiB, 2, checksum.None)
+	if err := status.SaveStatus(path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestGCUploads(t *testing.T) {
+	old := time.Now().Add(-48 * time.Hour)
+	tests := []struct {
+		name  string
+		opts  GCOptions
+		stale []string
+		kept  int
+	}{
+		{"all", GCOptions{}, []string{"new/c", "old/a", "old/b"}, 0},
+		{"older than", GCOptions{OlderThan: 24 * time.Hour}, []string{"old/a", "old/b"}, 1},
+		// Uploads outside the prefix are not listed at all.
+		{"prefix", GCOptions{Prefix: "new/"}, []string{"new/c"}, 0},
+		{"initiator", GCOptions{OlderThan: 24 * time.Hour, Initiator: "alice"}, []string{"old/a"}, 2},
+		{"no match", GCOptions{Initiator: "carol"}, nil, 3},
+		{"dry run", GCOptions{DryRun: true}, []string{"new/c", "old/a", "old/b"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t)
+			// Every upload is listed on a page of its own.
+			srv.PageSize = 1
+			cfg := testConfig(t, srv)
+			srv.StartUpload("old/a", "alice", old, []byte("abc"), []byte("defg"))
+			srv.StartUpload("old/b", "bob", old)
+			srv.StartUpload("new/c", "alice", time.Now())
+
+			result, err := newTestUploader(t, cfg).GCUploads(context.Background(), tt.opts)
+			if err != nil {
+				t.Fatalf("GCUploads: %v", err)
+			}
+			if got := staleKeys(result); fmt.Sprint(got) != fmt.Sprint(tt.stale) {
+				t.Errorf("stale uploads %v, want %v", got, tt.stale)
+			}
+			if result.Kept != tt.kept {
+				t.Errorf("kept %d uploads, want %d", result.Kept, tt.kept)
+			}
+			if failed := result.Failed(); len(failed) != 0 {
+				t.Errorf("failed to abort %d uploads: %v", len(failed), failed[0].Err)
+			}
+			var reclaimed int64
+			for _, s := range result.Stale {
+				if s.Key == "old/a" {
+					reclaimed = 7
+					if s.Parts != 2 || s.Size != 7 || s.Age < 48*time.Hour {
+						t.Errorf("old/a has %d parts of %d bytes, %v old", s.Parts, s.Size, s.Age)
+					}
+				}
+			}
+			if result.Reclaimed() != reclaimed {
+				t.Errorf("reclaimed %d bytes, want %d", result.Reclaimed(), reclaimed)
+			}
+
+			want := map[string]bool{"new/c": true, "old/a": true, "old/b": true}
+			if !tt.opts.DryRun {
+				for _, key := range tt.stale {
+					delete(want, key)
+				}
+			}
+			var remaining []string
+			for key := range want {
+				remaining = append(remaining, key)
+			}
+			sort.Strings(remaining)
+			if got := uploadKeys(srv.Uploads()); fmt.Sprint(got) != fmt.Sprint(remaining) {
+				t.Errorf("uploads left %v, want %v", got, remaining)
+			}
+			if tt.opts.DryRun && srv.CountRequests("AbortMultipartUpload", 0) != 0 {
+				t.Error("dry run aborted uploads")
+			}
+		})
+	}
+}
+
+func TestGCUploadsPagination(t *testing.T) {
+	srv := newTestServer(t)
+	srv.PageSize = 2
+	cfg := testConfig(t, srv)
+	for i := 0; i < 5; i++ {
+		srv.StartUpload(fmt.Sprintf("key-%d", i), "s3test", time.Now())
+	}
+
+	result, err := newTestUploader(t, cfg).GCUploads(context.Background(), GCOptions{})
+	if err != nil {
+		t.Fatalf("GCUploads: %v", err)
+	}
+	if len(result.Stale) != 5 {
+		t.Errorf("found %d stale uploads, want 5", len(result.Stale))
+	}
+	if n := srv.CountRequests("ListMultipartUploads", 0); n != 3 {
+		t.Errorf("listed %d pages of uploads, want 3", n)
+	}
+	if uploads := srv.Uploads(); len(uploads) != 0 {
+		t.Errorf("%d uploads left", len(uploads))
+	}
+}
+
+func TestGCUploadsStatusFiles(t *testing.T) {
+	srv := newTestServer(t)
+	cfg := testConfig(t, srv)
+	old := time.Now().Add(-48 * time.Hour)
+	lockedID := srv.StartUpload("locked.bin", "s3test", old)
+	staleID := srv.StartUpload("stale.bin", "s3test", old)
+	lockedPath := trackUpload(t, cfg, "locked.bin", lockedID)
+	stalePath := trackUpload(t, cfg, "stale.bin", staleID)
+
+	// Stand in for another process resuming the upload.
+	lock, err := lockStatus(lockedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lock.unlock()
+
+	result, err := newTestUploader(t, cfg).GCUploads(context.Background(), GCOptions{OlderThan: time.Hour})
+	if err != nil {
+		t.Fatalf("GCUploads: %v", err)
+	}
+	if len(result.Stale) != 1 || result.Stale[0].Key != "stale.bin" || result.Stale[0].StatusFilePath != stalePath {
+		t.Fatalf("stale uploads %+v, want stale.bin tracked by %s", result.Stale, stalePath)
+	}
+	if result.Kept != 1 || result.Active != 1 {
+		t.Errorf("kept %d uploads, %d active, want the locked one", result.Kept, result.Active)
+	}
+	if got := uploadKeys(srv.Uploads()); fmt.Sprint(got) != "[locked.bin]" {
+		t.Errorf("uploads left %v, want the locked one", got)
+	}
+	for _, path := range []string{stalePath, lockPath(stalePath)} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s of the aborted upload was not removed: %v", path, err)
+		}
+	}
+	if _, err := os.Stat(lockedPath); err != nil {
+		t.Errorf("status file of the locked upload: %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/yucori/Favus/internal/config"
+)
+
+// InterruptedError is returned when an upload is stopped by its context
+// before it completed. The multipart upload is left in place and its
+// progress saved, so that it can be continued with `favus resume`.
+type InterruptedError struct {
+	StatusFilePath string // Status file to pass to `favus resume`
+	Err            error  // Why the upload stopped, usually context.Canceled
+}
+
+func (e *InterruptedError) Error() string {
+	return fmt.Sprintf("upload interrupted (%v); resume it from %s", e.Err, e.StatusFilePath)
+}
+
+func (e *InterruptedError) Unwrap() error {
+	return e.Err
+}
+
+// graceContext returns a context for requests already in flight when ctx
+// is canceled. It is canceled grace after ctx is, giving those requests
+// time to finish instead of cutting them off immediately.
+func graceContext(ctx context.Context, grace time.Duration) (context.Context, context.CancelFunc) {
+	if grace <= 0 {
+		grace = config.DefaultShutdownGracePeriod
+	}
+	graceCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stop := context.AfterFunc(ctx, func() {
+		select {
+		case <-time.After(grace):
+			cancel()
+		case <-graceCtx.Done():
+		}
+	})
+	return graceCtx, func() {
+		stop()
+		cancel()
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !unix
+
+package uploader
+
+import "os"
+
+// lockFile does nothing where flock(2) is not available: uploads are not
+// protected against being resumed by two processes at once.
+func lockFile(f *os.File) error {
+	return nil
+}
+
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,243 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/yucori/Favus/internal/checksum"
+	"github.com/yucori/Favus/internal/chunker"
+	"github.com/yucori/Favus/internal/encryption"
+	"github.com/yucori/Favus/internal/etag"
+	"github.com/yucori/Favus/internal/progress"
+	"github.com/yucori/Favus/internal/storage"
+	"github.com/yucori/Favus/pkg/utils"
+)
+
+// partUploader uploads the chunks of a single multipart upload and records
+// every completed part in the shared UploadStatus.
+type partUploader struct {
+	store          storage.ObjectStore
+	chunker        *chunker.FileChunker
+	status         *UploadStatus
+	statusFilePath string
+	retry          utils.RetryPolicy
+	log            *utils.Logger // With the file, key and upload ID
+	progress       *progress.Tracker
+	key            *encryption.DataKey // Encrypts the parts, unless nil
+}
+
+// partBody returns the content of chunk ch of the file as sent to the
+// store, and its size: the chunk itself, or its ciphertext if key is set.
+// The caller must close it.
+func partBody(ctx context.Context, fileChunker *chunker.FileChunker, ch chunker.Chunk, key *encryption.DataKey) (io.ReadSeekCloser, int64, error) {
+	reader, err := fileChunker.GetChunkReaderContext(ctx, ch)
+	if err != nil {
+		return nil, 0, err
+	}
+	if key == nil {
+		return reader, ch.Size, nil
+	}
+	enc, err := key.NewEncrypter(reader, ch.Offset, ch.Size, isLastChunk(fileChunker, ch))
+	if err != nil {
+		reader.Close()
+		return nil, 0, err
+	}
+	return encryptedChunk{enc, reader}, enc.Size(), nil
+}
+
+// encryptedChunk reads the ciphertext of a chunk and closes its file.
+type encryptedChunk struct {
+	*encryption.Encrypter
+	io.Closer
+}
+
+// partSize returns the size of chunk ch of the file as sent to the store.
+func partSize(fileChunker *chunker.FileChunker, ch chunker.Chunk, key *encryption.DataKey) int64 {
+	if key == nil {
+		return ch.Size
+	}
+	return encryption.EncryptedSize(ch.Size, isLastChunk(fileChunker, ch))
+}
+
+func isLastChunk(fileChunker *chunker.FileChunker, ch chunker.Chunk) bool {
+	return ch.Offset+ch.Size == fileChunker.FileSize()
+}
+
+// uploadPart uploads a single chunk, retrying on failure, and records its ETag.
+// No new attempt is started once ctx is done; the request itself runs with
+// partCtx, which outlives ctx by the shutdown grace period.
+func (pu *partUploader) uploadPart(ctx, partCtx context.Context, ch chunker.Chunk) error {
+	log := pu.log.With("part", ch.Index)
+	reader, size, err := partBody(partCtx, pu.chunker, ch, pu.key)
+	if err != nil {
+		log.Error("Failed to get chunk reader", "error", err)
+		return fmt.Errorf("failed to get chunk reader for part %d: %w", ch.Index, err)
+	}
+	defer reader.Close()
+
+	// Checksum the chunk before sending it so the store can reject a part
+	// that was corrupted on the way.
+	sum := storage.Checksum{Algorithm: pu.status.ChecksumAlgorithm}
+	if sum.Value, err = checksum.Compute(sum.Algorithm, reader); err != nil {
+		log.Error("Failed to compute checksum", "algorithm", sum.Algorithm, "error", err)
+		return fmt.Errorf("failed to compute checksum of part %d: %w", ch.Index, err)
+	}
+
+	start := time.Now()
+	log.Debug("Uploading part", "offset", ch.Offset, "bytes", size)
+
+	body := pu.progress.Reader(reader)
+	pu.progress.PartStarted()
+	var eTag string
+	err = pu.retry.With(utils.RetryPolicy{Logger: log}).Do(ctx, func() error {
+		// Rewind in case a previous attempt consumed part of the chunk
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		var partErr error
+		eTag, partErr = pu.store.UploadPart(partCtx, pu.status.Key, pu.status.UploadID, ch.Index, body, size, sum)
+		return partErr
+	})
+	if err != nil {
+		// Rewind so that the bytes of the failed part are not counted as sent.
+		body.Seek(0, io.SeekStart)
+		pu.progress.PartFinished(false)
+		log.Error("Failed to upload part", "error", err)
+		return fmt.Errorf("failed to upload part %d after retries: %w", ch.Index, err)
+	}
+
+	pu.status.AddCompletedPart(ch.Index, eTag, sum.Value)
+	pu.progress.PartFinished(true)
+	if err := pu.status.SaveStatus(pu.statusFilePath); err != nil {
+		log.Error("Failed to save status after completing part", "status_file", pu.statusFilePath, "error", err)
+		// Non-fatal, but log it
+	}
+	log.Info("Uploaded part", "bytes", size, "etag", eTag, "duration", time.Since(start))
+	return nil
+}
+
+// uploadChunks uploads the given chunks using a pool of at most concurrency
+// workers, returning the first error once all in-flight parts finish.
+//
+// Once ctx is done no new part is started, and parts in flight get grace
+// to finish before they are canceled too.
+func (pu *partUploader) uploadChunks(ctx context.Context, chunks []chunker.Chunk, concurrency int, grace time.Duration) error {
+	partCtx, cancel := graceContext(ctx, grace)
+	defer cancel()
+	pu.progress.Start()
+	err := chunker.ForEachContext(ctx, chunks, concurrency, func(ch chunker.Chunk) error {
+		return pu.uploadPart(ctx, partCtx, ch)
+	})
+	pu.progress.Stop(err)
+	return err
+}
+
+// interrupted flushes the status of an upload stopped because ctx is done
+// and returns the InterruptedError describing how to continue it. It
+// returns nil if ctx is not done.
+func (pu *partUploader) interrupted(ctx context.Context) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	if err := pu.status.SaveStatus(pu.statusFilePath); err != nil {
+		pu.log.Error("Failed to save status", "status_file", pu.statusFilePath, "error", err)
+	}
+	pu.status.Mu.Lock()
+	completed := len(pu.status.CompletedParts)
+	pu.status.Mu.Unlock()
+	pu.log.Info("Upload interrupted", "completed_parts", completed, "total_parts", pu.status.TotalParts, "status_file", pu.statusFilePath)
+	return &InterruptedError{StatusFilePath: pu.statusFilePath, Err: ctx.Err()}
+}
+
+// completeRetry overrides the retry policy for CompleteMultipartUpload. If
+// a response is lost after the store assembled the object, every further
+// attempt fails with NoSuchUpload, so only a few attempts are worth making.
+var completeRetry = utils.RetryPolicy{MaxAttempts: 3}
+
+// noSuchUploadRetry overrides the retry policy for requests about an
+// upload that may no longer exist, so that they fail right away if it does
+// not, even when the store does not report it with a status code.
+var noSuchUploadRetry = utils.RetryPolicy{Retryable: func(err error) bool {
+	return !storage.IsNoSuchUpload(err) && utils.IsRetryable(err)
+}}
+
+// complete completes the multipart upload recorded in status, retrying
+// transient failures. It is not interrupted by ctx being canceled, since
+// every part has been uploaded by then.
+func (pu *partUploader) complete(ctx context.Context) (storage.CompletedObject, error) {
+	var completed storage.CompletedObject
+	err := pu.retry.With(completeRetry).Do(context.WithoutCancel(ctx), func() error {
+		var err error
+		completed, err = pu.store.CompleteMultipartUpload(context.WithoutCancel(ctx), pu.status.Key, pu.status.UploadID, completedParts(pu.status))
+		return err
+	})
+	return completed, err
+}
+
+// completedParts returns the completed parts recorded in status sorted by
+// part number, as required by CompleteMultipartUpload.
+func completedParts(status *UploadStatus) []storage.CompletedPart {
+	status.Mu.Lock()
+	defer status.Mu.Unlock()
+
+	parts := make([]storage.CompletedPart, 0, len(status.CompletedParts))
+	for partNum, eTag := range status.CompletedParts {
+		parts = append(parts, storage.CompletedPart{
+			PartNumber: partNum,
+			ETag:       eTag,
+			Checksum: storage.Checksum{
+				Algorithm: status.ChecksumAlgorithm,
+				Value:     status.PartChecksums[partNum],
+			},
+		})
+	}
+	sort.Slice(parts, func(i, j int) bool {
+		return parts[i].PartNumber < parts[j].PartNumber
+	})
+	return parts
+}
+
+// verifyCompleted checks the composite checksum of a completed upload
+// against the one computed from the part checksums recorded in its status.
+func (pu *partUploader) verifyCompleted(obj storage.CompletedObject) error {
+	status := pu.status
+	if status.ChecksumAlgorithm == checksum.None {
+		return nil
+	}
+
+	parts := completedParts(status)
+	sums := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p.Checksum.Value == "" {
+			return fmt.Errorf("no %s checksum recorded for part %d", status.ChecksumAlgorithm, p.PartNumber)
+		}
+		sums = append(sums, p.Checksum.Value)
+	}
+	expected, err := checksum.Composite(status.ChecksumAlgorithm, sums)
+	if err != nil {
+		return err
+	}
+
+	// The composite MD5 is the multipart ETag, unless the object is
+	// encrypted with SSE-KMS or SSE-C.
+	actual := obj.Checksum
+	if status.ChecksumAlgorithm == checksum.MD5 {
+		if !storage.ETagIsMD5(obj.SSE) {
+			pu.log.Info("ETag of the object is not an MD5 digest; skipping composite verification", "sse", obj.SSE)
+			return nil
+		}
+		actual = etag.Normalize(obj.ETag)
+	}
+	if actual == "" {
+		pu.log.Info("Store did not report a checksum; skipping composite verification", "algorithm", status.ChecksumAlgorithm)
+		return nil
+	}
+	if actual != expected {
+		return fmt.Errorf("composite %s checksum mismatch for %s: store reported %s, expected %s", status.ChecksumAlgorithm, status.Key, actual, expected)
+	}
+	pu.log.Info("Verified composite checksum", "algorithm", status.ChecksumAlgorithm, "checksum", expected)
+	return nil
+}
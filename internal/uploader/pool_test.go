@@ -0,0 +1,60 @@
+package uploader
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yucori/Favus/internal/storage"
+)
+
+// concurrencyStore counts the parts an ObjectStore is sent at the same time.
+type concurrencyStore struct {
+	storage.ObjectStore
+
+	mu        sync.Mutex
+	active    int
+	maxActive int
+}
+
+func (s *concurrencyStore) UploadPart(ctx context.Context, key, uploadID string, partNumber int, body io.ReadSeeker, size int64, sum storage.Checksum) (string, error) {
+	s.mu.Lock()
+	s.active++
+	s.maxActive = max(s.maxActive, s.active)
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.active--
+		s.mu.Unlock()
+	}()
+	return s.ObjectStore.UploadPart(ctx, key, uploadID, partNumber, body, size, sum)
+}
+
+func TestUploadFileConcurrency(t *testing.T) {
+	for _, concurrency := range []int{1, 3} {
+		t.Run(fmt.Sprint(concurrency), func(t *testing.T) {
+			srv := newTestServer(t)
+			cfg := testConfig(t, srv)
+			cfg.Concurrency = concurrency
+			path, data := writeTestFile(t, 6*partSize5MiB+1)
+			srv.SetLatency("UploadPart", 50*time.Millisecond)
+			store := &concurrencyStore{ObjectStore: srv.Store()}
+
+			if _, err := NewUploaderWithStore(cfg, store).UploadFile(context.Background(), path, "data.bin"); err != nil {
+				t.Fatalf("UploadFile: %v", err)
+			}
+			if store.maxActive != concurrency {
+				t.Errorf("up to %d parts were sent at the same time, want %d", store.maxActive, concurrency)
+			}
+			checkObject(t, srv, "data.bin", data)
+			obj, _ := srv.Object("data.bin")
+			want := []int64{partSize5MiB, partSize5MiB, partSize5MiB, partSize5MiB, partSize5MiB, partSize5MiB, 1}
+			if fmt.Sprint(obj.PartSizes) != fmt.Sprint(want) {
+				t.Errorf("object was assembled from parts of %v bytes, want %v", obj.PartSizes, want)
+			}
+		})
+	}
+}
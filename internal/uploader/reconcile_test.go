@@ -0,0 +1,117 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/yucori/Favus/internal/checksum"
+	"github.com/yucori/Favus/internal/s3test"
+	"github.com/yucori/Favus/internal/storage"
+)
+
+// storePart uploads data as part partNumber of an upload directly to srv,
+// bypassing the uploader.
+func storePart(t *testing.T, srv *s3test.Server, key, uploadID string, partNumber int, data []byte, algorithm checksum.Algorithm) {
+	t.Helper()
+	sum := storage.Checksum{Algorithm: algorithm}
+	var err error
+	if sum.Value, err = checksum.Compute(algorithm, bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := srv.Store().UploadPart(context.Background(), key, uploadID, partNumber, bytes.NewReader(data), int64(len(data)), sum); err != nil {
+		t.Fatalf("storing part %d: %v", partNumber, err)
+	}
+}
+
+func TestResumeUploadReconcilesWithStore(t *testing.T) {
+	for _, algorithm := range []checksum.Algorithm{checksum.None, checksum.CRC32C} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			srv := newTestServer(t)
+			cfg := testConfig(t, srv)
+			cfg.Concurrency = 1
+			cfg.ChecksumAlgorithm = algorithm
+			path, data := writeTestFile(t, 6*partSize5MiB)
+			u := newTestUploader(t, cfg)
+			chunk := func(part int) []byte { return data[(part-1)*partSize5MiB : part*partSize5MiB] }
+
+			statusFilePath := interruptAfter(t, u, path, "data.bin", 2)
+			status, err := LoadStatus(statusFilePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Parts complete in order with a single worker.
+			next := len(status.CompletedParts) + 1
+			if next > 4 {
+				t.Fatalf("%d parts completed before the interruption", next-1)
+			}
+			// The part after the last recorded one reached the store without
+			// being recorded, part 1 was overwritten with other content, and
+			// the part after that is recorded but missing from the store.
+			storePart(t, srv, "data.bin", status.UploadID, next, chunk(next), algorithm)
+			storePart(t, srv, "data.bin", status.UploadID, 1, bytes.Repeat([]byte{1}, partSize5MiB), algorithm)
+			status.AddCompletedPart(next+1, `"0123456789abcdef0123456789abcdef"`, "")
+			if err := status.SaveStatus(statusFilePath); err != nil {
+				t.Fatal(err)
+			}
+			before := make(map[int]int)
+			for part := 1; part <= 6; part++ {
+				before[part] = srv.CountRequests("UploadPart", part)
+			}
+
+			if _, err := newTestResumeUploader(u).ResumeUpload(context.Background(), statusFilePath); err != nil {
+				t.Fatalf("ResumeUpload: %v", err)
+			}
+			checkObject(t, srv, "data.bin", data)
+			for part := 1; part <= 6; part++ {
+				want := 1
+				if part > 1 && part <= next {
+					want = 0
+				}
+				if n := srv.CountRequests("UploadPart", part) - before[part]; n != want {
+					t.Errorf("part %d was sent %d times on resume, want %d", part, n, want)
+				}
+			}
+		})
+	}
+}
+
+func TestResumeUploadWhenUploadIsGone(t *testing.T) {
+	srv := newTestServer(t)
+	cfg := testConfig(t, srv)
+	cfg.Concurrency = 1
+	path, data := writeTestFile(t, 3*partSize5MiB)
+	u := newTestUploader(t, cfg)
+	statusFilePath := interruptAfter(t, u, path, "data.bin", 1)
+	status, err := LoadStatus(statusFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.Store().AbortMultipartUpload(context.Background(), "data.bin", status.UploadID); err != nil {
+		t.Fatal(err)
+	}
+	sent := srv.CountRequests("UploadPart", 0)
+
+	ru := newTestResumeUploader(u)
+	if _, err := ru.ResumeUpload(context.Background(), statusFilePath); !errors.Is(err, ErrUploadNotFound) {
+		t.Fatalf("ResumeUpload returned %v, want ErrUploadNotFound", err)
+	}
+	if n := srv.CountRequests("UploadPart", 0); n != sent {
+		t.Errorf("%d parts were sent for the lost upload", n-sent)
+	}
+	if _, err := os.Stat(statusFilePath); err != nil {
+		t.Fatalf("status file of the lost upload: %v", err)
+	}
+
+	ru.Restart = true
+	if _, err := ru.ResumeUpload(context.Background(), statusFilePath); err != nil {
+		t.Fatalf("ResumeUpload with Restart: %v", err)
+	}
+	checkObject(t, srv, "data.bin", data)
+	if n := srv.CountRequests("UploadPart", 0) - sent; n != 3 {
+		t.Errorf("restart sent %d parts, want all 3", n)
+	}
+	checkNoStatusFiles(t, cfg.StateDir)
+}
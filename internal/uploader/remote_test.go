@@ -0,0 +1,188 @@
+package uploader
+
+import (
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yucori/Favus/internal/encryption"
+	"github.com/yucori/Favus/internal/storage"
+)
+
+// writeKeyFile writes a random master key to a new file and returns its
+// path.
+func writeKeyFile(t *testing.T) string {
+	t.Helper()
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "master.key")
+	if err := os.WriteFile(path, key, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newTestKeys returns a KeyProvider with a random master key.
+func newTestKeys(t *testing.T) encryption.KeyProvider {
+	t.Helper()
+	keys, err := encryption.NewKeyfileProvider(writeKeyFile(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+// loseStatus interrupts the upload of path to key with u once parts parts
+// have completed and removes its status file, returning the upload ID.
+func loseStatus(t *testing.T, u *S3Uploader, path, key string, parts int) string {
+	t.Helper()
+	statusFilePath := interruptAfter(t, u, path, key, parts)
+	status, err := LoadStatus(statusFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(statusFilePath); err != nil {
+		t.Fatal(err)
+	}
+	return status.UploadID
+}
+
+func TestResumeRemoteUpload(t *testing.T) {
+	srv := newTestServer(t)
+	cfg := testConfig(t, srv)
+	cfg.Concurrency = 1
+	path, data := writeTestFile(t, 4*partSize5MiB+5)
+	u := newTestUploader(t, cfg)
+	uploadID := loseStatus(t, u, path, "data.bin", 2)
+
+	r := RemoteUpload{FilePath: path, Key: "data.bin", UploadID: uploadID, Bucket: cfg.S3BucketName}
+	if _, err := newTestResumeUploader(u).ResumeRemoteUpload(context.Background(), r); err != nil {
+		t.Fatalf("ResumeRemoteUpload: %v", err)
+	}
+	checkObject(t, srv, "data.bin", data)
+	for part := 1; part <= 5; part++ {
+		if n := srv.CountRequests("UploadPart", part); n != 1 {
+			t.Errorf("part %d was sent %d times, want 1", part, n)
+		}
+	}
+	checkNoStatusFiles(t, cfg.StateDir)
+}
+
+func TestResumeRemoteUploadWithKeysOfPlainUpload(t *testing.T) {
+	srv := newTestServer(t)
+	cfg := testConfig(t, srv)
+	cfg.Concurrency = 1
+	path, data := writeTestFile(t, 4*partSize5MiB+5)
+	u := newTestUploader(t, cfg)
+	uploadID := loseStatus(t, u, path, "data.bin", 2)
+
+	// The upload was not encrypted, so it is resumed as it started even
+	// though encryption is now configured.
+	ru := newTestResumeUploader(u)
+	ru.Keys = newTestKeys(t)
+	r := RemoteUpload{FilePath: path, Key: "data.bin", UploadID: uploadID, Bucket: cfg.S3BucketName}
+	if _, err := ru.ResumeRemoteUpload(context.Background(), r); err != nil {
+		t.Fatalf("ResumeRemoteUpload: %v", err)
+	}
+	checkObject(t, srv, "data.bin", data)
+	checkNoStatusFiles(t, cfg.StateDir)
+}
+
+func TestResumeRemoteUploadRefusesOtherFile(t *testing.T) {
+	srv := newTestServer(t)
+	cfg := testConfig(t, srv)
+	cfg.Concurrency = 1
+	path, data := writeTestFile(t, 4*partSize5MiB)
+	u := newTestUploader(t, cfg)
+	uploadID := loseStatus(t, u, path, "data.bin", 2)
+	sent := srv.CountRequests("UploadPart", 0)
+
+	other := filepath.Join(t.TempDir(), "other.bin")
+	if err := os.WriteFile(other, data[:partSize5MiB+partSize5MiB/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	r := RemoteUpload{FilePath: other, Key: "data.bin", UploadID: uploadID, Bucket: cfg.S3BucketName}
+	if _, err := newTestResumeUploader(u).ResumeRemoteUpload(context.Background(), r); err == nil {
+		t.Fatal("ResumeRemoteUpload succeeded with another file")
+	}
+	if n := srv.CountRequests("UploadPart", 0); n != sent {
+		t.Errorf("%d parts were sent for another file", n-sent)
+	}
+	if _, ok := srv.Object("data.bin"); ok {
+		t.Error("object was stored")
+	}
+}
+
+func TestResumeRemoteUploadWithSinglePart(t *testing.T) {
+	const partSize = 6 * 1024 * 1024
+	srv := newTestServer(t)
+	cfg := testConfig(t, srv)
+	path, data := writeTestFile(t, 2*partSize+7)
+	u := newTestUploader(t, cfg)
+	uploadID, err := u.Store.CreateMultipartUpload(context.Background(), "data.bin", cfg.ChecksumAlgorithm, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	storePart(t, srv, "data.bin", uploadID, 1, data[:partSize], cfg.ChecksumAlgorithm)
+
+	// A single part does not tell the part size, and the default one does
+	// not fit it.
+	ru := newTestResumeUploader(u)
+	r := RemoteUpload{FilePath: path, Key: "data.bin", UploadID: uploadID, Bucket: cfg.S3BucketName}
+	if _, err := ru.ResumeRemoteUpload(context.Background(), r); err == nil || !strings.Contains(err.Error(), "too few parts") {
+		t.Fatalf("ResumeRemoteUpload returned %v, want an error asking for the part size", err)
+	}
+
+	r.PartSize = partSize
+	if _, err := ru.ResumeRemoteUpload(context.Background(), r); err != nil {
+		t.Fatalf("ResumeRemoteUpload: %v", err)
+	}
+	checkObject(t, srv, "data.bin", data)
+	if n := srv.CountRequests("UploadPart", 1); n != 1 {
+		t.Errorf("part 1 was sent %d times, want 1", n)
+	}
+}
+
+func TestResumeRemoteUploadRefusesEncryptedUpload(t *testing.T) {
+	tests := []struct {
+		name     string
+		newStore func(t *testing.T, u *S3Uploader) storage.ObjectStore
+	}{
+		// The fake S3 server does not return the metadata of an upload in
+		// progress, so the sizes of its parts tell.
+		{"s3", func(t *testing.T, u *S3Uploader) storage.ObjectStore { return u.Store }},
+		{"local", func(t *testing.T, u *S3Uploader) storage.ObjectStore {
+			store, err := storage.NewLocalStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t)
+			cfg := testConfig(t, srv)
+			cfg.Concurrency = 1
+			path, _ := writeTestFile(t, 4*partSize5MiB)
+			u := newTestUploader(t, cfg)
+			u.Store = tt.newStore(t, u)
+			u.Keys = newTestKeys(t)
+			uploadID := loseStatus(t, u, path, "data.bin", 2)
+
+			for _, keys := range []encryption.KeyProvider{nil, u.Keys} {
+				ru := newTestResumeUploader(u)
+				ru.Keys = keys
+				r := RemoteUpload{FilePath: path, Key: "data.bin", UploadID: uploadID, Bucket: cfg.S3BucketName}
+				if _, err := ru.ResumeRemoteUpload(context.Background(), r); err == nil || !strings.Contains(err.Error(), "encrypted") {
+					t.Errorf("ResumeRemoteUpload with Keys set %v returned %v, want an error about encryption", keys != nil, err)
+				}
+			}
+		})
+	}
+}
@@ -1,138 +1,611 @@
 package uploader
 
 import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/s3"
-	"github.com/yucori/Favus/internal/chunker" // Update with your actual module path
+	"github.com/yucori/Favus/internal/checksum"
+	"github.com/yucori/Favus/internal/chunker"
+	"github.com/yucori/Favus/internal/encryption"
+	"github.com/yucori/Favus/internal/etag"
+	"github.com/yucori/Favus/internal/progress"
+	"github.com/yucori/Favus/internal/storage"
 
 	// config 패키지는 ResumeUploader에서 직접 사용하지 않으므로 임포트 제거 (필요시 다시 추가)
-	"github.com/yucori/Favus/pkg/utils" // Update with your actual module path
+	"github.com/yucori/Favus/pkg/utils"
 )
 
 // ResumeUploader allows resuming a multipart upload.
 type ResumeUploader struct {
-	S3Client *s3.S3
-	// Logger 필드 제거: utils 패키지 함수를 직접 호출하므로 더 이상 필요 없음
+	Store       storage.ObjectStore
+	Concurrency int // Number of parts uploaded in parallel
+	// GracePeriod is how long parts in flight may run after the context of
+	// ResumeUpload is canceled. Zero means config.DefaultShutdownGracePeriod.
+	GracePeriod time.Duration
+	// Retry is the retry policy for upload requests. Zero fields use
+	// utils.DefaultRetryPolicy.
+	Retry utils.RetryPolicy
+	// Logger receives the uploader's log records. The package logger of
+	// pkg/utils is used when nil.
+	Logger *utils.Logger
+	// Progress receives progress events while the remaining parts are
+	// sent. No progress is reported when nil.
+	Progress progress.Func
+	// Restart makes ResumeUpload start the upload over in a new multipart
+	// upload if the recorded one no longer exists, instead of failing with
+	// ErrUploadNotFound.
+	Restart bool
+	// StateDir is the directory of the status files, used for the status
+	// file of a restarted or rebuilt upload. Empty means
+	// config.DefaultStateDir.
+	StateDir string
+	// Keys holds the master key of uploads encrypted on the client. It
+	// must be the one they started with.
+	Keys encryption.KeyProvider
 }
 
+// ErrUploadNotFound is returned by ResumeUpload when the multipart upload
+// recorded in the status file no longer exists in the store, because it
+// was aborted, completed or expired.
+var ErrUploadNotFound = errors.New("multipart upload no longer exists")
+
 // NewResumeUploader creates a new ResumeUploader.
-func NewResumeUploader(s3Client *s3.S3) *ResumeUploader { // logger 인자 제거
+func NewResumeUploader(store storage.ObjectStore, concurrency int) *ResumeUploader {
 	return &ResumeUploader{
-		S3Client: s3Client,
+		Store:       store,
+		Concurrency: concurrency,
 	}
 }
 
-// ResumeUpload resumes a multipart upload from a saved status.
-func (ru *ResumeUploader) ResumeUpload(statusFilePath string) error {
+func (ru *ResumeUploader) logger() *utils.Logger {
+	if ru.Logger != nil {
+		return ru.Logger
+	}
+	return utils.Default()
+}
+
+// ResumeUpload resumes a multipart upload from a saved status. Like
+// S3Uploader.UploadFile, it returns an *InterruptedError if ctx is canceled
+// before all parts are uploaded.
+//
+// The status is first reconciled with the parts the store has, so that a
+// part whose upload finished after the status was last saved is not sent
+// again, and a part the store lost is. If the upload itself is gone, it
+// fails with ErrUploadNotFound before sending anything, unless Restart is
+// set.
+func (ru *ResumeUploader) ResumeUpload(ctx context.Context, statusFilePath string) (*UploadResult, error) {
+	start := time.Now()
+	// Keep another process from resuming the same upload at the same time.
+	// The status is loaded only once the lock is held, since the process
+	// that held it may have completed the upload and removed it meanwhile.
+	lock, err := lockStatus(statusFilePath)
+	if err != nil {
+		ru.logger().Error("Cannot lock upload status", "status_file", statusFilePath, "error", err)
+		return nil, fmt.Errorf("cannot resume upload: %w", err)
+	}
 	status, err := LoadStatus(statusFilePath)
 	if err != nil {
-		utils.Error("Failed to load upload status for resume from %s: %v", statusFilePath, err)
-		return fmt.Errorf("failed to load upload status for resume: %w", err)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Do not leave behind the lock file just created.
+			removeStatus(statusFilePath, lock)
+		} else {
+			lock.unlock()
+		}
+		ru.logger().Error("Failed to load upload status for resume", "status_file", statusFilePath, "error", err)
+		return nil, fmt.Errorf("failed to load upload status for resume: %w", err)
 	}
+	return ru.resume(ctx, start, status, statusFilePath, lock)
+}
 
-	utils.Info("Resuming upload for file: %s with UploadID: %s", status.FilePath, status.UploadID)
+// resume resumes the upload of status, whose lock is held, and releases
+// the lock when done.
+func (ru *ResumeUploader) resume(ctx context.Context, start time.Time, status *UploadStatus, statusFilePath string, lock *statusLock) (*UploadResult, error) {
+	defer func() { lock.unlock() }()
+	log := ru.logger().With("file", status.FilePath, "key", status.Key, "upload_id", status.UploadID)
+	log.Info("Resuming upload", "status_file", statusFilePath)
 
-	// ResumeUploader는 Config 객체에 직접 접근할 수 없으므로,
-	// 청크 사이즈는 chunker.DefaultChunkSize를 사용하거나
-	// UploadStatus에 저장된 청크 사이즈를 사용해야 합니다.
-	// 현재는 DefaultChunkSize를 사용합니다.
-	fileChunker, err := chunker.NewFileChunker(status.FilePath, chunker.DefaultChunkSize)
+	// Refuse to resume if the file changed since the upload started, since
+	// the already uploaded parts would no longer match its content.
+	if err := status.CheckSource(); err != nil {
+		log.Error("Cannot resume upload", "error", err)
+		return nil, fmt.Errorf("cannot resume upload: %w", err)
+	}
+	if err := status.CheckCustomerKey(storage.CustomerKeyMD5(ru.Store)); err != nil {
+		log.Error("Cannot resume upload", "error", err)
+		return nil, fmt.Errorf("cannot resume upload: %w", err)
+	}
+	key, err := encryption.OpenDataKey(ru.Keys, status.Encryption)
 	if err != nil {
-		utils.Error("Failed to create file chunker for resume for %s: %v", status.FilePath, err)
-		return fmt.Errorf("failed to create file chunker for resume: %w", err)
+		log.Error("Cannot decrypt the data key of the upload", "error", err)
+		return nil, fmt.Errorf("cannot resume encrypted upload: %w", err)
 	}
-	chunks := fileChunker.Chunks()
 
+	// Rebuild the chunks with the same chunk size used when the upload started.
+	fileChunker, err := chunker.NewFileChunker(status.FilePath, status.ChunkSize)
+	if err != nil {
+		log.Error("Failed to create file chunker for resume", "error", err)
+		return nil, fmt.Errorf("failed to create file chunker for resume: %w", err)
+	}
+	// Parts already uploaded cannot be split differently.
+	if fileChunker.ChunkSize() != status.ChunkSize {
+		log.Error("Recorded part size is outside multipart upload limits; aborting resume", "part_size", status.ChunkSize, "reason", fileChunker.Adjustment())
+		return nil, fmt.Errorf("cannot resume upload: %s; abort it and upload the file again", fileChunker.Adjustment())
+	}
 	// Ensure the total parts match
-	if len(chunks) != status.TotalParts {
-		utils.Error("Mismatch in total parts for %s: expected %d, got %d from status. Aborting resume.", status.FilePath, len(chunks), status.TotalParts)
-		return fmt.Errorf("mismatch in total parts: expected %d, got %d from status", len(chunks), status.TotalParts)
+	if parts := len(fileChunker.Chunks()); parts != status.TotalParts {
+		log.Error("Mismatch in total parts; aborting resume", "parts", parts, "status_parts", status.TotalParts)
+		return nil, fmt.Errorf("mismatch in total parts: expected %d, got %d from status", parts, status.TotalParts)
 	}
 
-	completedParts := make([]*s3.CompletedPart, 0, len(status.CompletedParts))
-	for partNum, eTag := range status.CompletedParts {
-		completedParts = append(completedParts, &s3.CompletedPart{
-			PartNumber: aws.Int64(int64(partNum)),
-			ETag:       aws.String(eTag),
-		})
+	retry := ru.Retry.With(utils.RetryPolicy{Logger: log})
+	parts, err := ru.listParts(ctx, status.Key, status.UploadID, retry, log)
+	switch {
+	case err == nil:
+		if err := ru.reconcile(ctx, status, statusFilePath, fileChunker, key, parts, true, log); err != nil {
+			return nil, err
+		}
+	case errors.Is(err, ErrUploadNotFound) && ru.Restart:
+		newStatusFilePath, newLock, newKey, err := ru.restart(ctx, status, statusFilePath, lock, key, retry, log)
+		if err != nil {
+			return nil, err
+		}
+		statusFilePath, lock, key = newStatusFilePath, newLock, newKey
+		log = ru.logger().With("file", status.FilePath, "key", status.Key, "upload_id", status.UploadID)
+	default:
+		return nil, err
+	}
+	return ru.finish(ctx, start, status, statusFilePath, lock, fileChunker, key, retry, log)
+}
+
+// RemoteUpload identifies a multipart upload to resume with
+// ResumeRemoteUpload, without its status file.
+type RemoteUpload struct {
+	FilePath string // The file being uploaded
+	Key      string
+	UploadID string
+	Bucket   string // Recorded in the new status file
+	// PartSize is the part size if the store has fewer than two parts to
+	// infer it from.
+	// Zero means the default, adjusted like UploadFile does.
+	PartSize int64
+	// ChecksumAlgorithm is the checksum sent with every part if the store
+	// has no part telling which one the upload uses.
+	ChecksumAlgorithm checksum.Algorithm
+	// Verify reads every stored part back from the file to confirm its
+	// content, by ETag or checksum, instead of only checking its size.
+	// Parts that do not match are uploaded again.
+	Verify bool
+}
+
+// ResumeRemoteUpload resumes a multipart upload whose status file was lost,
+// rebuilding the status from the parts the store has. The part size is
+// that of the first stored part if the store has two or more, and
+// r.PartSize otherwise. Every stored part must have the size the file
+// gives it, or the upload is refused as not being of this file. Uploads
+// encrypted on the client are refused, since their data key is lost,
+// whether or not encryption is configured. A new status file is saved, so
+// that an interrupted resume can continue with ResumeUpload.
+//
+// If the status file of the upload still exists, it is used instead.
+func (ru *ResumeUploader) ResumeRemoteUpload(ctx context.Context, r RemoteUpload) (*UploadResult, error) {
+	start := time.Now()
+	statusFilePath := StatusFilePath(ru.StateDir, r.Bucket, r.Key, r.UploadID)
+	log := ru.logger().With("file", r.FilePath, "key", r.Key, "upload_id", r.UploadID)
+	lock, err := lockStatus(statusFilePath)
+	if err != nil {
+		log.Error("Cannot lock upload status", "status_file", statusFilePath, "error", err)
+		return nil, fmt.Errorf("cannot resume upload: %w", err)
+	}
+	if status, err := LoadStatus(statusFilePath); err == nil && status.UploadID == r.UploadID && status.Key == r.Key {
+		log.Info("Found the status file of the upload", "status_file", statusFilePath)
+		return ru.resume(ctx, start, status, statusFilePath, lock)
+	}
+	defer lock.unlock()
+
+	log.Info("Resuming upload from the parts in the store")
+	retry := ru.Retry.With(utils.RetryPolicy{Logger: log})
+	parts, err := ru.listParts(ctx, r.Key, r.UploadID, retry, log)
+	if err != nil {
+		return nil, err
+	}
+
+	partSize := r.PartSize
+	algorithm := r.ChecksumAlgorithm
+	// Every part but the last has the part size, and only the stored part
+	// with the highest number may be the last, so the size is known if the
+	// store has two parts or more.
+	inferred := len(parts) > 1
+	if inferred {
+		partSize = parts[0].Size
+	}
+	if len(parts) > 0 {
+		if p := parts[0]; p.Checksum.Value != "" {
+			algorithm = p.Checksum.Algorithm
+		}
+	}
+	fileChunker, err := chunker.NewFileChunker(r.FilePath, partSize)
+	if err != nil {
+		log.Error("Failed to create file chunker for resume", "error", err)
+		return nil, fmt.Errorf("failed to create file chunker for resume: %w", err)
+	}
+	encrypted, err := ru.encryptedUpload(ctx, r, fileChunker.FileSize(), parts)
+	if err != nil {
+		log.Error("Failed to read upload metadata", "error", err)
+		return nil, fmt.Errorf("failed to read upload metadata: %w", err)
+	}
+	if encrypted {
+		// The wrapped data key of the upload is not readable from the
+		// store before the upload completes.
+		log.Error("Cannot resume an upload encrypted on the client without its status file")
+		return nil, errors.New("cannot resume an encrypted upload without its status file, which holds its data key; abort it and upload the file again")
+	}
+	if inferred && fileChunker.ChunkSize() != partSize {
+		log.Error("Part size of the upload is outside multipart upload limits", "part_size", partSize, "reason", fileChunker.Adjustment())
+		return nil, fmt.Errorf("cannot resume upload: %s", fileChunker.Adjustment())
 	}
+	if err := checkPartSizes(fileChunker, parts); err != nil {
+		log.Error("Stored parts do not fit the file", "part_size", fileChunker.ChunkSize(), "error", err)
+		if !inferred {
+			return nil, fmt.Errorf("cannot resume upload with %s: %w; the store has too few parts to tell the part size, so give the one the upload started with", r.FilePath, err)
+		}
+		return nil, fmt.Errorf("cannot resume upload with %s: %w", r.FilePath, err)
+	}
+	log.Info("Rebuilt upload status", "part_size", fileChunker.ChunkSize(), "parts", len(fileChunker.Chunks()), "stored_parts", len(parts), "checksum", algorithm)
 
-	// Upload remaining parts
+	status := NewUploadStatus(sourcePath(r.FilePath), r.Bucket, r.Key, r.UploadID, fileChunker.ChunkSize(), len(fileChunker.Chunks()), algorithm)
+	// S3 rejects parts sent with another SSE-C key than the upload started
+	// with, so the configured key is taken to be that one.
+	status.SSECustomerKeyMD5 = storage.CustomerKeyMD5(ru.Store)
+	if err := status.RecordSource(); err != nil {
+		log.Error("Failed to record source file state", "error", err)
+		return nil, fmt.Errorf("failed to record source file state: %w", err)
+	}
+	if err := ru.reconcile(ctx, status, statusFilePath, fileChunker, nil, parts, r.Verify, log); err != nil {
+		return nil, err
+	}
+	if err := status.SaveStatus(statusFilePath); err != nil {
+		log.Error("Failed to save status", "status_file", statusFilePath, "error", err)
+	}
+	return ru.finish(ctx, start, status, statusFilePath, lock, fileChunker, nil, retry, log)
+}
+
+// encryptedUpload reports whether the upload r, of a file of fileSize bytes
+// with parts in the store, is encrypted on the client. The metadata the
+// upload was created with tells, if the store returns it. Otherwise the
+// upload is taken to be encrypted if the sizes of its parts are those of
+// the file encrypted in parts of a whole number of segments.
+func (ru *ResumeUploader) encryptedUpload(ctx context.Context, r RemoteUpload, fileSize int64, parts []storage.UploadedPart) (bool, error) {
+	metadata, ok, err := storage.UploadMetadata(ctx, ru.Store, r.Key, r.UploadID)
+	if ok || err != nil {
+		return encryption.IsEncrypted(metadata), err
+	}
+	if len(parts) == 0 {
+		return false, nil
+	}
+	const encryptedSegment = encryption.SegmentSize + encryption.Overhead
+	first := parts[0]
+	if first.Size%encryptedSegment != 0 {
+		// Only a single part holding the whole file is not a whole
+		// number of segments.
+		return len(parts) == 1 && first.PartNumber == 1 && first.Size == encryption.EncryptedSize(fileSize, true), nil
+	}
+	plainPartSize := first.Size / encryptedSegment * encryption.SegmentSize
+	total := max((fileSize+plainPartSize-1)/plainPartSize, 1)
+	for _, p := range parts {
+		want := first.Size
+		switch n := int64(p.PartNumber); {
+		case n > total:
+			return false, nil
+		case n == total:
+			want = encryption.EncryptedSize(fileSize-(total-1)*plainPartSize, true)
+		}
+		if p.Size != want {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// checkPartSizes returns an error if a stored part does not have the size
+// of the chunk of the file with its number.
+func checkPartSizes(fileChunker *chunker.FileChunker, parts []storage.UploadedPart) error {
+	chunks := fileChunker.Chunks()
+	for _, p := range parts {
+		if p.PartNumber < 1 || p.PartNumber > len(chunks) {
+			return fmt.Errorf("part %d is beyond the %d parts of %d bytes the file has", p.PartNumber, len(chunks), fileChunker.ChunkSize())
+		}
+		if want := chunks[p.PartNumber-1].Size; p.Size != want {
+			return fmt.Errorf("part %d has %d bytes, but the file gives it %d with parts of %d bytes", p.PartNumber, p.Size, want, fileChunker.ChunkSize())
+		}
+	}
+	return nil
+}
+
+// finish uploads the parts of the file that status does not record as
+// completed and completes the upload, encrypting the parts with key unless
+// it is nil. On success the status file is removed and lock released.
+func (ru *ResumeUploader) finish(ctx context.Context, start time.Time, status *UploadStatus, statusFilePath string, lock *statusLock, fileChunker *chunker.FileChunker, key *encryption.DataKey, retry utils.RetryPolicy, log *utils.Logger) (*UploadResult, error) {
+	chunks := fileChunker.Chunks()
+	var remaining []chunker.Chunk
+	var completedBytes, sentSize int64
 	for _, ch := range chunks {
+		size := partSize(fileChunker, ch, key)
+		sentSize += size
 		if status.IsPartCompleted(ch.Index) {
-			utils.Info("Part %d already completed, skipping.", ch.Index)
+			log.Debug("Part already completed, skipping", "part", ch.Index)
+			completedBytes += size
 			continue
 		}
+		remaining = append(remaining, ch)
+	}
+	tracker := progress.NewTracker(status.Key, sentSize, len(chunks), ru.Progress)
+	tracker.Skip(completedBytes, len(chunks)-len(remaining))
 
-		reader, err := fileChunker.GetChunkReader(ch)
-		if err != nil {
-			utils.Error("Failed to get chunk reader for part %d of %s: %v", ch.Index, status.FilePath, err)
-			return fmt.Errorf("failed to get chunk reader for part %d: %w", ch.Index, err)
-		}
-
-		utils.Info("Uploading part %d (offset %d, size %d) for file %s", ch.Index, ch.Offset, ch.Size, status.FilePath)
-
-		var uploadOutput *s3.UploadPartOutput
-		err = utils.Retry(5, 2*time.Second, func() error {
-			var partErr error
-			uploadOutput, partErr = ru.S3Client.UploadPart(&s3.UploadPartInput{
-				Body:          aws.ReadSeekCloser(reader),
-				Bucket:        aws.String(status.Bucket),
-				Key:           aws.String(status.Key),
-				PartNumber:    aws.Int64(int64(ch.Index)),
-				UploadId:      aws.String(status.UploadID),
-				ContentLength: aws.Int64(ch.Size),
-			})
-			if partErr != nil {
-				utils.Error("Failed to upload part %d for %s: %v", ch.Index, status.FilePath, partErr)
-				return partErr
+	pu := &partUploader{
+		store:          ru.Store,
+		chunker:        fileChunker,
+		status:         status,
+		statusFilePath: statusFilePath,
+		retry:          retry,
+		log:            log,
+		progress:       tracker,
+		key:            key,
+	}
+	log.Info("Uploading remaining parts", "parts", len(remaining), "completed_parts", len(chunks)-len(remaining))
+	if err := pu.uploadChunks(ctx, remaining, ru.Concurrency, ru.GracePeriod); err != nil {
+		if interrupted := pu.interrupted(ctx); interrupted != nil {
+			return nil, interrupted
+		}
+		return nil, err
+	}
+
+	// Complete the multipart upload
+	log.Info("Completing multipart upload")
+	completed, err := pu.complete(ctx)
+	if err != nil {
+		log.Error("Failed to complete multipart upload", "error", err)
+		return nil, fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	if err := pu.verifyCompleted(completed); err != nil {
+		log.Error("Integrity check failed", "error", err)
+		// The upload is complete and cannot be resumed any more.
+		if err := removeStatus(statusFilePath, lock); err != nil {
+			log.Error("Failed to remove status file", "status_file", statusFilePath, "error", err)
+		}
+		return nil, fmt.Errorf("object %s was stored but failed its integrity check: %w", status.Key, err)
+	}
+
+	result := &UploadResult{
+		File:     status.FilePath,
+		Key:      status.Key,
+		UploadID: status.UploadID,
+		Size:     fileChunker.FileSize(),
+		Sent:     sentSize - completedBytes,
+		Parts:    len(chunks),
+		ETag:     etag.Normalize(completed.ETag),
+		Checksum: completed.Checksum,
+		Duration: time.Since(start),
+	}
+	log.Info("Multipart upload completed successfully", "bytes", result.Sent, "duration", result.Duration)
+
+	// Clean up status file
+	if err := removeStatus(statusFilePath, lock); err != nil {
+		log.Error("Failed to remove status file", "status_file", statusFilePath, "error", err)
+	}
+
+	return result, nil
+}
+
+// listParts lists the parts the store has for an upload. It returns an
+// error wrapping ErrUploadNotFound if the upload is gone.
+func (ru *ResumeUploader) listParts(ctx context.Context, key, uploadID string, retry utils.RetryPolicy, log *utils.Logger) ([]storage.UploadedPart, error) {
+	var parts []storage.UploadedPart
+	err := retry.With(noSuchUploadRetry).Do(ctx, func() error {
+		var err error
+		parts, err = ru.Store.ListParts(ctx, key, uploadID)
+		return err
+	})
+	if err != nil {
+		if storage.IsNoSuchUpload(err) {
+			log.Error("Multipart upload no longer exists", "error", err)
+			return nil, fmt.Errorf("cannot resume upload %s of %s: %w", uploadID, key, ErrUploadNotFound)
+		}
+		log.Error("Failed to list uploaded parts", "error", err)
+		return nil, fmt.Errorf("failed to list uploaded parts: %w", err)
+	}
+	return parts, nil
+}
+
+// reconcile merges parts, the parts the store has for the upload of status,
+// into it, and saves it if it changed. Parts are only trusted if their
+// size matches the file and, when the status does not already record the
+// same ETag, if verify is false or their content is confirmed against the
+// file by ETag or checksum; other parts are dropped from the status so
+// that they are sent again.
+func (ru *ResumeUploader) reconcile(ctx context.Context, status *UploadStatus, statusFilePath string, fileChunker *chunker.FileChunker, key *encryption.DataKey, parts []storage.UploadedPart, verify bool, log *utils.Logger) error {
+	remote := make(map[int]storage.UploadedPart, len(parts))
+	for _, p := range parts {
+		remote[p.PartNumber] = p
+	}
+
+	var adopted, dropped int
+	for _, ch := range fileChunker.Chunks() {
+		part, stored := remote[ch.Index]
+		recorded, ok := status.CompletedParts[ch.Index]
+		switch {
+		case !stored || part.Size != partSize(fileChunker, ch, key):
+			if ok {
+				log.Warn("Part recorded as uploaded is missing from the store or has the wrong size; uploading it again", "part", ch.Index, "stored", stored, "bytes", part.Size)
+				dropped++
 			}
-			return nil
-		})
+			ru.dropPart(status, ch.Index)
+			continue
+		case ok && etag.Equal(recorded, part.ETag) && (part.Checksum.Value == "" || part.Checksum.Value == status.PartChecksums[ch.Index]):
+			continue
+		}
+		if !verify {
+			if sum, known := storedChecksum(part, status.ChecksumAlgorithm); known {
+				status.AddCompletedPart(ch.Index, part.ETag, sum)
+				adopted++
+				continue
+			}
+		}
 
+		sum, confirmed, err := confirmPart(ctx, fileChunker, ch, key, part, status.ChecksumAlgorithm)
 		if err != nil {
-			utils.Error("Failed to upload part %d for %s after retries: %v", ch.Index, status.FilePath, err)
-			return fmt.Errorf("failed to upload part %d after retries: %w", ch.Index, err)
+			log.Error("Failed to read part", "part", ch.Index, "error", err)
+			return fmt.Errorf("failed to read part %d: %w", ch.Index, err)
 		}
-
-		status.AddCompletedPart(ch.Index, *uploadOutput.ETag)
-		if err := status.SaveStatus(statusFilePath); err != nil {
-			utils.Error("Failed to save status after completing part %d for %s: %v", ch.Index, status.FilePath, err)
-			// Non-fatal, but log it
+		if !confirmed {
+			log.Warn("Stored part does not match the file; uploading it again", "part", ch.Index)
+			if ok {
+				dropped++
+			}
+			ru.dropPart(status, ch.Index)
+			continue
 		}
-		utils.Info("Successfully uploaded part %d. ETag: %s", ch.Index, *uploadOutput.ETag)
+		log.Debug("Adopting part found in the store", "part", ch.Index, "etag", part.ETag)
+		status.AddCompletedPart(ch.Index, part.ETag, sum)
+		adopted++
+	}
+	if adopted == 0 && dropped == 0 {
+		log.Debug("Upload status matches the store", "stored_parts", len(parts))
+		return nil
+	}
+	log.Info("Reconciled upload status with the store", "stored_parts", len(parts), "adopted", adopted, "dropped", dropped)
+	if err := status.SaveStatus(statusFilePath); err != nil {
+		log.Error("Failed to save status", "status_file", statusFilePath, "error", err)
+	}
+	return nil
+}
 
-		completedParts = append(completedParts, &s3.CompletedPart{
-			PartNumber: aws.Int64(int64(ch.Index)),
-			ETag:       uploadOutput.ETag,
-		})
+// dropPart removes a part from status, so that it is uploaded again.
+func (ru *ResumeUploader) dropPart(status *UploadStatus, partNumber int) {
+	status.Mu.Lock()
+	defer status.Mu.Unlock()
+	delete(status.CompletedParts, partNumber)
+	delete(status.PartChecksums, partNumber)
+}
+
+// confirmPart reads the chunk ch of the file, encrypted with key unless it
+// is nil, and reports whether the stored part has the same content, by its
+// ETag if it is the MD5 of the part or by the checksum of algorithm. It
+// also returns that checksum, to record with the part.
+func confirmPart(ctx context.Context, fileChunker *chunker.FileChunker, ch chunker.Chunk, key *encryption.DataKey, part storage.UploadedPart, algorithm checksum.Algorithm) (string, bool, error) {
+	reader, _, err := partBody(ctx, fileChunker, ch, key)
+	if err != nil {
+		return "", false, err
 	}
+	defer reader.Close()
 
-	// Complete the multipart upload
-	utils.Info("Completing multipart upload for file: %s", status.FilePath)
-	_, err = ru.S3Client.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
-		Bucket:   aws.String(status.Bucket),
-		Key:      aws.String(status.Key),
-		UploadId: aws.String(status.UploadID),
-		MultipartUpload: &s3.CompletedMultipartUpload{
-			Parts: completedParts,
-		},
+	digest := md5.New()
+	w := io.Writer(digest)
+	h := algorithm.New()
+	if h != nil {
+		w = io.MultiWriter(digest, h)
+	}
+	if _, err := io.Copy(w, reader); err != nil {
+		return "", false, err
+	}
+	var sum string
+	if h != nil {
+		sum = base64.StdEncoding.EncodeToString(h.Sum(nil))
+	}
+	if part.Checksum.Value != "" {
+		return sum, part.Checksum.Algorithm == algorithm && part.Checksum.Value == sum, nil
+	}
+	// The ETag of a part is its MD5, unless the store encrypts it.
+	return sum, etag.Normalize(part.ETag) == hex.EncodeToString(digest.Sum(nil)), nil
+}
+
+// storedChecksum returns the checksum of algorithm of a stored part, as
+// far as the store reports it, and whether it does.
+func storedChecksum(part storage.UploadedPart, algorithm checksum.Algorithm) (string, bool) {
+	switch {
+	case algorithm == checksum.None:
+		return "", true
+	case part.Checksum.Algorithm == algorithm && part.Checksum.Value != "":
+		return part.Checksum.Value, true
+	case algorithm == checksum.MD5:
+		// The ETag of a part is its MD5, unless the store encrypts it.
+		digest, err := hex.DecodeString(etag.Normalize(part.ETag))
+		if err != nil || len(digest) != md5.Size {
+			return "", false
+		}
+		return base64.StdEncoding.EncodeToString(digest), true
+	}
+	return "", false
+}
+
+// restart replaces the multipart upload of status, which no longer exists,
+// with a new one, forgetting the completed parts. The status moves to the
+// status file of the new upload, whose path and lock are returned in place
+// of statusFilePath and lock. An encrypted upload starts over under a new
+// data key, returned in place of key.
+func (ru *ResumeUploader) restart(ctx context.Context, status *UploadStatus, statusFilePath string, lock *statusLock, key *encryption.DataKey, retry utils.RetryPolicy, log *utils.Logger) (string, *statusLock, *encryption.DataKey, error) {
+	log.Info("Restarting upload from scratch")
+	fileInfo, err := os.Stat(status.FilePath)
+	if err != nil {
+		log.Error("Failed to get file info", "error", err)
+		return "", nil, nil, fmt.Errorf("failed to get file info: %w", err)
+	}
+	if key != nil {
+		// Parts of the lost upload may have been stored elsewhere, so the
+		// file is not encrypted again under the same key and nonces.
+		key, err = encryption.NewDataKey(ru.Keys)
+		if err != nil {
+			log.Error("Failed to create data key", "error", err)
+			return "", nil, nil, fmt.Errorf("failed to create data key: %w", err)
+		}
+	}
+	metadata := uploadMetadata(fileInfo, status.ChunkSize, key)
+	var uploadID string
+	err = retry.Do(ctx, func() error {
+		var err error
+		uploadID, err = ru.Store.CreateMultipartUpload(ctx, status.Key, status.ChecksumAlgorithm, metadata)
+		return err
 	})
 	if err != nil {
-		utils.Error("Failed to complete multipart upload for %s: %v", status.FilePath, err)
-		return fmt.Errorf("failed to complete multipart upload: %w", err)
+		log.Error("Failed to initiate multipart upload", "error", err)
+		return "", nil, nil, fmt.Errorf("failed to initiate multipart upload: %w", err)
 	}
 
-	utils.Info("Multipart upload completed successfully for %s", status.FilePath)
+	log.Info("Initiated multipart upload", "new_upload_id", uploadID)
 
-	// Clean up status file
-	if err := os.Remove(statusFilePath); err != nil {
-		utils.Error("Failed to remove status file %s: %v", statusFilePath, err)
+	newStatusFilePath := StatusFilePath(ru.StateDir, status.Bucket, status.Key, uploadID)
+	newLock, err := lockStatus(newStatusFilePath)
+	if err != nil {
+		log.Error("Failed to lock upload status", "status_file", newStatusFilePath, "error", err)
+		// Nothing tracks the new upload, which would be left behind.
+		abortErr := retry.With(noSuchUploadRetry).Do(context.Background(), func() error {
+			return ru.Store.AbortMultipartUpload(context.Background(), status.Key, uploadID)
+		})
+		if abortErr != nil {
+			log.Error("Failed to abort multipart upload", "new_upload_id", uploadID, "error", abortErr)
+		}
+		return "", nil, nil, fmt.Errorf("failed to lock upload status: %w", err)
 	}
-
-	return nil
+	status.Mu.Lock()
+	status.UploadID = uploadID
+	status.CompletedParts = make(map[int]string)
+	status.PartChecksums = make(map[int]string)
+	if key != nil {
+		status.Encryption = key.Metadata()
+	}
+	status.Mu.Unlock()
+	if err := status.SaveStatus(newStatusFilePath); err != nil {
+		log.Error("Failed to save status", "status_file", newStatusFilePath, "error", err)
+	}
+	if err := removeStatus(statusFilePath, lock); err != nil {
+		log.Error("Failed to remove status file", "status_file", statusFilePath, "error", err)
+	}
+	return newStatusFilePath, newLock, key, nil
 }
@@ -0,0 +1,163 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/yucori/Favus/internal/progress"
+)
+
+// interruptAfter uploads path to key with u and cancels the upload once
+// parts parts have completed. It returns the status file of the
+// interrupted upload.
+func interruptAfter(t *testing.T, u *S3Uploader, path, key string, parts int) string {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var once sync.Once
+	u.Progress = func(e progress.Event) {
+		if e.Parts >= parts {
+			once.Do(cancel)
+		}
+	}
+	defer func() { u.Progress = nil }()
+
+	_, err := u.UploadFile(ctx, path, key)
+	var interrupted *InterruptedError
+	if !errors.As(err, &interrupted) {
+		t.Fatalf("UploadFile returned %v, want an *InterruptedError", err)
+	}
+	return interrupted.StatusFilePath
+}
+
+// newTestResumeUploader returns a ResumeUploader sharing the store and
+// settings of u.
+func newTestResumeUploader(u *S3Uploader) *ResumeUploader {
+	ru := NewResumeUploader(u.Store, u.Config.Concurrency)
+	ru.Retry = u.Config.Retry
+	ru.StateDir = u.Config.StateDir
+	return ru
+}
+
+func TestResumeUploadAfterBadETag(t *testing.T) {
+	srv := newTestServer(t)
+	cfg := testConfig(t, srv)
+	cfg.Concurrency = 1
+	path, data := writeTestFile(t, 4*partSize5MiB)
+	u := newTestUploader(t, cfg)
+
+	srv.CorruptETag(1)
+	statusFilePath := interruptAfter(t, u, path, "data.bin", 2)
+	srv.ClearFaults()
+	status, err := LoadStatus(statusFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if eTag := status.CompletedParts[1]; eTag != `"00000000000000000000000000000000"` {
+		t.Fatalf("part 1 is recorded with ETag %s, want the corrupted one", eTag)
+	}
+
+	if _, err := newTestResumeUploader(u).ResumeUpload(context.Background(), statusFilePath); err != nil {
+		t.Fatalf("ResumeUpload: %v", err)
+	}
+	checkObject(t, srv, "data.bin", data)
+	// The ETag of part 1 is taken from the store instead of sending the
+	// part again.
+	if n := srv.CountRequests("UploadPart", 1); n != 1 {
+		t.Errorf("part 1 was sent %d times, want 1", n)
+	}
+	checkNoStatusFiles(t, cfg.StateDir)
+}
+
+func TestResumeUploadSendsRemainingParts(t *testing.T) {
+	srv := newTestServer(t)
+	cfg := testConfig(t, srv)
+	cfg.Concurrency = 1
+	path, data := writeTestFile(t, 5*partSize5MiB+10)
+	u := newTestUploader(t, cfg)
+
+	statusFilePath := interruptAfter(t, u, path, "data.bin", 2)
+	status, err := LoadStatus(statusFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.ChunkSize != partSize5MiB || status.FileSize != int64(len(data)) || status.Fingerprint == "" {
+		t.Fatalf("status records chunk size %d, file size %d and fingerprint %q", status.ChunkSize, status.FileSize, status.Fingerprint)
+	}
+
+	// The remaining parts may be sent by more workers than the upload
+	// started with.
+	ru := newTestResumeUploader(u)
+	ru.Concurrency = 3
+	result, err := ru.ResumeUpload(context.Background(), statusFilePath)
+	if err != nil {
+		t.Fatalf("ResumeUpload: %v", err)
+	}
+	checkObject(t, srv, "data.bin", data)
+	for part := 1; part <= 6; part++ {
+		if n := srv.CountRequests("UploadPart", part); n != 1 {
+			t.Errorf("part %d was sent %d times, want 1", part, n)
+		}
+	}
+	// The parts completed before the interruption are full ones.
+	if want := int64(len(data) - len(status.CompletedParts)*partSize5MiB); result.Sent != want {
+		t.Errorf("resume sent %d bytes, want %d", result.Sent, want)
+	}
+	checkNoStatusFiles(t, cfg.StateDir)
+}
+
+func TestResumeUploadRefusesChangedFile(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(t *testing.T, path string, data []byte)
+	}{
+		{"content", func(t *testing.T, path string, data []byte) {
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			changed := append([]byte{data[0] + 1}, data[1:]...)
+			if err := os.WriteFile(path, changed, 0644); err != nil {
+				t.Fatal(err)
+			}
+			// Keep the size and modification time, so that only the
+			// fingerprint tells.
+			if err := os.Chtimes(path, info.ModTime(), info.ModTime()); err != nil {
+				t.Fatal(err)
+			}
+		}},
+		{"size", func(t *testing.T, path string, data []byte) {
+			if err := os.WriteFile(path, data[:len(data)-1], 0644); err != nil {
+				t.Fatal(err)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t)
+			cfg := testConfig(t, srv)
+			cfg.Concurrency = 1
+			path, data := writeTestFile(t, 3*partSize5MiB)
+			u := newTestUploader(t, cfg)
+			statusFilePath := interruptAfter(t, u, path, "data.bin", 1)
+			sent := srv.CountRequests("UploadPart", 0)
+
+			tt.change(t, path, data)
+			if _, err := newTestResumeUploader(u).ResumeUpload(context.Background(), statusFilePath); err == nil {
+				t.Fatal("ResumeUpload succeeded although the file changed")
+			}
+			if n := srv.CountRequests("UploadPart", 0); n != sent {
+				t.Errorf("%d parts were sent for the changed file", n-sent)
+			}
+			if _, err := os.Stat(statusFilePath); err != nil {
+				t.Errorf("status file of the refused upload: %v", err)
+			}
+			if uploads := srv.Uploads(); len(uploads) != 1 {
+				t.Errorf("%d multipart uploads in progress, want the refused one", len(uploads))
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yucori/Favus/internal/config"
+	"github.com/yucori/Favus/internal/downloader"
+	"github.com/yucori/Favus/internal/s3test"
+	"github.com/yucori/Favus/internal/storage"
+	"github.com/yucori/Favus/internal/verifier"
+)
+
+// testTLSConfig returns a configuration like testConfig, but reaching srv
+// over TLS, which SSE-C requires.
+func testTLSConfig(t *testing.T, srv *s3test.Server) *config.Config {
+	t.Helper()
+	tls := httptest.NewTLSServer(srv.Server.Config.Handler)
+	t.Cleanup(tls.Close)
+	cfg := testConfig(t, srv)
+	cfg.S3Endpoint = tls.URL
+	cfg.S3InsecureSkipVerify = true
+	return cfg
+}
+
+// withSSE returns a copy of cfg encrypting objects with the given
+// server-side encryption and, for SSE-C, the key in keyFile.
+func withSSE(cfg *config.Config, sse, keyFile string) *config.Config {
+	c := *cfg
+	c.SSE = sse
+	c.SSECustomerKeyFile = keyFile
+	if sse == config.SSEKMS {
+		c.SSEKMSKeyID = "alias/favus-test"
+		c.SSEKMSContext = `{"project":"favus"}`
+	}
+	return &c
+}
+
+func TestUploadFileSSE(t *testing.T) {
+	for _, sse := range []string{config.SSES3, config.SSEKMS, config.SSEC} {
+		t.Run(sse, func(t *testing.T) {
+			srv := newTestServer(t)
+			var keyFile string
+			if sse == config.SSEC {
+				keyFile = writeKeyFile(t)
+			}
+			cfg := withSSE(testTLSConfig(t, srv), sse, keyFile)
+			path, data := writeTestFile(t, 2*partSize5MiB+3)
+			u := newTestUploader(t, cfg)
+
+			if _, err := u.UploadFile(context.Background(), path, "data.bin"); err != nil {
+				t.Fatalf("UploadFile: %v", err)
+			}
+			info, err := u.Store.HeadObject(context.Background(), "data.bin")
+			if err != nil {
+				t.Fatalf("HeadObject: %v", err)
+			}
+			if info.SSE != sse {
+				t.Errorf("object is encrypted with %q, want %q", info.SSE, sse)
+			}
+
+			out := filepath.Join(t.TempDir(), "download.bin")
+			if err := downloader.NewDownloader(cfg, u.Store).DownloadFile(context.Background(), "data.bin", out); err != nil {
+				t.Fatalf("DownloadFile: %v", err)
+			}
+			if got, err := os.ReadFile(out); err != nil || !bytes.Equal(got, data) {
+				t.Fatalf("downloaded object differs from the file (%v)", err)
+			}
+			report, err := verifier.NewVerifier(cfg, u.Store).VerifyFile(context.Background(), path, "data.bin")
+			if err != nil {
+				t.Fatalf("VerifyFile: %v", err)
+			}
+			if !report.OK() {
+				t.Errorf("verification of the object failed: %+v", report)
+			}
+		})
+	}
+}
+
+func TestSSECRequiresKey(t *testing.T) {
+	srv := newTestServer(t)
+	cfg := testTLSConfig(t, srv)
+	path, _ := writeTestFile(t, partSize5MiB)
+	u := newTestUploader(t, withSSE(cfg, config.SSEC, writeKeyFile(t)))
+	if _, err := u.UploadFile(context.Background(), path, "data.bin"); err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+
+	for name, c := range map[string]*config.Config{
+		"no key":    cfg,
+		"other key": withSSE(cfg, config.SSEC, writeKeyFile(t)),
+	} {
+		store, err := storage.NewS3StoreFromConfig(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.HeadObject(context.Background(), "data.bin"); err == nil {
+			t.Errorf("HeadObject with %s succeeded", name)
+		}
+	}
+}
+
+func TestResumeUploadSSECRequiresSameKey(t *testing.T) {
+	srv := newTestServer(t)
+	cfg := withSSE(testTLSConfig(t, srv), config.SSEC, writeKeyFile(t))
+	cfg.Concurrency = 1
+	path, data := writeTestFile(t, 3*partSize5MiB)
+	u := newTestUploader(t, cfg)
+	statusFilePath := interruptAfter(t, u, path, "data.bin", 1)
+	sent := srv.CountRequests("UploadPart", 0)
+
+	other, err := storage.NewS3StoreFromConfig(withSSE(cfg, config.SSEC, writeKeyFile(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ru := newTestResumeUploader(u)
+	ru.Store = other
+	if _, err := ru.ResumeUpload(context.Background(), statusFilePath); err == nil || !strings.Contains(err.Error(), "not with the configured key") {
+		t.Fatalf("ResumeUpload with another SSE-C key returned %v, want an error about the key", err)
+	}
+	if n := srv.CountRequests("UploadPart", 0); n != sent {
+		t.Errorf("%d parts were sent with another key", n-sent)
+	}
+
+	ru.Store = u.Store
+	if _, err := ru.ResumeUpload(context.Background(), statusFilePath); err != nil {
+		t.Fatalf("ResumeUpload: %v", err)
+	}
+	obj, _ := srv.Object("data.bin")
+	if !bytes.Equal(obj.Data, data) {
+		t.Error("resumed object differs from the file")
+	}
+}
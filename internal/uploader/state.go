@@ -0,0 +1,207 @@
+package uploader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/yucori/Favus/internal/config"
+)
+
+// statusFileExt is the extension of upload status files.
+const statusFileExt = ".upload_status"
+
+// ErrUploadLocked is returned when another process is already working on an
+// upload, as told by the lock of its status file.
+var ErrUploadLocked = errors.New("upload is in progress in another process")
+
+// statusDir returns the directory of the status files under stateDir, or
+// under config.DefaultStateDir if stateDir is empty.
+func statusDir(stateDir string) string {
+	if stateDir == "" {
+		stateDir = config.DefaultStateDir()
+	}
+	return filepath.Join(stateDir, "uploads")
+}
+
+// StatusFilePath returns the path of the status file tracking the
+// multipart upload uploadID of key to bucket, under stateDir. It is unique
+// per upload, so that files sharing a base name, or several uploads of one
+// file, never share a status file.
+func StatusFilePath(stateDir, bucket, key, uploadID string) string {
+	sum := sha256.Sum256([]byte(bucket + "\x00" + key + "\x00" + uploadID))
+	name := path.Base(key)
+	if len(name) > 64 {
+		// Cut at a rune boundary, keeping the name valid UTF-8.
+		n := 64
+		for n > 0 && !utf8.RuneStart(name[n]) {
+			n--
+		}
+		name = name[:n]
+	}
+	return filepath.Join(statusDir(stateDir), fmt.Sprintf("%s-%s%s", name, hex.EncodeToString(sum[:8]), statusFileExt))
+}
+
+// sourcePath returns filePath as recorded in a status file. It is made
+// absolute, since the status file is kept away from the file and the
+// upload may be resumed from any directory.
+func sourcePath(filePath string) string {
+	if abs, err := filepath.Abs(filePath); err == nil {
+		return abs
+	}
+	return filePath
+}
+
+// statusLock is the advisory lock of an upload, held by the process working
+// on it. It is a lock file next to the status file, since the status file
+// itself is replaced on every save.
+type statusLock struct {
+	file *os.File
+}
+
+func lockPath(statusFilePath string) string {
+	return statusFilePath + ".lock"
+}
+
+// lockStatus takes the lock of the upload tracked by statusFilePath. It
+// fails with ErrUploadLocked if another process holds it.
+//
+// The lock file is removed along with the status file by its holder, so a
+// lock taken on a file that has meanwhile been unlinked, or replaced by a
+// new one, protects nothing; the lock is then taken again on the file now
+// at the path.
+func lockStatus(statusFilePath string) (*statusLock, error) {
+	if err := os.MkdirAll(filepath.Dir(statusFilePath), 0700); err != nil {
+		return nil, err
+	}
+	for {
+		f, err := os.OpenFile(lockPath(statusFilePath), os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, err
+		}
+		if err := lockFile(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+		held, err := f.Stat()
+		if err != nil {
+			unlockFile(f)
+			f.Close()
+			return nil, err
+		}
+		current, err := os.Stat(lockPath(statusFilePath))
+		if err == nil && os.SameFile(held, current) {
+			return &statusLock{file: f}, nil
+		}
+		unlockFile(f)
+		f.Close()
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+}
+
+// unlock releases the lock, if it is still held.
+func (l *statusLock) unlock() {
+	if l.file == nil {
+		return
+	}
+	unlockFile(l.file)
+	l.file.Close()
+	l.file = nil
+}
+
+// removeStatus deletes the status file of a finished upload along with its
+// lock file, and releases the lock. The lock file is unlinked while still
+// locked, which lockStatus detects in a process that opened it just before.
+func removeStatus(statusFilePath string, lock *statusLock) error {
+	err := os.Remove(statusFilePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
+	if lock != nil {
+		os.Remove(lockPath(statusFilePath))
+		lock.unlock()
+	}
+	return err
+}
+
+// isLocked reports whether a process holds the lock of the upload tracked
+// by statusFilePath.
+func isLocked(statusFilePath string) bool {
+	f, err := os.Open(lockPath(statusFilePath))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		return errors.Is(err, ErrUploadLocked)
+	}
+	unlockFile(f)
+	return false
+}
+
+// TrackedUpload is an upload with a status file in the state directory.
+type TrackedUpload struct {
+	StatusFilePath string
+	Status         *UploadStatus // Nil if the status file cannot be read
+	Err            error         // Why the status file cannot be read
+	Updated        time.Time     // When the status was last saved
+	Active         bool          // Whether a process is working on the upload
+}
+
+// CompletedBytes returns the size of the parts already uploaded.
+func (t *TrackedUpload) CompletedBytes() int64 {
+	s := t.Status
+	if s == nil {
+		return 0
+	}
+	var n int64
+	for part := range s.CompletedParts {
+		if part == s.TotalParts {
+			n += s.FileSize - int64(s.TotalParts-1)*s.ChunkSize
+		} else {
+			n += s.ChunkSize
+		}
+	}
+	return n
+}
+
+// ListTrackedUploads returns the uploads with a status file under
+// stateDir, the most recently updated first.
+func ListTrackedUploads(stateDir string) ([]TrackedUpload, error) {
+	dir := statusDir(stateDir)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to list status files: %w", err)
+	}
+	var uploads []TrackedUpload
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), statusFileExt) || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		t := TrackedUpload{StatusFilePath: filepath.Join(dir, e.Name())}
+		if info, err := e.Info(); err == nil {
+			t.Updated = info.ModTime()
+		}
+		t.Status, t.Err = LoadStatus(t.StatusFilePath)
+		t.Active = isLocked(t.StatusFilePath)
+		uploads = append(uploads, t)
+	}
+	sort.Slice(uploads, func(i, j int) bool {
+		return uploads[i].Updated.After(uploads[j].Updated)
+	})
+	return uploads, nil
+}
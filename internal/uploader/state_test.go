@@ -0,0 +1,119 @@
+package uploader
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestStatusFilePath(t *testing.T) {
+	stateDir := t.TempDir()
+	key := "photos/" + strings.Repeat("사진", 40) + ".jpg"
+	path := StatusFilePath(stateDir, "bucket", key, "upload-1")
+
+	if filepath.Dir(path) != statusDir(stateDir) {
+		t.Errorf("status file %s is not in %s", path, statusDir(stateDir))
+	}
+	name := filepath.Base(path)
+	if !utf8.ValidString(name) {
+		t.Errorf("status file name %q is not valid UTF-8", name)
+	}
+	if !strings.HasPrefix(name, "사진") || !strings.HasSuffix(name, statusFileExt) {
+		t.Errorf("status file name %q does not start with the key's base name", name)
+	}
+	if other := StatusFilePath(stateDir, "bucket", key, "upload-2"); other == path {
+		t.Error("two uploads of the same key share a status file")
+	}
+}
+
+func TestResumeUploadLocked(t *testing.T) {
+	srv := newTestServer(t)
+	cfg := testConfig(t, srv)
+	cfg.Concurrency = 1
+	path, data := writeTestFile(t, 3*partSize5MiB)
+	u := newTestUploader(t, cfg)
+	statusFilePath := interruptAfter(t, u, path, "data.bin", 1)
+
+	// Stand in for another process working on the upload.
+	lock, err := lockStatus(statusFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploads, err := ListTrackedUploads(cfg.StateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(uploads) != 1 || !uploads[0].Active {
+		t.Errorf("tracked uploads %+v, want the locked one as active", uploads)
+	}
+	ru := newTestResumeUploader(u)
+	if _, err := ru.ResumeUpload(context.Background(), statusFilePath); !errors.Is(err, ErrUploadLocked) {
+		t.Fatalf("ResumeUpload returned %v, want ErrUploadLocked", err)
+	}
+
+	lock.unlock()
+	if _, err := ru.ResumeUpload(context.Background(), statusFilePath); err != nil {
+		t.Fatalf("ResumeUpload once unlocked: %v", err)
+	}
+	checkObject(t, srv, "data.bin", data)
+	entries, err := os.ReadDir(statusDir(cfg.StateDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		t.Errorf("file left in the state directory: %s", e.Name())
+	}
+}
+
+func TestResumeUploadMissingStatus(t *testing.T) {
+	stateDir := t.TempDir()
+	if err := os.MkdirAll(statusDir(stateDir), 0700); err != nil {
+		t.Fatal(err)
+	}
+	statusFilePath := StatusFilePath(stateDir, "bucket", "data.bin", "gone")
+
+	ru := NewResumeUploader(nil, 1)
+	if _, err := ru.ResumeUpload(context.Background(), statusFilePath); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("ResumeUpload returned %v, want an error for the missing status file", err)
+	}
+	if _, err := os.Stat(lockPath(statusFilePath)); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("lock file of the missing status file: %v", err)
+	}
+}
+
+func TestSaveStatusIsAtomic(t *testing.T) {
+	stateDir := t.TempDir()
+	statusFilePath := StatusFilePath(stateDir, "bucket", "data.bin", "upload")
+	status := NewUploadStatus("/data.bin", "bucket", "data.bin", "upload", partSize5MiB, 2, "")
+	for part := 1; part <= 2; part++ {
+		status.AddCompletedPart(part, `"etag"`, "")
+		if err := status.SaveStatus(statusFilePath); err != nil {
+			t.Fatalf("SaveStatus: %v", err)
+		}
+	}
+
+	loaded, err := LoadStatus(statusFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(loaded.CompletedParts) != 2 {
+		t.Errorf("loaded status records %d parts, want 2", len(loaded.CompletedParts))
+	}
+	// No temporary file is left next to the status file.
+	entries, err := os.ReadDir(filepath.Dir(statusFilePath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		var names []string
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		t.Errorf("files in the state directory: %v, want only the status file", names)
+	}
+}
@@ -0,0 +1,274 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yucori/Favus/internal/checksum"
+	"github.com/yucori/Favus/internal/chunker"
+	"github.com/yucori/Favus/internal/encryption"
+	"github.com/yucori/Favus/internal/etag"
+	"github.com/yucori/Favus/internal/progress"
+	"github.com/yucori/Favus/internal/storage"
+	"github.com/yucori/Favus/pkg/utils"
+)
+
+// Limits of streamed uploads. They are variables so that tests can stream
+// many parts without gigabytes of data.
+var (
+	// partsPerSizeStep is how many parts of a stream are sent before its
+	// part size doubles. Starting from 5 MiB, ten steps of 1,000 parts
+	// reach the 5 TiB object size limit within the 10,000 part limit.
+	partsPerSizeStep = 1000
+	// streamMinPartSize and streamMaxParts are the multipart limits.
+	streamMinPartSize int64 = chunker.MinPartSize
+	streamMaxParts          = chunker.MaxParts
+)
+
+// streamPartSize returns the size of part partNumber of a stream whose
+// first parts are base bytes.
+func streamPartSize(base int64, partNumber int) int64 {
+	return chunker.GrowingPartSize(base, chunker.MaxPartSize, partsPerSizeStep, partNumber)
+}
+
+// UploadStream performs a multipart upload of everything read from r until
+// EOF, such as the standard input. Its length need not be known: r is read
+// one part at a time into at most Config.Concurrency buffers, which are
+// sent in parallel, and the part size grows as the stream gets longer so
+// that it never needs more than chunker.MaxParts parts.
+//
+// A stream cannot be resumed, so the upload is aborted if it fails or ctx
+// is canceled. Parts in flight at that point get the shutdown grace period
+// to finish first.
+func (u *S3Uploader) UploadStream(ctx context.Context, r io.Reader, s3Key string) (*UploadResult, error) {
+	start := time.Now()
+	log := u.logger().With("file", "-", "key", s3Key)
+	log.Info("Starting streaming upload", "location", u.Store.Location(s3Key))
+
+	basePartSize := min(max(u.Config.ChunkSize, streamMinPartSize), chunker.MaxPartSize)
+	// The size of the first parts and the step of their growth let `favus
+	// verify` split the object into its parts again.
+	step := strconv.Itoa(partsPerSizeStep)
+	metadata := map[string]string{storage.MetaPartSize: strconv.FormatInt(basePartSize, 10), storage.MetaPartSizeStep: step}
+	var key *encryption.DataKey
+	if u.Keys != nil {
+		basePartSize = encryption.PartSize(basePartSize)
+		var err error
+		if key, err = encryption.NewDataKey(u.Keys); err != nil {
+			log.Error("Failed to create data key", "error", err)
+			return nil, err
+		}
+		metadata = key.Metadata()
+		metadata[storage.MetaPartSize] = strconv.FormatInt(encryption.EncryptedSize(basePartSize, false), 10)
+		metadata[storage.MetaPartSizeStep] = step
+	}
+	var uploadID string
+	err := u.Config.Retry.With(utils.RetryPolicy{Logger: log}).Do(ctx, func() error {
+		var err error
+		uploadID, err = u.Store.CreateMultipartUpload(ctx, s3Key, u.Config.ChecksumAlgorithm, metadata)
+		return err
+	})
+	if err != nil {
+		log.Error("Failed to initiate multipart upload", "error", err)
+		return nil, fmt.Errorf("failed to initiate multipart upload: %w", err)
+	}
+	log = log.With("upload_id", uploadID)
+	log.Info("Initiated multipart upload", "part_size", basePartSize, "encrypted", key != nil)
+
+	// The status is kept in memory only, to collect the completed parts.
+	status := NewUploadStatus("-", u.Config.S3BucketName, s3Key, uploadID, basePartSize, 0, u.Config.ChecksumAlgorithm)
+	pu := &partUploader{
+		store:    u.Store,
+		status:   status,
+		retry:    u.Config.Retry.With(utils.RetryPolicy{Logger: log}),
+		log:      log,
+		progress: progress.NewTracker(s3Key, -1, 0, u.Progress),
+		key:      key,
+	}
+	parts, bytesRead, err := pu.uploadStream(ctx, r, basePartSize, u.Config.Concurrency, u.Config.ShutdownGracePeriod)
+	if err != nil {
+		log.Error("Streaming upload failed", "error", err)
+		u.AbortMultipartUpload(s3Key, uploadID)
+		return nil, err
+	}
+	status.TotalParts = parts
+	sent := bytesRead
+	if key != nil {
+		sent = encryption.Size(bytesRead)
+	}
+
+	log.Info("Completing multipart upload", "parts", parts, "bytes", bytesRead)
+	completed, err := pu.complete(ctx)
+	if err != nil {
+		log.Error("Failed to complete multipart upload", "error", err)
+		u.AbortMultipartUpload(s3Key, uploadID)
+		return nil, fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	if err := pu.verifyCompleted(completed); err != nil {
+		log.Error("Integrity check failed", "error", err)
+		return nil, fmt.Errorf("object %s was stored but failed its integrity check: %w", s3Key, err)
+	}
+	result := &UploadResult{
+		File:     "-",
+		Key:      s3Key,
+		UploadID: uploadID,
+		Size:     bytesRead,
+		Sent:     sent,
+		Parts:    parts,
+		ETag:     etag.Normalize(completed.ETag),
+		Checksum: completed.Checksum,
+		Duration: time.Since(start),
+	}
+	log.Info("Multipart upload completed successfully", "bytes", bytesRead, "duration", result.Duration)
+	return result, nil
+}
+
+// uploadStream reads r one part at a time and uploads the parts using at
+// most concurrency buffers, returning the number of parts and bytes sent.
+func (pu *partUploader) uploadStream(ctx context.Context, r io.Reader, basePartSize int64, concurrency int, grace time.Duration) (int, int64, error) {
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	partCtx, cancel := graceContext(ctx, grace)
+	defer cancel()
+
+	// Every buffer in the pool is either being filled or being sent, so
+	// at most concurrency parts are held in memory at a time.
+	buffers := make(chan []byte, concurrency)
+	for i := 0; i < concurrency; i++ {
+		buffers <- nil
+	}
+	failed := make(chan struct{})
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			close(failed)
+		})
+	}
+
+	pu.progress.Start()
+	parts := 0
+	var total int64
+read:
+	for eof := false; !eof; {
+		partNumber := parts + 1
+		if partNumber > streamMaxParts {
+			// Fine if the stream ends right at the limit, unless the final
+			// encryption segment still has to be sent.
+			if _, err := io.ReadFull(r, make([]byte, 1)); !errors.Is(err, io.EOF) || pu.key != nil {
+				fail(fmt.Errorf("stream is longer than %d parts", streamMaxParts))
+			}
+			break
+		}
+		var buf []byte
+		select {
+		case buf = <-buffers:
+		case <-failed:
+			break read
+		case <-ctx.Done():
+			fail(ctx.Err())
+			break read
+		}
+		size := streamPartSize(basePartSize, partNumber)
+		if pu.key != nil {
+			size = encryption.PartSize(size)
+		}
+		if int64(cap(buf)) < size {
+			buf = make([]byte, size)
+		}
+		n, err := io.ReadFull(r, buf[:size])
+		switch {
+		case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+			eof = true
+		case err != nil:
+			fail(fmt.Errorf("failed to read part %d: %w", partNumber, err))
+			break read
+		}
+		if ctx.Err() != nil {
+			fail(ctx.Err())
+			break
+		}
+		// An empty stream is sent as a single empty part, so that the
+		// object is still created. An encrypted stream that ends with a
+		// part also needs one, for its final encryption segment.
+		if n == 0 && parts > 0 && pu.key == nil {
+			break
+		}
+		parts++
+		offset := total
+		total += int64(n)
+
+		wg.Add(1)
+		go func(partNumber int, buf []byte, n int, offset int64, final bool) {
+			defer wg.Done()
+			defer func() { buffers <- buf }()
+			if err := pu.uploadBuffer(ctx, partCtx, partNumber, buf[:n], offset, final); err != nil {
+				fail(err)
+			}
+		}(partNumber, buf, n, offset, eof)
+	}
+	wg.Wait()
+	pu.progress.Stop(firstErr)
+
+	return parts, total, firstErr
+}
+
+// uploadBuffer uploads data as part partNumber, retrying on failure, and
+// records its ETag. data is at offset in the stream, and final tells
+// whether it ends the stream, for encrypting it. Like uploadPart, no new
+// attempt is started once ctx is done, while the request runs with
+// partCtx.
+func (pu *partUploader) uploadBuffer(ctx, partCtx context.Context, partNumber int, data []byte, offset int64, final bool) error {
+	log := pu.log.With("part", partNumber)
+	start := time.Now()
+	var reader io.ReadSeeker = bytes.NewReader(data)
+	size := int64(len(data))
+	if pu.key != nil {
+		enc, err := pu.key.NewEncrypter(reader, offset, size, final)
+		if err != nil {
+			log.Error("Failed to encrypt part", "error", err)
+			return fmt.Errorf("failed to encrypt part %d: %w", partNumber, err)
+		}
+		reader, size = enc, enc.Size()
+	}
+	sum := storage.Checksum{Algorithm: pu.status.ChecksumAlgorithm}
+	var err error
+	if sum.Value, err = checksum.Compute(sum.Algorithm, reader); err != nil {
+		log.Error("Failed to compute checksum", "algorithm", sum.Algorithm, "error", err)
+		return fmt.Errorf("failed to compute checksum of part %d: %w", partNumber, err)
+	}
+
+	log.Debug("Uploading part", "bytes", size)
+	body := pu.progress.Reader(reader)
+	pu.progress.PartStarted()
+	var eTag string
+	err = pu.retry.With(utils.RetryPolicy{Logger: log}).Do(ctx, func() error {
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		var partErr error
+		eTag, partErr = pu.store.UploadPart(partCtx, pu.status.Key, pu.status.UploadID, partNumber, body, size, sum)
+		return partErr
+	})
+	if err != nil {
+		body.Seek(0, io.SeekStart)
+		pu.progress.PartFinished(false)
+		log.Error("Failed to upload part", "error", err)
+		return fmt.Errorf("failed to upload part %d after retries: %w", partNumber, err)
+	}
+	pu.status.AddCompletedPart(partNumber, eTag, sum.Value)
+	pu.progress.PartFinished(true)
+	log.Info("Uploaded part", "bytes", size, "etag", eTag, "duration", time.Since(start))
+	return nil
+}
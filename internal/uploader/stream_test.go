@@ -0,0 +1,150 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yucori/Favus/internal/s3test"
+	"github.com/yucori/Favus/internal/storage"
+	"github.com/yucori/Favus/internal/verifier"
+)
+
+// setStreamLimits lowers the limits of streamed uploads, and of srv, until
+// the test ends, so that streams of many parts stay small.
+func setStreamLimits(t *testing.T, srv *s3test.Server, minPartSize int64, step, maxParts int) {
+	t.Helper()
+	savedMin, savedStep, savedMax := streamMinPartSize, partsPerSizeStep, streamMaxParts
+	t.Cleanup(func() {
+		streamMinPartSize, partsPerSizeStep, streamMaxParts = savedMin, savedStep, savedMax
+	})
+	streamMinPartSize, partsPerSizeStep, streamMaxParts = minPartSize, step, maxParts
+	srv.MinPartSize = minPartSize
+}
+
+// randomData returns n random bytes.
+func randomData(t *testing.T, n int) []byte {
+	t.Helper()
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestUploadStream(t *testing.T) {
+	srv := newTestServer(t)
+	cfg := testConfig(t, srv)
+	data := randomData(t, 2*partSize5MiB+17)
+
+	result, err := newTestUploader(t, cfg).UploadStream(context.Background(), bytes.NewReader(data), "stream.bin")
+	if err != nil {
+		t.Fatalf("UploadStream: %v", err)
+	}
+	if result.Parts != 3 || result.Size != int64(len(data)) {
+		t.Errorf("got %d parts of %d bytes, want 3 parts of %d bytes", result.Parts, result.Size, len(data))
+	}
+	checkObject(t, srv, "stream.bin", data)
+	if uploads := srv.Uploads(); len(uploads) != 0 {
+		t.Errorf("%d multipart uploads left in progress", len(uploads))
+	}
+}
+
+func TestUploadStreamEmpty(t *testing.T) {
+	srv := newTestServer(t)
+	cfg := testConfig(t, srv)
+
+	result, err := newTestUploader(t, cfg).UploadStream(context.Background(), strings.NewReader(""), "empty.bin")
+	if err != nil {
+		t.Fatalf("UploadStream: %v", err)
+	}
+	if result.Parts != 1 || result.Size != 0 {
+		t.Errorf("got %d parts of %d bytes, want a single empty part", result.Parts, result.Size)
+	}
+	checkObject(t, srv, "empty.bin", nil)
+}
+
+func TestUploadStreamPartSizeGrowth(t *testing.T) {
+	const base = 1024
+	srv := newTestServer(t)
+	cfg := testConfig(t, srv)
+	cfg.ChunkSize = base
+	setStreamLimits(t, srv, base, 3, 100)
+	// Three parts of each size, the last step cut short.
+	data := randomData(t, 3*base+3*2*base+3*4*base+100)
+
+	u := newTestUploader(t, cfg)
+	result, err := u.UploadStream(context.Background(), bytes.NewReader(data), "stream.bin")
+	if err != nil {
+		t.Fatalf("UploadStream: %v", err)
+	}
+	checkObject(t, srv, "stream.bin", data)
+	obj, _ := srv.Object("stream.bin")
+	want := []int64{base, base, base, 2 * base, 2 * base, 2 * base, 4 * base, 4 * base, 4 * base, 100}
+	if fmt.Sprint(obj.PartSizes) != fmt.Sprint(want) {
+		t.Errorf("object was assembled from parts of %v bytes, want %v", obj.PartSizes, want)
+	}
+	if result.Parts != len(want) {
+		t.Errorf("result reports %d parts, want %d", result.Parts, len(want))
+	}
+	if obj.Metadata[storage.MetaPartSize] != fmt.Sprint(base) || obj.Metadata[storage.MetaPartSizeStep] != "3" {
+		t.Errorf("object metadata %v does not record the part sizes", obj.Metadata)
+	}
+
+	// The recorded growth lets the object be verified against a file.
+	path := filepath.Join(t.TempDir(), "stream.bin")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	v := verifier.NewVerifier(cfg, u.Store)
+	verified, err := v.VerifyFile(context.Background(), path, "stream.bin")
+	if err != nil {
+		t.Fatalf("VerifyFile: %v", err)
+	}
+	if !verified.OK() {
+		t.Errorf("stream does not verify: %+v", verified)
+	}
+}
+
+func TestUploadStreamPartLimit(t *testing.T) {
+	const base = 1024
+	for _, tt := range []struct {
+		name string
+		size int
+		ok   bool
+	}{
+		{"at limit", 4 * base, true},
+		{"over limit", 4*base + 1, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newTestServer(t)
+			cfg := testConfig(t, srv)
+			cfg.ChunkSize = base
+			setStreamLimits(t, srv, base, 100, 4)
+			data := randomData(t, tt.size)
+
+			_, err := newTestUploader(t, cfg).UploadStream(context.Background(), bytes.NewReader(data), "stream.bin")
+			if !tt.ok {
+				if err == nil || !strings.Contains(err.Error(), "longer than 4 parts") {
+					t.Fatalf("UploadStream returned %v, want an error about the part limit", err)
+				}
+				if _, ok := srv.Object("stream.bin"); ok {
+					t.Error("object was stored")
+				}
+				if n := srv.CountRequests("AbortMultipartUpload", 0); n != 1 {
+					t.Errorf("upload was aborted %d times, want 1", n)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("UploadStream: %v", err)
+			}
+			checkObject(t, srv, "stream.bin", data)
+		})
+	}
+}
@@ -5,36 +5,124 @@ import (
 	"fmt"
 	"os"
 	"sync"
+	"time"
+
+	"github.com/yucori/Favus/internal/checksum"
+	"github.com/yucori/Favus/pkg/utils"
 )
 
 // UploadStatus represents the status of a multipart upload.
 type UploadStatus struct {
-	FilePath       string `json:"filePath"`
-	UploadID       string `json:"uploadId"`
-	Bucket         string `json:"bucket"`
-	Key            string `json:"key"`
+	FilePath       string         `json:"filePath"`
+	UploadID       string         `json:"uploadId"`
+	Bucket         string         `json:"bucket"`
+	Key            string         `json:"key"`
+	ChunkSize      int64          `json:"chunkSize"`      // Part size used to split the file
+	FileSize       int64          `json:"fileSize"`       // Size of the file when the upload started
+	ModTime        time.Time      `json:"modTime"`        // Modification time of the file when the upload started
+	Fingerprint    string         `json:"fingerprint"`    // Content fingerprint of the file when the upload started
 	CompletedParts map[int]string `json:"completedParts"` // Map of part number to ETag
-	TotalParts     int `json:"totalParts"`
-	Mu             sync.Mutex `json:"-"` // Mutex to protect concurrent access
+	TotalParts     int            `json:"totalParts"`
+
+	ChecksumAlgorithm checksum.Algorithm `json:"checksumAlgorithm,omitempty"` // Checksum sent with every part
+	PartChecksums     map[int]string     `json:"partChecksums,omitempty"`     // Map of part number to base64 checksum
+
+	// Encryption is the metadata of the data key of an upload encrypted on
+	// the client, which holds the key wrapped by the master key.
+	Encryption map[string]string `json:"encryption,omitempty"`
+	// SSECustomerKeyMD5 is the fingerprint of the SSE-C key the parts are
+	// encrypted with by the store, which must be sent again to add parts.
+	SSECustomerKeyMD5 string `json:"sseCustomerKeyMD5,omitempty"`
+
+	Mu sync.Mutex `json:"-"` // Mutex to protect concurrent access
 }
 
 // NewUploadStatus creates a new UploadStatus.
-func NewUploadStatus(filePath, bucket, key, uploadID string, totalParts int) *UploadStatus {
+func NewUploadStatus(filePath, bucket, key, uploadID string, chunkSize int64, totalParts int, algorithm checksum.Algorithm) *UploadStatus {
 	return &UploadStatus{
-		FilePath:       filePath,
-		UploadID:       uploadID,
-		Bucket:         bucket,
-		Key:            key,
-		CompletedParts: make(map[int]string),
-		TotalParts:     totalParts,
+		FilePath:          filePath,
+		UploadID:          uploadID,
+		Bucket:            bucket,
+		Key:               key,
+		ChunkSize:         chunkSize,
+		CompletedParts:    make(map[int]string),
+		TotalParts:        totalParts,
+		ChecksumAlgorithm: algorithm,
+		PartChecksums:     make(map[int]string),
 	}
 }
 
-// AddCompletedPart adds a completed part to the status.
-func (us *UploadStatus) AddCompletedPart(partNumber int, eTag string) {
+// RecordSource records the size, modification time and fingerprint of the
+// file being uploaded so that a later resume can detect changes to it.
+func (us *UploadStatus) RecordSource() error {
+	fileInfo, err := os.Stat(us.FilePath)
+	if err != nil {
+		return fmt.Errorf("failed to get file info: %w", err)
+	}
+	fingerprint, err := FileFingerprint(us.FilePath)
+	if err != nil {
+		return err
+	}
+
+	us.Mu.Lock()
+	defer us.Mu.Unlock()
+	us.FileSize = fileInfo.Size()
+	us.ModTime = fileInfo.ModTime()
+	us.Fingerprint = fingerprint
+	return nil
+}
+
+// CheckSource returns an error if the file no longer matches the size,
+// modification time or fingerprint recorded when the upload started.
+func (us *UploadStatus) CheckSource() error {
+	if us.ChunkSize <= 0 || us.Fingerprint == "" {
+		return fmt.Errorf("upload status for %s does not record the chunk size and file fingerprint; cannot resume safely", us.FilePath)
+	}
+
+	fileInfo, err := os.Stat(us.FilePath)
+	if err != nil {
+		return fmt.Errorf("failed to get file info: %w", err)
+	}
+	if fileInfo.Size() != us.FileSize {
+		return fmt.Errorf("file %s has changed since the upload started: size is %d bytes, expected %d", us.FilePath, fileInfo.Size(), us.FileSize)
+	}
+	if !fileInfo.ModTime().Equal(us.ModTime) {
+		return fmt.Errorf("file %s has changed since the upload started: modified at %s, expected %s", us.FilePath, fileInfo.ModTime().Format(time.RFC3339Nano), us.ModTime.Format(time.RFC3339Nano))
+	}
+
+	fingerprint, err := FileFingerprint(us.FilePath)
+	if err != nil {
+		return err
+	}
+	if fingerprint != us.Fingerprint {
+		return fmt.Errorf("file %s has changed since the upload started: content fingerprint does not match", us.FilePath)
+	}
+	return nil
+}
+
+// CheckCustomerKey returns an error if keyMD5, the fingerprint of the SSE-C
+// key that would be sent with the remaining parts, is not that of the key
+// the upload started with.
+func (us *UploadStatus) CheckCustomerKey(keyMD5 string) error {
+	switch {
+	case keyMD5 == us.SSECustomerKeyMD5:
+		return nil
+	case us.SSECustomerKeyMD5 == "":
+		return fmt.Errorf("upload of %s was started without SSE-C, but an SSE-C key is configured", us.FilePath)
+	case keyMD5 == "":
+		return fmt.Errorf("upload of %s was started with SSE-C key %s, but no SSE-C key is configured", us.FilePath, us.SSECustomerKeyMD5)
+	}
+	return fmt.Errorf("upload of %s was started with SSE-C key %s, not with the configured key %s", us.FilePath, us.SSECustomerKeyMD5, keyMD5)
+}
+
+// AddCompletedPart adds a completed part and its checksum, if any, to the status.
+func (us *UploadStatus) AddCompletedPart(partNumber int, eTag, sum string) {
 	us.Mu.Lock()
 	defer us.Mu.Unlock()
 	us.CompletedParts[partNumber] = eTag
+	if sum != "" {
+		us.PartChecksums[partNumber] = sum
+	}
 }
 
 // IsPartCompleted checks if a part has been completed.
@@ -45,7 +133,7 @@ func (us *UploadStatus) IsPartCompleted(partNumber int) bool {
 	return exists
 }
 
-// SaveStatus saves the current upload status to a file.
+// SaveStatus saves the current upload status to a file, atomically.
 func (us *UploadStatus) SaveStatus(statusFilePath string) error {
 	us.Mu.Lock()
 	defer us.Mu.Unlock()
@@ -54,7 +142,7 @@ func (us *UploadStatus) SaveStatus(statusFilePath string) error {
 	if err != nil {
 		return fmt.Errorf("failed to marshal upload status: %w", err)
 	}
-	return os.WriteFile(statusFilePath, data, 0644)
+	return utils.WriteFileAtomic(statusFilePath, data)
 }
 
 // LoadStatus loads an upload status from a file.
@@ -68,5 +156,8 @@ func LoadStatus(statusFilePath string) (*UploadStatus, error) {
 		return nil, fmt.Errorf("failed to unmarshal upload status: %w", err)
 	}
 	us.Mu = sync.Mutex{} // Initialize mutex after unmarshaling
+	if us.PartChecksums == nil {
+		us.PartChecksums = make(map[int]string)
+	}
 	return &us, nil
-}
\ No newline at end of file
+}
@@ -1,196 +1,299 @@
 package uploader
 
 import (
+	"context"
 	"fmt"
+	"maps"
 	"os"
-	"path/filepath"
+	"strconv"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/yucori/Favus/internal/chunker"
 	"github.com/yucori/Favus/internal/config"
+	"github.com/yucori/Favus/internal/encryption"
+	"github.com/yucori/Favus/internal/etag"
+	"github.com/yucori/Favus/internal/progress"
+	"github.com/yucori/Favus/internal/storage"
 	"github.com/yucori/Favus/pkg/utils" // utils 패키지 임포트 유지
 )
 
-// S3Uploader handles file uploads and deletions to S3.
+// S3Uploader handles file uploads and deletions to an object store.
 type S3Uploader struct {
-	S3Client *s3.S3
-	Config   *config.Config
-	// Logger 필드 제거: utils 패키지 함수를 직접 호출하므로 더 이상 필요 없음
+	Store  storage.ObjectStore
+	Config *config.Config
+	// Logger receives the uploader's log records. The package logger of
+	// pkg/utils is used when nil.
+	Logger *utils.Logger
+	// Progress receives progress events of every upload while its parts
+	// are sent. No progress is reported when nil.
+	Progress progress.Func
+	// Keys, if set, encrypts every upload on the client under a new data
+	// key wrapped by it; see package encryption.
+	Keys encryption.KeyProvider
 }
 
-// NewS3Uploader creates a new S3Uploader instance.
-func NewS3Uploader(cfg *config.Config) (*S3Uploader, error) { // logger 인자 제거
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(cfg.AwsRegion),
-	})
+// NewS3Uploader creates a new S3Uploader instance using the storage backend
+// selected in cfg.
+func NewS3Uploader(cfg *config.Config) (*S3Uploader, error) {
+	store, err := storage.NewFromConfig(cfg)
 	if err != nil {
 		// utils.Fatal 대신 utils.Error를 사용하여 오류를 반환하고,
 		// 호출하는 main 함수에서 Fatal 처리하도록 하는 것이 더 유연합니다.
-		utils.Error("Failed to create AWS session: %v", err)
-		return nil, fmt.Errorf("failed to create AWS session: %w", err)
+		utils.Error("Failed to create object store: %v", err)
+		return nil, fmt.Errorf("failed to create object store: %w", err)
 	}
+	return NewUploaderWithStore(cfg, store), nil
+}
+
+// NewUploaderWithStore creates a new S3Uploader that uses store.
+func NewUploaderWithStore(cfg *config.Config, store storage.ObjectStore) *S3Uploader {
 	return &S3Uploader{
-		S3Client: s3.New(sess),
-		Config:   cfg,
-	}, nil
+		Store:  store,
+		Config: cfg,
+	}
+}
+
+func (u *S3Uploader) logger() *utils.Logger {
+	if u.Logger != nil {
+		return u.Logger
+	}
+	return utils.Default()
+}
+
+// UploadResult describes a completed multipart upload.
+type UploadResult struct {
+	File     string // Local file, or "-" for a stream
+	Key      string
+	UploadID string
+	Size     int64 // Size of the object
+	Sent     int64 // Bytes sent by this call, less than Size for a resumed upload
+	Parts    int
+	ETag     string // Without quotes
+	Checksum string // Composite checksum reported by the store, if the upload uses one
+	Duration time.Duration
+}
+
+// Throughput returns the average bytes sent per second.
+func (r *UploadResult) Throughput() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.Sent) / r.Duration.Seconds()
 }
 
-// UploadFile performs a multipart upload of a file to S3.
-func (u *S3Uploader) UploadFile(filePath, s3Key string) error {
-	utils.Info("Starting multipart upload for file: %s to s3://%s/%s", filePath, u.Config.S3BucketName, s3Key)
+// UploadFile performs a multipart upload of a file to the object store.
+//
+// If ctx is canceled while parts are being uploaded, no new part is
+// started and the parts in flight get the configured shutdown grace period
+// to finish. The upload is then left in place and an *InterruptedError
+// naming its status file is returned.
+func (u *S3Uploader) UploadFile(ctx context.Context, filePath, s3Key string) (*UploadResult, error) {
+	start := time.Now()
+	log := u.logger().With("file", filePath, "key", s3Key)
+	log.Info("Starting multipart upload", "location", u.Store.Location(s3Key))
 
 	fileInfo, err := os.Stat(filePath)
 	if err != nil {
-		utils.Error("Failed to get file info for %s: %v", filePath, err)
-		return fmt.Errorf("failed to get file info: %w", err)
+		log.Error("Failed to get file info", "error", err)
+		return nil, fmt.Errorf("failed to get file info: %w", err)
 	}
 
 	if fileInfo.Size() == 0 {
-		utils.Error("Cannot upload empty file: %s", filePath)
-		return fmt.Errorf("cannot upload empty file: %s", filePath)
+		log.Error("Cannot upload empty file")
+		return nil, fmt.Errorf("cannot upload empty file: %s", filePath)
 	}
 
 	// config에서 청크 사이즈를 가져옵니다.
-	fileChunker, err := chunker.NewFileChunker(filePath, u.Config.ChunkSize)
+	chunkSize := u.Config.ChunkSize
+	var key *encryption.DataKey
+	if u.Keys != nil {
+		// Parts must hold whole encryption segments.
+		chunkSize = encryption.PartSize(chunkSize)
+		if key, err = encryption.NewDataKey(u.Keys); err != nil {
+			log.Error("Failed to create data key", "error", err)
+			return nil, err
+		}
+	}
+	fileChunker, err := chunker.NewFileChunker(filePath, chunkSize)
 	if err != nil {
-		utils.Error("Failed to create file chunker: %v", err)
-		return fmt.Errorf("failed to create file chunker: %w", err)
+		log.Error("Failed to create file chunker", "error", err)
+		return nil, fmt.Errorf("failed to create file chunker: %w", err)
+	}
+	if reason := fileChunker.Adjustment(); reason != "" {
+		log.Info("Adjusted part size to respect multipart upload limits", "requested", u.Config.ChunkSize, "part_size", fileChunker.ChunkSize(), "reason", reason)
 	}
 	chunks := fileChunker.Chunks()
+	var sentSize int64
+	for _, ch := range chunks {
+		sentSize += partSize(fileChunker, ch, key)
+	}
 
 	// 1. Initiate Multipart Upload
-	initiateOutput, err := u.S3Client.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
-		Bucket: aws.String(u.Config.S3BucketName),
-		Key:    aws.String(s3Key),
+	metadata := uploadMetadata(fileInfo, fileChunker.ChunkSize(), key)
+	var uploadID string
+	err = u.Config.Retry.With(utils.RetryPolicy{Logger: log}).Do(ctx, func() error {
+		var err error
+		uploadID, err = u.Store.CreateMultipartUpload(ctx, s3Key, u.Config.ChecksumAlgorithm, metadata)
+		return err
 	})
 	if err != nil {
-		utils.Error("Failed to initiate multipart upload for %s: %v", s3Key, err)
-		return fmt.Errorf("failed to initiate multipart upload: %w", err)
+		log.Error("Failed to initiate multipart upload", "error", err)
+		return nil, fmt.Errorf("failed to initiate multipart upload: %w", err)
 	}
-	uploadID := *initiateOutput.UploadId
-	utils.Info("Initiated multipart upload with UploadID: %s", uploadID)
+	log = log.With("upload_id", uploadID)
+	log.Info("Initiated multipart upload", "parts", len(chunks), "bytes", fileInfo.Size(), "encrypted", key != nil)
 
 	// Create a status tracker
-	statusFilePath := filepath.Join(os.TempDir(), fmt.Sprintf("%s.upload_status", filepath.Base(filePath)))
-	status := NewUploadStatus(filePath, u.Config.S3BucketName, s3Key, uploadID, len(chunks))
-
-	var completedParts []*s3.CompletedPart
-	for _, ch := range chunks {
-		reader, err := fileChunker.GetChunkReader(ch)
-		if err != nil {
-			utils.Error("Failed to get chunk reader for part %d: %v", ch.Index, err)
-			u.AbortMultipartUpload(s3Key, uploadID) // Abort upload on critical error
-			return fmt.Errorf("failed to get chunk reader for part %d: %w", ch.Index, err)
-		}
-
-		utils.Info("Uploading part %d (offset %d, size %d) for file %s", ch.Index, ch.Offset, ch.Size, filePath)
-
-		var uploadOutput *s3.UploadPartOutput
-		err = utils.Retry(5, 2*time.Second, func() error {
-			var partErr error
-			uploadOutput, partErr = u.S3Client.UploadPart(&s3.UploadPartInput{
-				Body:          aws.ReadSeekCloser(reader),
-				Bucket:        aws.String(u.Config.S3BucketName),
-				Key:           aws.String(s3Key),
-				PartNumber:    aws.Int64(int64(ch.Index)),
-				UploadId:      aws.String(uploadID),
-				ContentLength: aws.Int64(ch.Size),
-			})
-			if partErr != nil {
-				utils.Error("Failed to upload part %d: %v", ch.Index, partErr)
-				return partErr
-			}
-			return nil
-		})
-
-		if err != nil {
-			utils.Error("Failed to upload part %d after retries: %v", ch.Index, err)
-			u.AbortMultipartUpload(s3Key, uploadID)
-			return fmt.Errorf("failed to upload part %d after retries: %w", ch.Index, err)
-		}
+	statusFilePath := StatusFilePath(u.Config.StateDir, u.Config.S3BucketName, s3Key, uploadID)
+	status := NewUploadStatus(sourcePath(filePath), u.Config.S3BucketName, s3Key, uploadID, fileChunker.ChunkSize(), len(chunks), u.Config.ChecksumAlgorithm)
+	if key != nil {
+		status.Encryption = key.Metadata()
+	}
+	status.SSECustomerKeyMD5 = storage.CustomerKeyMD5(u.Store)
+	if err := status.RecordSource(); err != nil {
+		log.Error("Failed to record source file state", "error", err)
+		u.AbortMultipartUpload(s3Key, uploadID)
+		return nil, fmt.Errorf("failed to record source file state: %w", err)
+	}
+	// The lock of a new upload is free, but taking it keeps `favus resume`
+	// from working on the upload while this process does.
+	lock, err := lockStatus(statusFilePath)
+	if err != nil {
+		log.Error("Failed to lock upload status", "status_file", statusFilePath, "error", err)
+		u.AbortMultipartUpload(s3Key, uploadID)
+		return nil, fmt.Errorf("failed to lock upload status: %w", err)
+	}
+	defer lock.unlock()
+	if err := status.SaveStatus(statusFilePath); err != nil {
+		log.Error("Failed to save initial status", "status_file", statusFilePath, "error", err)
+		// Non-fatal, but log it
+	}
 
-		status.AddCompletedPart(ch.Index, *uploadOutput.ETag)
-		if err := status.SaveStatus(statusFilePath); err != nil {
-			utils.Error("Failed to save status after completing part %d: %v", ch.Index, err)
-			// Non-fatal, but log it
+	// 2. Upload parts in parallel
+	pu := &partUploader{
+		store:          u.Store,
+		chunker:        fileChunker,
+		status:         status,
+		statusFilePath: statusFilePath,
+		retry:          u.Config.Retry.With(utils.RetryPolicy{Logger: log}),
+		log:            log,
+		progress:       progress.NewTracker(s3Key, sentSize, len(chunks), u.Progress),
+		key:            key,
+	}
+	if err := pu.uploadChunks(ctx, chunks, u.Config.Concurrency, u.Config.ShutdownGracePeriod); err != nil {
+		if interrupted := pu.interrupted(ctx); interrupted != nil {
+			return nil, interrupted
 		}
-
-		completedParts = append(completedParts, &s3.CompletedPart{
-			PartNumber: aws.Int64(int64(ch.Index)),
-			ETag:       uploadOutput.ETag,
-		})
-		utils.Info("Successfully uploaded part %d. ETag: %s", ch.Index, *uploadOutput.ETag)
+		u.AbortMultipartUpload(s3Key, uploadID)
+		removeStatus(statusFilePath, lock)
+		return nil, err
 	}
 
 	// 3. Complete Multipart Upload
-	utils.Info("Completing multipart upload for file: %s", filePath)
-	_, err = u.S3Client.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
-		Bucket:   aws.String(u.Config.S3BucketName),
-		Key:      aws.String(s3Key),
-		UploadId: aws.String(uploadID),
-		MultipartUpload: &s3.CompletedMultipartUpload{
-			Parts: completedParts,
-		},
-	})
+	// Every part is uploaded at this point, so finish even if interrupted.
+	log.Info("Completing multipart upload")
+	completed, err := pu.complete(ctx)
 	if err != nil {
-		utils.Error("Failed to complete multipart upload: %v", err)
+		log.Error("Failed to complete multipart upload", "error", err)
 		u.AbortMultipartUpload(s3Key, uploadID)
-		return fmt.Errorf("failed to complete multipart upload: %w", err)
+		removeStatus(statusFilePath, lock)
+		return nil, fmt.Errorf("failed to complete multipart upload: %w", err)
+	}
+	if err := pu.verifyCompleted(completed); err != nil {
+		log.Error("Integrity check failed", "error", err)
+		// The upload is complete and cannot be resumed any more.
+		if err := removeStatus(statusFilePath, lock); err != nil {
+			log.Error("Failed to remove status file", "status_file", statusFilePath, "error", err)
+		}
+		return nil, fmt.Errorf("object %s was stored but failed its integrity check: %w", s3Key, err)
 	}
 
-	utils.Info("Multipart upload completed successfully for %s", filePath)
+	result := &UploadResult{
+		File:     filePath,
+		Key:      s3Key,
+		UploadID: uploadID,
+		Size:     fileInfo.Size(),
+		Sent:     sentSize,
+		Parts:    len(chunks),
+		ETag:     etag.Normalize(completed.ETag),
+		Checksum: completed.Checksum,
+		Duration: time.Since(start),
+	}
+	log.Info("Multipart upload completed successfully", "bytes", result.Size, "duration", result.Duration)
 
 	// Clean up status file
-	if err := os.Remove(statusFilePath); err != nil {
-		utils.Error("Failed to remove status file %s: %v", statusFilePath, err)
+	if err := removeStatus(statusFilePath, lock); err != nil {
+		log.Error("Failed to remove status file", "status_file", statusFilePath, "error", err)
 	}
 
-	return nil
+	return result, nil
+}
+
+// objectMetadata returns the user metadata recorded on an object uploaded
+// from a file with the given info.
+func objectMetadata(fileInfo os.FileInfo) map[string]string {
+	return map[string]string{
+		storage.MetaMtime: fileInfo.ModTime().UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// uploadMetadata returns the user metadata recorded on an object uploaded
+// from a file with the given info in parts of partSize bytes, encrypted
+// with key unless it is nil. The part size is recorded so that `favus
+// verify` can recompute the ETag of the object later; like the ETag, it is
+// that of the ciphertext of an encrypted object.
+func uploadMetadata(fileInfo os.FileInfo, partSize int64, key *encryption.DataKey) map[string]string {
+	metadata := objectMetadata(fileInfo)
+	if key != nil {
+		partSize = encryption.EncryptedSize(partSize, false)
+		maps.Copy(metadata, key.Metadata())
+	}
+	metadata[storage.MetaPartSize] = strconv.FormatInt(partSize, 10)
+	return metadata
 }
 
-// DeleteFile deletes a file from S3.
+// DeleteFile deletes a file from the object store.
 func (u *S3Uploader) DeleteFile(s3Key string) error {
-	utils.Info("Deleting file s3://%s/%s", u.Config.S3BucketName, s3Key)
-	_, err := u.S3Client.DeleteObject(&s3.DeleteObjectInput{
-		Bucket: aws.String(u.Config.S3BucketName),
-		Key:    aws.String(s3Key),
+	log := u.logger().With("key", s3Key)
+	log.Info("Deleting file", "location", u.Store.Location(s3Key))
+	err := u.Config.Retry.With(utils.RetryPolicy{Logger: log}).Do(context.Background(), func() error {
+		return u.Store.DeleteObject(context.Background(), s3Key)
 	})
 	if err != nil {
-		utils.Error("Failed to delete file %s from S3: %v", s3Key, err)
-		return fmt.Errorf("failed to delete file %s from S3: %w", s3Key, err)
+		log.Error("Failed to delete file", "error", err)
+		return fmt.Errorf("failed to delete file %s: %w", s3Key, err)
 	}
-	utils.Info("Successfully deleted file s3://%s/%s", u.Config.S3BucketName, s3Key)
+	log.Info("Successfully deleted file")
 	return nil
 }
 
 // AbortMultipartUpload aborts an ongoing multipart upload.
 func (u *S3Uploader) AbortMultipartUpload(s3Key, uploadID string) error {
-	utils.Info("Aborting multipart upload for key: %s, UploadID: %s", s3Key, uploadID)
-	_, err := u.S3Client.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
-		Bucket:   aws.String(u.Config.S3BucketName),
-		Key:      aws.String(s3Key),
-		UploadId: aws.String(uploadID),
+	log := u.logger().With("key", s3Key, "upload_id", uploadID)
+	log.Info("Aborting multipart upload")
+	err := u.Config.Retry.With(utils.RetryPolicy{Logger: log}).With(noSuchUploadRetry).Do(context.Background(), func() error {
+		return u.Store.AbortMultipartUpload(context.Background(), s3Key, uploadID)
 	})
 	if err != nil {
-		utils.Error("Failed to abort multipart upload: %v", err)
+		log.Error("Failed to abort multipart upload", "error", err)
 		return fmt.Errorf("failed to abort multipart upload: %w", err)
 	}
-	utils.Info("Multipart upload aborted successfully for key: %s, UploadID: %s", s3Key, uploadID)
+	log.Info("Multipart upload aborted successfully")
 	return nil
 }
 
-// ListMultipartUploads lists all ongoing multipart uploads for the bucket.
-func (u *S3Uploader) ListMultipartUploads() ([]*s3.MultipartUpload, error) {
-	utils.Info("Listing ongoing multipart uploads for bucket: %s", u.Config.S3BucketName)
-	output, err := u.S3Client.ListMultipartUploads(&s3.ListMultipartUploadsInput{
-		Bucket: aws.String(u.Config.S3BucketName),
+// ListMultipartUploads lists all ongoing multipart uploads in the store.
+func (u *S3Uploader) ListMultipartUploads() ([]storage.MultipartUpload, error) {
+	u.logger().Info("Listing ongoing multipart uploads", "location", u.Store.Location(""))
+	var uploads []storage.MultipartUpload
+	err := u.Config.Retry.With(utils.RetryPolicy{Logger: u.logger()}).Do(context.Background(), func() error {
+		var err error
+		uploads, err = u.Store.ListMultipartUploads(context.Background(), "")
+		return err
 	})
 	if err != nil {
-		utils.Error("Failed to list multipart uploads: %v", err)
+		u.logger().Error("Failed to list multipart uploads", "error", err)
 		return nil, fmt.Errorf("failed to list multipart uploads: %w", err)
 	}
-	return output.Uploads, nil
+	return uploads, nil
 }
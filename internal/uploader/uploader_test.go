@@ -0,0 +1,254 @@
+package uploader
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yucori/Favus/internal/checksum"
+	"github.com/yucori/Favus/internal/config"
+	"github.com/yucori/Favus/internal/s3test"
+	"github.com/yucori/Favus/internal/storage"
+	"github.com/yucori/Favus/pkg/utils"
+)
+
+const partSize5MiB = 5 * 1024 * 1024
+
+// newTestServer starts a fake S3 server that is closed when the test ends.
+func newTestServer(t *testing.T) *s3test.Server {
+	t.Helper()
+	srv := s3test.NewServer("favus-test")
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// testConfig returns a configuration pointing at srv, with parts of 5 MiB,
+// status files in a temporary directory and retries without waits.
+func testConfig(t *testing.T, srv *s3test.Server) *config.Config {
+	t.Helper()
+	cfg := srv.Config()
+	cfg.ChunkSize = partSize5MiB
+	cfg.StateDir = t.TempDir()
+	cfg.Retry = utils.RetryPolicy{InitialInterval: time.Millisecond, MaxInterval: time.Millisecond}
+	return cfg
+}
+
+// writeTestFile writes size random bytes to a new file and returns its
+// path and content.
+func writeTestFile(t *testing.T, size int) (string, []byte) {
+	t.Helper()
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path, data
+}
+
+// newTestUploader returns an S3Uploader for cfg, failing the test if it
+// cannot be created.
+func newTestUploader(t *testing.T, cfg *config.Config) *S3Uploader {
+	t.Helper()
+	u, err := NewS3Uploader(cfg)
+	if err != nil {
+		t.Fatalf("NewS3Uploader: %v", err)
+	}
+	return u
+}
+
+// checkObject fails the test unless srv holds want under key.
+func checkObject(t *testing.T, srv *s3test.Server, key string, want []byte) {
+	t.Helper()
+	obj, ok := srv.Object(key)
+	if !ok {
+		t.Fatalf("object %s was not stored", key)
+	}
+	if !bytes.Equal(obj.Data, want) {
+		t.Fatalf("object %s has %d bytes that differ from the %d bytes of the file", key, len(obj.Data), len(want))
+	}
+}
+
+// checkNoStatusFiles fails the test if status files are left in stateDir.
+func checkNoStatusFiles(t *testing.T, stateDir string) {
+	t.Helper()
+	uploads, err := ListTrackedUploads(stateDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, u := range uploads {
+		t.Errorf("status file left behind: %s", u.StatusFilePath)
+	}
+}
+
+func TestUploadFile(t *testing.T) {
+	srv := newTestServer(t)
+	cfg := testConfig(t, srv)
+	path, data := writeTestFile(t, 3*partSize5MiB+123)
+
+	result, err := newTestUploader(t, cfg).UploadFile(context.Background(), path, "dir/data.bin")
+	if err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	if result.Parts != 4 || result.Size != int64(len(data)) {
+		t.Errorf("got %d parts of %d bytes, want 4 parts of %d bytes", result.Parts, result.Size, len(data))
+	}
+	checkObject(t, srv, "dir/data.bin", data)
+	if uploads := srv.Uploads(); len(uploads) != 0 {
+		t.Errorf("%d multipart uploads left in progress", len(uploads))
+	}
+	checkNoStatusFiles(t, cfg.StateDir)
+}
+
+func TestUploadFileRetriesFailedPart(t *testing.T) {
+	srv := newTestServer(t)
+	cfg := testConfig(t, srv)
+	path, data := writeTestFile(t, 3*partSize5MiB)
+	srv.FailPart(2, 2)
+
+	if _, err := newTestUploader(t, cfg).UploadFile(context.Background(), path, "data.bin"); err != nil {
+		t.Fatalf("UploadFile: %v", err)
+	}
+	checkObject(t, srv, "data.bin", data)
+	if n := srv.CountRequests("UploadPart", 2); n != 3 {
+		t.Errorf("part 2 was sent %d times, want 3", n)
+	}
+	if n := srv.CountRequests("UploadPart", 1); n != 1 {
+		t.Errorf("part 1 was sent %d times, want 1", n)
+	}
+}
+
+func TestUploadFileAbortsWhenPartFails(t *testing.T) {
+	srv := newTestServer(t)
+	cfg := testConfig(t, srv)
+	cfg.Retry.MaxAttempts = 3
+	path, _ := writeTestFile(t, 3*partSize5MiB)
+	srv.FailPart(2, -1)
+
+	if _, err := newTestUploader(t, cfg).UploadFile(context.Background(), path, "data.bin"); err == nil {
+		t.Fatal("UploadFile succeeded although part 2 always fails")
+	}
+	if n := srv.CountRequests("UploadPart", 2); n != cfg.Retry.MaxAttempts {
+		t.Errorf("part 2 was sent %d times, want %d", n, cfg.Retry.MaxAttempts)
+	}
+	if n := srv.CountRequests("AbortMultipartUpload", 0); n != 1 {
+		t.Errorf("upload was aborted %d times, want 1", n)
+	}
+	if uploads := srv.Uploads(); len(uploads) != 0 {
+		t.Errorf("%d multipart uploads left in progress", len(uploads))
+	}
+	checkNoStatusFiles(t, cfg.StateDir)
+}
+
+func TestUploadFileAbortsWhenCompleteFails(t *testing.T) {
+	srv := newTestServer(t)
+	cfg := testConfig(t, srv)
+	path, _ := writeTestFile(t, 2*partSize5MiB)
+	srv.InjectFault(s3test.Fault{
+		Operation: "CompleteMultipartUpload",
+		Status:    http.StatusInternalServerError,
+		Code:      "InternalError",
+		Times:     -1,
+	})
+
+	if _, err := newTestUploader(t, cfg).UploadFile(context.Background(), path, "data.bin"); err == nil {
+		t.Fatal("UploadFile succeeded although completing the upload always fails")
+	}
+	if n := srv.CountRequests("CompleteMultipartUpload", 0); n != completeRetry.MaxAttempts {
+		t.Errorf("completing was attempted %d times, want %d", n, completeRetry.MaxAttempts)
+	}
+	if n := srv.CountRequests("AbortMultipartUpload", 0); n != 1 {
+		t.Errorf("upload was aborted %d times, want 1", n)
+	}
+	if _, ok := srv.Object("data.bin"); ok {
+		t.Error("object was stored")
+	}
+	if uploads := srv.Uploads(); len(uploads) != 0 {
+		t.Errorf("%d multipart uploads left in progress", len(uploads))
+	}
+	checkNoStatusFiles(t, cfg.StateDir)
+}
+
+// wrongETagStore reports a wrong ETag for every completed upload.
+type wrongETagStore struct {
+	storage.ObjectStore
+}
+
+func (s wrongETagStore) CompleteMultipartUpload(ctx context.Context, key, uploadID string, parts []storage.CompletedPart) (storage.CompletedObject, error) {
+	obj, err := s.ObjectStore.CompleteMultipartUpload(ctx, key, uploadID, parts)
+	obj.ETag = `"00000000000000000000000000000000-2"`
+	return obj, err
+}
+
+func TestUploadFileFailsVerification(t *testing.T) {
+	srv := newTestServer(t)
+	cfg := testConfig(t, srv)
+	cfg.ChecksumAlgorithm = checksum.MD5
+	path, _ := writeTestFile(t, 2*partSize5MiB)
+
+	_, err := NewUploaderWithStore(cfg, wrongETagStore{srv.Store()}).UploadFile(context.Background(), path, "data.bin")
+	if err == nil {
+		t.Fatal("UploadFile succeeded although the ETag of the object is wrong")
+	}
+	if !strings.Contains(err.Error(), "was stored") {
+		t.Errorf("error %q does not say that the object was stored", err)
+	}
+	if _, ok := srv.Object("data.bin"); !ok {
+		t.Error("object was not stored")
+	}
+	checkNoStatusFiles(t, cfg.StateDir)
+}
+
+func TestUploadFileInterruptedUnderLatency(t *testing.T) {
+	srv := newTestServer(t)
+	cfg := testConfig(t, srv)
+	cfg.Concurrency = 2
+	cfg.ShutdownGracePeriod = 5 * time.Second
+	path, _ := writeTestFile(t, 5*partSize5MiB)
+	srv.SetLatency("UploadPart", 300*time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(100*time.Millisecond, cancel)
+	start := time.Now()
+	_, err := newTestUploader(t, cfg).UploadFile(ctx, path, "data.bin")
+	elapsed := time.Since(start)
+
+	var interrupted *InterruptedError
+	if !errors.As(err, &interrupted) {
+		t.Fatalf("UploadFile returned %v, want an *InterruptedError", err)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v does not wrap context.Canceled", err)
+	}
+	// The two parts in flight finish within the grace period, and no
+	// other part is started.
+	if elapsed > 2*time.Second {
+		t.Errorf("UploadFile took %v to return after being canceled", elapsed)
+	}
+	if n := srv.CountRequests("UploadPart", 0); n != 2 {
+		t.Errorf("%d parts were sent, want the 2 in flight", n)
+	}
+	status, err := LoadStatus(interrupted.StatusFilePath)
+	if err != nil {
+		t.Fatalf("status of the interrupted upload: %v", err)
+	}
+	if len(status.CompletedParts) != 2 {
+		t.Errorf("status records %d completed parts, want 2", len(status.CompletedParts))
+	}
+	if n := srv.CountRequests("AbortMultipartUpload", 0); n != 0 {
+		t.Error("interrupted upload was aborted")
+	}
+	if uploads := srv.Uploads(); len(uploads) != 1 || uploads[0].UploadID != status.UploadID {
+		t.Errorf("multipart uploads in progress: %v, want only %s", uploads, status.UploadID)
+	}
+}
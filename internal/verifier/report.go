@@ -0,0 +1,60 @@
+package verifier
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+)
+
+// WriteReport writes a human readable report of the comparison to w,
+// including a table of the parts that differ.
+func (r *Result) WriteReport(w io.Writer) error {
+	status := "OK"
+	if !r.OK() {
+		status = "MISMATCH"
+	}
+	fmt.Fprintf(w, "Verify %s: %s\n", status, r.LocalPath)
+	fmt.Fprintf(w, "  local:    %s (%d bytes)\n", r.LocalPath, r.LocalSize)
+	fmt.Fprintf(w, "  remote:   %s (%d bytes)\n", r.Location, r.RemoteSize)
+	if r.PartSize > 0 {
+		fmt.Fprintf(w, "  part size: %d bytes\n", r.PartSize)
+	}
+	if r.LocalETag != "" {
+		fmt.Fprintf(w, "  ETag:     local %s, remote %s\n", r.LocalETag, r.RemoteETag)
+	}
+	if r.RemoteChecksum != "" {
+		fmt.Fprintf(w, "  %s: local %s, remote %s\n", r.ChecksumAlgorithm, r.LocalChecksum, r.RemoteChecksum)
+	}
+	for _, n := range r.Notes {
+		fmt.Fprintf(w, "  note: %s\n", n)
+	}
+	for _, m := range r.Mismatches {
+		fmt.Fprintf(w, "  mismatch: %s\n", m)
+	}
+	if len(r.Parts) == 0 {
+		return nil
+	}
+
+	fmt.Fprintf(w, "\n%d part(s) differ:\n", len(r.Parts))
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "PART\tOFFSET\tLOCAL SIZE\tREMOTE SIZE\tLOCAL %s\tREMOTE %s\n", r.DigestAlgorithm, r.DigestAlgorithm)
+	for _, p := range r.Parts {
+		fmt.Fprintf(tw, "%d\t%d\t%s\t%s\t%s\t%s\n", p.PartNumber, p.Offset, sizeColumn(p.LocalSize, p.Local), sizeColumn(p.RemoteSize, p.Remote), dash(p.Local), dash(p.Remote))
+	}
+	return tw.Flush()
+}
+
+// sizeColumn formats the size of a part, or "-" if the part does not exist.
+func sizeColumn(size int64, digest string) string {
+	if size == 0 && digest == "" {
+		return "-"
+	}
+	return fmt.Sprintf("%d", size)
+}
+
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,538 @@
+// Package verifier compares local files against stored objects by
+// recomputing, from the local content, the ETag and checksums the store
+// reports for the object.
+package verifier
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/yucori/Favus/internal/checksum"
+	"github.com/yucori/Favus/internal/chunker"
+	"github.com/yucori/Favus/internal/config"
+	"github.com/yucori/Favus/internal/encryption"
+	"github.com/yucori/Favus/internal/etag"
+	"github.com/yucori/Favus/internal/storage"
+	"github.com/yucori/Favus/pkg/utils"
+)
+
+// PartDiff describes a part whose local and stored content differ.
+type PartDiff struct {
+	PartNumber int
+	Offset     int64
+	LocalSize  int64  // Zero if the local file has no such part
+	RemoteSize int64  // Zero if the object has no such part
+	Local      string // Digest of the local part
+	Remote     string // Digest of the stored part, empty if unknown
+}
+
+// Result is the outcome of comparing a local file with a stored object.
+type Result struct {
+	LocalPath  string
+	Key        string
+	Location   string
+	LocalSize  int64
+	RemoteSize int64
+	PartSize   int64 // Part size used to recompute the ETag; zero for single-part objects
+
+	LocalETag  string
+	RemoteETag string
+
+	ChecksumAlgorithm checksum.Algorithm // Algorithm of the stored checksum, if any
+	LocalChecksum     string
+	RemoteChecksum    string
+
+	// DigestAlgorithm is the algorithm of the digests in Parts: MD5 (hex)
+	// unless the store reports per-part checksums (base64).
+	DigestAlgorithm checksum.Algorithm
+	Parts           []PartDiff // Parts that differ, in part order
+
+	Mismatches []string // Why the file does not match the object
+	Notes      []string // Checks that could not be performed
+}
+
+// OK reports whether the local file matches the stored object.
+func (r *Result) OK() bool {
+	return len(r.Mismatches) == 0
+}
+
+func (r *Result) mismatch(format string, v ...interface{}) {
+	r.Mismatches = append(r.Mismatches, fmt.Sprintf(format, v...))
+}
+
+func (r *Result) note(format string, v ...interface{}) {
+	r.Notes = append(r.Notes, fmt.Sprintf(format, v...))
+}
+
+// Verifier compares local files against objects in a store.
+type Verifier struct {
+	Store  storage.ObjectStore
+	Config *config.Config
+	// Keys holds the master key of objects encrypted on the client. Such
+	// objects are compared with the local file encrypted under their data
+	// key, and cannot be verified when it is nil.
+	Keys encryption.KeyProvider
+	// Logger receives the verifier's log records. The package logger of
+	// pkg/utils is used when nil.
+	Logger *utils.Logger
+}
+
+// NewVerifier creates a new Verifier that reads from store.
+func NewVerifier(cfg *config.Config, store storage.ObjectStore) *Verifier {
+	return &Verifier{
+		Store:  store,
+		Config: cfg,
+	}
+}
+
+func (v *Verifier) logger() *utils.Logger {
+	if v.Logger != nil {
+		return v.Logger
+	}
+	return utils.Default()
+}
+
+// VerifyFile compares the file at localPath with the object at key.
+//
+// The object's ETag is recomputed from the file using the part layout of
+// the upload: the part sizes reported by the store, the part size recorded
+// in the object's metadata, the size of its first part, the configured
+// chunk size, or a size derived from the ETag's part count, whichever first
+// reproduces the part count. The parts of a streamed upload grow as its
+// metadata records. Stored checksums are compared as well. When they
+// disagree, the parts that differ are listed in the result.
+//
+// An object encrypted on the client is compared with the ciphertext of the
+// file under the object's data key, so sizes, digests and parts in the
+// result are those of the ciphertext.
+//
+// An error is returned only if the comparison could not be made; a
+// mismatch is reported through Result.OK.
+func (v *Verifier) VerifyFile(ctx context.Context, localPath, key string) (*Result, error) {
+	log := v.logger().With("file", localPath, "key", key)
+	log.Info("Verifying file", "location", v.Store.Location(key))
+
+	fileInfo, err := os.Stat(localPath)
+	if err != nil {
+		log.Error("Failed to get file info", "error", err)
+		return nil, fmt.Errorf("failed to get file info: %w", err)
+	}
+	retry := v.Config.Retry.With(utils.RetryPolicy{Logger: log})
+	var info storage.ObjectInfo
+	err = retry.Do(ctx, func() error {
+		var err error
+		info, err = v.Store.HeadObject(ctx, key)
+		return err
+	})
+	if err != nil {
+		log.Error("Failed to get object info", "error", err)
+		return nil, fmt.Errorf("failed to get object info: %w", err)
+	}
+	var attrs storage.ObjectAttributes
+	err = retry.Do(ctx, func() error {
+		var err error
+		attrs, err = v.Store.GetObjectAttributes(ctx, key)
+		return err
+	})
+	if err != nil {
+		// Not every store or policy allows GetObjectAttributes; the ETag
+		// can still be checked without it.
+		log.Info("Could not get object checksums", "error", err)
+		attrs = storage.ObjectAttributes{}
+	}
+
+	dataKey, err := encryption.OpenDataKey(v.Keys, info.Metadata)
+	if err != nil {
+		log.Error("Cannot verify encrypted object", "error", err)
+		return nil, fmt.Errorf("cannot verify encrypted object: %w", err)
+	}
+	local, localSize, err := openLocal(localPath, fileInfo.Size(), dataKey)
+	if err != nil {
+		log.Error("Failed to open file", "error", err)
+		return nil, err
+	}
+	defer local.Close()
+
+	result := &Result{
+		LocalPath:  localPath,
+		Key:        key,
+		Location:   v.Store.Location(key),
+		LocalSize:  localSize,
+		RemoteSize: info.Size,
+		RemoteETag: etag.Normalize(info.ETag),
+	}
+	if result.LocalSize != result.RemoteSize {
+		if dataKey != nil {
+			result.mismatch("size differs: local file encrypts to %d bytes, object has %d", result.LocalSize, result.RemoteSize)
+		} else {
+			result.mismatch("size differs: local file has %d bytes, object has %d", result.LocalSize, result.RemoteSize)
+		}
+	}
+
+	partCount := etag.PartCount(info.ETag)
+	growth := growthOf(info)
+	var remoteSizes []int64
+	for _, p := range attrs.Parts {
+		remoteSizes = append(remoteSizes, p.Size)
+	}
+	if partCount > 0 && len(remoteSizes) != partCount {
+		remoteSizes = nil
+		result.PartSize = v.partSize(info, partCount, growth)
+		if result.PartSize <= 0 {
+			return nil, fmt.Errorf("cannot determine the part size of %s: %d parts, %d bytes", key, partCount, info.Size)
+		}
+	} else if partCount > 0 {
+		result.PartSize = remoteSizes[0]
+	}
+
+	localChunks := layout(result.LocalSize, remoteSizes, result.PartSize, growth)
+	remoteChunks := layout(result.RemoteSize, remoteSizes, result.PartSize, growth)
+
+	algorithm := attrs.Checksum.Algorithm
+	if len(attrs.Parts) > 0 && attrs.Parts[0].Checksum.Algorithm != checksum.None {
+		algorithm = attrs.Parts[0].Checksum.Algorithm
+	}
+	localMD5s, localSums, err := digestParts(local, localChunks, algorithm)
+	if err != nil {
+		log.Error("Failed to read file", "error", err)
+		return nil, err
+	}
+
+	// ETag
+	etagMatches := true
+	switch {
+	case !storage.ETagIsMD5(info.SSE):
+		result.note("object is encrypted with %s, so its ETag is not an MD5 digest; ETag not compared", info.SSE)
+	case partCount > 0:
+		result.LocalETag, err = etag.FromPartDigests(localMD5s)
+		if err != nil {
+			return nil, err
+		}
+	case len(result.RemoteETag) == 32:
+		result.LocalETag = localMD5s[0]
+	default:
+		// Objects encrypted with SSE-KMS or SSE-C do not have an MD5 ETag.
+		result.note("ETag %s is not an MD5 digest; ETag not compared", result.RemoteETag)
+	}
+	if result.LocalETag != "" && result.LocalETag != result.RemoteETag {
+		etagMatches = false
+		result.mismatch("ETag differs: local %s, object %s", result.LocalETag, result.RemoteETag)
+	}
+
+	// Stored checksum
+	checksumMatches := true
+	if attrs.Checksum.Value != "" {
+		result.ChecksumAlgorithm = attrs.Checksum.Algorithm
+		result.RemoteChecksum = attrs.Checksum.Value
+		if partCount > 0 {
+			result.LocalChecksum, err = checksum.Composite(algorithm, localSums)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			result.LocalChecksum = localSums[0]
+		}
+		// S3 does not consistently append the part count to composite
+		// checksums, so only the checksum itself is compared.
+		if stripPartCount(result.LocalChecksum) != stripPartCount(result.RemoteChecksum) {
+			checksumMatches = false
+			result.mismatch("%s checksum differs: local %s, object %s", result.ChecksumAlgorithm, result.LocalChecksum, result.RemoteChecksum)
+		}
+	}
+
+	if partCount == 0 || (etagMatches && checksumMatches && result.LocalSize == result.RemoteSize) {
+		return result, nil
+	}
+
+	// Per-part diff
+	var remoteDigests []string
+	if algorithm != checksum.None && len(attrs.Parts) == len(remoteChunks) {
+		result.DigestAlgorithm = algorithm
+		for _, p := range attrs.Parts {
+			remoteDigests = append(remoteDigests, p.Checksum.Value)
+		}
+	} else {
+		result.DigestAlgorithm = checksum.MD5
+		localSums = localMD5s
+		log.Info("Part checksums are not stored; reading the object to compare its parts")
+		remoteDigests, err = v.remotePartDigests(ctx, key, info.ETag, remoteChunks, log)
+		if err != nil {
+			return nil, err
+		}
+	}
+	result.Parts = diffParts(localChunks, localSums, remoteChunks, remoteDigests)
+	return result, nil
+}
+
+// ETagMatches reports whether the file at localPath has the ETag of the
+// object described by info, which must come from HeadObject. Unlike
+// VerifyFile it does not fetch checksums or compare parts, which makes it
+// cheap enough to call for every file of a tree.
+//
+// It returns false for objects whose ETag cannot be recomputed, such as
+// objects encrypted with SSE-KMS, or encrypted on the client with another
+// master key than Keys.
+func (v *Verifier) ETagMatches(localPath string, info storage.ObjectInfo) (bool, error) {
+	if !storage.ETagIsMD5(info.SSE) {
+		return false, nil
+	}
+	var partSize int64
+	growth := growthOf(info)
+	if partCount := etag.PartCount(info.ETag); partCount > 0 {
+		if partSize = v.partSize(info, partCount, growth); partSize <= 0 {
+			return false, nil
+		}
+	} else if len(etag.Normalize(info.ETag)) != 32 {
+		return false, nil
+	}
+	dataKey, err := encryption.OpenDataKey(v.Keys, info.Metadata)
+	if err != nil {
+		return false, nil
+	}
+	if dataKey == nil && growth.step == 0 {
+		computed, err := etag.ComputeFile(localPath, partSize)
+		if err != nil {
+			return false, err
+		}
+		return etag.Equal(computed, info.ETag), nil
+	}
+
+	fileInfo, err := os.Stat(localPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to get file info: %w", err)
+	}
+	local, size, err := openLocal(localPath, fileInfo.Size(), dataKey)
+	if err != nil {
+		return false, err
+	}
+	defer local.Close()
+	digests, _, err := digestParts(local, layout(size, nil, partSize, growth), checksum.None)
+	if err != nil {
+		return false, err
+	}
+	computed := digests[0]
+	if partSize > 0 {
+		if computed, err = etag.FromPartDigests(digests); err != nil {
+			return false, err
+		}
+	}
+	return etag.Equal(computed, info.ETag), nil
+}
+
+// partSize returns the size of the first part of a multipart object with
+// partCount parts whose sizes are not reported by the store and grow as
+// growth says, or zero if none of the candidates reproduces the part count.
+func (v *Verifier) partSize(info storage.ObjectInfo, partCount int, growth growth) int64 {
+	var candidates []int64
+	if n, err := strconv.ParseInt(info.Metadata[storage.MetaPartSize], 10, 64); err == nil {
+		candidates = append(candidates, n)
+	}
+	candidates = append(candidates, info.PartSize, v.Config.ChunkSize)
+	// The uploader replaces a chunk size outside the multipart limits.
+	if size, _, err := chunker.OptimalPartSize(info.Size, v.Config.ChunkSize); err == nil {
+		candidates = append(candidates, size)
+	}
+	candidates = append(candidates, derivePartSize(info.Size, partCount))
+	for _, size := range candidates {
+		if size > 0 && len(layout(info.Size, nil, size, growth)) == partCount {
+			return size
+		}
+	}
+	return 0
+}
+
+// derivePartSize returns the smallest whole number of MiB that splits size
+// bytes into partCount parts, which is how most tools pick part sizes.
+func derivePartSize(size int64, partCount int) int64 {
+	const mib = 1024 * 1024
+	if partCount <= 0 {
+		return 0
+	}
+	partSize := (size + int64(partCount) - 1) / int64(partCount)
+	return (partSize + mib - 1) / mib * mib
+}
+
+// growth is how the part size of a multipart object grows: it doubles
+// every step parts up to largest, as for streamed uploads, or never if
+// step is zero.
+type growth struct {
+	step    int
+	largest int64
+}
+
+// growthOf returns the growth of the parts of the object described by
+// info, as recorded in its metadata.
+func growthOf(info storage.ObjectInfo) growth {
+	step, err := strconv.Atoi(info.Metadata[storage.MetaPartSizeStep])
+	if err != nil || step <= 0 {
+		return growth{}
+	}
+	// Encrypted parts hold a whole number of segments.
+	largest := int64(chunker.MaxPartSize)
+	if encryption.IsEncrypted(info.Metadata) {
+		largest = encryption.EncryptedSize(encryption.PartSize(chunker.MaxPartSize), false)
+	}
+	return growth{step: step, largest: largest}
+}
+
+// partSize returns the size of part partNumber of an object whose first
+// part is base bytes.
+func (g growth) partSize(base int64, partNumber int) int64 {
+	if g.step == 0 {
+		return base
+	}
+	return chunker.GrowingPartSize(base, g.largest, g.step, partNumber)
+}
+
+// layout splits total bytes into parts with the given sizes, followed by
+// parts of partSize bytes, grown as growth says, for anything beyond them.
+// Without sizes or a part size the content is a single part.
+func layout(total int64, sizes []int64, partSize int64, growth growth) []chunker.Chunk {
+	if len(sizes) == 0 && partSize <= 0 {
+		return []chunker.Chunk{{Index: 1, Offset: 0, Size: total}}
+	}
+	var chunks []chunker.Chunk
+	var offset int64
+	for _, size := range sizes {
+		if offset >= total {
+			return chunks
+		}
+		if offset+size > total {
+			size = total - offset
+		}
+		chunks = append(chunks, chunker.Chunk{Index: len(chunks) + 1, Offset: offset, Size: size})
+		offset += size
+	}
+	if partSize <= 0 {
+		partSize = sizes[0]
+	}
+	for offset < total {
+		size := growth.partSize(partSize, len(chunks)+1)
+		if offset+size > total {
+			size = total - offset
+		}
+		chunks = append(chunks, chunker.Chunk{Index: len(chunks) + 1, Offset: offset, Size: size})
+		offset += size
+	}
+	return chunks
+}
+
+// encryptedFile is the ciphertext of a local file, which it closes.
+type encryptedFile struct {
+	*encryption.Encrypter
+	io.Closer
+}
+
+// openLocal opens the file at localPath, of size bytes, as the content it
+// is compared with: the file itself, or its ciphertext under key if key is
+// set. It returns the content and its size.
+func openLocal(localPath string, size int64, key *encryption.DataKey) (io.ReadSeekCloser, int64, error) {
+	file, err := os.Open(localPath)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open file: %w", err)
+	}
+	if key == nil {
+		return file, size, nil
+	}
+	enc, err := key.NewEncrypter(file, 0, size, true)
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return encryptedFile{enc, file}, enc.Size(), nil
+}
+
+// digestParts returns the hex MD5 and, unless algorithm is checksum.None,
+// the base64 checksum of each chunk of content.
+func digestParts(content io.ReadSeeker, chunks []chunker.Chunk, algorithm checksum.Algorithm) ([]string, []string, error) {
+	md5s := make([]string, 0, len(chunks))
+	var sums []string
+	for _, ch := range chunks {
+		md5Hash := md5.New()
+		var w io.Writer = md5Hash
+		sumHash := algorithm.New()
+		if sumHash != nil {
+			w = io.MultiWriter(md5Hash, sumHash)
+		}
+		if _, err := content.Seek(ch.Offset, io.SeekStart); err != nil {
+			return nil, nil, fmt.Errorf("failed to read part %d: %w", ch.Index, err)
+		}
+		if _, err := io.CopyN(w, content, ch.Size); err != nil {
+			return nil, nil, fmt.Errorf("failed to read part %d: %w", ch.Index, err)
+		}
+		md5s = append(md5s, hex.EncodeToString(md5Hash.Sum(nil)))
+		if sumHash != nil {
+			sums = append(sums, base64.StdEncoding.EncodeToString(sumHash.Sum(nil)))
+		}
+	}
+	return md5s, sums, nil
+}
+
+// remotePartDigests reads each part of the object and returns its hex MD5.
+func (v *Verifier) remotePartDigests(ctx context.Context, key, eTag string, chunks []chunker.Chunk, log *utils.Logger) ([]string, error) {
+	digests := make([]string, len(chunks))
+	retry := v.Config.Retry.With(utils.RetryPolicy{Logger: log})
+	err := chunker.ForEachContext(ctx, chunks, v.Config.Concurrency, func(ch chunker.Chunk) error {
+		var h hash.Hash
+		err := retry.Do(ctx, func() error {
+			body, err := v.Store.GetObjectRange(ctx, key, eTag, ch.Offset, ch.Size)
+			if err != nil {
+				return err
+			}
+			defer body.Close()
+			h = md5.New()
+			_, err = io.Copy(h, io.LimitReader(body, ch.Size))
+			return err
+		})
+		if err != nil {
+			log.Error("Failed to read part", "part", ch.Index, "error", err)
+			return fmt.Errorf("failed to read part %d of the object: %w", ch.Index, err)
+		}
+		digests[ch.Index-1] = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return digests, nil
+}
+
+// diffParts returns the parts whose size or digest differ.
+func diffParts(localChunks []chunker.Chunk, localDigests []string, remoteChunks []chunker.Chunk, remoteDigests []string) []PartDiff {
+	n := len(localChunks)
+	if len(remoteChunks) > n {
+		n = len(remoteChunks)
+	}
+	var diffs []PartDiff
+	for i := 0; i < n; i++ {
+		d := PartDiff{PartNumber: i + 1}
+		if i < len(localChunks) {
+			d.Offset = localChunks[i].Offset
+			d.LocalSize = localChunks[i].Size
+			d.Local = localDigests[i]
+		}
+		if i < len(remoteChunks) {
+			d.Offset = remoteChunks[i].Offset
+			d.RemoteSize = remoteChunks[i].Size
+			d.Remote = remoteDigests[i]
+		}
+		if d.LocalSize != d.RemoteSize || d.Local != d.Remote {
+			diffs = append(diffs, d)
+		}
+	}
+	return diffs
+}
+
+// stripPartCount removes the "-N" suffix of a composite checksum.
+func stripPartCount(sum string) string {
+	value, _, _ := strings.Cut(sum, "-")
+	return value
+}
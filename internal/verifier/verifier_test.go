@@ -0,0 +1,229 @@
+package verifier
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/yucori/Favus/internal/checksum"
+	"github.com/yucori/Favus/internal/s3test"
+	"github.com/yucori/Favus/internal/storage"
+	"github.com/yucori/Favus/pkg/utils"
+)
+
+// partSize is the size of the parts of the objects verified by tests.
+const partSize = 1024
+
+// newTestVerifier returns a verifier reading from a new fake S3 server
+// that accepts parts of partSize bytes.
+func newTestVerifier(t *testing.T) (*Verifier, *s3test.Server) {
+	t.Helper()
+	srv := s3test.NewServer("favus-test")
+	t.Cleanup(srv.Close)
+	srv.MinPartSize = partSize
+	cfg := srv.Config()
+	cfg.Retry = utils.RetryPolicy{InitialInterval: time.Millisecond, MaxInterval: time.Millisecond}
+	return NewVerifier(cfg, srv.Store()), srv
+}
+
+// randomData returns n random bytes.
+func randomData(t *testing.T, n int) []byte {
+	t.Helper()
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// writeFile writes data to a new file and returns its path.
+func writeFile(t *testing.T, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "data.bin")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// putMultipart stores data under key as a multipart object of parts of
+// partSize bytes, each carrying a checksum computed with algorithm.
+func putMultipart(t *testing.T, store storage.ObjectStore, key string, data []byte, algorithm checksum.Algorithm) {
+	t.Helper()
+	ctx := context.Background()
+	uploadID, err := store.CreateMultipartUpload(ctx, key, algorithm, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parts []storage.CompletedPart
+	for offset := 0; offset < len(data); offset += partSize {
+		part := data[offset:min(offset+partSize, len(data))]
+		sum := storage.Checksum{Algorithm: algorithm}
+		if sum.Value, err = checksum.Compute(algorithm, bytes.NewReader(part)); err != nil {
+			t.Fatal(err)
+		}
+		n := len(parts) + 1
+		eTag, err := store.UploadPart(ctx, key, uploadID, n, bytes.NewReader(part), int64(len(part)), sum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts = append(parts, storage.CompletedPart{PartNumber: n, ETag: eTag, Checksum: sum})
+	}
+	if _, err := store.CompleteMultipartUpload(ctx, key, uploadID, parts); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyFile(t *testing.T) {
+	for _, algorithm := range []checksum.Algorithm{checksum.None, checksum.CRC32C, checksum.SHA256} {
+		t.Run(algorithm.String(), func(t *testing.T) {
+			v, _ := newTestVerifier(t)
+			data := randomData(t, 3*partSize+100)
+			putMultipart(t, v.Store, "data.bin", data, algorithm)
+
+			result, err := v.VerifyFile(context.Background(), writeFile(t, data), "data.bin")
+			if err != nil {
+				t.Fatalf("VerifyFile: %v", err)
+			}
+			if !result.OK() {
+				t.Fatalf("file does not match: %v", result.Mismatches)
+			}
+			if result.PartSize != partSize || result.LocalETag != result.RemoteETag || result.RemoteETag == "" {
+				t.Errorf("ETag %s of parts of %d bytes, object has %s", result.LocalETag, result.PartSize, result.RemoteETag)
+			}
+			if algorithm != checksum.None && (result.ChecksumAlgorithm != algorithm || result.RemoteChecksum == "") {
+				t.Errorf("%s checksum was not compared: %+v", algorithm, result)
+			}
+		})
+	}
+}
+
+func TestVerifyFileSinglePart(t *testing.T) {
+	v, srv := newTestVerifier(t)
+	data := randomData(t, 100)
+	srv.PutObject("data.bin", data)
+
+	result, err := v.VerifyFile(context.Background(), writeFile(t, data), "data.bin")
+	if err != nil {
+		t.Fatalf("VerifyFile: %v", err)
+	}
+	if !result.OK() || result.PartSize != 0 || result.LocalETag != result.RemoteETag {
+		t.Errorf("single-part object does not verify: %+v", result)
+	}
+
+	data[50] ^= 1
+	result, err = v.VerifyFile(context.Background(), writeFile(t, data), "data.bin")
+	if err != nil {
+		t.Fatalf("VerifyFile: %v", err)
+	}
+	if result.OK() || len(result.Parts) != 0 {
+		t.Errorf("changed file matches, or has a part diff: %+v", result)
+	}
+}
+
+func TestVerifyFileMismatch(t *testing.T) {
+	tests := []struct {
+		algorithm checksum.Algorithm
+		digests   checksum.Algorithm // Of the part diff
+		reads     int                // Ranged reads of the object
+	}{
+		// Without stored part checksums, every part of the object is read.
+		{checksum.None, checksum.MD5, 4},
+		{checksum.CRC32C, checksum.CRC32C, 0},
+		{checksum.SHA256, checksum.SHA256, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.algorithm.String(), func(t *testing.T) {
+			v, srv := newTestVerifier(t)
+			data := randomData(t, 3*partSize+100)
+			putMultipart(t, v.Store, "data.bin", data, tt.algorithm)
+			changed := bytes.Clone(data)
+			changed[partSize+10] ^= 1
+
+			result, err := v.VerifyFile(context.Background(), writeFile(t, changed), "data.bin")
+			if err != nil {
+				t.Fatalf("VerifyFile: %v", err)
+			}
+			if result.OK() {
+				t.Fatal("changed file matches the object")
+			}
+			if len(result.Parts) != 1 || result.Parts[0].PartNumber != 2 || result.Parts[0].Offset != partSize {
+				t.Fatalf("parts differ: %+v, want part 2", result.Parts)
+			}
+			d := result.Parts[0]
+			if d.LocalSize != partSize || d.RemoteSize != partSize || d.Local == d.Remote || d.Remote == "" {
+				t.Errorf("part 2 diff %+v, want parts of equal size and different digests", d)
+			}
+			if result.DigestAlgorithm != tt.digests {
+				t.Errorf("parts compared with %s, want %s", result.DigestAlgorithm, tt.digests)
+			}
+			if n := srv.CountRequests("GetObject", 0); n != tt.reads {
+				t.Errorf("read %d parts of the object, want %d", n, tt.reads)
+			}
+		})
+	}
+}
+
+func TestVerifyFileSizeMismatch(t *testing.T) {
+	v, _ := newTestVerifier(t)
+	data := randomData(t, 3*partSize+100)
+	putMultipart(t, v.Store, "data.bin", data, checksum.CRC32C)
+
+	// The file is split like the object: its first three parts match, its
+	// fourth has the size of the object's last part but other content, and
+	// the rest is an extra part.
+	longer := append(bytes.Clone(data[:3*partSize]), randomData(t, partSize+100)...)
+	result, err := v.VerifyFile(context.Background(), writeFile(t, longer), "data.bin")
+	if err != nil {
+		t.Fatalf("VerifyFile: %v", err)
+	}
+	if result.OK() || result.LocalSize != int64(len(longer)) || result.RemoteSize != int64(len(data)) {
+		t.Fatalf("file of %d bytes matches or is misreported: %+v", len(longer), result)
+	}
+	var got []string
+	for _, d := range result.Parts {
+		got = append(got, fmt.Sprintf("%d:%d/%d", d.PartNumber, d.LocalSize, d.RemoteSize))
+	}
+	if want := "[4:100/100 5:1024/0]"; fmt.Sprint(got) != want {
+		t.Errorf("parts differ: %v, want %s", got, want)
+	}
+}
+
+func TestLayout(t *testing.T) {
+	tests := []struct {
+		name     string
+		total    int64
+		sizes    []int64
+		partSize int64
+		growth   growth
+		want     string
+	}{
+		{"single part", 25, nil, 0, growth{}, "[25]"},
+		{"equal parts", 25, nil, 10, growth{}, "[10 10 5]"},
+		{"reported sizes", 25, []int64{8, 8, 9}, 0, growth{}, "[8 8 9]"},
+		{"beyond reported sizes", 25, []int64{8}, 0, growth{}, "[8 8 8 1]"},
+		{"shorter than reported", 10, []int64{8, 8}, 0, growth{}, "[8 2]"},
+		{"growing", 150, nil, 10, growth{step: 2, largest: 40}, "[10 10 20 20 40 40 10]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int64
+			var offset int64
+			for i, ch := range layout(tt.total, tt.sizes, tt.partSize, tt.growth) {
+				if ch.Index != i+1 || ch.Offset != offset {
+					t.Errorf("part %d is numbered %d at offset %d", i+1, ch.Index, ch.Offset)
+				}
+				got = append(got, ch.Size)
+				offset += ch.Size
+			}
+			if fmt.Sprint(got) != tt.want {
+				t.Errorf("layout has parts of %v bytes, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package walker
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, name string
+		want          bool
+	}{
+		// Patterns without a slash match the last segment.
+		{"*.log", "app.log", true},
+		{"*.log", "logs/2024/app.log", true},
+		{"*.log", "app.log.gz", false},
+		{"build", "src/build", true},
+		// Patterns with a slash match the whole path.
+		{"logs/*.log", "logs/app.log", true},
+		{"logs/*.log", "old/logs/app.log", false},
+		{"logs/*.log", "logs/2024/app.log", false},
+		// "**" matches zero or more segments.
+		{"**/*.log", "app.log", true},
+		{"**/*.log", "a/b/c/app.log", true},
+		{"logs/**", "logs", true},
+		{"logs/**", "logs/a/b.txt", true},
+		{"logs/**", "other/a.txt", false},
+		{"src/**/test/*.go", "src/test/a.go", true},
+		{"src/**/test/*.go", "src/a/b/test/a.go", true},
+		{"src/**/test/*.go", "src/a/b/a.go", false},
+		{"**/node_modules/**", "web/node_modules/x/y.js", true},
+		{"a/**/b/**/c", "a/x/b/y/z/c", true},
+		{"a/**/b/**/c", "a/x/y/c", false},
+		// Wildcards do not cross segments.
+		{"src/*.go", "src/pkg/a.go", false},
+		{"src/?.go", "src/a.go", true},
+		{"src/[ab].go", "src/c.go", false},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestValidatePattern(t *testing.T) {
+	for _, p := range []string{"*.log", "**/a", "a/**/b", "[a-z]*"} {
+		if err := ValidatePattern(p); err != nil {
+			t.Errorf("ValidatePattern(%q) = %v", p, err)
+		}
+	}
+	for _, p := range []string{"", "[", "a/[b"} {
+		if err := ValidatePattern(p); err == nil {
+			t.Errorf("ValidatePattern(%q) accepted a malformed pattern", p)
+		}
+	}
+}
+
+func TestOptionsSelects(t *testing.T) {
+	opts := Options{Include: []string{"**/*.go", "README"}, Exclude: []string{"vendor", "**/testdata/**"}}
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"main.go", true},
+		{"pkg/a/a.go", true},
+		{"README", true},
+		{"notes.txt", false},
+		// Excluded directories exclude everything under them.
+		{"vendor/x/x.go", false},
+		{"pkg/vendor/y.go", false},
+		{"pkg/testdata/z.go", false},
+	}
+	for _, tt := range tests {
+		if got := opts.Selects(tt.name); got != tt.want {
+			t.Errorf("Selects(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// Package walker lists the regular files under a local directory tree,
+// filtered by include/exclude glob patterns, for directory uploads.
+package walker
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yucori/Favus/pkg/utils"
+)
+
+// SymlinkPolicy says what to do with symbolic links found in the tree.
+type SymlinkPolicy string
+
+// Supported symlink policies.
+const (
+	// SymlinksSkip ignores symbolic links.
+	SymlinksSkip SymlinkPolicy = "skip"
+	// SymlinksFollow treats a link as the file or directory it points to.
+	SymlinksFollow SymlinkPolicy = "follow"
+)
+
+// ParseSymlinkPolicy parses a symlink policy name. An empty name means skip.
+func ParseSymlinkPolicy(name string) (SymlinkPolicy, error) {
+	switch strings.ToLower(name) {
+	case "", "skip":
+		return SymlinksSkip, nil
+	case "follow":
+		return SymlinksFollow, nil
+	}
+	return "", fmt.Errorf("unsupported symlink policy: %s (use skip or follow)", name)
+}
+
+// Options controls which files Walk returns.
+type Options struct {
+	// Include limits the walk to files matching at least one pattern. All
+	// files are included when empty.
+	Include []string
+	// Exclude drops files, and prunes directories, matching any pattern.
+	// Exclude takes precedence over Include.
+	Exclude []string
+	// Symlinks is the symlink policy; the zero value skips links.
+	Symlinks SymlinkPolicy
+	// Logger receives the records of the files skipped. The package logger
+	// of pkg/utils is used when nil.
+	Logger *utils.Logger
+}
+
+func (o Options) logger() *utils.Logger {
+	if o.Logger != nil {
+		return o.Logger
+	}
+	return utils.Default()
+}
+
+// Selects reports whether Walk would return a file at the slash-separated
+// relative path relPath, ignoring symlinks. It is used to apply the same
+// filters to remote keys.
+func (o Options) Selects(relPath string) bool {
+	if len(o.Include) > 0 && !matchAny(o.Include, relPath) {
+		return false
+	}
+	// Walk prunes excluded directories, so check every parent as well.
+	for p := relPath; p != "."; p = path.Dir(p) {
+		if matchAny(o.Exclude, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// File is a regular file found by Walk.
+type File struct {
+	Path    string // Path of the file on disk
+	RelPath string // Slash-separated path relative to the walk root
+	Size    int64
+	ModTime time.Time
+}
+
+// Walk returns the regular files under root selected by opts, sorted by
+// relative path.
+func Walk(root string, opts Options) ([]File, error) {
+	for _, p := range append(append([]string(nil), opts.Include...), opts.Exclude...) {
+		if err := ValidatePattern(p); err != nil {
+			return nil, err
+		}
+	}
+	fi, err := os.Stat(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get directory info: %w", err)
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+
+	w := &walk{opts: opts, log: opts.logger().With("root", root), parents: make(map[string]bool)}
+	if err := w.dir(root, ""); err != nil {
+		return nil, err
+	}
+	sort.Slice(w.files, func(i, j int) bool {
+		return w.files[i].RelPath < w.files[j].RelPath
+	})
+	return w.files, nil
+}
+
+type walk struct {
+	opts    Options
+	log     *utils.Logger
+	files   []File
+	parents map[string]bool // Resolved directories being walked, to break symlink cycles
+}
+
+func (w *walk) dir(dirPath, relDir string) error {
+	resolved, err := filepath.EvalSymlinks(dirPath)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", dirPath, err)
+	}
+	if w.parents[resolved] {
+		w.log.Info("Skipping symlink cycle", "path", dirPath)
+		return nil
+	}
+	w.parents[resolved] = true
+	defer delete(w.parents, resolved)
+
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return fmt.Errorf("failed to read directory %s: %w", dirPath, err)
+	}
+	for _, e := range entries {
+		p := filepath.Join(dirPath, e.Name())
+		rel := e.Name()
+		if relDir != "" {
+			rel = relDir + "/" + e.Name()
+		}
+
+		info, err := e.Info()
+		if err != nil {
+			return fmt.Errorf("failed to get file info for %s: %w", p, err)
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			if w.opts.Symlinks != SymlinksFollow {
+				w.log.Info("Skipping symlink", "path", p)
+				continue
+			}
+			if info, err = os.Stat(p); err != nil {
+				w.log.Error("Skipping broken symlink", "path", p, "error", err)
+				continue
+			}
+		}
+
+		if matchAny(w.opts.Exclude, rel) {
+			continue
+		}
+		switch {
+		case info.IsDir():
+			if err := w.dir(p, rel); err != nil {
+				return err
+			}
+		case info.Mode().IsRegular():
+			if len(w.opts.Include) > 0 && !matchAny(w.opts.Include, rel) {
+				continue
+			}
+			w.files = append(w.files, File{
+				Path:    p,
+				RelPath: rel,
+				Size:    info.Size(),
+				ModTime: info.ModTime(),
+			})
+		default:
+			w.log.Info("Skipping file that is not regular", "path", p, "mode", info.Mode().Type().String())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package walker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// makeTree creates the files at the given slash-separated paths under a
+// new directory and returns the directory.
+func makeTree(t *testing.T, paths ...string) string {
+	t.Helper()
+	root := t.TempDir()
+	for _, p := range paths {
+		full := filepath.Join(root, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(full), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(full, []byte(p), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root
+}
+
+// walkPaths returns the relative paths Walk returns for root and opts.
+func walkPaths(t *testing.T, root string, opts Options) []string {
+	t.Helper()
+	files, err := Walk(root, opts)
+	if err != nil {
+		t.Fatalf("Walk: %v", err)
+	}
+	var paths []string
+	for _, f := range files {
+		paths = append(paths, f.RelPath)
+		if f.Path != filepath.Join(root, filepath.FromSlash(f.RelPath)) {
+			t.Errorf("file %s has path %s", f.RelPath, f.Path)
+		}
+	}
+	return paths
+}
+
+func TestWalk(t *testing.T) {
+	root := makeTree(t,
+		"README.md",
+		"main.go",
+		"cmd/app/main.go",
+		"cmd/app/main_test.go",
+		"docs/guide.md",
+		"node_modules/lib/index.js",
+		"web/node_modules/lib/index.js",
+		"web/app.js",
+		"build/out.bin",
+		"build/keep.go",
+	)
+	tests := []struct {
+		name string
+		opts Options
+		want string
+	}{
+		{"all", Options{}, "[README.md build/keep.go build/out.bin cmd/app/main.go cmd/app/main_test.go docs/guide.md main.go node_modules/lib/index.js web/app.js web/node_modules/lib/index.js]"},
+		{"include basename", Options{Include: []string{"*.go"}}, "[build/keep.go cmd/app/main.go cmd/app/main_test.go main.go]"},
+		{"include path", Options{Include: []string{"cmd/**"}}, "[cmd/app/main.go cmd/app/main_test.go]"},
+		{"include top level", Options{Include: []string{"*.md"}, Exclude: []string{"docs/**"}}, "[README.md]"},
+		// An excluded directory is pruned with everything under it, even
+		// files that are included.
+		{"prune", Options{Include: []string{"*.go", "*.js"}, Exclude: []string{"build", "node_modules"}}, "[cmd/app/main.go cmd/app/main_test.go main.go web/app.js]"},
+		{"prune nested", Options{Exclude: []string{"**/node_modules"}}, "[README.md build/keep.go build/out.bin cmd/app/main.go cmd/app/main_test.go docs/guide.md main.go web/app.js]"},
+		{"exclude wins", Options{Include: []string{"**/*.go"}, Exclude: []string{"*_test.go"}}, "[build/keep.go cmd/app/main.go main.go]"},
+		{"no match", Options{Include: []string{"*.rs"}}, "[]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			paths := walkPaths(t, root, tt.opts)
+			if got := fmt.Sprint(paths); got != tt.want {
+				t.Errorf("Walk = %s, want %s", got, tt.want)
+			}
+			// Selects applies the same filters to keys.
+			for _, p := range paths {
+				if !tt.opts.Selects(p) {
+					t.Errorf("Selects(%q) = false for a file Walk returned", p)
+				}
+			}
+		})
+	}
+}
+
+func TestWalkSymlinks(t *testing.T) {
+	root := makeTree(t, "dir/a.txt")
+	target := makeTree(t, "b.txt")
+	for _, link := range []struct{ target, name string }{
+		{filepath.Join(target, "b.txt"), "link.txt"},
+		{target, "linkdir"},
+		{filepath.Join(root, "missing"), "broken"},
+		// A link back to an ancestor would be walked forever.
+		{root, "dir/loop"},
+	} {
+		if err := os.Symlink(link.target, filepath.Join(root, filepath.FromSlash(link.name))); err != nil {
+			t.Skipf("cannot create symlinks: %v", err)
+		}
+	}
+
+	if got := fmt.Sprint(walkPaths(t, root, Options{})); got != "[dir/a.txt]" {
+		t.Errorf("Walk skipping symlinks = %s, want [dir/a.txt]", got)
+	}
+	// The broken link and the loop are skipped.
+	got := fmt.Sprint(walkPaths(t, root, Options{Symlinks: SymlinksFollow}))
+	if want := "[dir/a.txt link.txt linkdir/b.txt]"; got != want {
+		t.Errorf("Walk following symlinks = %s, want %s", got, want)
+	}
+}
+
+func TestWalkErrors(t *testing.T) {
+	root := makeTree(t, "a.txt")
+	if _, err := Walk(filepath.Join(root, "a.txt"), Options{}); err == nil {
+		t.Error("Walk accepted a file as the root")
+	}
+	if _, err := Walk(filepath.Join(root, "missing"), Options{}); err == nil {
+		t.Error("Walk accepted a missing root")
+	}
+	if _, err := Walk(root, Options{Exclude: []string{"["}}); err == nil {
+		t.Error("Walk accepted a malformed pattern")
+	}
+}
+
+func TestParseSymlinkPolicy(t *testing.T) {
+	for name, want := range map[string]SymlinkPolicy{"": SymlinksSkip, "skip": SymlinksSkip, "Follow": SymlinksFollow} {
+		if got, err := ParseSymlinkPolicy(name); err != nil || got != want {
+			t.Errorf("ParseSymlinkPolicy(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if _, err := ParseSymlinkPolicy("copy"); err == nil {
+		t.Error("ParseSymlinkPolicy accepted copy")
+	}
+}
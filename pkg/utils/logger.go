@@ -1,38 +1,318 @@
 package utils
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 )
 
-// logger는 utils 패키지 전체에서 사용될 로거 인스턴스입니다.
-var logger *log.Logger
+// Level is the severity of a log record.
+type Level = slog.Level
+
+// Log levels, from the most to the least verbose.
+const (
+	LevelDebug = slog.LevelDebug
+	LevelInfo  = slog.LevelInfo
+	LevelWarn  = slog.LevelWarn
+	LevelError = slog.LevelError
+	LevelFatal = slog.Level(12)
+)
+
+// ParseLevel parses a level name: debug, info, warn or error. An empty name
+// means info.
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return LevelDebug, nil
+	case "", "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("unsupported log level: %s (use debug, info, warn or error)", name)
+}
+
+// LogFormat is the encoding of log records.
+type LogFormat string
+
+// Supported log formats.
+const (
+	// LogText writes one human-readable line per record, followed by its
+	// fields as key=value pairs.
+	LogText LogFormat = "text"
+	// LogJSON writes one JSON object per record.
+	LogJSON LogFormat = "json"
+)
+
+// ParseLogFormat parses a log format name. An empty name means text.
+func ParseLogFormat(name string) (LogFormat, error) {
+	switch strings.ToLower(name) {
+	case "", "text":
+		return LogText, nil
+	case "json":
+		return LogJSON, nil
+	}
+	return "", fmt.Errorf("unsupported log format: %s (use text or json)", name)
+}
+
+// LogOptions configures a Logger.
+type LogOptions struct {
+	Level  Level
+	Format LogFormat
+	// File is the path of a file the records are appended to. Records go
+	// to Output when empty.
+	File string
+	// Output receives the records when File is empty. Defaults to stderr.
+	Output io.Writer
+}
+
+// Logger writes leveled log records with key-value fields, such as
+// "key", "upload_id", "part", "bytes" or "duration".
+type Logger struct {
+	slog   *slog.Logger
+	level  *slog.LevelVar
+	closer io.Closer // Log file opened by NewLogger, if any
+}
+
+// NewLogger creates a Logger from opts.
+func NewLogger(opts LogOptions) (*Logger, error) {
+	out := opts.Output
+	if out == nil {
+		out = os.Stderr
+	}
+	var closer io.Closer
+	if opts.File != "" {
+		f, err := os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open log file: %w", err)
+		}
+		out, closer = f, f
+	}
+
+	level := new(slog.LevelVar)
+	level.Set(opts.Level)
+	var handler slog.Handler
+	switch opts.Format {
+	case LogJSON:
+		handler = slog.NewJSONHandler(out, &slog.HandlerOptions{
+			Level: level,
+			ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+				if len(groups) == 0 && a.Key == slog.LevelKey {
+					a.Value = slog.StringValue(levelName(a.Value.Any().(slog.Level)))
+				}
+				// Durations are written as "1.5s" rather than in nanoseconds.
+				if a.Value.Kind() == slog.KindDuration {
+					a.Value = slog.StringValue(a.Value.Duration().String())
+				}
+				return a
+			},
+		})
+	case LogText, "":
+		handler = &textHandler{mu: new(sync.Mutex), w: out, level: level}
+	default:
+		return nil, fmt.Errorf("unsupported log format: %s", opts.Format)
+	}
+	return &Logger{slog: slog.New(handler), level: level, closer: closer}, nil
+}
+
+// With returns a Logger that adds the given key-value pairs to every record.
+// It shares the level of l.
+func (l *Logger) With(args ...interface{}) *Logger {
+	return &Logger{slog: l.slog.With(args...), level: l.level}
+}
+
+// SetLevel changes the minimum level of the records written, for l and
+// every Logger derived from it with With.
+func (l *Logger) SetLevel(level Level) {
+	l.level.Set(level)
+}
+
+// Close closes the log file opened by NewLogger, if any.
+func (l *Logger) Close() error {
+	if l.closer == nil {
+		return nil
+	}
+	return l.closer.Close()
+}
+
+// Debug logs msg with the key-value pairs in args at debug level.
+func (l *Logger) Debug(msg string, args ...interface{}) {
+	l.log(LevelDebug, msg, args...)
+}
+
+// Info logs msg with the key-value pairs in args at info level.
+func (l *Logger) Info(msg string, args ...interface{}) {
+	l.log(LevelInfo, msg, args...)
+}
+
+// Warn logs msg with the key-value pairs in args at warn level.
+func (l *Logger) Warn(msg string, args ...interface{}) {
+	l.log(LevelWarn, msg, args...)
+}
+
+// Error logs msg with the key-value pairs in args at error level.
+func (l *Logger) Error(msg string, args ...interface{}) {
+	l.log(LevelError, msg, args...)
+}
+
+func (l *Logger) log(level Level, msg string, args ...interface{}) {
+	ctx := context.Background()
+	if !l.slog.Enabled(ctx, level) {
+		return
+	}
+	l.slog.Log(ctx, level, msg, args...)
+}
+
+// std is the logger behind the package-level functions.
+var std *Logger
 
 func init() {
-	// init 함수는 패키지가 로드될 때 자동으로 호출됩니다.
-	// 여기서 로거를 초기화하여 [FAVUS] 접두사, 날짜/시간, 파일/라인 정보를 포함하도록 설정합니다.
-	logger = log.New(os.Stdout, "[FAVUS] ", log.Ldate|log.Ltime|log.Lshortfile)
+	std, _ = NewLogger(LogOptions{Level: LevelInfo, Format: LogText})
+}
+
+// Default returns the logger used by the package-level functions.
+func Default() *Logger {
+	return std
+}
+
+// SetDefault makes l the logger used by the package-level functions.
+func SetDefault(l *Logger) {
+	std = l
+}
+
+// Debug logs a debug message.
+func Debug(format string, v ...interface{}) {
+	std.log(LevelDebug, fmt.Sprintf(format, v...))
 }
 
 // Info logs an info message.
 func Info(format string, v ...interface{}) {
-	logger.Printf("INFO: "+format, v...)
+	std.log(LevelInfo, fmt.Sprintf(format, v...))
+}
+
+// Warn logs a warning message.
+func Warn(format string, v ...interface{}) {
+	std.log(LevelWarn, fmt.Sprintf(format, v...))
 }
 
 // Error logs an error message.
 func Error(format string, v ...interface{}) {
-	logger.Printf("ERROR: "+format, v...)
+	std.log(LevelError, fmt.Sprintf(format, v...))
 }
 
 // Fatal logs a fatal message and exits the program.
 // Critical errors that prevent further operation should use Fatal.
 func Fatal(format string, v ...interface{}) {
-	logger.Fatalf("FATAL: "+format, v...)
+	std.log(LevelFatal, fmt.Sprintf(format, v...))
+	os.Exit(1)
 }
 
-// NewLogger 함수는 이 접근 방식에서는 더 이상 필요하지 않습니다.
-// 하지만 만약 필요하다면 다음과 같이 더미 함수로 남겨둘 수 있습니다.
-// 혹은 이 함수 자체가 제거되어야 합니다.
-// func NewLogger() *Logger {
-//     return &Logger{logger} // 만약 Logger struct를 유지한다면
-// }
+func levelName(level Level) string {
+	switch {
+	case level >= LevelFatal:
+		return "FATAL"
+	case level >= LevelError:
+		return "ERROR"
+	case level >= LevelWarn:
+		return "WARN"
+	case level >= LevelInfo:
+		return "INFO"
+	}
+	return "DEBUG"
+}
+
+// textHandler writes records as
+//
+//	[FAVUS] 2006/01/02 15:04:05 INFO: message key=value ...
+type textHandler struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	level  slog.Leveler
+	prefix string // Group of the attributes added from now on, with a trailing dot
+	attrs  []byte // Attributes added with WithAttrs, already formatted
+}
+
+func (h *textHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *textHandler) Handle(_ context.Context, r slog.Record) error {
+	buf := []byte("[FAVUS] ")
+	if !r.Time.IsZero() {
+		buf = r.Time.AppendFormat(buf, "2006/01/02 15:04:05 ")
+	}
+	buf = append(buf, levelName(r.Level)...)
+	buf = append(buf, ": "...)
+	buf = append(buf, r.Message...)
+	buf = append(buf, h.attrs...)
+	r.Attrs(func(a slog.Attr) bool {
+		buf = appendAttr(buf, h.prefix, a)
+		return true
+	})
+	buf = append(buf, '\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.w.Write(buf)
+	return err
+}
+
+func (h *textHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.attrs = append([]byte(nil), h.attrs...)
+	for _, a := range attrs {
+		h2.attrs = appendAttr(h2.attrs, h.prefix, a)
+	}
+	return &h2
+}
+
+func (h *textHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.prefix += name + "."
+	return &h2
+}
+
+// appendAttr appends a as " key=value", flattening groups into dotted keys.
+func appendAttr(buf []byte, prefix string, a slog.Attr) []byte {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return buf
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			buf = appendAttr(buf, prefix, ga)
+		}
+		return buf
+	}
+	buf = append(buf, ' ')
+	buf = append(buf, prefix...)
+	buf = append(buf, a.Key...)
+	buf = append(buf, '=')
+	var s string
+	switch a.Value.Kind() {
+	case slog.KindDuration:
+		s = a.Value.Duration().Round(time.Millisecond).String()
+	case slog.KindTime:
+		s = a.Value.Time().Format(time.RFC3339)
+	default:
+		s = a.Value.String()
+	}
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		s = strconv.Quote(s)
+	}
+	return append(buf, s...)
+}
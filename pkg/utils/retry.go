@@ -1,19 +1,188 @@
 package utils
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"syscall"
 	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 )
 
-func Retry(attempts int, sleep time.Duration, fn func() error) error {
+// RetryPolicy says how often and how long to retry a failing operation.
+// Zero fields take their value from DefaultRetryPolicy.
+//
+// The wait before each retry grows exponentially from InitialInterval up to
+// MaxInterval and is drawn uniformly from [0, wait) ("full jitter"), so that
+// parallel workers do not retry in lockstep. If the error carries a hint of
+// how long to wait, such as the Retry-After header of a throttling
+// response, the wait is at least that long.
+type RetryPolicy struct {
+	MaxAttempts     int           // Total number of attempts, including the first
+	InitialInterval time.Duration // Wait before the first retry, before jitter
+	MaxInterval     time.Duration // Upper bound of the wait before jitter
+	Multiplier      float64       // Growth of the wait after every retry
+	MaxElapsedTime  time.Duration // Give up once a retry would start later than this after the first attempt
+	// Retryable reports whether an error may go away by trying again. It
+	// defaults to IsRetryable.
+	Retryable func(error) bool
+	// Logger receives a warning for every retry. It defaults to the
+	// package logger.
+	Logger *Logger
+}
+
+// DefaultRetryPolicy is used for the fields a RetryPolicy leaves zero.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:     5,
+	InitialInterval: time.Second,
+	MaxInterval:     30 * time.Second,
+	Multiplier:      2,
+	MaxElapsedTime:  5 * time.Minute,
+	Retryable:       IsRetryable,
+}
+
+// With returns p with the non-zero fields of override replacing its own,
+// for callers that need a different policy for a single operation.
+func (p RetryPolicy) With(override RetryPolicy) RetryPolicy {
+	if override.MaxAttempts > 0 {
+		p.MaxAttempts = override.MaxAttempts
+	}
+	if override.InitialInterval > 0 {
+		p.InitialInterval = override.InitialInterval
+	}
+	if override.MaxInterval > 0 {
+		p.MaxInterval = override.MaxInterval
+	}
+	if override.Multiplier > 0 {
+		p.Multiplier = override.Multiplier
+	}
+	if override.MaxElapsedTime > 0 {
+		p.MaxElapsedTime = override.MaxElapsedTime
+	}
+	if override.Retryable != nil {
+		p.Retryable = override.Retryable
+	}
+	if override.Logger != nil {
+		p.Logger = override.Logger
+	}
+	return p
+}
+
+// Do calls fn until it succeeds, fails with an error that is not
+// retryable, or the policy gives up. Once ctx is done no further attempt is
+// started and the wait between attempts is cut short; an attempt already
+// running is not interrupted by Do itself.
+func (p RetryPolicy) Do(ctx context.Context, fn func() error) error {
+	p = DefaultRetryPolicy.With(p)
+	if p.MaxInterval < p.InitialInterval {
+		p.MaxInterval = p.InitialInterval
+	}
+	log := p.Logger
+	if log == nil {
+		log = std
+	}
+	start := time.Now()
 	var err error
-	for i := 0; i < attempts; i++ {
+	for attempt := 1; ; attempt++ {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			if err == nil {
+				return ctxErr
+			}
+			return fmt.Errorf("%w (last error: %v)", ctxErr, err)
+		}
 		err = fn()
 		if err == nil {
 			return nil
 		}
-		fmt.Printf("Retrying (%d/%d) after error: %v\n", i+1, attempts, err)
-		time.Sleep(sleep)
+		if !p.Retryable(err) {
+			return err
+		}
+		if attempt >= p.MaxAttempts {
+			break
+		}
+		wait := p.backoff(attempt, err)
+		if time.Since(start)+wait > p.MaxElapsedTime {
+			break
+		}
+		log.Warn("Retrying after error", "attempt", attempt, "max_attempts", p.MaxAttempts, "wait", wait, "error", err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+		}
 	}
 	return fmt.Errorf("all retries failed: %w", err)
 }
+
+// backoff returns how long to wait before the retry following attempt.
+func (p RetryPolicy) backoff(attempt int, err error) time.Duration {
+	ceiling := float64(p.InitialInterval)
+	for i := 1; i < attempt && ceiling < float64(p.MaxInterval); i++ {
+		ceiling *= p.Multiplier
+	}
+	ceiling = min(ceiling, float64(p.MaxInterval))
+	wait := time.Duration(rand.Int64N(int64(ceiling) + 1))
+
+	var hint interface{ RetryAfter() time.Duration }
+	if errors.As(err, &hint) {
+		wait = max(wait, hint.RetryAfter())
+	}
+	return wait
+}
+
+// throttleStatus are HTTP statuses that ask the client to slow down or try
+// again later.
+var throttleStatus = map[int]bool{
+	http.StatusRequestTimeout:  true,
+	http.StatusTooManyRequests: true,
+}
+
+// IsRetryable reports whether err is worth retrying: throttling, server
+// (5xx) and network errors are. Every other error is taken to be
+// permanent, including other client (4xx) errors such as AccessDenied or
+// NoSuchBucket, cancellation and errors of local files.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var failure awserr.RequestFailure
+	if errors.As(err, &failure) && failure.StatusCode() != 0 {
+		status := failure.StatusCode()
+		if status >= 500 || throttleStatus[status] {
+			return true
+		}
+		// S3 reports some transient conditions, such as RequestTimeout,
+		// with a 400 status.
+		return request.IsErrorRetryable(failure) || request.IsErrorThrottle(failure) || failure.Code() == "BadDigest"
+	}
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		// Errors without a response, e.g. connection failures.
+		return request.IsErrorRetryable(awsErr) || request.IsErrorThrottle(awsErr)
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// Retry calls fn up to attempts times, waiting about sleep before the first
+// retry and exponentially longer after that, until it succeeds or fails
+// with an error that is not retryable.
+func Retry(attempts int, sleep time.Duration, fn func() error) error {
+	return RetryContext(context.Background(), attempts, sleep, fn)
+}
+
+// RetryContext is like Retry, but stops once ctx is done: no further attempt
+// is started and the wait between attempts is cut short. An attempt already
+// running is not interrupted by RetryContext itself.
+func RetryContext(ctx context.Context, attempts int, sleep time.Duration, fn func() error) error {
+	return RetryPolicy{MaxAttempts: attempts, InitialInterval: sleep}.Do(ctx, fn)
+}
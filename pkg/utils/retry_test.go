@@ -0,0 +1,179 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+// s3Error returns the error the SDK returns for an S3 error response.
+func s3Error(status int, code string) error {
+	return awserr.NewRequestFailure(awserr.New(code, code+" message", nil), status, "request-id")
+}
+
+// retryAfter is an error carrying a hint of how long to wait.
+type retryAfter struct {
+	error
+	after time.Duration
+}
+
+func (e retryAfter) RetryAfter() time.Duration {
+	return e.after
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"InternalError", s3Error(http.StatusInternalServerError, "InternalError"), true},
+		{"ServiceUnavailable", s3Error(http.StatusServiceUnavailable, "ServiceUnavailable"), true},
+		{"SlowDown", s3Error(http.StatusServiceUnavailable, "SlowDown"), true},
+		{"TooManyRequests", s3Error(http.StatusTooManyRequests, "TooManyRequests"), true},
+		{"RequestTimeout", s3Error(http.StatusBadRequest, "RequestTimeout"), true},
+		{"BadDigest", s3Error(http.StatusBadRequest, "BadDigest"), true},
+		{"AccessDenied", s3Error(http.StatusForbidden, "AccessDenied"), false},
+		{"NoSuchBucket", s3Error(http.StatusNotFound, "NoSuchBucket"), false},
+		{"NoSuchUpload", s3Error(http.StatusNotFound, "NoSuchUpload"), false},
+		{"InvalidArgument", s3Error(http.StatusBadRequest, "InvalidArgument"), false},
+		{"wrapped 5xx", fmt.Errorf("failed to upload part: %w", s3Error(http.StatusBadGateway, "BadGateway")), true},
+		{"send failure", awserr.New(request.ErrCodeRequestError, "send request failed", &net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}), true},
+		{"net.Error", &net.OpError{Op: "read", Err: errors.New("i/o timeout")}, true},
+		{"connection reset", fmt.Errorf("read: %w", syscall.ECONNRESET), true},
+		{"connection refused", syscall.ECONNREFUSED, true},
+		{"broken pipe", syscall.EPIPE, true},
+		{"unexpected EOF", io.ErrUnexpectedEOF, true},
+		{"canceled", context.Canceled, false},
+		{"deadline", fmt.Errorf("request: %w", context.DeadlineExceeded), false},
+		{"missing file", &os.PathError{Op: "open", Path: "data.bin", Err: os.ErrNotExist}, false},
+		{"other", errors.New("invalid part size"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := DefaultRetryPolicy.With(RetryPolicy{InitialInterval: 100 * time.Millisecond, MaxInterval: time.Second})
+	err := errors.New("failure")
+	tests := []struct {
+		attempt int
+		ceiling time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{20, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.attempt), func(t *testing.T) {
+			var longest time.Duration
+			for i := 0; i < 1000; i++ {
+				wait := p.backoff(tt.attempt, err)
+				if wait < 0 || wait > tt.ceiling {
+					t.Fatalf("wait %v is outside [0, %v]", wait, tt.ceiling)
+				}
+				longest = max(longest, wait)
+			}
+			// Full jitter spreads the waits over the whole range.
+			if longest < tt.ceiling/2 {
+				t.Errorf("longest of 1000 waits is %v, want up to %v", longest, tt.ceiling)
+			}
+		})
+	}
+}
+
+func TestBackoffRetryAfter(t *testing.T) {
+	p := DefaultRetryPolicy.With(RetryPolicy{InitialInterval: 10 * time.Millisecond, MaxInterval: 10 * time.Millisecond})
+	tests := []struct {
+		name string
+		err  error
+		min  time.Duration
+		max  time.Duration
+	}{
+		{"no hint", s3Error(http.StatusServiceUnavailable, "SlowDown"), 0, 10 * time.Millisecond},
+		{"longer hint", retryAfter{s3Error(http.StatusServiceUnavailable, "SlowDown"), 3 * time.Second}, 3 * time.Second, 3 * time.Second},
+		{"wrapped hint", fmt.Errorf("failed: %w", retryAfter{s3Error(http.StatusTooManyRequests, "TooManyRequests"), 2 * time.Second}), 2 * time.Second, 2 * time.Second},
+		{"shorter hint", retryAfter{s3Error(http.StatusServiceUnavailable, "SlowDown"), time.Nanosecond}, time.Nanosecond, 10 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				if wait := p.backoff(1, tt.err); wait < tt.min || wait > tt.max {
+					t.Fatalf("wait %v is outside [%v, %v]", wait, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestDo(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 3, InitialInterval: time.Millisecond, MaxInterval: time.Millisecond}
+	tests := []struct {
+		name     string
+		errs     []error
+		attempts int
+		ok       bool
+	}{
+		{"success", nil, 1, true},
+		{"transient", []error{s3Error(http.StatusServiceUnavailable, "SlowDown")}, 2, true},
+		{"permanent", []error{s3Error(http.StatusForbidden, "AccessDenied")}, 1, false},
+		{"exhausted", []error{io.ErrUnexpectedEOF, io.ErrUnexpectedEOF, io.ErrUnexpectedEOF, io.ErrUnexpectedEOF}, 3, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := p.Do(context.Background(), func() error {
+				attempts++
+				if attempts <= len(tt.errs) {
+					return tt.errs[attempts-1]
+				}
+				return nil
+			})
+			if (err == nil) != tt.ok {
+				t.Errorf("Do returned %v", err)
+			}
+			if attempts != tt.attempts {
+				t.Errorf("fn was called %d times, want %d", attempts, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestDoStopsWhenCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	start := time.Now()
+	attempts := 0
+	err := RetryPolicy{MaxAttempts: 5, InitialInterval: time.Second}.Do(ctx, func() error {
+		attempts++
+		cancel()
+		return io.ErrUnexpectedEOF
+	})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Do returned %v, want context.Canceled", err)
+	}
+	if attempts != 1 {
+		t.Errorf("fn was called %d times, want 1", attempts)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Do took %v to return after being canceled", elapsed)
+	}
+}